@@ -1,28 +1,43 @@
 package local
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"time"
 
 	ijwt "github.com/madappgang/identifo/jwt"
 	"github.com/madappgang/identifo/model"
 )
 
-var supportedSignatureAlgorithms = [2]ijwt.TokenSignatureAlgorithm{ijwt.TokenSignatureAlgorithmES256, ijwt.TokenSignatureAlgorithmRS256}
+// supportedSignatureAlgorithms are the algorithms we could detect by the keys.
+// PS256 uses the same keys as RS256, so it should be set explicitly.
+var supportedSignatureAlgorithms = [5]ijwt.TokenSignatureAlgorithm{
+	ijwt.TokenSignatureAlgorithmES256,
+	ijwt.TokenSignatureAlgorithmES384,
+	ijwt.TokenSignatureAlgorithmES512,
+	ijwt.TokenSignatureAlgorithmRS256,
+	ijwt.TokenSignatureAlgorithmEdDSA,
+}
 
 // KeyStorage is a wrapper over public and private key files.
 type KeyStorage struct {
+	Folder         string
 	PublicKeyPath  string
 	PrivateKeyPath string
+	KeySetPath     string
 }
 
 // NewKeyStorage creates and returns new key files storage.
 func NewKeyStorage(settings model.KeyStorageSettings) (*KeyStorage, error) {
 	return &KeyStorage{
+		Folder:         settings.Folder,
 		PrivateKeyPath: path.Join(settings.Folder, model.PrivateKeyName),
 		PublicKeyPath:  path.Join(settings.Folder, model.PublicKeyName),
+		KeySetPath:     path.Join(settings.Folder, model.KeySetName),
 	}, nil
 }
 
@@ -101,6 +116,86 @@ func (ks *KeyStorage) LoadKeys(alg ijwt.TokenSignatureAlgorithm) (*model.JWTKeys
 	return keys, nil
 }
 
+// InsertKeySet saves key files and the key set description.
+// Key files are written first, so the key set never refers to the missing files.
+func (ks *KeyStorage) InsertKeySet(keySet *model.JWTKeySet) error {
+	if keySet == nil || len(keySet.Keys) == 0 {
+		return fmt.Errorf("Cannot insert empty key set")
+	}
+
+	for _, k := range keySet.Keys {
+		// Retired keys are not loaded, their files stay as they are.
+		if k.Private == nil || k.Public == nil {
+			continue
+		}
+		privatePEM, err := ijwt.MarshalPrivateKeyToPEM(k.Private)
+		if err != nil {
+			return fmt.Errorf("Cannot encode private key of version %d: %s", k.Version, err)
+		}
+		publicPEM, err := ijwt.MarshalPublicKeyToPEM(k.Public)
+		if err != nil {
+			return fmt.Errorf("Cannot encode public key of version %d: %s", k.Version, err)
+		}
+
+		privateName, publicName := model.KeyNames(k.Version)
+		if err := ioutil.WriteFile(path.Join(ks.Folder, privateName), privatePEM, 0600); err != nil {
+			return fmt.Errorf("Cannot write private key file: %s", err)
+		}
+		if err := ioutil.WriteFile(path.Join(ks.Folder, publicName), publicPEM, 0644); err != nil {
+			return fmt.Errorf("Cannot write public key file: %s", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(keySet, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Cannot marshal key set: %s", err)
+	}
+	if err := ioutil.WriteFile(ks.KeySetPath, data, 0644); err != nil {
+		return fmt.Errorf("Cannot write key set file: %s", err)
+	}
+	return nil
+}
+
+// LoadKeySet loads the key set. Keys that are already retired are not loaded.
+// When there is no key set file, the single key pair becomes the only active key.
+func (ks *KeyStorage) LoadKeySet(alg ijwt.TokenSignatureAlgorithm) (*model.JWTKeySet, error) {
+	data, err := ioutil.ReadFile(ks.KeySetPath)
+	if os.IsNotExist(err) {
+		keys, err := ks.LoadKeys(alg)
+		if err != nil {
+			return nil, err
+		}
+		return model.NewJWTKeySet(keys)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read key set file: %s", err)
+	}
+
+	keySet := new(model.JWTKeySet)
+	if err := json.Unmarshal(data, keySet); err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal key set file: %s", err)
+	}
+
+	now := time.Now()
+	for i, k := range keySet.Keys {
+		if k.StateAt(now) == model.KeyStateRetired {
+			continue
+		}
+		if alg != ijwt.TokenSignatureAlgorithmAuto && k.Algorithm != alg {
+			return nil, fmt.Errorf("Key of version %d has algorithm %s, expected %s", k.Version, k.Algorithm, alg)
+		}
+
+		privateName, publicName := model.KeyNames(k.Version)
+		if keySet.Keys[i].Private, err = ijwt.LoadPrivateKeyFromPEM(path.Join(ks.Folder, privateName), k.Algorithm); err != nil {
+			return nil, fmt.Errorf("Cannot load private key of version %d: %s", k.Version, err)
+		}
+		if keySet.Keys[i].Public, err = ijwt.LoadPublicKeyFromPEM(path.Join(ks.Folder, publicName), k.Algorithm); err != nil {
+			return nil, fmt.Errorf("Cannot load public key of version %d: %s", k.Version, err)
+		}
+	}
+	return keySet, nil
+}
+
 func (ks *KeyStorage) loadKeys(alg ijwt.TokenSignatureAlgorithm, keys *model.JWTKeys) error {
 	privateKey, err := ijwt.LoadPrivateKeyFromPEM(ks.PrivateKeyPath, alg)
 	if err != nil {
@@ -1,16 +1,19 @@
 package s3
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
-	jwt "github.com/form3tech-oss/jwt-go"
 	s3Storage "github.com/madappgang/identifo/external_services/storage/s3"
 	ijwt "github.com/madappgang/identifo/jwt"
 	"github.com/madappgang/identifo/model"
@@ -20,8 +23,10 @@ import (
 type KeyStorage struct {
 	Client         *s3.S3
 	Bucket         string
+	Folder         string
 	PublicKeyPath  string
 	PrivateKeyPath string
+	KeySetPath     string
 }
 
 // NewKeyStorage creates and returns new S3-backed key files storage.
@@ -34,8 +39,10 @@ func NewKeyStorage(settings model.KeyStorageSettings) (*KeyStorage, error) {
 	return &KeyStorage{
 		Client:         s3Client,
 		Bucket:         settings.Bucket,
+		Folder:         settings.Folder,
 		PrivateKeyPath: path.Join(settings.Folder, model.PrivateKeyName),
 		PublicKeyPath:  path.Join(settings.Folder, model.PublicKeyName),
+		KeySetPath:     path.Join(settings.Folder, model.KeySetName),
 	}, nil
 }
 
@@ -105,14 +112,151 @@ func (ks *KeyStorage) LoadKeys(alg ijwt.TokenSignatureAlgorithm) (*model.JWTKeys
 	return keys, nil
 }
 
-func (ks *KeyStorage) guessTokenServiceAlgorithm(publicKey []byte) (interface{}, error) {
-	_, errES := jwt.ParseECPublicKeyFromPEM(publicKey)
-	if errES == nil {
-		return ijwt.TokenSignatureAlgorithmES256, nil
+// InsertKeySet puts key files and the key set description into S3 key storage.
+// Key files are put first, so the key set never refers to the missing files.
+func (ks *KeyStorage) InsertKeySet(keySet *model.JWTKeySet) error {
+	if keySet == nil || len(keySet.Keys) == 0 {
+		return fmt.Errorf("Empty key set")
+	}
+
+	for _, k := range keySet.Keys {
+		// Retired keys are not loaded, their files stay as they are.
+		if k.Private == nil || k.Public == nil {
+			continue
+		}
+		privatePEM, err := ijwt.MarshalPrivateKeyToPEM(k.Private)
+		if err != nil {
+			return fmt.Errorf("Cannot encode private key of version %d: %s", k.Version, err)
+		}
+		publicPEM, err := ijwt.MarshalPublicKeyToPEM(k.Public)
+		if err != nil {
+			return fmt.Errorf("Cannot encode public key of version %d: %s", k.Version, err)
+		}
+
+		privateName, publicName := model.KeyNames(k.Version)
+		if err := ks.putObject(path.Join(ks.Folder, privateName), privatePEM, "application/x-pem-file"); err != nil {
+			return err
+		}
+		if err := ks.putObject(path.Join(ks.Folder, publicName), publicPEM, "application/x-pem-file"); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(keySet)
+	if err != nil {
+		return fmt.Errorf("Cannot marshal key set: %s", err)
+	}
+	return ks.putObject(ks.KeySetPath, data, "application/json")
+}
+
+// LoadKeySet loads the key set from S3 key storage. Keys that are already retired are not loaded.
+// When there is no key set description, the single key pair becomes the only active key.
+func (ks *KeyStorage) LoadKeySet(alg ijwt.TokenSignatureAlgorithm) (*model.JWTKeySet, error) {
+	data, err := ks.getObject(ks.KeySetPath)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return ks.loadSingleKeySet(alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get %s from S3: %s", ks.KeySetPath, err)
+	}
+
+	keySet := new(model.JWTKeySet)
+	if err := json.Unmarshal(data, keySet); err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal key set: %s", err)
+	}
+
+	now := time.Now()
+	for i, k := range keySet.Keys {
+		if k.StateAt(now) == model.KeyStateRetired {
+			continue
+		}
+		if alg != ijwt.TokenSignatureAlgorithmAuto && k.Algorithm != alg {
+			return nil, fmt.Errorf("Key of version %d has algorithm %s, expected %s", k.Version, k.Algorithm, alg)
+		}
+
+		privateName, publicName := model.KeyNames(k.Version)
+		if keySet.Keys[i].Private, keySet.Keys[i].Public, err = ks.loadKeyPair(privateName, publicName, k.Algorithm); err != nil {
+			return nil, fmt.Errorf("Cannot load keys of version %d: %s", k.Version, err)
+		}
 	}
-	_, errRS := jwt.ParseRSAPublicKeyFromPEM(publicKey)
-	if errRS == nil {
-		return ijwt.TokenSignatureAlgorithmRS256, nil
+	return keySet, nil
+}
+
+// loadSingleKeySet makes the key set of the single key pair.
+func (ks *KeyStorage) loadSingleKeySet(alg ijwt.TokenSignatureAlgorithm) (*model.JWTKeySet, error) {
+	if alg == ijwt.TokenSignatureAlgorithmAuto {
+		publicKey, err := ks.getObject(ks.PublicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot get %s from S3: %s", ks.PublicKeyPath, err)
+		}
+		guessedAlg, err := ks.guessTokenServiceAlgorithm(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		alg = guessedAlg.(ijwt.TokenSignatureAlgorithm)
+	}
+
+	privateKey, publicKey, err := ks.loadKeyPair(model.PrivateKeyName, model.PublicKeyName, alg)
+	if err != nil {
+		return nil, err
+	}
+	return model.NewJWTKeySet(&model.JWTKeys{Private: privateKey, Public: publicKey, Algorithm: alg})
+}
+
+// loadKeyPair gets and parses private and public keys.
+func (ks *KeyStorage) loadKeyPair(privateName, publicName string, alg ijwt.TokenSignatureAlgorithm) (interface{}, interface{}, error) {
+	privatePEM, err := ks.getObject(path.Join(ks.Folder, privateName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot get %s from S3: %s", privateName, err)
+	}
+	privateKey, err := ijwt.ParsePrivateKeyFromPEM(privatePEM, alg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot load private key: %s", err)
+	}
+
+	publicPEM, err := ks.getObject(path.Join(ks.Folder, publicName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot get %s from S3: %s", publicName, err)
+	}
+	publicKey, err := ijwt.LoadPublicKeyFromString(string(publicPEM), alg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot load public key: %s", err)
+	}
+	return privateKey, publicKey, nil
+}
+
+func (ks *KeyStorage) getObject(key string) ([]byte, error) {
+	resp, err := ks.Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(ks.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (ks *KeyStorage) putObject(key string, body []byte, contentType string) error {
+	if _, err := ks.Client.PutObject(&s3.PutObjectInput{
+		Bucket:       aws.String(ks.Bucket),
+		Key:          aws.String(key),
+		ACL:          aws.String("private"),
+		StorageClass: aws.String(s3.ObjectStorageClassStandard),
+		Body:         bytes.NewReader(body),
+		ContentType:  aws.String(contentType),
+	}); err != nil {
+		return fmt.Errorf("Cannot put %s to S3: %s", key, err)
+	}
+	log.Printf("Successfully put %s to S3\n", key)
+	return nil
+}
+
+func (ks *KeyStorage) guessTokenServiceAlgorithm(publicKey []byte) (interface{}, error) {
+	_, alg, err := ijwt.LoadPublicKeyFromStringAuto(string(publicKey))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot guess token service algorithm: %s", err)
 	}
-	return nil, fmt.Errorf("Cannot guess token service algorithm. It's neither ES256 (%s), nor RS256 (%s)", errES, errRS)
+	return alg, nil
 }
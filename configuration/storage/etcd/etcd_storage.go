@@ -140,6 +140,16 @@ func (cs *ConfigurationStorage) LoadKeys(alg ijwt.TokenSignatureAlgorithm) (*mod
 	return cs.keyStorage.LoadKeys(alg)
 }
 
+// InsertKeySet inserts new key set into the key storage.
+func (cs *ConfigurationStorage) InsertKeySet(keySet *model.JWTKeySet) error {
+	return cs.keyStorage.InsertKeySet(keySet)
+}
+
+// LoadKeySet loads key set from the key storage.
+func (cs *ConfigurationStorage) LoadKeySet(alg ijwt.TokenSignatureAlgorithm) (*model.JWTKeySet, error) {
+	return cs.keyStorage.LoadKeySet(alg)
+}
+
 // GetUpdateChan implements ConfigurationStorage interface.
 func (cs *ConfigurationStorage) GetUpdateChan() chan interface{} {
 	return make(chan interface{}, 1)
@@ -73,3 +73,8 @@ func (es emailService) SendVerifyEmail(subject, recipient string, data interface
 func (es emailService) SendTFAEmail(subject, recipient string, data interface{}) error {
 	return es.SendTemplateEmail(subject, recipient, es.tmpltr.TFATemplate, data)
 }
+
+// SendMagicLinkEmail sends emails with the link to sign in.
+func (es emailService) SendMagicLinkEmail(subject, recipient string, data interface{}) error {
+	return es.SendTemplateEmail(subject, recipient, es.tmpltr.MagicLinkTemplate, data)
+}
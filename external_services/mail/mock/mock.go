@@ -66,3 +66,9 @@ func (es emailService) SendTFAEmail(subject, recipient string, data interface{})
 	fmt.Printf("✉️: MOCK EMAIL SERVICE: Sending TFA Email \nsubject: %s\recipient: %s\n data: %+v\n\n", subject, recipient, data)
 	return nil
 }
+
+// SendMagicLinkEmail returns nil error.
+func (es emailService) SendMagicLinkEmail(subject, recipient string, data interface{}) error {
+	fmt.Printf("✉️: MOCK EMAIL SERVICE: Sending magic link Email \nsubject: %s\recipient: %s\n data: %+v\n\n", subject, recipient, data)
+	return nil
+}
@@ -126,6 +126,11 @@ func (es *EmailService) SendTFAEmail(subject, recipient string, data interface{}
 	return es.SendTemplateEmail(subject, recipient, es.tmpltr.TFATemplate, data)
 }
 
+// SendMagicLinkEmail sends emails with the link to sign in.
+func (es *EmailService) SendMagicLinkEmail(subject, recipient string, data interface{}) error {
+	return es.SendTemplateEmail(subject, recipient, es.tmpltr.MagicLinkTemplate, data)
+}
+
 func logAWSError(err error) {
 	if err == nil {
 		return
@@ -32,6 +32,7 @@ func NewClient(authorizationCode string, appleInfo *model.AppleInfo) *Client {
 // Client is a client for making REST API requests to Apple authorization servers.
 type Client struct {
 	AuthorizationCode string
+	RedirectURI       string // RedirectURI the authorization code has been sent to, if it has been requested by the web page.
 	ClientID          string
 	ClientSecret      string
 	BaseURL           *url.URL
@@ -57,6 +58,9 @@ func (c *Client) MyProfile() (User, error) {
 	form.Set("client_secret", c.ClientSecret)
 	form.Set("code", c.AuthorizationCode)
 	form.Set("grant_type", "authorization_code")
+	if len(c.RedirectURI) > 0 {
+		form.Set("redirect_uri", c.RedirectURI)
+	}
 
 	var user User
 
@@ -0,0 +1,79 @@
+package apple
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/madappgang/identifo/model"
+)
+
+// authorizeURL is where the web pages redirect users to sign in with Apple.
+const authorizeURL = "https://appleid.apple.com/auth/authorize"
+
+// ErrEmptyUserID is when Apple user ID is empty.
+var ErrEmptyUserID = errors.New("Apple user id is not accessible. ")
+
+// NewProvider creates Sign In with Apple federated provider. Its settings are model.AppleInfo.
+func NewProvider() *Provider {
+	return &Provider{}
+}
+
+// Provider signs users in with Apple authorization codes.
+type Provider struct{}
+
+// ID implements model.FederatedProvider.
+func (p *Provider) ID() model.FederatedIdentityProvider {
+	return model.AppleIDProvider
+}
+
+// UserProfile exchanges authorization code for the user ID.
+func (p *Provider) UserProfile(settings model.FederatedSettings, credential model.FederatedCredential) (model.FederatedProfile, error) {
+	appleInfo, ok := p.appleInfo(settings)
+	if !ok {
+		return model.FederatedProfile{}, model.ErrFederatedProviderNotConfigured
+	}
+
+	c := NewClient(credential.AuthorizationCode, &appleInfo)
+	c.RedirectURI = credential.RedirectURI
+	appleProfile, err := c.MyProfile()
+	if err != nil {
+		return model.FederatedProfile{}, err
+	}
+
+	if len(appleProfile.ID) == 0 {
+		return model.FederatedProfile{}, ErrEmptyUserID
+	}
+	return model.FederatedProfile{ID: appleProfile.ID}, nil
+}
+
+// RedirectConfigured implements model.FederatedRedirectProvider.
+func (p *Provider) RedirectConfigured(settings model.FederatedSettings) bool {
+	_, ok := p.appleInfo(settings)
+	return ok
+}
+
+// SignInURL returns the URL of Sign In with Apple page.
+// No scopes are requested, so Apple sends the code back in the query, like the other providers.
+func (p *Provider) SignInURL(settings model.FederatedSettings, redirectURI, state, nonce string) (string, error) {
+	appleInfo, ok := p.appleInfo(settings)
+	if !ok {
+		return "", model.ErrFederatedProviderNotConfigured
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("response_mode", "query")
+	q.Set("client_id", appleInfo.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	return authorizeURL + "?" + q.Encode(), nil
+}
+
+func (p *Provider) appleInfo(settings model.FederatedSettings) (model.AppleInfo, bool) {
+	var appleInfo model.AppleInfo
+	if err := settings.Decode(&appleInfo); err != nil {
+		return model.AppleInfo{}, false
+	}
+	return appleInfo, len(appleInfo.ClientID) > 0 && len(appleInfo.ClientSecret) > 0
+}
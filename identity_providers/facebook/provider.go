@@ -0,0 +1,90 @@
+package facebook
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/madappgang/identifo/model"
+)
+
+// loginDialogURL is where the web pages redirect users to sign in with Facebook.
+const loginDialogURL = "https://www.facebook.com/v3.2/dialog/oauth"
+
+// ErrEmptyUserID is when Facebook user ID is empty.
+var ErrEmptyUserID = errors.New("Facebook user id is not accessible. ")
+
+// Settings are the app's Facebook settings, needed to sign in with redirect on the web pages.
+// Apps, which send access tokens themselves, do not need them.
+type Settings struct {
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// NewProvider creates Facebook federated provider. Its settings are Settings.
+func NewProvider() *Provider {
+	return &Provider{}
+}
+
+// Provider signs users in with Facebook access tokens.
+type Provider struct{}
+
+// ID implements model.FederatedProvider.
+func (p *Provider) ID() model.FederatedIdentityProvider {
+	return model.FacebookIDProvider
+}
+
+// UserProfile fetches the profile of the access token owner.
+// If there is authorization code instead of access token, it is exchanged for access token first.
+func (p *Provider) UserProfile(settings model.FederatedSettings, credential model.FederatedCredential) (model.FederatedProfile, error) {
+	accessToken := credential.AccessToken
+	if len(accessToken) == 0 && len(credential.AuthorizationCode) > 0 {
+		s, ok := p.settings(settings)
+		if !ok {
+			return model.FederatedProfile{}, model.ErrFederatedProviderNotConfigured
+		}
+
+		var err error
+		if accessToken, err = ExchangeCode(s.ClientID, s.ClientSecret, credential.RedirectURI, credential.AuthorizationCode); err != nil {
+			return model.FederatedProfile{}, err
+		}
+	}
+
+	fbProfile, err := NewClient(accessToken).MyProfile()
+	if err != nil {
+		return model.FederatedProfile{}, err
+	}
+
+	if len(fbProfile.ID) == 0 {
+		return model.FederatedProfile{}, ErrEmptyUserID
+	}
+	return model.FederatedProfile{ID: fbProfile.ID, Email: fbProfile.Email, Name: fbProfile.Name}, nil
+}
+
+// RedirectConfigured implements model.FederatedRedirectProvider.
+func (p *Provider) RedirectConfigured(settings model.FederatedSettings) bool {
+	_, ok := p.settings(settings)
+	return ok
+}
+
+// SignInURL returns the URL of Facebook login dialog.
+func (p *Provider) SignInURL(settings model.FederatedSettings, redirectURI, state, nonce string) (string, error) {
+	s, ok := p.settings(settings)
+	if !ok {
+		return "", model.ErrFederatedProviderNotConfigured
+	}
+
+	q := url.Values{}
+	q.Set("client_id", s.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("state", state)
+	q.Set("response_type", "code")
+	return loginDialogURL + "?" + q.Encode(), nil
+}
+
+func (p *Provider) settings(settings model.FederatedSettings) (Settings, bool) {
+	var s Settings
+	if err := settings.Decode(&s); err != nil {
+		return Settings{}, false
+	}
+	return s, len(s.ClientID) > 0 && len(s.ClientSecret) > 0
+}
@@ -1,13 +1,19 @@
 package facebook
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 // exchangeTokenURL is Facebook endpoint for exchanging short-lived tokens.
 const exchangeTokenURL = "https://graph.facebook.com/oauth/access_token"
 
+// exchangeCodeURL is Facebook endpoint for exchanging authorization code for access token.
+const exchangeCodeURL = "https://graph.facebook.com/v3.2/oauth/access_token"
+
 // ExchangeToken exchanges short living token to long living token.
 // See https://developers.facebook.com/docs/facebook-login/access-tokens/refreshing.
 func ExchangeToken(appID, appSecret, shortToken string) (string, error) {
@@ -26,3 +32,42 @@ func ExchangeToken(appID, appSecret, shortToken string) (string, error) {
 	req.Header.Set("Accept", "application/json")
 	return "", nil
 }
+
+// ExchangeCode exchanges authorization code, which the login dialog has sent to redirectURI, for access token.
+// See https://developers.facebook.com/docs/facebook-login/manually-build-a-login-flow#confirm.
+func ExchangeCode(appID, appSecret, redirectURI, code string) (string, error) {
+	req, err := http.NewRequest("GET", exchangeCodeURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Add("client_id", appID)
+	q.Add("client_secret", appSecret)
+	q.Add("redirect_uri", redirectURI)
+	q.Add("code", code)
+
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("Facebook response error: %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if len(token.AccessToken) == 0 {
+		return "", fmt.Errorf("Facebook response does not have access token")
+	}
+	return token.AccessToken, nil
+}
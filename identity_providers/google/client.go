@@ -0,0 +1,101 @@
+package google
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	jwt "github.com/form3tech-oss/jwt-go"
+	jwtValidator "github.com/madappgang/identifo/jwt/validator"
+)
+
+const (
+	// JWKSURL is where Google publishes the keys ID tokens are signed with.
+	JWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+)
+
+// Issuers are the values of "iss" claim Google ID tokens could have.
+var Issuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+var (
+	// ErrInvalidIssuer is when ID token is not issued by Google.
+	ErrInvalidIssuer = errors.New("Google ID token has invalid issuer")
+	// ErrInvalidAudience is when ID token is issued to the client of another app.
+	ErrInvalidAudience = errors.New("Google ID token has invalid audience")
+	// ErrNoExpiration is when ID token does not have expiration time.
+	ErrNoExpiration = errors.New("Google ID token has no expiration time")
+	// ErrEmptySubject is when ID token does not have the user ID.
+	ErrEmptySubject = errors.New("Google ID token has empty or non-string subject")
+)
+
+// NewClient creates new client for verifying Google ID tokens against the keys published at jwksURL.
+// Client is returned even if the keys are not available yet, it will fetch them on the first token.
+func NewClient(jwksURL string) (*Client, error) {
+	jwks, err := jwtValidator.NewJWKS(jwksURL, &http.Client{Timeout: 15 * time.Second})
+	return &Client{jwks: jwks}, err
+}
+
+// Client verifies ID tokens apps get with Google Sign-In.
+type Client struct {
+	jwks *jwtValidator.JWKS
+}
+
+// User is what we can get about the user from Google ID token.
+type User struct {
+	ID            string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// MyProfile verifies ID token, which must be issued to one of the clientIDs, and returns its owner profile.
+func (c *Client) MyProfile(idToken string, clientIDs []string) (User, error) {
+	var user User
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(idToken, claims, c.jwks.Keyfunc); err != nil {
+		return user, err
+	}
+
+	// Expiration time is checked on parse, if the token has it.
+	if !claims.VerifyExpiresAt(jwt.TimeFunc().Unix(), true) {
+		return user, ErrNoExpiration
+	}
+
+	validIssuer := false
+	for _, iss := range Issuers {
+		if claims.VerifyIssuer(iss, true) {
+			validIssuer = true
+			break
+		}
+	}
+	if !validIssuer {
+		return user, ErrInvalidIssuer
+	}
+
+	validAudience := false
+	for _, aud := range clientIDs {
+		if len(aud) > 0 && claims.VerifyAudience(aud, true) {
+			validAudience = true
+			break
+		}
+	}
+	if !validAudience {
+		return user, ErrInvalidAudience
+	}
+
+	var ok bool
+	user.ID, ok = claims["sub"].(string)
+	if user.ID == "" || !ok {
+		return user, ErrEmptySubject
+	}
+	user.Email, _ = claims["email"].(string)
+	user.Name, _ = claims["name"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		user.EmailVerified = verified
+	case string:
+		user.EmailVerified = verified == "true"
+	}
+	return user, nil
+}
@@ -0,0 +1,90 @@
+package google
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/form3tech-oss/jwt-go"
+)
+
+func TestMyProfile(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Unable to generate key %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Unable to generate key %v", err)
+	}
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": "google",
+			"n":   base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+		}}})
+	}))
+	defer jwks.Close()
+
+	c, err := NewClient(jwks.URL)
+	if err != nil {
+		t.Fatalf("Unable to create client %v", err)
+	}
+
+	idToken := func(key *rsa.PrivateKey, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "google"
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("Unable to sign token %v", err)
+		}
+		return s
+	}
+	claims := func(iss, aud string, exp int64) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            iss,
+			"aud":            aud,
+			"sub":            "1234567890",
+			"email":          "user@example.com",
+			"email_verified": true,
+			"iat":            time.Now().Unix(),
+			"exp":            exp,
+		}
+	}
+	clientIDs := []string{"android.apps.googleusercontent.com", "ios.apps.googleusercontent.com"}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		idToken string
+		wantErr bool
+	}{
+		{"valid token", idToken(privateKey, claims("https://accounts.google.com", "ios.apps.googleusercontent.com", exp)), false},
+		{"issuer without scheme", idToken(privateKey, claims("accounts.google.com", "android.apps.googleusercontent.com", exp)), false},
+		{"another app client", idToken(privateKey, claims("https://accounts.google.com", "web.apps.googleusercontent.com", exp)), true},
+		{"another issuer", idToken(privateKey, claims("https://example.com", "ios.apps.googleusercontent.com", exp)), true},
+		{"expired token", idToken(privateKey, claims("https://accounts.google.com", "ios.apps.googleusercontent.com", time.Now().Add(-time.Hour).Unix())), true},
+		{"wrong signature", idToken(otherKey, claims("https://accounts.google.com", "ios.apps.googleusercontent.com", exp)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := c.MyProfile(tt.idToken, clientIDs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MyProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (user.ID != "1234567890" || user.Email != "user@example.com" || !user.EmailVerified) {
+				t.Errorf("MyProfile() = %+v", user)
+			}
+		})
+	}
+}
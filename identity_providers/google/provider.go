@@ -0,0 +1,52 @@
+package google
+
+import (
+	"log"
+	"sync"
+
+	"github.com/madappgang/identifo/model"
+)
+
+// NewProvider creates Google Sign-In federated provider, which verifies ID tokens against the keys published at jwksURL.
+// Its settings are model.GoogleInfo.
+func NewProvider(jwksURL string) *Provider {
+	return &Provider{jwksURL: jwksURL}
+}
+
+// Provider signs users in with Google ID tokens.
+type Provider struct {
+	jwksURL    string
+	clientOnce sync.Once
+	client     *Client
+}
+
+// ID implements model.FederatedProvider.
+func (p *Provider) ID() model.FederatedIdentityProvider {
+	return model.GoogleIDProvider
+}
+
+// UserProfile verifies ID token, issued to one of the app's Google clients, and returns its owner profile.
+func (p *Provider) UserProfile(settings model.FederatedSettings, credential model.FederatedCredential) (model.FederatedProfile, error) {
+	var googleInfo model.GoogleInfo
+	if err := settings.Decode(&googleInfo); err != nil || len(googleInfo.ClientIDs) == 0 {
+		return model.FederatedProfile{}, model.ErrFederatedProviderNotConfigured
+	}
+
+	user, err := p.googleClient().MyProfile(credential.IDToken, googleInfo.ClientIDs)
+	if err != nil {
+		return model.FederatedProfile{}, err
+	}
+	return model.FederatedProfile{ID: user.ID, Email: user.Email, EmailVerified: user.EmailVerified, Name: user.Name}, nil
+}
+
+// googleClient returns Google client, which is created on the first use,
+// so Google keys are fetched only when someone signs in with Google.
+func (p *Provider) googleClient() *Client {
+	p.clientOnce.Do(func() {
+		var err error
+		if p.client, err = NewClient(p.jwksURL); err != nil {
+			log.Println("Cannot fetch Google keys, will try again on the next token:", err)
+		}
+	})
+	return p.client
+}
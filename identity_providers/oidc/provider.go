@@ -0,0 +1,322 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/form3tech-oss/jwt-go"
+	jwtValidator "github.com/madappgang/identifo/jwt/validator"
+	"github.com/madappgang/identifo/model"
+)
+
+// discoveryPath is where providers publish their configuration (OpenID Connect Discovery 1.0, section 4).
+const discoveryPath = "/.well-known/openid-configuration"
+
+// Standard claims, used when the claim mapping does not set others.
+const (
+	claimUserID        = "sub"
+	claimEmail         = "email"
+	claimEmailVerified = "email_verified"
+)
+
+var (
+	// ErrInvalidIssuer is when ID token or provider configuration has unexpected issuer.
+	ErrInvalidIssuer = errors.New("OpenID Connect provider issuer is invalid")
+	// ErrInvalidAudience is when ID token is issued to another client.
+	ErrInvalidAudience = errors.New("ID token audience is invalid")
+	// ErrInvalidNonce is when ID token nonce differs from the one sent in authentication request.
+	ErrInvalidNonce = errors.New("ID token nonce is invalid")
+	// ErrNoExpiration is when ID token does not have expiration time.
+	ErrNoExpiration = errors.New("ID token has no expiration time")
+	// ErrNoIDToken is when token response does not have ID token.
+	ErrNoIDToken = errors.New("Token response does not have ID token")
+	// ErrEmptyUserID is when ID token does not have user ID claim.
+	ErrEmptyUserID = errors.New("ID token has empty or non-string user ID claim")
+	// ErrEmptyCredential is when neither ID token, nor authorization code is provided.
+	ErrEmptyCredential = errors.New("ID token or authorization code with redirect URI is required. ")
+)
+
+// User is what we get about the user from ID token, according to the claim mapping.
+type User struct {
+	ID            string
+	Email         string
+	EmailVerified bool
+}
+
+// metadata is the part of provider configuration we need.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider creates the provider, its configuration is discovered on the first use.
+func NewProvider(settings model.OIDCProviderSettings) *Provider {
+	return &Provider{
+		settings:   settings,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Provider is an upstream OpenID Connect provider.
+type Provider struct {
+	settings   model.OIDCProviderSettings
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata
+	jwks     *jwtValidator.JWKS
+}
+
+// ID implements model.FederatedProvider, users are linked to the issuer.
+func (p *Provider) ID() model.FederatedIdentityProvider {
+	return model.OIDCIDProvider(p.settings.Issuer)
+}
+
+// UserProfile implements model.FederatedProvider, the provider has its settings already.
+// The app either sends ID token it has got itself, or authorization code with redirect URI it has been sent to,
+// then we exchange the code with the client secret.
+func (p *Provider) UserProfile(_ model.FederatedSettings, credential model.FederatedCredential) (model.FederatedProfile, error) {
+	var user User
+	var err error
+
+	switch {
+	case len(credential.IDToken) > 0:
+		user, err = p.VerifyIDToken(credential.IDToken, credential.Nonce)
+	case len(credential.AuthorizationCode) > 0 && len(credential.RedirectURI) > 0:
+		user, err = p.Exchange(credential.AuthorizationCode, credential.RedirectURI, credential.CodeVerifier, credential.Nonce)
+	default:
+		err = ErrEmptyCredential
+	}
+	if err != nil {
+		return model.FederatedProfile{}, err
+	}
+	return model.FederatedProfile{ID: user.ID, Email: user.Email, EmailVerified: user.EmailVerified}, nil
+}
+
+// RedirectConfigured implements model.FederatedRedirectProvider, the provider has its settings already.
+func (p *Provider) RedirectConfigured(_ model.FederatedSettings) bool {
+	return len(p.settings.ClientID) > 0
+}
+
+// SignInURL implements model.FederatedRedirectProvider.
+func (p *Provider) SignInURL(_ model.FederatedSettings, redirectURI, state, nonce string) (string, error) {
+	return p.AuthCodeURL(redirectURI, state, nonce)
+}
+
+// AuthCodeURL returns the URL of the authentication request with authorization code flow.
+// State and nonce must be unguessable, and checked when the user comes back to redirectURI.
+func (p *Provider) AuthCodeURL(redirectURI, state, nonce string) (string, error) {
+	md, _, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(md.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.settings.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(p.scopes(), " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange exchanges authorization code for ID token and returns the user it identifies.
+// Code verifier is sent if the authentication request has had code challenge.
+func (p *Provider) Exchange(code, redirectURI, codeVerifier, nonce string) (User, error) {
+	md, _, err := p.discover()
+	if err != nil {
+		return User{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	if len(codeVerifier) > 0 {
+		form.Set("code_verifier", codeVerifier)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return User{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.settings.ClientID), url.QueryEscape(p.settings.ClientSecret))
+
+	var tr struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = p.doJSON(req, &tr); err != nil {
+		return User{}, err
+	}
+	if len(tr.Error) > 0 {
+		return User{}, fmt.Errorf("OpenID Connect provider error: %s %s", tr.Error, tr.ErrorDescription)
+	}
+	if len(tr.IDToken) == 0 {
+		return User{}, ErrNoIDToken
+	}
+	return p.VerifyIDToken(tr.IDToken, nonce)
+}
+
+// VerifyIDToken verifies ID token, issued to our client, and returns the user it identifies.
+// Nonce is checked if it is not empty.
+func (p *Provider) VerifyIDToken(idToken, nonce string) (User, error) {
+	md, jwks, err := p.discover()
+	if err != nil {
+		return User{}, err
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err = jwt.ParseWithClaims(idToken, claims, jwks.Keyfunc); err != nil {
+		return User{}, err
+	}
+
+	// Expiration time is checked on parse, if the token has it.
+	if !claims.VerifyExpiresAt(jwt.TimeFunc().Unix(), true) {
+		return User{}, ErrNoExpiration
+	}
+	if iss, _ := claims["iss"].(string); iss != md.Issuer {
+		return User{}, ErrInvalidIssuer
+	}
+	if !p.verifyAudience(claims) {
+		return User{}, ErrInvalidAudience
+	}
+	if len(nonce) > 0 {
+		if n, _ := claims["nonce"].(string); n != nonce {
+			return User{}, ErrInvalidNonce
+		}
+	}
+
+	mapping := p.settings.ClaimMapping
+	user := User{}
+	user.ID, _ = claims[claimOrDefault(mapping.UserID, claimUserID)].(string)
+	if len(user.ID) == 0 {
+		return User{}, ErrEmptyUserID
+	}
+	user.Email, _ = claims[claimOrDefault(mapping.Email, claimEmail)].(string)
+	switch verified := claims[claimOrDefault(mapping.EmailVerified, claimEmailVerified)].(type) {
+	case bool:
+		user.EmailVerified = verified
+	case string:
+		user.EmailVerified = verified == "true"
+	}
+	return user, nil
+}
+
+// verifyAudience checks that the token is issued to our client (OpenID Connect Core 1.0, section 3.1.3.7).
+func (p *Provider) verifyAudience(claims jwt.MapClaims) bool {
+	var aud []string
+	switch a := claims["aud"].(type) {
+	case string:
+		aud = []string{a}
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+	}
+
+	found := false
+	for _, a := range aud {
+		if a == p.settings.ClientID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	// Authorized party must be our client, if the token has several audiences.
+	if azp, ok := claims["azp"].(string); ok && len(aud) > 1 && azp != p.settings.ClientID {
+		return false
+	}
+	return true
+}
+
+// discover fetches provider configuration and creates its key set once.
+// If the provider is not available, we try again on the next request.
+func (p *Provider) discover() (*metadata, *jwtValidator.JWKS, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, p.jwks, nil
+	}
+
+	issuer := strings.TrimSuffix(p.settings.Issuer, "/")
+	req, err := http.NewRequest(http.MethodGet, issuer+discoveryPath, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var md metadata
+	if err = p.doJSON(req, &md); err != nil {
+		return nil, nil, err
+	}
+	// Issuer must be exactly the one the configuration is fetched from (OpenID Connect Discovery 1.0, section 4.3).
+	if strings.TrimSuffix(md.Issuer, "/") != issuer {
+		return nil, nil, ErrInvalidIssuer
+	}
+	if len(md.AuthorizationEndpoint) == 0 || len(md.TokenEndpoint) == 0 || len(md.JWKSURI) == 0 {
+		return nil, nil, fmt.Errorf("OpenID Connect provider configuration is incomplete")
+	}
+
+	jwks, err := jwtValidator.NewJWKS(md.JWKSURI, p.httpClient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.metadata, p.jwks = &md, jwks
+	return p.metadata, p.jwks, nil
+}
+
+func (p *Provider) scopes() []string {
+	scopes := []string{"openid"}
+	for _, s := range p.settings.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+func (p *Provider) doJSON(req *http.Request, v interface{}) error {
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Token endpoint returns errors as JSON with 400 status code.
+	if resp.StatusCode >= 500 || (resp.StatusCode >= 300 && resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusUnauthorized) {
+		return fmt.Errorf("OpenID Connect provider response status: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func claimOrDefault(claim, defaultClaim string) string {
+	if len(claim) > 0 {
+		return claim
+	}
+	return defaultClaim
+}
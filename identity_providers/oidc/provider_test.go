@@ -0,0 +1,140 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	jwt "github.com/form3tech-oss/jwt-go"
+	"github.com/madappgang/identifo/model"
+)
+
+// testIssuer is a local stand-in of the OpenID Connect provider, which issues ID tokens for the authorization code.
+type testIssuer struct {
+	*httptest.Server
+	key     *ecdsa.PrivateKey
+	code    string
+	idToken string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key %v", err)
+	}
+	ti := &testIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 ti.URL,
+			"authorization_endpoint": ti.URL + "/authorize",
+			"token_endpoint":         ti.URL + "/token",
+			"jwks_uri":               ti.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "EC",
+			"crv": "P-256",
+			"kid": "test",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "client" || secret != "secret" || r.PostFormValue("code") != ti.code {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": ti.idToken})
+	})
+	ti.Server = httptest.NewServer(mux)
+	return ti
+}
+
+func (ti *testIssuer) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = "test"
+	s, err := token.SignedString(ti.key)
+	if err != nil {
+		t.Fatalf("Unable to sign token %v", err)
+	}
+	return s
+}
+
+func TestProvider(t *testing.T) {
+	ti := newTestIssuer(t)
+	defer ti.Close()
+
+	p := NewProvider(model.OIDCProviderSettings{
+		Name:         "keycloak",
+		Issuer:       ti.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"email"},
+		ClaimMapping: model.OIDCClaimMapping{UserID: "oid"},
+	})
+
+	authURL, err := p.AuthCodeURL("https://identifo.example.com/web/federated/callback", "state", "nonce")
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	if q := u.Query(); q.Get("scope") != "openid email" || q.Get("state") != "state" || q.Get("nonce") != "nonce" || q.Get("client_id") != "client" {
+		t.Errorf("AuthCodeURL() = %s", authURL)
+	}
+
+	claims := func(aud interface{}, nonce string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            ti.URL,
+			"aud":            aud,
+			"sub":            "subject",
+			"oid":            "object-id",
+			"email":          "user@example.com",
+			"email_verified": true,
+			"nonce":          nonce,
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Minute).Unix(),
+		}
+	}
+
+	ti.code = "code"
+	ti.idToken = ti.sign(t, claims("client", "nonce"))
+	user, err := p.Exchange("code", "https://identifo.example.com/web/federated/callback", "", "nonce")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if user.ID != "object-id" || user.Email != "user@example.com" || !user.EmailVerified {
+		t.Errorf("Exchange() = %+v", user)
+	}
+	if _, err = p.Exchange("wrong", "https://identifo.example.com/web/federated/callback", "", "nonce"); err == nil {
+		t.Error("Exchange() with wrong code should fail")
+	}
+
+	tests := []struct {
+		name    string
+		idToken string
+		nonce   string
+		wantErr error
+	}{
+		{"audience list", ti.sign(t, claims([]string{"client", "other"}, "")), "", nil},
+		{"another client", ti.sign(t, claims("other", "")), "", ErrInvalidAudience},
+		{"replayed nonce", ti.sign(t, claims("client", "old")), "nonce", ErrInvalidNonce},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.VerifyIDToken(tt.idToken, tt.nonce); err != tt.wantErr {
+				t.Errorf("VerifyIDToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
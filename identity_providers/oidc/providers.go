@@ -0,0 +1,53 @@
+package oidc
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/madappgang/identifo/model"
+)
+
+// NewProviders creates empty provider cache.
+func NewProviders() *Providers {
+	return &Providers{providers: make(map[string]*Provider)}
+}
+
+// Providers caches the providers of all the apps, so their configuration and keys are not fetched on every sign-in.
+type Providers struct {
+	mu        sync.Mutex
+	providers map[string]*Provider
+}
+
+// Provider returns the app's provider. It is created again when the app changes the provider settings.
+func (ps *Providers) Provider(appID string, settings model.OIDCProviderSettings) *Provider {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	key := appID + "/" + strings.ToLower(settings.Name)
+	if p, ok := ps.providers[key]; ok && reflect.DeepEqual(p.settings, settings) {
+		return p
+	}
+
+	p := NewProvider(settings)
+	ps.providers[key] = p
+	return p
+}
+
+// AppProvider implements model.FederatedProviderSource, it returns the app's provider with the name.
+func (ps *Providers) AppProvider(app model.AppData, name string) (model.FederatedProvider, bool) {
+	settings, ok := app.OIDCProvider(name)
+	if !ok {
+		return nil, false
+	}
+	return ps.Provider(app.ID, settings), true
+}
+
+// AppProviderNames implements model.FederatedProviderSource.
+func (ps *Providers) AppProviderNames(app model.AppData) []string {
+	names := make([]string, len(app.OIDCProviders))
+	for i, p := range app.OIDCProviders {
+		names[i] = p.Name
+	}
+	return names
+}
@@ -0,0 +1,20 @@
+package registry
+
+import (
+	"github.com/madappgang/identifo/identity_providers/apple"
+	"github.com/madappgang/identifo/identity_providers/facebook"
+	"github.com/madappgang/identifo/identity_providers/google"
+	"github.com/madappgang/identifo/identity_providers/oidc"
+	"github.com/madappgang/identifo/model"
+)
+
+// NewDefault creates the registry of the built-in federated providers:
+// Facebook, Apple, Google, which ID token keys are published at googleJWKSURL, and the apps' OpenID Connect providers.
+func NewDefault(googleJWKSURL string) *model.FederatedProviderRegistry {
+	r := model.NewFederatedProviderRegistry()
+	r.Register(facebook.NewProvider())
+	r.Register(apple.NewProvider())
+	r.Register(google.NewProvider(googleJWKSURL))
+	r.RegisterSource(oidc.NewProviders())
+	return r
+}
@@ -0,0 +1,113 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+
+	jwt "github.com/form3tech-oss/jwt-go"
+)
+
+var (
+	// ErrNotEdPrivateKey is when the key is not a valid Ed25519 private key.
+	ErrNotEdPrivateKey = errors.New("Key is not a valid Ed25519 private key")
+	// ErrNotEdPublicKey is when the key is not a valid Ed25519 public key.
+	ErrNotEdPublicKey = errors.New("Key is not a valid Ed25519 public key")
+)
+
+// SigningMethodEd25519 implements EdDSA signing method with Ed25519 keys (RFC 8037).
+// jwt-go does not support it, so we register it ourselves.
+type SigningMethodEd25519 struct{}
+
+// SigningMethodEdDSA is EdDSA signing method instance.
+var SigningMethodEdDSA *SigningMethodEd25519
+
+func init() {
+	SigningMethodEdDSA = &SigningMethodEd25519{}
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+// Alg implements jwt.SigningMethod.
+func (m *SigningMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+// Verify implements jwt.SigningMethod. Key must be ed25519.PublicKey.
+func (m *SigningMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKey
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Sign implements jwt.SigningMethod. Key must be ed25519.PrivateKey.
+func (m *SigningMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKey
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// ParseEdPrivateKeyFromPEM parses PKCS #8 encoded Ed25519 private key.
+func ParseEdPrivateKeyFromPEM(b []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrNotEdPrivateKey
+	}
+	return privateKey, nil
+}
+
+// ParseEdPublicKeyFromPEM parses PKIX encoded Ed25519 public key.
+func ParseEdPublicKeyFromPEM(b []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrNotEdPublicKey
+	}
+	return publicKey, nil
+}
+
+func generateEdKeys() (interface{}, interface{}, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privateKey, publicKey, nil
+}
@@ -0,0 +1,25 @@
+package jwt
+
+import (
+	jwt "github.com/form3tech-oss/jwt-go"
+)
+
+// UserInfo is a set of standard OpenID Connect claims about the user.
+// More info: https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims.
+type UserInfo struct {
+	PreferredUsername   string `json:"preferred_username,omitempty"`
+	Email               string `json:"email,omitempty"`
+	EmailVerified       *bool  `json:"email_verified,omitempty"`
+	PhoneNumber         string `json:"phone_number,omitempty"`
+	PhoneNumberVerified *bool  `json:"phone_number_verified,omitempty"`
+}
+
+// IDTokenClaims is a set of OpenID Connect ID token claims.
+// More info: https://openid.net/specs/openid-connect-core-1_0.html#IDToken.
+type IDTokenClaims struct {
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time,omitempty"`
+	AtHash   string `json:"at_hash,omitempty"`
+	UserInfo
+	jwt.StandardClaims
+}
@@ -0,0 +1,152 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+
+	jwt "github.com/form3tech-oss/jwt-go"
+)
+
+// rsaKeySize is a size of generated RSA keys.
+const rsaKeySize = 2048
+
+// KeyID returns public key ID, using SHA-1 fingerprint.
+func KeyID(publicKey interface{}) string {
+	if der, err := x509.MarshalPKIXPublicKey(publicKey); err == nil {
+		s := sha1.Sum(der)
+		return base64.RawURLEncoding.EncodeToString(s[:]) // slice from [20]byte
+	}
+	return ""
+}
+
+// GenerateKeys generates new private and public keys for the algorithm.
+func GenerateKeys(alg TokenSignatureAlgorithm) (interface{}, interface{}, error) {
+	switch alg {
+	case TokenSignatureAlgorithmES256, TokenSignatureAlgorithmES384, TokenSignatureAlgorithmES512:
+		privateKey, err := ecdsa.GenerateKey(ecCurves[alg], rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return privateKey, &privateKey.PublicKey, nil
+	case TokenSignatureAlgorithmEdDSA:
+		return generateEdKeys()
+	case TokenSignatureAlgorithmRS256, TokenSignatureAlgorithmPS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+		if err != nil {
+			return nil, nil, err
+		}
+		return privateKey, &privateKey.PublicKey, nil
+	default:
+		return nil, nil, ErrWrongSignatureAlgorithm
+	}
+}
+
+// MarshalPrivateKeyToPEM encodes private key to PEM, in the form LoadPrivateKeyFromPEM understands.
+func MarshalPrivateKeyToPEM(privateKey interface{}) ([]byte, error) {
+	switch k := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}), nil
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	default:
+		return nil, ErrWrongSignatureAlgorithm
+	}
+}
+
+// MarshalPublicKeyToPEM encodes public key to PEM.
+func MarshalPublicKeyToPEM(publicKey interface{}) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKeyFromPEM parses private key from PEM bytes.
+func ParsePrivateKeyFromPEM(b []byte, alg TokenSignatureAlgorithm) (interface{}, error) {
+	switch alg {
+	case TokenSignatureAlgorithmES256, TokenSignatureAlgorithmES384, TokenSignatureAlgorithmES512:
+		privateKey, err := jwt.ParseECPrivateKeyFromPEM(b)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCurve(privateKey.Curve, alg); err != nil {
+			return nil, err
+		}
+		return privateKey, nil
+	case TokenSignatureAlgorithmRS256, TokenSignatureAlgorithmPS256:
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(b)
+		if err != nil {
+			return nil, err
+		}
+		return privateKey, nil
+	case TokenSignatureAlgorithmEdDSA:
+		privateKey, err := ParseEdPrivateKeyFromPEM(b)
+		if err != nil {
+			return nil, err
+		}
+		return privateKey, nil
+	default:
+		return nil, ErrWrongSignatureAlgorithm
+	}
+}
+
+// ParsePublicKeyFromPEM parses public key from PEM bytes.
+func ParsePublicKeyFromPEM(b []byte, alg TokenSignatureAlgorithm) (interface{}, error) {
+	switch alg {
+	case TokenSignatureAlgorithmES256, TokenSignatureAlgorithmES384, TokenSignatureAlgorithmES512:
+		publicKey, err := jwt.ParseECPublicKeyFromPEM(b)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCurve(publicKey.Curve, alg); err != nil {
+			return nil, err
+		}
+		return publicKey, nil
+	case TokenSignatureAlgorithmRS256, TokenSignatureAlgorithmPS256:
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM(b)
+		if err != nil {
+			return nil, err
+		}
+		return publicKey, nil
+	case TokenSignatureAlgorithmEdDSA:
+		publicKey, err := ParseEdPublicKeyFromPEM(b)
+		if err != nil {
+			return nil, err
+		}
+		return publicKey, nil
+	default:
+		return nil, ErrWrongSignatureAlgorithm
+	}
+}
+
+// checkCurve ensures that ECDSA key curve matches the algorithm, so the algorithm could be detected by the key.
+func checkCurve(curve elliptic.Curve, alg TokenSignatureAlgorithm) error {
+	if curve != ecCurves[alg] {
+		return ErrWrongSignatureAlgorithm
+	}
+	return nil
+}
+
+var ecCurves = map[TokenSignatureAlgorithm]elliptic.Curve{
+	TokenSignatureAlgorithmES256: elliptic.P256(),
+	TokenSignatureAlgorithmES384: elliptic.P384(),
+	TokenSignatureAlgorithmES512: elliptic.P521(),
+}
@@ -9,8 +9,10 @@ func (e Error) Description() string {
 }
 
 var descriptions = map[Error]string{
-	ErrorTokenIsEmpty:   "Bearer JWT token is missing",
-	ErrorTokenIsInvalid: "Token validation failed",
+	ErrorTokenIsEmpty:    "Bearer JWT token is missing",
+	ErrorTokenIsInvalid:  "Token validation failed",
+	ErrorTokenACRTooLow:  "Token authentication context class is lower than required",
+	ErrorTokenAuthTooOld: "User has authenticated too long ago",
 }
 
 const (
@@ -18,4 +20,8 @@ const (
 	ErrorTokenIsEmpty = "middleware.token_is_empty"
 	//ErrorTokenIsInvalid token validation failed
 	ErrorTokenIsInvalid = "middleware.token_is_invalid"
+	//ErrorTokenACRTooLow means that user has to pass one more factor, with the step-up endpoint
+	ErrorTokenACRTooLow = "middleware.token_acr_too_low"
+	//ErrorTokenAuthTooOld means that user has to authenticate again, with the step-up endpoint
+	ErrorTokenAuthTooOld = "middleware.token_auth_too_old"
 )
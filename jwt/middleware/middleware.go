@@ -3,6 +3,8 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/madappgang/identifo/jwt"
 	"github.com/madappgang/identifo/jwt/validator"
@@ -63,6 +65,41 @@ func JWT(eh ErrorHandler, c validator.Config) (Handler, error) {
 	}, nil
 }
 
+//AuthContext returns middleware function which demands the user of the token to have authenticated strong enough and recently enough.
+//It should follow JWT middleware, which puts the token to the context.
+//minACR is the lowest acr claim the token could have, like model.ACRMultiFactor, empty to accept any.
+//maxAge is how long ago the user could have authenticated, zero to accept any time.
+//The user gets the token with the higher acr and the fresh auth_time from the step-up endpoint.
+func AuthContext(eh ErrorHandler, minACR string, maxAge time.Duration) Handler {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		token := TokenFromContext(r.Context())
+		if acrLevel(token.ACR()) < acrLevel(minACR) {
+			rw.Header().Set("WWW-Authenticate", `Bearer error="insufficient_user_authentication", acr_values="`+minACR+`"`)
+			eh.Error(rw, ErrorTokenACRTooLow, http.StatusUnauthorized, "")
+			return
+		}
+
+		if maxAge > 0 {
+			authTime := token.AuthTime()
+			if authTime.IsZero() || time.Since(authTime) > maxAge {
+				rw.Header().Set("WWW-Authenticate", `Bearer error="insufficient_user_authentication", max_age=`+strconv.Itoa(int(maxAge.Seconds())))
+				eh.Error(rw, ErrorTokenAuthTooOld, http.StatusUnauthorized, "")
+				return
+			}
+		}
+		next.ServeHTTP(rw, r)
+	}
+}
+
+// acrLevel returns the strength of the authentication context class, zero for the unknown ones.
+func acrLevel(acr string) int {
+	level, err := strconv.Atoi(acr)
+	if err != nil {
+		return 0
+	}
+	return level
+}
+
 // TokenFromContext returns token from request context.
 func TokenFromContext(ctx context.Context) jwt.Token {
 	return ctx.Value(model.TokenContextKey).(jwt.Token)
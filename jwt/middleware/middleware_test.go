@@ -1,12 +1,18 @@
 package middleware_test
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
+	jwtgo "github.com/form3tech-oss/jwt-go"
+	"github.com/madappgang/identifo/jwt"
 	"github.com/madappgang/identifo/jwt/middleware"
 	"github.com/madappgang/identifo/jwt/validator"
+	"github.com/madappgang/identifo/model"
 )
 
 const (
@@ -86,3 +92,49 @@ type mockErrorHandler struct {
 func (m mockErrorHandler) Error(rw http.ResponseWriter, errorType middleware.Error, status int, description string) {
 	m.e = errorType
 }
+
+func TestAuthContext(t *testing.T) {
+	now := time.Now().Unix()
+	hourAgo := time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name     string
+		acr      string
+		authTime int64
+		minACR   string
+		maxAge   time.Duration
+		want     middleware.Error
+	}{
+		{"no requirements", "", 0, "", 0, ""},
+		{"multi-factor", model.ACRMultiFactor, now, model.ACRMultiFactor, 0, ""},
+		{"single factor", model.ACRSingleFactor, now, model.ACRMultiFactor, 0, middleware.ErrorTokenACRTooLow},
+		{"no acr", "", now, model.ACRSingleFactor, 0, middleware.ErrorTokenACRTooLow},
+		{"recent auth", model.ACRSingleFactor, now, "", 5 * time.Minute, ""},
+		{"old auth", model.ACRMultiFactor, hourAgo, model.ACRMultiFactor, 5 * time.Minute, middleware.ErrorTokenAuthTooOld},
+		{"no auth time", model.ACRMultiFactor, 0, model.ACRMultiFactor, 5 * time.Minute, middleware.ErrorTokenAuthTooOld},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := &jwt.JWToken{JWT: &jwtgo.Token{Claims: &jwt.Claims{ACR: tt.acr, AuthTime: tt.authTime}}}
+			req := httptest.NewRequest(http.MethodGet, "/transfer", nil)
+			req = req.WithContext(context.WithValue(req.Context(), model.TokenContextKey, jwt.Token(token)))
+
+			eh := &recordingErrorHandler{}
+			passed := false
+			middleware.AuthContext(eh, tt.minACR, tt.maxAge)(httptest.NewRecorder(), req, func(rw http.ResponseWriter, r *http.Request) {
+				passed = true
+			})
+			if eh.e != tt.want || passed != (tt.want == "") {
+				t.Errorf("AuthContext() error = %v, passed %v, want error %v", eh.e, passed, tt.want)
+			}
+		})
+	}
+}
+
+type recordingErrorHandler struct {
+	e middleware.Error
+}
+
+func (h *recordingErrorHandler) Error(rw http.ResponseWriter, errorType middleware.Error, status int, description string) {
+	h.e = errorType
+}
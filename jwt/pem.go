@@ -2,11 +2,17 @@ package jwt
 
 import (
 	"io/ioutil"
-
-	jwt "github.com/form3tech-oss/jwt-go"
 )
 
-var supportedSignatureAlgorithms = []TokenSignatureAlgorithm{TokenSignatureAlgorithmES256, TokenSignatureAlgorithmRS256}
+// supportedSignatureAlgorithms are the algorithms we could detect by the key.
+// PS256 uses the same keys as RS256, so it should be set explicitly.
+var supportedSignatureAlgorithms = []TokenSignatureAlgorithm{
+	TokenSignatureAlgorithmES256,
+	TokenSignatureAlgorithmES384,
+	TokenSignatureAlgorithmES512,
+	TokenSignatureAlgorithmRS256,
+	TokenSignatureAlgorithmEdDSA,
+}
 
 // LoadPrivateKeyFromPEM loads private key from PEM file.
 func LoadPrivateKeyFromPEM(file string, alg TokenSignatureAlgorithm) (interface{}, error) {
@@ -15,21 +21,7 @@ func LoadPrivateKeyFromPEM(file string, alg TokenSignatureAlgorithm) (interface{
 		return nil, err
 	}
 
-	var privateKey interface{}
-	switch alg {
-	case TokenSignatureAlgorithmES256:
-		privateKey, err = jwt.ParseECPrivateKeyFromPEM(prkb)
-	case TokenSignatureAlgorithmRS256:
-		privateKey, err = jwt.ParseRSAPrivateKeyFromPEM(prkb)
-	default:
-		return nil, ErrWrongSignatureAlgorithm
-	}
-
-	if err != nil {
-		return nil, err
-	}
-	return privateKey, nil
-
+	return ParsePrivateKeyFromPEM(prkb, alg)
 }
 
 // LoadPublicKeyFromPEM loads public key from PEM file.
@@ -44,20 +36,7 @@ func LoadPublicKeyFromPEM(file string, alg TokenSignatureAlgorithm) (interface{}
 		return nil, err
 	}
 
-	var publicKey interface{}
-	switch alg {
-	case TokenSignatureAlgorithmES256:
-		publicKey, err = jwt.ParseECPublicKeyFromPEM(pkb)
-	case TokenSignatureAlgorithmRS256:
-		publicKey, err = jwt.ParseRSAPublicKeyFromPEM(pkb)
-	default:
-		return nil, ErrWrongSignatureAlgorithm
-	}
-
-	if err != nil {
-		return nil, err
-	}
-	return publicKey, nil
+	return ParsePublicKeyFromPEM(pkb, alg)
 }
 
 //LoadPublicKeyFromPEMAuto loads keys from pem file with key algorithm auto detection
@@ -81,22 +60,7 @@ func LoadPublicKeyFromString(s string, alg TokenSignatureAlgorithm) (interface{}
 		return k, e
 	}
 
-	var publicKey interface{}
-	var err error
-
-	switch alg {
-	case TokenSignatureAlgorithmES256:
-		publicKey, err = jwt.ParseECPublicKeyFromPEM([]byte(s))
-	case TokenSignatureAlgorithmRS256:
-		publicKey, err = jwt.ParseRSAPublicKeyFromPEM([]byte(s))
-	default:
-		return nil, ErrWrongSignatureAlgorithm
-	}
-
-	if err != nil {
-		return nil, err
-	}
-	return publicKey, nil
+	return ParsePublicKeyFromPEM([]byte(s), alg)
 }
 
 //LoadPublicKeyFromStringAuto loads keys from string with key algorithm auto detection
@@ -1,17 +1,17 @@
 package service
 
 import (
-	"crypto/sha1"
-	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	jwt "github.com/form3tech-oss/jwt-go"
 	ijwt "github.com/madappgang/identifo/jwt"
 	jwtValidator "github.com/madappgang/identifo/jwt/validator"
 	"github.com/madappgang/identifo/model"
+	"github.com/rs/xid"
 )
 
 var (
@@ -25,6 +25,12 @@ var (
 	ErrInvalidOfflineScope = errors.New("Requested scope don't have offline value")
 	// ErrInvalidUser is when the user cannot obtain the new token.
 	ErrInvalidUser = errors.New("The user cannot obtain the new token")
+	// ErrInvalidScope is when the app is not allowed to request the scope.
+	ErrInvalidScope = errors.New("Requested scope is not allowed for the application")
+	// ErrUnknownKey is when the token key ID does not match any key of the key set.
+	ErrUnknownKey = errors.New("Token is signed with unknown key")
+	// ErrTokenFamilyRevoked is when the refresh token family has been revoked, so no more tokens are issued in it.
+	ErrTokenFamilyRevoked = errors.New("Refresh token family has been revoked")
 
 	// TokenLifespan is a token expiration time, one week.
 	TokenLifespan = int64(604800) // int64(1*7*24*60*60)
@@ -32,11 +38,37 @@ var (
 	InviteTokenLifespan = int64(3600) // int64(1*60*60)
 	// RefreshTokenLifespan is a default expiration time for refresh tokens, one year.
 	RefreshTokenLifespan = int64(31536000) // int(365*24*60*60)
+	// AuthorizationCodeLifespan is an OAuth 2.0 authorization code expiration time, five minutes.
+	AuthorizationCodeLifespan = int64(300) // int64(5*60)
+	// WebAuthnSessionLifespan is a WebAuthn ceremony session expiration time, five minutes.
+	WebAuthnSessionLifespan = int64(300) // int64(5*60)
+	// TFAChallengeLifespan is how long the user has to pass the second factor on the web login page, ten minutes.
+	TFAChallengeLifespan = int64(600) // int64(10*60)
+	// MagicLinkLifespan is how long the link in the email signs the user in, fifteen minutes.
+	MagicLinkLifespan = int64(900) // int64(15*60)
 )
 
 const (
 	// PayloadName is a JWT token payload "name".
 	PayloadName = "name"
+	// PayloadRedirectURI is an authorization code payload "redirect_uri".
+	PayloadRedirectURI = "redirect_uri"
+	// PayloadCodeChallenge is an authorization code payload "code_challenge".
+	PayloadCodeChallenge = "code_challenge"
+	// PayloadNonce is an authorization code payload "nonce".
+	PayloadNonce = "nonce"
+	// PayloadAuthTime is an authorization code payload "auth_time".
+	PayloadAuthTime = "auth_time"
+	// PayloadAMR is an authorization code payload "amr".
+	PayloadAMR = "amr"
+	// PayloadACR is an authorization code payload "acr".
+	PayloadACR = "acr"
+	// PayloadWebAuthnCeremony is a WebAuthn session payload "ceremony".
+	PayloadWebAuthnCeremony = "ceremony"
+	// PayloadWebAuthnChallenge is a WebAuthn session payload "challenge".
+	PayloadWebAuthnChallenge = "challenge"
+	// PayloadEmail is a magic link payload "email".
+	PayloadEmail = "email"
 )
 
 // NewJWTokenService returns new JWT token service.
@@ -44,13 +76,37 @@ const (
 // - privateKeyPath - the path to the private key in pem format. Please keep it in a secret place.
 // - publicKeyPath - the path to the public key.
 func NewJWTokenService(keys *model.JWTKeys, issuer string, tokenStorage model.TokenStorage, appStorage model.AppStorage, userStorage model.UserStorage, options ...func(TokenService) error) (TokenService, error) {
-	if keys == nil || keys.Private == nil || keys.Public == nil {
-		return nil, fmt.Errorf("One of the keys is empty, or both")
+	keySet, err := model.NewJWTKeySet(keys)
+	if err != nil {
+		return nil, err
+	}
+	return NewJWTokenServiceWithKeySet(keySet, issuer, tokenStorage, appStorage, userStorage, options...)
+}
+
+// NewJWTokenServiceWithKeySet returns new JWT token service, which signs tokens with the active key of the key set,
+// and verifies them with any key of the key set which is not retired yet.
+// All the keys of the key set should use the same algorithm.
+func NewJWTokenServiceWithKeySet(keySet *model.JWTKeySet, issuer string, tokenStorage model.TokenStorage, appStorage model.AppStorage, userStorage model.UserStorage, options ...func(TokenService) error) (TokenService, error) {
+	if keySet == nil {
+		return nil, fmt.Errorf("Key set is empty")
 	}
 
-	tokenServiceAlg, ok := keys.Algorithm.(ijwt.TokenSignatureAlgorithm)
-	if !ok || tokenServiceAlg == ijwt.TokenSignatureAlgorithmAuto {
-		return nil, fmt.Errorf("Unknown token service algorithm %s ", keys.Algorithm)
+	signingKey, ok := keySet.SigningKey(ijwt.TimeFunc())
+	if !ok {
+		return nil, fmt.Errorf("Key set has no active key")
+	}
+
+	tokenServiceAlg := signingKey.Algorithm
+	if tokenServiceAlg == ijwt.TokenSignatureAlgorithmAuto {
+		return nil, fmt.Errorf("Unknown token service algorithm %s ", tokenServiceAlg)
+	}
+	for _, k := range keySet.VerificationKeys(ijwt.TimeFunc()) {
+		if k.Private == nil || k.Public == nil {
+			return nil, fmt.Errorf("Key of version %d is empty", k.Version)
+		}
+		if k.Algorithm != tokenServiceAlg {
+			return nil, fmt.Errorf("Key of version %d has algorithm %s, expected %s", k.Version, k.Algorithm, tokenServiceAlg)
+		}
 	}
 
 	t := &JWTokenService{
@@ -61,8 +117,7 @@ func NewJWTokenService(keys *model.JWTKeys, issuer string, tokenStorage model.To
 		resetTokenLifespan:     int64(2 * 60 * 60),      // 2 hours is a default expiration time for refresh tokens.
 		webCookieTokenLifespan: int64(2 * 24 * 60 * 60), // 2 days is a default default expiration time for access tokens.
 		algorithm:              tokenServiceAlg,
-		privateKey:             keys.Private,
-		publicKey:              keys.Public,
+		keySet:                 keySet,
 	}
 
 	// Apply options.
@@ -77,8 +132,7 @@ func NewJWTokenService(keys *model.JWTKeys, issuer string, tokenStorage model.To
 
 // JWTokenService is a JWT token service.
 type JWTokenService struct {
-	privateKey             interface{} // *ecdsa.PrivateKey, or *rsa.PrivateKey
-	publicKey              interface{} // *ecdsa.PublicKey, or *rsa.PublicKey
+	keySet                 *model.JWTKeySet // private keys are *ecdsa.PrivateKey, or *rsa.PrivateKey
 	tokenStorage           model.TokenStorage
 	appStorage             model.AppStorage
 	userStorage            model.UserStorage
@@ -95,28 +149,34 @@ func (ts *JWTokenService) Issuer() string {
 
 // Algorithm  returns signature algorithm.
 func (ts *JWTokenService) Algorithm() string {
-	switch ts.algorithm {
-	case ijwt.TokenSignatureAlgorithmES256:
-		return "ES256"
-	case ijwt.TokenSignatureAlgorithmRS256:
-		return "RS256"
-	default:
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
 		return ""
 	}
+	return sm.Alg()
 }
 
-// PublicKey returns public key.
+// PublicKey returns public key of the signing key.
 func (ts *JWTokenService) PublicKey() interface{} {
-	return ts.publicKey
+	return ts.signingKey().Public
 }
 
-// KeyID returns public key ID, using SHA-1 fingerprint.
+// KeyID returns ID of the signing key, using SHA-1 fingerprint.
 func (ts *JWTokenService) KeyID() string {
-	if der, err := x509.MarshalPKIXPublicKey(ts.publicKey); err == nil {
-		s := sha1.Sum(der)
-		return base64.RawURLEncoding.EncodeToString(s[:]) // slice from [20]byte
-	}
-	return ""
+	return ts.signingKey().ID()
+}
+
+// VerificationKeys returns all the keys tokens could be verified with, including the next key.
+func (ts *JWTokenService) VerificationKeys() []model.JWTKey {
+	return ts.keySet.VerificationKeys(ijwt.TimeFunc())
+}
+
+// signingKey returns the key to sign new tokens with.
+// Retirement of the current key is never scheduled before the next key activation,
+// so there is always an active key.
+func (ts *JWTokenService) signingKey() model.JWTKey {
+	k, _ := ts.keySet.SigningKey(ijwt.TimeFunc())
+	return k
 }
 
 // WebCookieTokenLifespan return auth token lifespan
@@ -129,9 +189,16 @@ func (ts *JWTokenService) Parse(s string) (ijwt.Token, error) {
 	tokenString := strings.TrimSpace(s)
 
 	token, err := jwt.ParseWithClaims(tokenString, &ijwt.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// since we only use the one private key to sign the tokens,
-		// we also only use its public counterpart to verify them.
-		return ts.publicKey, nil
+		// Tokens without key ID could be signed by the signing key only.
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return ts.signingKey().Public, nil
+		}
+		k, ok := ts.keySet.VerificationKey(kid, ijwt.TimeFunc())
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		return k.Public, nil
 	})
 	if err != nil {
 		return nil, err
@@ -159,7 +226,8 @@ func (ts *JWTokenService) ValidateTokenString(tstr string, v jwtValidator.Valida
 }
 
 // NewAccessToken creates new access token for user.
-func (ts *JWTokenService) NewAccessToken(u model.User, scopes []string, app model.AppData, requireTFA bool, tokenPayload map[string]interface{}) (ijwt.Token, error) {
+// Auth context tells how and when the user has authenticated, it is empty for the tokens issued without authentication, like refreshed ones.
+func (ts *JWTokenService) NewAccessToken(u model.User, scopes []string, app model.AppData, requireTFA bool, tokenPayload map[string]interface{}, authCtx model.AuthContext) (ijwt.Token, error) {
 	if !app.Active {
 		return nil, ErrInvalidApp
 	}
@@ -191,9 +259,12 @@ func (ts *JWTokenService) NewAccessToken(u model.User, scopes []string, app mode
 	}
 
 	claims := ijwt.Claims{
-		Scopes:  strings.Join(scopes, " "),
-		Payload: payload,
-		Type:    tokenType,
+		Scopes:   strings.Join(scopes, " "),
+		Payload:  payload,
+		Type:     tokenType,
+		AuthTime: authCtx.Time,
+		AMR:      authCtx.Methods,
+		ACR:      authCtx.ACR,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: (now + lifespan),
 			Issuer:    ts.issuer,
@@ -203,14 +274,54 @@ func (ts *JWTokenService) NewAccessToken(u model.User, scopes []string, app mode
 		},
 	}
 
-	var sm jwt.SigningMethod
-	switch ts.algorithm {
-	case ijwt.TokenSignatureAlgorithmES256:
-		sm = jwt.SigningMethodES256
-	case ijwt.TokenSignatureAlgorithmRS256:
-		sm = jwt.SigningMethodRS256
-	default:
-		return nil, ijwt.ErrWrongSignatureAlgorithm
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	token := ijwt.NewTokenWithClaims(sm, ts.KeyID(), claims)
+	if token == nil {
+		return nil, ErrCreatingToken
+	}
+	return &ijwt.JWToken{JWT: token, New: true}, nil
+}
+
+// NewClientAccessToken creates new access token for the service app itself, with the app as a subject.
+// It is used for client credentials grant, requested scopes must be allowed by the app.
+func (ts *JWTokenService) NewClientAccessToken(app model.AppData, scopes []string) (ijwt.Token, error) {
+	if !app.Active || app.Type != model.Service {
+		return nil, ErrInvalidApp
+	}
+
+	// The app gets only the scopes it is allowed, the one without scopes gets none.
+	for _, s := range scopes {
+		if !contains(app.Scopes, s) {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	now := ijwt.TimeFunc().Unix()
+
+	lifespan := app.TokenLifespan
+	if lifespan == 0 {
+		lifespan = TokenLifespan
+	}
+
+	claims := ijwt.Claims{
+		Scopes: strings.Join(scopes, " "),
+		Type:   model.TokenTypeAccess,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: (now + lifespan),
+			Issuer:    ts.issuer,
+			Subject:   app.ID,
+			Audience:  []string{app.ID},
+			IssuedAt:  now,
+		},
+	}
+
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
+		return nil, err
 	}
 
 	token := ijwt.NewTokenWithClaims(sm, ts.KeyID(), claims)
@@ -220,8 +331,37 @@ func (ts *JWTokenService) NewAccessToken(u model.User, scopes []string, app mode
 	return &ijwt.JWToken{JWT: token, New: true}, nil
 }
 
-// NewRefreshToken creates new refresh token.
+// NewRefreshToken creates new refresh token, which starts the new token family.
 func (ts *JWTokenService) NewRefreshToken(u model.User, scopes []string, app model.AppData) (ijwt.Token, error) {
+	return ts.newRefreshToken(u, scopes, app, xid.New().String(), ts.tokenStorage.SaveTokenFamily)
+}
+
+// RotateRefreshToken creates new refresh token in exchange for the current token of the family,
+// and makes it the current one. Tokens issued before families were introduced have no family,
+// then the new family is started.
+// The token is issued only if currentToken is still the current one, so concurrent exchanges
+// of the same token get one new token, and the family revoked in between stays revoked.
+func (ts *JWTokenService) RotateRefreshToken(u model.User, scopes []string, app model.AppData, familyID, currentToken string) (ijwt.Token, error) {
+	if len(familyID) == 0 {
+		return ts.NewRefreshToken(u, scopes, app)
+	}
+
+	family, err := ts.tokenStorage.TokenFamily(familyID)
+	if err != nil {
+		return nil, err
+	}
+	if family.Revoked || family.UserID != u.ID || family.AppID != app.ID {
+		return nil, ErrTokenFamilyRevoked
+	}
+
+	swap := func(family model.TokenFamily) error {
+		return ts.tokenStorage.SwapTokenFamily(family, currentToken)
+	}
+	return ts.newRefreshToken(u, scopes, app, familyID, swap)
+}
+
+// newRefreshToken creates new refresh token of the family, and makes it the current one with saveFamily.
+func (ts *JWTokenService) newRefreshToken(u model.User, scopes []string, app model.AppData, familyID string, saveFamily func(model.TokenFamily) error) (ijwt.Token, error) {
 	if !app.Active || !app.Offline {
 		return nil, ErrInvalidApp
 	}
@@ -246,10 +386,13 @@ func (ts *JWTokenService) NewRefreshToken(u model.User, scopes []string, app mod
 	}
 
 	claims := ijwt.Claims{
-		Scopes:  strings.Join(scopes, " "),
-		Payload: payload,
-		Type:    model.TokenTypeRefresh,
+		Scopes:   strings.Join(scopes, " "),
+		Payload:  payload,
+		Type:     model.TokenTypeRefresh,
+		FamilyID: familyID,
 		StandardClaims: jwt.StandardClaims{
+			// Unique ID tells apart the tokens of the family, even if they are issued within the same second.
+			Id:        xid.New().String(),
 			ExpiresAt: (now + lifespan),
 			Issuer:    ts.issuer,
 			Subject:   u.ID,
@@ -258,14 +401,9 @@ func (ts *JWTokenService) NewRefreshToken(u model.User, scopes []string, app mod
 		},
 	}
 
-	var sm jwt.SigningMethod
-	switch ts.algorithm {
-	case ijwt.TokenSignatureAlgorithmES256:
-		sm = jwt.SigningMethodES256
-	case ijwt.TokenSignatureAlgorithmRS256:
-		sm = jwt.SigningMethodRS256
-	default:
-		return nil, ijwt.ErrWrongSignatureAlgorithm
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
+		return nil, err
 	}
 
 	token := ijwt.NewTokenWithClaims(sm, ts.KeyID(), claims)
@@ -282,6 +420,22 @@ func (ts *JWTokenService) NewRefreshToken(u model.User, scopes []string, app mod
 	if err := ts.tokenStorage.SaveToken(tokenString); err != nil {
 		return nil, ErrSavingToken
 	}
+
+	family := model.TokenFamily{
+		ID:           familyID,
+		UserID:       u.ID,
+		AppID:        app.ID,
+		CurrentToken: tokenString,
+		UpdatedAt:    time.Unix(now, 0),
+		ExpiresAt:    time.Unix(now+lifespan, 0),
+	}
+	if err := saveFamily(family); err == model.ErrorNotFound {
+		// The token is never returned, so it does not matter if it stays in the storage.
+		_ = ts.tokenStorage.DeleteToken(tokenString)
+		return nil, ErrTokenFamilyRevoked
+	} else if err != nil {
+		return nil, ErrSavingToken
+	}
 	return t, nil
 }
 
@@ -311,7 +465,7 @@ func (ts *JWTokenService) RefreshAccessToken(refreshToken ijwt.Token) (ijwt.Toke
 		return nil, ErrInvalidUser
 	}
 
-	token, err := ts.NewAccessToken(user, strings.Split(claims.Scopes, " "), app, false, nil)
+	token, err := ts.NewAccessToken(user, strings.Split(claims.Scopes, " "), app, false, nil, model.AuthContext{})
 	if err != nil {
 		return nil, err
 	}
@@ -354,14 +508,9 @@ func (ts *JWTokenService) NewInviteToken(email, role string) (ijwt.Token, error)
 		},
 	}
 
-	var sm jwt.SigningMethod
-	switch ts.algorithm {
-	case ijwt.TokenSignatureAlgorithmES256:
-		sm = jwt.SigningMethodES256
-	case ijwt.TokenSignatureAlgorithmRS256:
-		sm = jwt.SigningMethodRS256
-	default:
-		return nil, ijwt.ErrWrongSignatureAlgorithm
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
+		return nil, err
 	}
 
 	token := ijwt.NewTokenWithClaims(sm, ts.KeyID(), claims)
@@ -388,14 +537,9 @@ func (ts *JWTokenService) NewResetToken(userID string) (ijwt.Token, error) {
 		},
 	}
 
-	var sm jwt.SigningMethod
-	switch ts.algorithm {
-	case ijwt.TokenSignatureAlgorithmES256:
-		sm = jwt.SigningMethodES256
-	case ijwt.TokenSignatureAlgorithmRS256:
-		sm = jwt.SigningMethodRS256
-	default:
-		return nil, ijwt.ErrWrongSignatureAlgorithm
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
+		return nil, err
 	}
 
 	token := ijwt.NewTokenWithClaims(sm, ts.KeyID(), claims)
@@ -407,7 +551,8 @@ func (ts *JWTokenService) NewResetToken(userID string) (ijwt.Token, error) {
 }
 
 // NewWebCookieToken creates new web cookie token.
-func (ts *JWTokenService) NewWebCookieToken(u model.User) (ijwt.Token, error) {
+// It keeps how and when the user has authenticated, so the tokens issued from the web session tell it too.
+func (ts *JWTokenService) NewWebCookieToken(u model.User, authCtx model.AuthContext) (ijwt.Token, error) {
 	if !u.Active {
 		return nil, ErrInvalidUser
 	}
@@ -415,7 +560,10 @@ func (ts *JWTokenService) NewWebCookieToken(u model.User) (ijwt.Token, error) {
 	lifespan := ts.resetTokenLifespan
 
 	claims := ijwt.Claims{
-		Type: model.TokenTypeWebCookie,
+		Type:     model.TokenTypeWebCookie,
+		AuthTime: authCtx.Time,
+		AMR:      authCtx.Methods,
+		ACR:      authCtx.ACR,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: (now + lifespan),
 			Issuer:    ts.issuer,
@@ -425,14 +573,9 @@ func (ts *JWTokenService) NewWebCookieToken(u model.User) (ijwt.Token, error) {
 		},
 	}
 
-	var sm jwt.SigningMethod
-	switch ts.algorithm {
-	case ijwt.TokenSignatureAlgorithmES256:
-		sm = jwt.SigningMethodES256
-	case ijwt.TokenSignatureAlgorithmRS256:
-		sm = jwt.SigningMethodRS256
-	default:
-		return nil, ijwt.ErrWrongSignatureAlgorithm
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
+		return nil, err
 	}
 
 	token := ijwt.NewTokenWithClaims(sm, ts.KeyID(), claims)
@@ -443,6 +586,273 @@ func (ts *JWTokenService) NewWebCookieToken(u model.User) (ijwt.Token, error) {
 	return &ijwt.JWToken{JWT: token, New: true}, nil
 }
 
+// NewAuthorizationCode creates new short-lived OAuth 2.0 authorization code.
+// Payload keeps the request parameters the code is bound to, e.g. redirect URI and PKCE challenge.
+func (ts *JWTokenService) NewAuthorizationCode(u model.User, scopes []string, app model.AppData, payload map[string]interface{}) (ijwt.Token, error) {
+	if !app.Active {
+		return nil, ErrInvalidApp
+	}
+
+	if !u.Active {
+		return nil, ErrInvalidUser
+	}
+
+	now := ijwt.TimeFunc().Unix()
+
+	claims := ijwt.Claims{
+		Scopes:  strings.Join(scopes, " "),
+		Payload: payload,
+		Type:    model.TokenTypeAuthCode,
+		StandardClaims: jwt.StandardClaims{
+			Id:        xid.New().String(),
+			ExpiresAt: (now + AuthorizationCodeLifespan),
+			Issuer:    ts.issuer,
+			Subject:   u.ID,
+			Audience:  []string{app.ID},
+			IssuedAt:  now,
+		},
+	}
+
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	token := ijwt.NewTokenWithClaims(sm, ts.KeyID(), claims)
+	if token == nil {
+		return nil, ErrCreatingToken
+	}
+
+	return &ijwt.JWToken{JWT: token, New: true}, nil
+}
+
+// NewWebAuthnSession creates new short-lived session of the WebAuthn ceremony.
+// Payload keeps the ceremony state, i.e. the challenge the authenticator should sign.
+func (ts *JWTokenService) NewWebAuthnSession(u model.User, app model.AppData, payload map[string]interface{}) (ijwt.Token, error) {
+	if !app.Active {
+		return nil, ErrInvalidApp
+	}
+
+	if !u.Active {
+		return nil, ErrInvalidUser
+	}
+
+	now := ijwt.TimeFunc().Unix()
+
+	claims := ijwt.Claims{
+		Payload: payload,
+		Type:    model.TokenTypeWebAuthn,
+		StandardClaims: jwt.StandardClaims{
+			Id:        xid.New().String(),
+			ExpiresAt: (now + WebAuthnSessionLifespan),
+			Issuer:    ts.issuer,
+			Subject:   u.ID,
+			Audience:  []string{app.ID},
+			IssuedAt:  now,
+		},
+	}
+
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	token := ijwt.NewTokenWithClaims(sm, ts.KeyID(), claims)
+	if token == nil {
+		return nil, ErrCreatingToken
+	}
+
+	return &ijwt.JWToken{JWT: token, New: true}, nil
+}
+
+// NewTFAChallengeToken creates new short-lived token of the user who has passed the first factor on the web login page.
+// The user gets the web cookie token when they pass the second one, authCtx tells how they have passed the first.
+func (ts *JWTokenService) NewTFAChallengeToken(u model.User, app model.AppData, authCtx model.AuthContext) (ijwt.Token, error) {
+	if !app.Active {
+		return nil, ErrInvalidApp
+	}
+
+	if !u.Active {
+		return nil, ErrInvalidUser
+	}
+
+	now := ijwt.TimeFunc().Unix()
+
+	claims := ijwt.Claims{
+		Type:     model.TokenTypeTFAChallenge,
+		AuthTime: authCtx.Time,
+		AMR:      authCtx.Methods,
+		ACR:      authCtx.ACR,
+		StandardClaims: jwt.StandardClaims{
+			Id:        xid.New().String(),
+			ExpiresAt: (now + TFAChallengeLifespan),
+			Issuer:    ts.issuer,
+			Subject:   u.ID,
+			Audience:  []string{app.ID},
+			IssuedAt:  now,
+		},
+	}
+
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	token := ijwt.NewTokenWithClaims(sm, ts.KeyID(), claims)
+	if token == nil {
+		return nil, ErrCreatingToken
+	}
+
+	return &ijwt.JWToken{JWT: token, New: true}, nil
+}
+
+// NewTrustedDeviceToken creates new token of the device the user trusted after passing two-factor authentication.
+// Token ID is the device ID, so the device could be revoked.
+func (ts *JWTokenService) NewTrustedDeviceToken(u model.User, app model.AppData, deviceID string, expiresAt int64) (ijwt.Token, error) {
+	if !app.Active {
+		return nil, ErrInvalidApp
+	}
+
+	if !u.Active {
+		return nil, ErrInvalidUser
+	}
+
+	now := ijwt.TimeFunc().Unix()
+
+	claims := ijwt.Claims{
+		Type: model.TokenTypeTrustedDevice,
+		StandardClaims: jwt.StandardClaims{
+			Id:        deviceID,
+			ExpiresAt: expiresAt,
+			Issuer:    ts.issuer,
+			Subject:   u.ID,
+			Audience:  []string{app.ID},
+			IssuedAt:  now,
+		},
+	}
+
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	token := ijwt.NewTokenWithClaims(sm, ts.KeyID(), claims)
+	if token == nil {
+		return nil, ErrCreatingToken
+	}
+
+	return &ijwt.JWToken{JWT: token, New: true}, nil
+}
+
+// NewMagicLinkToken creates new short-lived token of the link, which signs in the owner of the email to the app.
+// There could be no user with this email yet, so the email is kept in the payload.
+func (ts *JWTokenService) NewMagicLinkToken(email string, app model.AppData) (ijwt.Token, error) {
+	if !app.Active {
+		return nil, ErrInvalidApp
+	}
+
+	now := ijwt.TimeFunc().Unix()
+
+	claims := ijwt.Claims{
+		Payload: map[string]interface{}{PayloadEmail: email},
+		Type:    model.TokenTypeMagicLink,
+		StandardClaims: jwt.StandardClaims{
+			Id:        xid.New().String(),
+			ExpiresAt: (now + MagicLinkLifespan),
+			Issuer:    ts.issuer,
+			Audience:  []string{app.ID},
+			IssuedAt:  now,
+		},
+	}
+
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	token := ijwt.NewTokenWithClaims(sm, ts.KeyID(), claims)
+	if token == nil {
+		return nil, ErrCreatingToken
+	}
+
+	return &ijwt.JWToken{JWT: token, New: true}, nil
+}
+
+// NewIDToken creates new OpenID Connect ID token.
+// Access token is used to compute "at_hash" claim, it could be empty.
+func (ts *JWTokenService) NewIDToken(u model.User, scopes []string, app model.AppData, accessToken, nonce string, authTime int64) (ijwt.Token, error) {
+	if !app.Active {
+		return nil, ErrInvalidApp
+	}
+
+	if !u.Active {
+		return nil, ErrInvalidUser
+	}
+
+	now := ijwt.TimeFunc().Unix()
+
+	lifespan := app.TokenLifespan
+	if lifespan == 0 {
+		lifespan = TokenLifespan
+	}
+
+	claims := ijwt.IDTokenClaims{
+		Nonce:    nonce,
+		AuthTime: authTime,
+		UserInfo: UserInfo(u, scopes),
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: (now + lifespan),
+			Issuer:    ts.issuer,
+			Subject:   u.ID,
+			Audience:  []string{app.ID},
+			IssuedAt:  now,
+		},
+	}
+
+	sm, err := ts.algorithm.SigningMethod()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := ts.algorithm.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	// at_hash is the base64url encoding of the left-most half of the access token hash.
+	if accessToken != "" {
+		h := hash.New()
+		h.Write([]byte(accessToken))
+		sum := h.Sum(nil)
+		claims.AtHash = base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+	}
+
+	token := ijwt.NewTokenWithClaims(sm, ts.KeyID(), claims)
+	if token == nil {
+		return nil, ErrCreatingToken
+	}
+
+	return &ijwt.JWToken{JWT: token, New: true}, nil
+}
+
+// UserInfo returns standard OpenID Connect claims about the user, allowed by the scopes.
+func UserInfo(u model.User, scopes []string) ijwt.UserInfo {
+	info := ijwt.UserInfo{}
+	if contains(scopes, ProfileScope) {
+		info.PreferredUsername = u.Username
+	}
+	if contains(scopes, EmailScope) && u.Email != "" {
+		verified := u.EmailVerified
+		info.Email = u.Email
+		info.EmailVerified = &verified
+	}
+	if contains(scopes, PhoneScope) && u.Phone != "" {
+		verified := u.PhoneVerified
+		info.PhoneNumber = u.Phone
+		info.PhoneNumberVerified = &verified
+	}
+	return info
+}
+
 // String returns string representation of a token.
 func (ts *JWTokenService) String(t ijwt.Token) (string, error) {
 	token, ok := t.(*ijwt.JWToken)
@@ -456,11 +866,27 @@ func (ts *JWTokenService) String(t ijwt.Token) (string, error) {
 	if !token.New && !token.JWT.Valid {
 		return "", ijwt.ErrTokenInvalid
 	}
+	// Signatures of some algorithms are randomized, so the new token is signed once,
+	// and the stored refresh token is exactly the one the client gets.
+	if token.New && len(token.JWT.Raw) > 0 {
+		return token.JWT.Raw, nil
+	}
+
+	// Token is signed with the key it has been created for.
+	signingKey := ts.signingKey()
+	if kid, _ := token.JWT.Header["kid"].(string); kid != "" && kid != signingKey.ID() {
+		k, ok := ts.keySet.VerificationKey(kid, ijwt.TimeFunc())
+		if !ok || k.StateAt(ijwt.TimeFunc()) != model.KeyStateActive {
+			return "", ErrUnknownKey
+		}
+		signingKey = k
+	}
 
-	str, err := token.JWT.SignedString(ts.privateKey)
+	str, err := token.JWT.SignedString(signingKey.Private)
 	if err != nil {
 		return "", err
 	}
+	token.JWT.Raw = str
 	return str, nil
 }
 
@@ -8,16 +8,32 @@ import (
 const (
 	// OfflineScope is a scope value to request refresh token.
 	OfflineScope = "offline"
+	// OpenIDScope is a scope value to request OpenID Connect ID token.
+	OpenIDScope = "openid"
+	// ProfileScope is a scope value to request user's profile claims.
+	ProfileScope = "profile"
+	// EmailScope is a scope value to request user's email claims.
+	EmailScope = "email"
+	// PhoneScope is a scope value to request user's phone number claims.
+	PhoneScope = "phone"
 )
 
 // TokenService is an abstract token manager.
 type TokenService interface {
-	NewAccessToken(u model.User, scopes []string, app model.AppData, requireTFA bool, tokenPayload map[string]interface{}) (ijwt.Token, error)
+	NewAccessToken(u model.User, scopes []string, app model.AppData, requireTFA bool, tokenPayload map[string]interface{}, authCtx model.AuthContext) (ijwt.Token, error)
 	NewRefreshToken(u model.User, scopes []string, app model.AppData) (ijwt.Token, error)
+	RotateRefreshToken(u model.User, scopes []string, app model.AppData, familyID, currentToken string) (ijwt.Token, error)
+	NewClientAccessToken(app model.AppData, scopes []string) (ijwt.Token, error)
 	RefreshAccessToken(token ijwt.Token) (ijwt.Token, error)
 	NewInviteToken(email, role string) (ijwt.Token, error)
 	NewResetToken(userID string) (ijwt.Token, error)
-	NewWebCookieToken(u model.User) (ijwt.Token, error)
+	NewWebCookieToken(u model.User, authCtx model.AuthContext) (ijwt.Token, error)
+	NewAuthorizationCode(u model.User, scopes []string, app model.AppData, payload map[string]interface{}) (ijwt.Token, error)
+	NewWebAuthnSession(u model.User, app model.AppData, payload map[string]interface{}) (ijwt.Token, error)
+	NewTFAChallengeToken(u model.User, app model.AppData, authCtx model.AuthContext) (ijwt.Token, error)
+	NewTrustedDeviceToken(u model.User, app model.AppData, deviceID string, expiresAt int64) (ijwt.Token, error)
+	NewMagicLinkToken(email string, app model.AppData) (ijwt.Token, error)
+	NewIDToken(u model.User, scopes []string, app model.AppData, accessToken, nonce string, authTime int64) (ijwt.Token, error)
 	Parse(string) (ijwt.Token, error)
 	String(ijwt.Token) (string, error)
 	Issuer() string
@@ -25,4 +41,5 @@ type TokenService interface {
 	WebCookieTokenLifespan() int64
 	PublicKey() interface{} // we are not using crypto.PublicKey here to avoid dependencies
 	KeyID() string
+	VerificationKeys() []model.JWTKey
 }
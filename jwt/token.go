@@ -25,6 +25,10 @@ type Token interface {
 	UserID() string
 	Type() string
 	Scopes() string
+	FamilyID() string
+	AuthTime() time.Time
+	AMR() []string
+	ACR() string
 	Payload() map[string]interface{}
 }
 
@@ -157,12 +161,54 @@ func (t *JWToken) Scopes() string {
 	return claims.Scopes
 }
 
+// FamilyID returns refresh token family ID.
+func (t *JWToken) FamilyID() string {
+	claims, ok := t.JWT.Claims.(*Claims)
+	if !ok {
+		return ""
+	}
+	return claims.FamilyID
+}
+
+// AuthTime returns the time the user has authenticated last, zero if the token does not tell it.
+func (t *JWToken) AuthTime() time.Time {
+	claims, ok := t.JWT.Claims.(*Claims)
+	if !ok || claims.AuthTime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.AuthTime, 0)
+}
+
+// AMR returns the authentication methods the user has passed.
+func (t *JWToken) AMR() []string {
+	claims, ok := t.JWT.Claims.(*Claims)
+	if !ok {
+		return []string{}
+	}
+	return claims.AMR
+}
+
+// ACR returns the authentication context class of the user.
+func (t *JWToken) ACR() string {
+	claims, ok := t.JWT.Claims.(*Claims)
+	if !ok {
+		return ""
+	}
+	return claims.ACR
+}
+
 // Claims is an extended claims structure.
 type Claims struct {
 	Payload map[string]interface{} `json:"payload,omitempty"`
 	Scopes  string                 `json:"scopes,omitempty"`
 	Type    string                 `json:"type,omitempty"`
 	KeyID   string                 `json:"kid,omitempty"` // optional keyID
+	// FamilyID groups refresh tokens, issued one in exchange for another, into a family.
+	FamilyID string `json:"fid,omitempty"`
+	// AuthTime, AMR and ACR tell when and how the user has authenticated.
+	AuthTime int64    `json:"auth_time,omitempty"`
+	AMR      []string `json:"amr,omitempty"`
+	ACR      string   `json:"acr,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -1,9 +1,14 @@
 package jwt
 
 import (
+	"crypto"
+	_ "crypto/sha256" // Register SHA-256 for at_hash calculation.
+	_ "crypto/sha512" // Register SHA-384 and SHA-512 for at_hash calculation.
 	"encoding/json"
 	"errors"
 	"fmt"
+
+	jwt "github.com/form3tech-oss/jwt-go"
 )
 
 var (
@@ -19,10 +24,15 @@ var (
 var StrToTokenSignAlg = map[string]TokenSignatureAlgorithm{
 	"es256": TokenSignatureAlgorithmES256,
 	"rs256": TokenSignatureAlgorithmRS256,
-	"auto":  TokenSignatureAlgorithmAuto}
+	"auto":  TokenSignatureAlgorithmAuto,
+	"es384": TokenSignatureAlgorithmES384,
+	"es512": TokenSignatureAlgorithmES512,
+	"ps256": TokenSignatureAlgorithmPS256,
+	"eddsa": TokenSignatureAlgorithmEdDSA,
+}
 
 // TokenSignatureAlgorithm is a signing algorithm used by the token service.
-// For now, we only support ES256 and RS256.
+// We support ES256, ES384, ES512, RS256, PS256 and EdDSA with Ed25519 keys.
 type TokenSignatureAlgorithm int
 
 const (
@@ -32,6 +42,14 @@ const (
 	TokenSignatureAlgorithmRS256
 	// TokenSignatureAlgorithmAuto tries to detect algorithm on the fly.
 	TokenSignatureAlgorithmAuto
+	// TokenSignatureAlgorithmES384 is a ES384 signature.
+	TokenSignatureAlgorithmES384
+	// TokenSignatureAlgorithmES512 is a ES512 signature.
+	TokenSignatureAlgorithmES512
+	// TokenSignatureAlgorithmPS256 is a PS256 signature, RSASSA-PSS with SHA-256.
+	TokenSignatureAlgorithmPS256
+	// TokenSignatureAlgorithmEdDSA is a EdDSA signature with Ed25519 key.
+	TokenSignatureAlgorithmEdDSA
 )
 
 // String implements Stringer.
@@ -43,11 +61,54 @@ func (alg TokenSignatureAlgorithm) String() string {
 		return "rs256"
 	case TokenSignatureAlgorithmAuto:
 		return "auto"
+	case TokenSignatureAlgorithmES384:
+		return "es384"
+	case TokenSignatureAlgorithmES512:
+		return "es512"
+	case TokenSignatureAlgorithmPS256:
+		return "ps256"
+	case TokenSignatureAlgorithmEdDSA:
+		return "eddsa"
 	default:
 		return fmt.Sprintf("TokenSignatureAlgorithm(%d)", alg)
 	}
 }
 
+// SigningMethod returns JWT signing method for the algorithm.
+func (alg TokenSignatureAlgorithm) SigningMethod() (jwt.SigningMethod, error) {
+	switch alg {
+	case TokenSignatureAlgorithmES256:
+		return jwt.SigningMethodES256, nil
+	case TokenSignatureAlgorithmES384:
+		return jwt.SigningMethodES384, nil
+	case TokenSignatureAlgorithmES512:
+		return jwt.SigningMethodES512, nil
+	case TokenSignatureAlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	case TokenSignatureAlgorithmPS256:
+		return jwt.SigningMethodPS256, nil
+	case TokenSignatureAlgorithmEdDSA:
+		return SigningMethodEdDSA, nil
+	default:
+		return nil, ErrWrongSignatureAlgorithm
+	}
+}
+
+// Hash returns the hash function used by the algorithm.
+// OpenID Connect uses it to compute "at_hash" claim, Ed25519 uses SHA-512 internally.
+func (alg TokenSignatureAlgorithm) Hash() (crypto.Hash, error) {
+	switch alg {
+	case TokenSignatureAlgorithmES256, TokenSignatureAlgorithmRS256, TokenSignatureAlgorithmPS256:
+		return crypto.SHA256, nil
+	case TokenSignatureAlgorithmES384:
+		return crypto.SHA384, nil
+	case TokenSignatureAlgorithmES512, TokenSignatureAlgorithmEdDSA:
+		return crypto.SHA512, nil
+	default:
+		return 0, ErrWrongSignatureAlgorithm
+	}
+}
+
 // MarshalJSON implements json.Marshaller.
 func (alg TokenSignatureAlgorithm) MarshalJSON() ([]byte, error) {
 	return json.Marshal(alg.String())
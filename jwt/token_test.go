@@ -3,10 +3,12 @@ package jwt_test
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	configStorageFile "github.com/madappgang/identifo/configuration/storage/file"
 	ijwt "github.com/madappgang/identifo/jwt"
 	jwtService "github.com/madappgang/identifo/jwt/service"
+	jwtValidator "github.com/madappgang/identifo/jwt/validator"
 	"github.com/madappgang/identifo/model"
 	"github.com/madappgang/identifo/storage/mem"
 )
@@ -284,7 +286,7 @@ func TestNewToken(t *testing.T) {
 		NewUserDefaultRole:           "",
 		AppleInfo:                    nil,
 	}
-	token, err := ts.NewAccessToken(user, scopes, app, false, nil)
+	token, err := ts.NewAccessToken(user, scopes, app, false, nil, model.AuthContext{})
 	if err != nil {
 		t.Errorf("Unable to create token %v", err)
 	}
@@ -314,3 +316,272 @@ func TestNewToken(t *testing.T) {
 		t.Errorf("Audience = %+v, want %+v", claims2.Audience, app.ID)
 	}
 }
+
+func TestKeyRotation(t *testing.T) {
+	us, err := mem.NewUserStorage()
+	if err != nil {
+		t.Fatalf("Unable to create user storage %v", err)
+	}
+	tstor, err := mem.NewTokenStorage()
+	if err != nil {
+		t.Fatalf("Unable to create token storage %v", err)
+	}
+	as, err := mem.NewAppStorage()
+	if err != nil {
+		t.Fatalf("Unable to create app storage %v", err)
+	}
+	configStorage, err := configStorageFile.NewConfigurationStorage(model.ConfigurationStorageSettings{
+		Type: model.ConfigurationStorageTypeFile,
+		KeyStorage: model.KeyStorageSettings{
+			Type:   model.KeyStorageTypeLocal,
+			Folder: keyPath,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to init configuration storage. %v", err)
+	}
+
+	// There is no key set file in test artifacts, so the key pair becomes the first version.
+	keySet, err := configStorage.LoadKeySet(ijwt.TokenSignatureAlgorithmES256)
+	if err != nil {
+		t.Fatalf("Cannot load key set = %s", err)
+	}
+	ts, err := jwtService.NewJWTokenServiceWithKeySet(keySet, testIssuer, tstor, as, us)
+	if err != nil {
+		t.Fatalf("Unable to create service %v", err)
+	}
+	oldToken, err := ts.NewResetToken("user")
+	if err != nil {
+		t.Fatalf("Unable to create token %v", err)
+	}
+	oldTokenString, err := ts.String(oldToken)
+	if err != nil {
+		t.Fatalf("Unable to serialize token %v", err)
+	}
+
+	privateKey, publicKey, err := ijwt.GenerateKeys(ijwt.TokenSignatureAlgorithmES256)
+	if err != nil {
+		t.Fatalf("Unable to generate keys %v", err)
+	}
+	now := time.Now()
+	if err = keySet.Rotate(privateKey, publicKey, now, now.Add(time.Hour)); err != nil {
+		t.Fatalf("Unable to rotate keys %v", err)
+	}
+	ts, err = jwtService.NewJWTokenServiceWithKeySet(keySet, testIssuer, tstor, as, us)
+	if err != nil {
+		t.Fatalf("Unable to create service %v", err)
+	}
+
+	if len(ts.VerificationKeys()) != 2 {
+		t.Errorf("Verification keys = %d, want 2", len(ts.VerificationKeys()))
+	}
+	if ts.KeyID() != ijwt.KeyID(publicKey) {
+		t.Errorf("Key ID = %s, want the new key ID %s", ts.KeyID(), ijwt.KeyID(publicKey))
+	}
+	newToken, err := ts.NewResetToken("user")
+	if err != nil {
+		t.Fatalf("Unable to create token %v", err)
+	}
+	newTokenString, err := ts.String(newToken)
+	if err != nil {
+		t.Fatalf("Unable to serialize token %v", err)
+	}
+	for _, s := range []string{oldTokenString, newTokenString} {
+		if _, err := ts.Parse(s); err != nil {
+			t.Errorf("Unable to parse token %v", err)
+		}
+	}
+
+	// The old key retires after an hour.
+	ijwt.TimeFunc = func() time.Time { return now.Add(90 * time.Minute) }
+	defer func() { ijwt.TimeFunc = time.Now }()
+	if _, err := ts.Parse(oldTokenString); err == nil {
+		t.Error("Token signed with the retired key should not be parsed")
+	}
+}
+
+func TestSignatureAlgorithms(t *testing.T) {
+	us, err := mem.NewUserStorage()
+	if err != nil {
+		t.Fatalf("Unable to create user storage %v", err)
+	}
+	tstor, err := mem.NewTokenStorage()
+	if err != nil {
+		t.Fatalf("Unable to create token storage %v", err)
+	}
+	as, err := mem.NewAppStorage()
+	if err != nil {
+		t.Fatalf("Unable to create app storage %v", err)
+	}
+
+	tests := []struct {
+		alg     ijwt.TokenSignatureAlgorithm
+		name    string
+		guessed ijwt.TokenSignatureAlgorithm
+	}{
+		{ijwt.TokenSignatureAlgorithmES256, "ES256", ijwt.TokenSignatureAlgorithmES256},
+		{ijwt.TokenSignatureAlgorithmES384, "ES384", ijwt.TokenSignatureAlgorithmES384},
+		{ijwt.TokenSignatureAlgorithmES512, "ES512", ijwt.TokenSignatureAlgorithmES512},
+		{ijwt.TokenSignatureAlgorithmRS256, "RS256", ijwt.TokenSignatureAlgorithmRS256},
+		{ijwt.TokenSignatureAlgorithmPS256, "PS256", ijwt.TokenSignatureAlgorithmRS256},
+		{ijwt.TokenSignatureAlgorithmEdDSA, "EdDSA", ijwt.TokenSignatureAlgorithmEdDSA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			privateKey, publicKey, err := ijwt.GenerateKeys(tt.alg)
+			if err != nil {
+				t.Fatalf("Unable to generate keys %v", err)
+			}
+
+			// Keys should survive PEM encoding, and the algorithm should be detected by the public key.
+			privatePEM, err := ijwt.MarshalPrivateKeyToPEM(privateKey)
+			if err != nil {
+				t.Fatalf("Unable to encode private key %v", err)
+			}
+			if privateKey, err = ijwt.ParsePrivateKeyFromPEM(privatePEM, tt.alg); err != nil {
+				t.Fatalf("Unable to parse private key %v", err)
+			}
+			publicPEM, err := ijwt.MarshalPublicKeyToPEM(publicKey)
+			if err != nil {
+				t.Fatalf("Unable to encode public key %v", err)
+			}
+			publicKey, alg, err := ijwt.LoadPublicKeyFromStringAuto(string(publicPEM))
+			if err != nil {
+				t.Fatalf("Unable to parse public key %v", err)
+			}
+			if alg != tt.guessed {
+				t.Errorf("Guessed algorithm = %v, want %v", alg, tt.guessed)
+			}
+
+			keys := &model.JWTKeys{Private: privateKey, Public: publicKey, Algorithm: tt.alg}
+			ts, err := jwtService.NewJWTokenService(keys, testIssuer, tstor, as, us)
+			if err != nil {
+				t.Fatalf("Unable to create service %v", err)
+			}
+			if ts.Algorithm() != tt.name {
+				t.Errorf("Algorithm = %v, want %v", ts.Algorithm(), tt.name)
+			}
+
+			token, err := ts.NewResetToken("user")
+			if err != nil {
+				t.Fatalf("Unable to create token %v", err)
+			}
+			tokenString, err := ts.String(token)
+			if err != nil {
+				t.Fatalf("Unable to serialize token %v", err)
+			}
+			if _, err := ts.Parse(tokenString); err != nil {
+				t.Fatalf("Unable to parse token %v", err)
+			}
+
+			v := jwtValidator.NewValidator(nil, []string{testIssuer}, nil, []string{model.TokenTypeReset})
+			parsed, err := ijwt.ParseTokenWithPublicKey(tokenString, publicKey)
+			if err != nil {
+				t.Fatalf("Unable to parse token with public key %v", err)
+			}
+			if err := v.Validate(parsed); err != nil {
+				t.Errorf("Validate error = %v", err)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	us, err := mem.NewUserStorage()
+	if err != nil {
+		t.Fatalf("Unable to create user storage %v", err)
+	}
+	tstor, err := mem.NewTokenStorage()
+	if err != nil {
+		t.Fatalf("Unable to create token storage %v", err)
+	}
+	as, err := mem.NewAppStorage()
+	if err != nil {
+		t.Fatalf("Unable to create app storage %v", err)
+	}
+	configStorage, err := configStorageFile.NewConfigurationStorage(model.ConfigurationStorageSettings{
+		Type: model.ConfigurationStorageTypeFile,
+		KeyStorage: model.KeyStorageSettings{
+			Type:   model.KeyStorageTypeLocal,
+			Folder: keyPath,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unable to init configuration storage. %v", err)
+	}
+	keys, err := configStorage.LoadKeys(ijwt.TokenSignatureAlgorithmES256)
+	if err != nil {
+		t.Fatalf("Unable to load keys %v", err)
+	}
+	ts, err := jwtService.NewJWTokenService(keys, testIssuer, tstor, as, us)
+	if err != nil {
+		t.Fatalf("Unable to create service %v", err)
+	}
+
+	user := model.User{ID: "user", Active: true}
+	app := model.AppData{ID: "app", Active: true, Offline: true}
+	scopes := []string{jwtService.OfflineScope}
+
+	token, err := ts.NewRefreshToken(user, scopes, app)
+	if err != nil {
+		t.Fatalf("Unable to create refresh token %v", err)
+	}
+	firstString, err := ts.String(token)
+	if err != nil {
+		t.Fatalf("Unable to serialize token %v", err)
+	}
+	first, err := ts.Parse(firstString)
+	if err != nil {
+		t.Fatalf("Unable to parse token %v", err)
+	}
+	if first.FamilyID() == "" {
+		t.Fatal("Refresh token has no family")
+	}
+
+	token, err = ts.RotateRefreshToken(user, scopes, app, first.FamilyID(), firstString)
+	if err != nil {
+		t.Fatalf("Unable to rotate refresh token %v", err)
+	}
+	secondString, err := ts.String(token)
+	if err != nil {
+		t.Fatalf("Unable to serialize token %v", err)
+	}
+	second, err := ts.Parse(secondString)
+	if err != nil {
+		t.Fatalf("Unable to parse token %v", err)
+	}
+	if second.FamilyID() != first.FamilyID() {
+		t.Errorf("Family ID = %s, want %s", second.FamilyID(), first.FamilyID())
+	}
+
+	family, err := tstor.TokenFamily(first.FamilyID())
+	if err != nil {
+		t.Fatalf("Unable to get token family %v", err)
+	}
+	if family.CurrentToken != secondString {
+		t.Error("The latest refresh token should be the current token of the family")
+	}
+	if firstString == secondString {
+		t.Error("Refresh tokens of the family should differ")
+	}
+
+	// Concurrent exchange of the same token loses the swap.
+	if _, err = ts.RotateRefreshToken(user, scopes, app, first.FamilyID(), firstString); err != jwtService.ErrTokenFamilyRevoked {
+		t.Errorf("RotateRefreshToken of the previous token error = %v, want %v", err, jwtService.ErrTokenFamilyRevoked)
+	}
+	if family, _ = tstor.TokenFamily(first.FamilyID()); family.CurrentToken != secondString {
+		t.Error("Failed rotation should keep the current token of the family")
+	}
+
+	family.Revoked = true
+	if err = tstor.SaveTokenFamily(family); err != nil {
+		t.Fatalf("Unable to save token family %v", err)
+	}
+	if _, err = ts.RotateRefreshToken(user, scopes, app, first.FamilyID(), secondString); err != jwtService.ErrTokenFamilyRevoked {
+		t.Errorf("RotateRefreshToken error = %v, want %v", err, jwtService.ErrTokenFamilyRevoked)
+	}
+	family.Revoked = false
+	if err = tstor.SwapTokenFamily(family, secondString); err != model.ErrorNotFound {
+		t.Errorf("SwapTokenFamily of revoked family error = %v, want %v", err, model.ErrorNotFound)
+	}
+}
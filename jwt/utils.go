@@ -24,13 +24,18 @@ func ExtractTokenFromBearerHeader(token string) []byte {
 
 // ParseTokenWithPublicKey parses token with provided public key.
 func ParseTokenWithPublicKey(t string, publicKey interface{}) (Token, error) {
-	tokenString := strings.TrimSpace(t)
-
-	parsedToken, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+	return ParseTokenWithKeyFunc(t, func(token *jwt.Token) (interface{}, error) {
 		// since we only use the one private key to sign the tokens,
 		// we also only use its public counter part to verify
 		return publicKey, nil
 	})
+}
+
+// ParseTokenWithKeyFunc parses token with the public key keyFunc selects for it, for example, by "kid" header.
+func ParseTokenWithKeyFunc(t string, keyFunc jwt.Keyfunc) (Token, error) {
+	tokenString := strings.TrimSpace(t)
+
+	parsedToken, err := jwt.ParseWithClaims(tokenString, &Claims{}, keyFunc)
 	if err != nil {
 		return nil, err
 	}
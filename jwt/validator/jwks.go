@@ -0,0 +1,295 @@
+package validator
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	jwtgo "github.com/form3tech-oss/jwt-go"
+)
+
+var (
+	// ErrUnknownKeyID is when there is no key with token "kid" in JWKS, even after refresh.
+	ErrUnknownKeyID = errors.New("Token is signed with unknown key")
+	// ErrKeyAlgorithmMismatch is when the token algorithm differs from the one JWKS declares for the key.
+	ErrKeyAlgorithmMismatch = errors.New("Token algorithm does not match the key")
+)
+
+const (
+	// DefaultJWKSRefreshInterval is how long the keys are used before they are refreshed in the background.
+	DefaultJWKSRefreshInterval = time.Hour
+	// DefaultJWKSMinRefreshInterval is the minimum time between two JWKS requests.
+	// Tokens with unknown "kid" could be sent by anyone, so we should not fetch JWKS for every one of them.
+	DefaultJWKSMinRefreshInterval = time.Minute
+)
+
+// JWKS is a set of public keys, published by the token issuer.
+// It lets us verify signatures of the tokens with claims other than ours,
+// like ID tokens of federated identity providers.
+type JWKS struct {
+	keySet *jwksKeySet
+}
+
+// NewJWKS creates JWKS and fetches the keys from url.
+// JWKS is returned even if the keys are not available yet, it will try again on the first token.
+func NewJWKS(url string, client *http.Client) (*JWKS, error) {
+	keySet, err := newJWKSKeySet(url, client, 0, 0)
+	return &JWKS{keySet: keySet}, err
+}
+
+// Keyfunc returns the key the token is signed with, it is selected by the token "kid" header.
+func (j *JWKS) Keyfunc(token *jwtgo.Token) (interface{}, error) {
+	return j.keySet.keyFunc(token)
+}
+
+// jwk is a JSON Web Key, with the fields we need to get the public key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// jwksKey is a public key from JWKS with the algorithm it is used with, if JWKS declares it.
+type jwksKey struct {
+	alg string
+	key interface{}
+}
+
+// jwksKeySet caches public keys from JWKS by their IDs.
+// Keys are refreshed in the background when they get old, and right away when the token has unknown "kid",
+// but JWKS is never requested more often than minRefreshInterval.
+type jwksKeySet struct {
+	url                string
+	client             *http.Client
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]jwksKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+// newJWKSKeySet creates key set and fetches the keys.
+// Key set is returned even if JWKS is not available yet, it will try again on the next token.
+func newJWKSKeySet(url string, client *http.Client, refreshInterval, minRefreshInterval time.Duration) (*jwksKeySet, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultJWKSRefreshInterval
+	}
+	if minRefreshInterval <= 0 {
+		minRefreshInterval = DefaultJWKSMinRefreshInterval
+	}
+
+	ks := &jwksKeySet{
+		url:                url,
+		client:             client,
+		refreshInterval:    refreshInterval,
+		minRefreshInterval: minRefreshInterval,
+		keys:               map[string]jwksKey{},
+	}
+	ks.startRefresh()
+	return ks, ks.refresh()
+}
+
+// key returns public key for the token key ID and algorithm.
+func (ks *jwksKeySet) key(kid, alg string) (interface{}, error) {
+	k, ok, stale := ks.cachedKey(kid)
+	if !ok {
+		// Keys could be rotated, let's check JWKS once.
+		if ks.startRefresh() {
+			if err := ks.refresh(); err != nil {
+				log.Println("Error refreshing JWKS:", err)
+			}
+			k, ok, _ = ks.cachedKey(kid)
+		}
+		if !ok {
+			return nil, ErrUnknownKeyID
+		}
+	} else if stale && ks.startRefresh() {
+		go func() {
+			if err := ks.refresh(); err != nil {
+				log.Println("Error refreshing JWKS:", err)
+			}
+		}()
+	}
+
+	if k.alg != "" && k.alg != alg {
+		return nil, ErrKeyAlgorithmMismatch
+	}
+	return k.key, nil
+}
+
+// keyFunc returns the key for the token, it could be used to parse the token.
+// Only asymmetric signature algorithms are accepted.
+func (ks *jwksKeySet) keyFunc(token *jwtgo.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if !supportedSignatureAlgs[alg] {
+		return nil, ErrKeyAlgorithmMismatch
+	}
+	kid, _ := token.Header["kid"].(string)
+	return ks.key(kid, alg)
+}
+
+// cachedKey returns the key from cache, and tells if it is time to refresh the cache.
+// Tokens without "kid" could be verified only if there is a single key.
+func (ks *jwksKeySet) cachedKey(kid string) (jwksKey, bool, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	stale := time.Since(ks.fetchedAt) > ks.refreshInterval
+	if kid == "" {
+		if len(ks.keys) != 1 {
+			return jwksKey{}, false, stale
+		}
+		for _, k := range ks.keys {
+			return k, true, stale
+		}
+	}
+	k, ok := ks.keys[kid]
+	return k, ok, stale
+}
+
+// startRefresh tells if JWKS could be requested now, and if so, counts the request,
+// so concurrent callers do not request it as well.
+func (ks *jwksKeySet) startRefresh() bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if !ks.attemptedAt.IsZero() && time.Since(ks.attemptedAt) < ks.minRefreshInterval {
+		return false
+	}
+	ks.attemptedAt = time.Now()
+	return true
+}
+
+// refresh fetches JWKS and replaces cached keys.
+// If JWKS is not available, we keep using the old keys.
+func (ks *jwksKeySet) refresh() error {
+	keys, err := ks.fetch()
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.fetchedAt = time.Now()
+	return nil
+}
+
+func (ks *jwksKeySet) fetch() (map[string]jwksKey, error) {
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot fetch JWKS: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Cannot fetch JWKS, status code %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("Cannot decode JWKS: %s", err)
+	}
+
+	keys := make(map[string]jwksKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("Skipping JWKS key %s: %s\n", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = jwksKey{alg: k.Alg, key: key}
+	}
+	return keys, nil
+}
+
+// publicKey decodes public key of the JWK (RFC 7518, section 6, and RFC 8037, section 2).
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("Unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("Point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("Unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Invalid Ed25519 key size %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("Unsupported key type %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("Empty key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,131 @@
+package validator
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	jwtgo "github.com/form3tech-oss/jwt-go"
+	"github.com/madappgang/identifo/jwt"
+	"github.com/madappgang/identifo/model"
+)
+
+type testJWKS struct {
+	sync.Mutex
+	keys     []jwk
+	requests int
+}
+
+func (s *testJWKS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.requests++
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+}
+
+func (s *testJWKS) set(keys ...jwk) {
+	s.Lock()
+	defer s.Unlock()
+	s.keys = keys
+}
+
+func (s *testJWKS) count() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.requests
+}
+
+func testJWK(t *testing.T, alg jwt.TokenSignatureAlgorithm, kid string) (jwk, interface{}) {
+	privateKey, publicKey, err := jwt.GenerateKeys(alg)
+	if err != nil {
+		t.Fatalf("Unable to generate keys %v", err)
+	}
+	sm, _ := alg.SigningMethod()
+	k := jwk{Use: "sig", Alg: sm.Alg(), Kid: kid}
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		k.Kty = "RSA"
+		k.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		k.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		k.Kty = "EC"
+		k.Crv = pub.Curve.Params().Name
+		k.X = base64.RawURLEncoding.EncodeToString(pub.X.Bytes())
+		k.Y = base64.RawURLEncoding.EncodeToString(pub.Y.Bytes())
+	}
+	return k, privateKey
+}
+
+func testTokenString(t *testing.T, alg jwt.TokenSignatureAlgorithm, kid string, privateKey interface{}) string {
+	sm, _ := alg.SigningMethod()
+	now := time.Now().Unix()
+	token := jwt.NewTokenWithClaims(sm, kid, jwt.Claims{
+		Type: model.TokenTypeAccess,
+		StandardClaims: jwtgo.StandardClaims{
+			ExpiresAt: now + 60,
+			IssuedAt:  now,
+			Issuer:    "identifo",
+			Audience:  []string{"app"},
+		},
+	})
+	s, err := token.SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("Unable to sign token %v", err)
+	}
+	return s
+}
+
+func TestJWKSValidator(t *testing.T) {
+	jwks := &testJWKS{}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+
+	ecKey, ecPrivate := testJWK(t, jwt.TokenSignatureAlgorithmES256, "ec")
+	rsaKey, rsaPrivate := testJWK(t, jwt.TokenSignatureAlgorithmRS256, "rsa")
+	jwks.set(ecKey)
+
+	c := NewConfig()
+	c.Audience = []string{"app"}
+	c.Issuer = []string{"identifo"}
+	c.PubKeyURL = server.URL
+	c.JWKSMinRefreshInterval = time.Hour
+	v, err := NewValidatorWithConfig(c)
+	if err != nil {
+		t.Fatalf("Unable to create validator %v", err)
+	}
+
+	if _, err := v.ValidateString(testTokenString(t, jwt.TokenSignatureAlgorithmES256, "ec", ecPrivate)); err != nil {
+		t.Errorf("ValidateString error = %v", err)
+	}
+	// The only key could verify tokens without "kid".
+	if _, err := v.ValidateString(testTokenString(t, jwt.TokenSignatureAlgorithmES256, "", ecPrivate)); err != nil {
+		t.Errorf("ValidateString error = %v", err)
+	}
+
+	// Rotated key is fetched on the first token with unknown "kid", but not more often than the min refresh interval.
+	jwks.set(ecKey, rsaKey)
+	v.(*validator).jwks.attemptedAt = time.Time{}
+	if _, err := v.ValidateString(testTokenString(t, jwt.TokenSignatureAlgorithmRS256, "rsa", rsaPrivate)); err != nil {
+		t.Errorf("ValidateString error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := v.ValidateString(testTokenString(t, jwt.TokenSignatureAlgorithmRS256, "unknown", rsaPrivate)); err == nil {
+			t.Error("Token with unknown kid should not be valid")
+		}
+	}
+	if jwks.count() != 2 {
+		t.Errorf("JWKS requests = %d, want 2", jwks.count())
+	}
+
+	// Key is used with the algorithm JWKS declares only.
+	if _, err := v.ValidateString(testTokenString(t, jwt.TokenSignatureAlgorithmPS256, "rsa", rsaPrivate)); err == nil {
+		t.Error("Token with algorithm mismatch should not be valid")
+	}
+}
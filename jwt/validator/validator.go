@@ -2,7 +2,9 @@ package validator
 
 import (
 	"errors"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/madappgang/identifo/jwt"
 	"github.com/madappgang/identifo/model"
@@ -34,8 +36,26 @@ const (
 	SignatureAlgES = "ES256"
 	// SignatureAlgRS is a hardcoded RS256 signature algorithm.
 	SignatureAlgRS = "RS256"
+	// SignatureAlgES384 is a ES384 signature algorithm.
+	SignatureAlgES384 = "ES384"
+	// SignatureAlgES512 is a ES512 signature algorithm.
+	SignatureAlgES512 = "ES512"
+	// SignatureAlgPS is a PS256 signature algorithm.
+	SignatureAlgPS = "PS256"
+	// SignatureAlgEdDSA is a EdDSA signature algorithm with Ed25519 key.
+	SignatureAlgEdDSA = "EdDSA"
 )
 
+// supportedSignatureAlgs are asymmetric signature algorithms tokens could be signed with.
+var supportedSignatureAlgs = map[string]bool{
+	SignatureAlgES:    true,
+	SignatureAlgRS:    true,
+	SignatureAlgES384: true,
+	SignatureAlgES512: true,
+	SignatureAlgPS:    true,
+	SignatureAlgEdDSA: true,
+}
+
 // Validator is an abstract token validator.
 type Validator interface {
 	Validate(jwt.Token) error
@@ -53,8 +73,15 @@ type Config struct {
 	PubKeyEnvName string
 	// PubKeyFileName file path with public key, could be empty if you want to use env variable.
 	PubKeyFileName string
-	// PubKeyURL URL for well-known JWKS
+	// PubKeyURL URL for well-known JWKS, used when neither env variable, nor file is set.
+	// Keys are selected by the token "kid" header, so key rotations are picked up automatically.
 	PubKeyURL string
+	// JWKSRefreshInterval is how often JWKS keys are refreshed, DefaultJWKSRefreshInterval if empty.
+	JWKSRefreshInterval time.Duration
+	// JWKSMinRefreshInterval is the minimum time between JWKS requests, DefaultJWKSMinRefreshInterval if empty.
+	JWKSMinRefreshInterval time.Duration
+	// HTTPClient is used to fetch JWKS, default client with 10 seconds timeout is used if empty.
+	HTTPClient *http.Client
 	// should we always check audience for the token. If yes and audience is empty the validation will fail.
 	IsAudienceRequired bool
 	// should we always check iss for the token. If yes and iss is empty the validation will fail.
@@ -103,6 +130,11 @@ func NewValidatorWithConfig(c Config) (Validator, error) {
 		key, _, err = jwt.LoadPublicKeyFromPEMAuto(c.PubKeyFileName)
 	}
 
+	var jwks *jwksKeySet
+	if key == nil && err == nil && len(c.PubKeyURL) > 0 {
+		jwks, err = newJWKSKeySet(c.PubKeyURL, c.HTTPClient, c.JWKSRefreshInterval, c.JWKSMinRefreshInterval)
+	}
+
 	return &validator{
 		audience:  c.Audience,
 		issuer:    c.Issuer,
@@ -111,11 +143,10 @@ func NewValidatorWithConfig(c Config) (Validator, error) {
 		strictAud: c.IsAudienceRequired,
 		strictIss: c.IsIssuerRequired,
 		publicKey: key,
+		jwks:      jwks,
 	}, err
 }
 
-// TODO: implement initializer with JWKS URL .well-known
-
 // validator is a JWT token validator.
 type validator struct {
 	audience   []string
@@ -123,6 +154,7 @@ type validator struct {
 	userID     []string
 	tokenType  []string
 	publicKey  interface{}
+	jwks       *jwksKeySet
 	strictIss  bool
 	strictAud  bool
 	strictUser bool
@@ -147,7 +179,7 @@ func (v *validator) Validate(t jwt.Token) error {
 	}
 
 	// Ensure the signature algorithm attack is not passing through.
-	if token.JWT.Method.Alg() != SignatureAlgES && token.JWT.Method.Alg() != SignatureAlgRS {
+	if !supportedSignatureAlgs[token.JWT.Method.Alg()] {
 		return jwt.ErrTokenInvalid
 	}
 
@@ -229,10 +261,17 @@ func (v *validator) Validate(t jwt.Token) error {
 
 // ValidateString validates string representation of the token.
 func (v *validator) ValidateString(t string) (jwt.Token, error) {
-	if v.publicKey == nil {
+	var token jwt.Token
+	var err error
+
+	switch {
+	case v.publicKey != nil:
+		token, err = jwt.ParseTokenWithPublicKey(t, v.publicKey)
+	case v.jwks != nil:
+		token, err = jwt.ParseTokenWithKeyFunc(t, v.jwks.keyFunc)
+	default:
 		return nil, ErrorConfigurationMissingPublicKey
 	}
-	token, err := jwt.ParseTokenWithPublicKey(t, v.publicKey)
 	if err != nil {
 		return nil, err
 	}
@@ -53,23 +53,32 @@ func initServer(configStorage model.ConfigurationStorage) model.Server {
 
 	dbTypes := make(map[model.DatabaseType]bool)
 	var partialComposers []server.PartialDatabaseComposer
+	var composerOptions []func(*server.Composer) error
 
-	dbTypes[server.ServerSettings.Storage.AppStorage.Type] = true
-	dbTypes[server.ServerSettings.Storage.UserStorage.Type] = true
-	dbTypes[server.ServerSettings.Storage.TokenStorage.Type] = true
-	dbTypes[server.ServerSettings.Storage.TokenBlacklist.Type] = true
-	dbTypes[server.ServerSettings.Storage.VerificationCodeStorage.Type] = true
-	dbTypes[server.ServerSettings.Storage.InviteStorage.Type] = true
+	storageSettings := server.ServerSettings.Storage
+	if storageSettings.UserStorage.Type == model.DBTypeLDAP && storageSettings.UserStorage.LDAP != nil {
+		// LDAP checks passwords in front of the primary user storage.
+		composerOptions = append(composerOptions, server.LDAPUserStorageOption(storageSettings.UserStorage))
+		storageSettings.UserStorage = storageSettings.UserStorage.LDAP.PrimaryStorage
+	}
+
+	dbTypes[storageSettings.AppStorage.Type] = true
+	dbTypes[storageSettings.UserStorage.Type] = true
+	dbTypes[storageSettings.TokenStorage.Type] = true
+	dbTypes[storageSettings.TokenBlacklist.Type] = true
+	dbTypes[storageSettings.VerificationCodeStorage.Type] = true
+	dbTypes[storageSettings.InviteStorage.Type] = true
+	dbTypes[storageSettings.DeviceCodeStorage.Type] = true
 
 	for dbType := range dbTypes {
-		pc, err := initPartialComposer(dbType, server.ServerSettings.Storage)
+		pc, err := initPartialComposer(dbType, storageSettings)
 		if err != nil {
 			log.Panicf("Cannot init partial composer for db type %s: %s\n", dbType, err)
 		}
 		partialComposers = append(partialComposers, pc)
 	}
 
-	dbComposer, err := server.NewComposer(server.ServerSettings, partialComposers)
+	dbComposer, err := server.NewComposer(server.ServerSettings, partialComposers, composerOptions...)
 	if err != nil {
 		log.Panicln("Cannot init database composer:", err)
 	}
@@ -1,6 +1,9 @@
 package model
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"strings"
+)
 
 // AppStorage is an abstract representation of applications data storage.
 type AppStorage interface {
@@ -33,6 +36,9 @@ type AppData struct {
 	TokenPayload                      []string                          `bson:"token_payload,omitempty" json:"token_payload,omitempty"`                   // Payload is a list of fields that are included in token. If it's empty, there are no fields in payload.
 	TFAStatus                         TFAStatus                         `bson:"tfa_status,omitempty" json:"tfa_status,omitempty"`
 	DebugTFACode                      string                            `bson:"debug_tfa_code,omitempty" json:"debug_tfa_code,omitempty"`
+	TFATypes                          []TFAType                         `bson:"tfa_types,omitempty" json:"tfa_types,omitempty"`                             // TFATypes are the second factors users could enroll in the app. The server-wide TFA type is used if empty.
+	PhishingResistantTFA              bool                              `bson:"phishing_resistant_tfa,omitempty" json:"phishing_resistant_tfa,omitempty"`   // PhishingResistantTFA makes users pass two-factor authentication with WebAuthn security keys instead of one-time passwords, e.g. in admin and staff apps.
+	TrustedDeviceLifespan             int64                             `bson:"trusted_device_lifespan,omitempty" json:"trusted_device_lifespan,omitempty"` // TrustedDeviceLifespan is how long in seconds users could skip two-factor authentication on the device they trusted, if 0 - trusted devices are disabled.
 	RegistrationForbidden             bool                              `bson:"registration_forbidden,omitempty" json:"registration_forbidden,omitempty"`
 	AnonymousRegistrationAllowed      bool                              `bson:"anonymous_registration_allowed,omitempty" json:"anonymous_registration_allowed,omitempty"`
 	AuthzWay                          AuthorizationWay                  `bson:"authorization_way,omitempty" json:"authorization_way,omitempty"`
@@ -41,7 +47,12 @@ type AppData struct {
 	RolesWhitelist                    []string                          `bson:"roles_whitelist,omitempty" json:"roles_whitelist,omitempty"`
 	RolesBlacklist                    []string                          `bson:"roles_blacklist,omitempty" json:"roles_blacklist,omitempty"`
 	NewUserDefaultRole                string                            `bson:"new_user_default_role,omitempty" json:"new_user_default_role,omitempty"`
+	NotifyRefreshTokenReuse           bool                              `bson:"notify_refresh_token_reuse,omitempty" json:"notify_refresh_token_reuse,omitempty"`
+	LinkFederatedByEmail              bool                              `bson:"link_federated_by_email,omitempty" json:"link_federated_by_email,omitempty"` // LinkFederatedByEmail links new federated identity to the existing user with the same verified email, instead of registering new user.
 	AppleInfo                         *AppleInfo                        `bson:"apple_info,omitempty" json:"apple_info,omitempty"`
+	GoogleInfo                        *GoogleInfo                       `bson:"google_info,omitempty" json:"google_info,omitempty"`
+	OIDCProviders                     []OIDCProviderSettings            `bson:"oidc_providers,omitempty" json:"oidc_providers,omitempty"`
+	FederatedProviders                map[string]FederatedSettings      `bson:"federated_providers,omitempty" json:"federated_providers,omitempty"` // FederatedProviders are the enabled federated providers with their settings, by provider name. If it's empty, Facebook and Apple are enabled, as well as Google with its info.
 	TokenPayloadService               TokenPayloadServiceType           `json:"token_payload_service,omitempty" bson:"token_payload_service,omitempty"`
 	TokenPayloadServicePluginSettings TokenPayloadServicePluginSettings `json:"token_payload_service_plugin_settings,omitempty" bson:"token_payload_service_plugin_settings,omitempty"`
 	TokenPayloadServiceHttpSettings   TokenPayloadServiceHttpSettings   `json:"token_payload_service_http_settings,omitempty" bson:"token_payload_service_http_settings,omitempty"`
@@ -55,6 +66,7 @@ const (
 	Android AppType = "android" // Android is an Android app.
 	IOS     AppType = "ios"     // IOS is an iOS app.
 	Desktop AppType = "desktop" // Desktop is a desktop app.
+	Service AppType = "service" // Service is a confidential machine-to-machine client, which obtains tokens with client credentials grant.
 )
 
 // AuthorizationWay is a way of authorization supported by the application.
@@ -97,6 +109,43 @@ type TokenPayloadServiceHttpSettings struct {
 	Secret string `json:"secret,omitempty" bson:"secret,omitempty"`
 }
 
+// OIDCProvider returns the settings of the app's upstream OpenID Connect provider by its name.
+func (a AppData) OIDCProvider(name string) (OIDCProviderSettings, bool) {
+	for _, p := range a.OIDCProviders {
+		if strings.EqualFold(p.Name, strings.TrimSpace(name)) {
+			return p, true
+		}
+	}
+	return OIDCProviderSettings{}, false
+}
+
+// FederatedProviderSettings returns the app's settings of the federated provider, if the app has enabled it.
+// Apps without federated providers list have Facebook and Apple enabled, as well as Google, if they have its info.
+// Apple is enabled without its info, so that its provider reports it is not configured, like before providers were pluggable.
+func (a AppData) FederatedProviderSettings(fid FederatedIdentityProvider) (FederatedSettings, bool) {
+	if len(a.FederatedProviders) > 0 {
+		for name, settings := range a.FederatedProviders {
+			if strings.EqualFold(name, string(fid)) {
+				return settings, true
+			}
+		}
+		return nil, false
+	}
+
+	switch fid {
+	case FacebookIDProvider:
+		return FederatedSettings{}, true
+	case AppleIDProvider:
+		if a.AppleInfo == nil {
+			return FederatedSettings{}, true
+		}
+		return federatedSettingsFrom(a.AppleInfo), true
+	case GoogleIDProvider:
+		return federatedSettingsFrom(a.GoogleInfo), a.GoogleInfo != nil
+	}
+	return nil, false
+}
+
 // AppDataFromJSON unmarshal AppData from JSON string
 func AppDataFromJSON(d []byte) (AppData, error) {
 	var apd AppData
@@ -111,6 +160,26 @@ func (a AppData) Sanitized() AppData {
 	if a.AppleInfo != nil {
 		a.AppleInfo.ClientSecret = ""
 	}
+	if len(a.OIDCProviders) > 0 {
+		providers := make([]OIDCProviderSettings, len(a.OIDCProviders))
+		for i, p := range a.OIDCProviders {
+			p.ClientSecret = ""
+			providers[i] = p
+		}
+		a.OIDCProviders = providers
+	}
+	if len(a.FederatedProviders) > 0 {
+		providers := make(map[string]FederatedSettings, len(a.FederatedProviders))
+		for name, settings := range a.FederatedProviders {
+			providers[name] = FederatedSettings{}
+			for k, v := range settings {
+				if !strings.Contains(strings.ToLower(k), "secret") {
+					providers[name][k] = v
+				}
+			}
+		}
+		a.FederatedProviders = providers
+	}
 
 	a.AuthzWay = ""
 	a.AuthzModel = ""
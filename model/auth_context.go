@@ -0,0 +1,59 @@
+package model
+
+import "time"
+
+// Authentication methods references, the "amr" claim values of the ways the user has authenticated with.
+const (
+	AMRPassword  = "pwd"      // AMRPassword is the password.
+	AMROTP       = "otp"      // AMROTP is the one-time password from the authenticator app or the email.
+	AMRSMS       = "sms"      // AMRSMS is the code in SMS.
+	AMRFederated = "fed"      // AMRFederated is the federated identity provider.
+	AMRWebAuthn  = "webauthn" // AMRWebAuthn is the security key or the passkey.
+)
+
+// Authentication context classes, the "acr" claim values, from the weakest to the strongest.
+const (
+	ACRSingleFactor = "1" // ACRSingleFactor is the class of the user who has passed one factor.
+	ACRMultiFactor  = "2" // ACRMultiFactor is the class of the user who has passed two factors, or the security key, which verifies the user itself.
+)
+
+// AuthContext tells how and when the user has authenticated.
+// Access tokens keep it in the "amr", "acr" and "auth_time" claims.
+type AuthContext struct {
+	Methods []string
+	ACR     string
+	// Time is when the user has authenticated last, Unix time.
+	Time int64
+}
+
+// NewAuthContext returns the context of the user who has just authenticated with the first factor.
+func NewAuthContext(method string) AuthContext {
+	acr := ACRSingleFactor
+	if method == AMRWebAuthn {
+		acr = ACRMultiFactor
+	}
+	return AuthContext{Methods: []string{method}, ACR: acr, Time: time.Now().Unix()}
+}
+
+// WithSecondFactor returns the context of the user who has just passed one more factor.
+func (c AuthContext) WithSecondFactor(method string) AuthContext {
+	methods := make([]string, 0, len(c.Methods)+1)
+	for _, m := range c.Methods {
+		if m != method {
+			methods = append(methods, m)
+		}
+	}
+	return AuthContext{Methods: append(methods, method), ACR: ACRMultiFactor, Time: time.Now().Unix()}
+}
+
+// TFAMethod returns the authentication method of the second factor type.
+func TFAMethod(tfaType TFAType) string {
+	switch tfaType {
+	case TFATypeSMS:
+		return AMRSMS
+	case TFATypeWebAuthn:
+		return AMRWebAuthn
+	default:
+		return AMROTP
+	}
+}
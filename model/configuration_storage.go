@@ -1,6 +1,8 @@
 package model
 
 import (
+	"fmt"
+
 	ijwt "github.com/madappgang/identifo/jwt"
 )
 
@@ -10,6 +12,8 @@ type ConfigurationStorage interface {
 	LoadServerSettings(*ServerSettings) error
 	InsertKeys(keys *JWTKeys) error
 	LoadKeys(ijwt.TokenSignatureAlgorithm) (*JWTKeys, error)
+	InsertKeySet(keySet *JWTKeySet) error
+	LoadKeySet(ijwt.TokenSignatureAlgorithm) (*JWTKeySet, error)
 	GetUpdateChan() chan interface{}
 	CloseUpdateChan()
 }
@@ -18,10 +22,22 @@ type ConfigurationStorage interface {
 const (
 	PublicKeyName  = "public.pem"
 	PrivateKeyName = "private.pem"
+	KeySetName     = "keyset.json"
 )
 
+// KeyNames returns private and public key names for the key set version.
+// The first version uses the same names as a single key pair, so existing keys become the first version of the key set.
+func KeyNames(version int) (string, string) {
+	if version <= 1 {
+		return PrivateKeyName, PublicKeyName
+	}
+	return fmt.Sprintf("private.v%d.pem", version), fmt.Sprintf("public.v%d.pem", version)
+}
+
 // KeyStorage stores keys used for signing and verifying JWT tokens.
 type KeyStorage interface {
 	InsertKeys(keys *JWTKeys) error
 	LoadKeys(alg ijwt.TokenSignatureAlgorithm) (*JWTKeys, error)
+	InsertKeySet(keySet *JWTKeySet) error
+	LoadKeySet(alg ijwt.TokenSignatureAlgorithm) (*JWTKeySet, error)
 }
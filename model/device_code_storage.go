@@ -0,0 +1,62 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// DeviceCodeStorage stores pending device authorization requests (RFC 8628).
+type DeviceCodeStorage interface {
+	CreateDeviceCode(dc DeviceCode) error
+	DeviceCodeByDeviceCode(deviceCode string) (DeviceCode, error)
+	DeviceCodeByUserCode(userCode string) (DeviceCode, error)
+	UpdateDeviceCode(dc DeviceCode) error
+	// UpdateDeviceCodePoll updates the polling interval and time, but only while the request is pending,
+	// so polling never overwrites the user's decision. It returns ErrorNotFound otherwise.
+	UpdateDeviceCodePoll(deviceCode string, interval int64, lastPolledAt time.Time) error
+	// ConsumeDeviceCode deletes the approved device code. Only one of the concurrent callers succeeds,
+	// the others get ErrorNotFound, as the device code is exchanged for tokens once.
+	ConsumeDeviceCode(deviceCode string) error
+	DeleteDeviceCode(deviceCode string) error
+	Close()
+}
+
+// DeviceCodeStatus is a status of the device authorization request.
+type DeviceCodeStatus string
+
+const (
+	// DeviceCodeStatusPending means that the user has not yet approved or denied the request.
+	DeviceCodeStatusPending DeviceCodeStatus = "pending"
+	// DeviceCodeStatusApproved means that the user has approved the request.
+	DeviceCodeStatusApproved DeviceCodeStatus = "approved"
+	// DeviceCodeStatusDenied means that the user has denied the request.
+	DeviceCodeStatusDenied DeviceCodeStatus = "denied"
+)
+
+// DeviceCode is a device authorization request.
+// Device code is a secret known to the device only, user code is shown to the user to type it in the browser.
+type DeviceCode struct {
+	DeviceCode   string           `json:"device_code" bson:"_id"`
+	UserCode     string           `json:"user_code" bson:"user_code"`
+	AppID        string           `json:"app_id" bson:"app_id"`
+	Scopes       []string         `json:"scopes,omitempty" bson:"scopes,omitempty"`
+	Status       DeviceCodeStatus `json:"status" bson:"status"`
+	UserID       string           `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	AuthTime     int64            `json:"auth_time,omitempty" bson:"auth_time,omitempty"`
+	AMR          []string         `json:"amr,omitempty" bson:"amr,omitempty"`
+	ACR          string           `json:"acr,omitempty" bson:"acr,omitempty"`
+	Interval     int64            `json:"interval" bson:"interval"`
+	LastPolledAt time.Time        `json:"last_polled_at" bson:"last_polled_at"`
+	ExpiresAt    time.Time        `json:"expires_at" bson:"expires_at"`
+}
+
+// Expired returns true if the device code cannot be used anymore.
+func (dc DeviceCode) Expired() bool {
+	return time.Now().After(dc.ExpiresAt)
+}
+
+// NormalizeUserCode brings the user code, typed by the user, to the stored form.
+// User codes are case insensitive, and dashes and spaces are ignored.
+func NormalizeUserCode(userCode string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(userCode)))
+}
@@ -15,6 +15,7 @@ type EmailService interface {
 	SendWelcomeEmail(subject, recipient string, data interface{}) error
 	SendVerifyEmail(subject, recipient string, data interface{}) error
 	SendTFAEmail(subject, recipient string, data interface{}) error
+	SendMagicLinkEmail(subject, recipient string, data interface{}) error
 
 	Templater() *EmailTemplater
 }
@@ -26,6 +27,7 @@ type EmailTemplater struct {
 	InviteTemplate        *template.Template
 	VerifyTemplate        *template.Template
 	TFATemplate           *template.Template
+	MagicLinkTemplate     *template.Template
 }
 
 // NewEmailTemplater creates new email templater.
@@ -36,6 +38,9 @@ func NewEmailTemplater(staticFilesStorage StaticFilesStorage) (*EmailTemplater,
 	if et.InviteTemplate, err = staticFilesStorage.ParseTemplate(StaticPagesNames.InviteEmail); err != nil {
 		return nil, err
 	}
+	if et.MagicLinkTemplate, err = staticFilesStorage.ParseTemplate(StaticPagesNames.MagicLinkEmail); err != nil {
+		return nil, err
+	}
 	if et.ResetPasswordTemplate, err = staticFilesStorage.ParseTemplate(StaticPagesNames.ResetPasswordEmail); err != nil {
 		return nil, err
 	}
@@ -1,7 +1,15 @@
 package model
 
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"sync"
+)
+
 // FederatedIdentityProvider is an external federated identity provider type.
-// If you are missing the provider you need, please feel free to add it here.
+// Besides the providers below, there are the ones registered in FederatedProviderRegistry,
+// and apps could configure their own OpenID Connect providers.
 type FederatedIdentityProvider string
 
 var (
@@ -15,13 +23,54 @@ var (
 	AppleIDProvider FederatedIdentityProvider = "APPLE"
 )
 
+// oidcIDProviderPrefix is a prefix of upstream OpenID Connect providers, configured per app.
+const oidcIDProviderPrefix = "OIDC_"
+
+// OIDCIDProvider returns the ID provider for the upstream OpenID Connect provider with the issuer.
+// Users are linked to the issuer, not to the name the app gives the provider, as user IDs are unique within the issuer only.
+// The issuer is encoded, as federated IDs are stored as "PROVIDER:id" and the provider is a part of the URL path.
+func OIDCIDProvider(issuer string) FederatedIdentityProvider {
+	return FederatedIdentityProvider(oidcIDProviderPrefix + base64.RawURLEncoding.EncodeToString([]byte(normalizeIssuer(issuer))))
+}
+
+// normalizeIssuer returns the issuer with lower case scheme and host, and without trailing slash.
+func normalizeIssuer(issuer string) string {
+	issuer = strings.TrimSuffix(strings.TrimSpace(issuer), "/")
+	u, err := url.Parse(issuer)
+	if err != nil || len(u.Host) == 0 {
+		return issuer
+	}
+	u.Scheme, u.Host = strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+	return u.String()
+}
+
+// knownFederatedIdentityProviders are the built-in providers and the ones registered in FederatedProviderRegistry.
+var knownFederatedIdentityProviders = struct {
+	sync.RWMutex
+	ids map[FederatedIdentityProvider]bool
+}{ids: map[FederatedIdentityProvider]bool{
+	FacebookIDProvider: true,
+	GoogleIDProvider:   true,
+	TwitterIDProvider:  true,
+	AppleIDProvider:    true,
+}}
+
+func registerFederatedIdentityProvider(fid FederatedIdentityProvider) {
+	knownFederatedIdentityProviders.Lock()
+	defer knownFederatedIdentityProviders.Unlock()
+	knownFederatedIdentityProviders.ids[fid] = true
+}
+
 // IsValid has to be called everywhere input happens, otherwise you risk to operate on bad data - no guarantees.
 func (fid FederatedIdentityProvider) IsValid() bool {
-	switch fid {
-	case FacebookIDProvider, GoogleIDProvider, TwitterIDProvider, AppleIDProvider:
-		return true
-	}
-	return false
+	knownFederatedIdentityProviders.RLock()
+	defer knownFederatedIdentityProviders.RUnlock()
+	return knownFederatedIdentityProviders.ids[fid] || fid.IsOIDC()
+}
+
+// IsOIDC tells if it is an upstream OpenID Connect provider.
+func (fid FederatedIdentityProvider) IsOIDC() bool {
+	return strings.HasPrefix(string(fid), oidcIDProviderPrefix) && len(fid) > len(oidcIDProviderPrefix)
 }
 
 // AppleInfo represents the information needed for Sign In with Apple.
@@ -29,3 +78,27 @@ type AppleInfo struct {
 	ClientID     string `json:"client_id,omitempty" bson:"client_id,omitempty"`
 	ClientSecret string `json:"client_secret,omitempty" bson:"client_secret,omitempty"`
 }
+
+// GoogleInfo represents the information needed for Google Sign-In.
+// Each platform of the app has its own OAuth client, ID tokens issued to any of them are accepted.
+type GoogleInfo struct {
+	ClientIDs []string `json:"client_ids,omitempty" bson:"client_ids,omitempty"`
+}
+
+// OIDCProviderSettings are the settings of the upstream OpenID Connect provider, like Okta, Azure AD or Keycloak.
+// Provider endpoints and keys are discovered from the issuer.
+type OIDCProviderSettings struct {
+	Name         string           `json:"name,omitempty" bson:"name,omitempty"` // Name identifies the provider in sign-in requests.
+	Issuer       string           `json:"issuer,omitempty" bson:"issuer,omitempty"`
+	ClientID     string           `json:"client_id,omitempty" bson:"client_id,omitempty"`
+	ClientSecret string           `json:"client_secret,omitempty" bson:"client_secret,omitempty"`
+	Scopes       []string         `json:"scopes,omitempty" bson:"scopes,omitempty"` // Scopes are requested in addition to "openid".
+	ClaimMapping OIDCClaimMapping `json:"claim_mapping,omitempty" bson:"claim_mapping,omitempty"`
+}
+
+// OIDCClaimMapping tells which ID token claims hold the user's data, standard claims are used for empty fields.
+type OIDCClaimMapping struct {
+	UserID        string `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Email         string `json:"email,omitempty" bson:"email,omitempty"`
+	EmailVerified string `json:"email_verified,omitempty" bson:"email_verified,omitempty"`
+}
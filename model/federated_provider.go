@@ -0,0 +1,175 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrFederatedProviderNotConfigured is when the app has enabled the provider, but its settings are incomplete.
+var ErrFederatedProviderNotConfigured = errors.New("Federated provider settings are incomplete")
+
+// FederatedProvider is a federated identity provider users sign in with.
+// Providers are registered in FederatedProviderRegistry, and apps enable them in their federated providers settings.
+type FederatedProvider interface {
+	// ID is the provider users are linked to.
+	ID() FederatedIdentityProvider
+	// UserProfile exchanges the credential for the user's federated ID and profile, using the app's settings of the provider.
+	UserProfile(settings FederatedSettings, credential FederatedCredential) (FederatedProfile, error)
+}
+
+// FederatedRedirectProvider is a provider users could sign in with on the web pages.
+// Pages redirect users to the provider, which sends them back with authorization code.
+type FederatedRedirectProvider interface {
+	FederatedProvider
+	// RedirectConfigured tells if the app's settings are enough to sign in with redirect, like the client secret to exchange the code.
+	RedirectConfigured(settings FederatedSettings) bool
+	// SignInURL returns the URL of the provider sign-in page, which sends the user back to redirectURI with authorization code and the state.
+	SignInURL(settings FederatedSettings, redirectURI, state, nonce string) (string, error)
+}
+
+// FederatedProviderSource creates the providers apps configure themselves, like upstream OpenID Connect providers.
+type FederatedProviderSource interface {
+	AppProvider(app AppData, name string) (FederatedProvider, bool)
+	AppProviderNames(app AppData) []string
+}
+
+// FederatedCredential is what the app has got from the provider after the user has signed in there.
+// Each provider uses the fields it needs.
+type FederatedCredential struct {
+	AccessToken       string
+	AuthorizationCode string
+	IDToken           string
+	RedirectURI       string // RedirectURI the authorization code has been sent to.
+	CodeVerifier      string // CodeVerifier, if the authorization code has been requested with code challenge.
+	Nonce             string // Nonce, if ID token has been requested with it.
+}
+
+// FederatedProfile is the user's profile at the provider.
+type FederatedProfile struct {
+	ID            string // ID is the federated ID the user is linked to.
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// FederatedSettings are the app's settings of the federated provider, like client ID and secret.
+// Every provider has its own settings, and decodes them into its own struct.
+type FederatedSettings map[string]interface{}
+
+// Decode decodes the settings into v, using v's JSON tags.
+func (s FederatedSettings) Decode(v interface{}) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// federatedSettingsFrom makes settings from the provider info struct.
+func federatedSettingsFrom(v interface{}) FederatedSettings {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	settings := FederatedSettings{}
+	if err = json.Unmarshal(data, &settings); err != nil {
+		return nil
+	}
+	return settings
+}
+
+// NewFederatedProviderRegistry creates empty registry.
+func NewFederatedProviderRegistry() *FederatedProviderRegistry {
+	return &FederatedProviderRegistry{providers: make(map[FederatedIdentityProvider]FederatedProvider)}
+}
+
+// FederatedProviderRegistry keeps the providers users could sign in with.
+type FederatedProviderRegistry struct {
+	mu        sync.RWMutex
+	providers map[FederatedIdentityProvider]FederatedProvider
+	sources   []FederatedProviderSource
+}
+
+// Register registers the provider, replacing the one with the same ID.
+func (r *FederatedProviderRegistry) Register(p FederatedProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.providers[p.ID()] = p
+	registerFederatedIdentityProvider(p.ID())
+}
+
+// RegisterSource registers the source of the providers apps configure themselves.
+func (r *FederatedProviderRegistry) RegisterSource(s FederatedProviderSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sources = append(r.sources, s)
+}
+
+// AppProvider returns the provider with the name, if the app has enabled it, and the app's settings of the provider.
+// Registered providers go first, then the ones apps configure themselves.
+func (r *FederatedProviderRegistry) AppProvider(app AppData, name string) (FederatedProvider, FederatedSettings, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if p, ok := r.providers[FederatedIdentityProvider(strings.ToUpper(strings.TrimSpace(name)))]; ok {
+		settings, enabled := app.FederatedProviderSettings(p.ID())
+		return p, settings, enabled
+	}
+
+	for _, s := range r.sources {
+		if p, ok := s.AppProvider(app, name); ok {
+			return p, nil, true
+		}
+	}
+	return nil, nil, false
+}
+
+// AppProviderNames returns the names of the providers the app has enabled.
+func (r *FederatedProviderRegistry) AppProviderNames(app AppData) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := []string{}
+	for fid := range r.providers {
+		if _, enabled := app.FederatedProviderSettings(fid); enabled {
+			name := strings.ToLower(string(fid))
+			names = append(names, strings.ToUpper(name[:1])+name[1:])
+		}
+	}
+	sort.Strings(names)
+
+	for _, s := range r.sources {
+		names = append(names, s.AppProviderNames(app)...)
+	}
+	return names
+}
+
+// LinkFederatedIDByEmail links the federated identity to the user with the same email, if both the provider and the user have verified it.
+// Only the providers trusted server-wide could link, as the others, like upstream OpenID Connect providers with admin-editable claims, could claim any email verified.
+// It returns ErrUserNotFound if there is no such user, or the provider is not trusted.
+func LinkFederatedIDByEmail(us UserStorage, trusted []string, provider FederatedIdentityProvider, profile FederatedProfile) (User, error) {
+	if !TrustsFederatedProvider(trusted, provider) || len(profile.Email) == 0 || !profile.EmailVerified {
+		return User{}, ErrUserNotFound
+	}
+
+	user, err := us.UserByEmail(profile.Email)
+	if err != nil || len(user.ID) == 0 || !user.EmailVerified || !strings.EqualFold(user.Email, profile.Email) {
+		return User{}, ErrUserNotFound
+	}
+	return us.AddFederatedID(user.ID, provider, profile.ID)
+}
+
+// TrustsFederatedProvider tells if the provider is in the trusted list, which has built-in providers by name and OpenID Connect providers by issuer.
+func TrustsFederatedProvider(trusted []string, provider FederatedIdentityProvider) bool {
+	for _, t := range trusted {
+		if provider == FederatedIdentityProvider(strings.ToUpper(strings.TrimSpace(t))) || provider == OIDCIDProvider(t) {
+			return true
+		}
+	}
+	return false
+}
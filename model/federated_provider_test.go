@@ -0,0 +1,116 @@
+package model
+
+import "testing"
+
+type fakeProvider struct{}
+
+func (fakeProvider) ID() FederatedIdentityProvider { return "GITHUB" }
+
+func (fakeProvider) UserProfile(settings FederatedSettings, credential FederatedCredential) (FederatedProfile, error) {
+	var s struct {
+		ClientID string `json:"client_id"`
+	}
+	if err := settings.Decode(&s); err != nil || s.ClientID == "" {
+		return FederatedProfile{}, ErrFederatedProviderNotConfigured
+	}
+	return FederatedProfile{ID: s.ClientID + ":" + credential.AccessToken}, nil
+}
+
+func TestFederatedProviderRegistry(t *testing.T) {
+	r := NewFederatedProviderRegistry()
+	r.Register(fakeProvider{})
+
+	if !FederatedIdentityProvider("GITHUB").IsValid() {
+		t.Error("registered provider should be valid")
+	}
+
+	tests := []struct {
+		name        string
+		app         AppData
+		provider    string
+		wantEnabled bool
+		wantID      string
+	}{
+		{"enabled", AppData{FederatedProviders: map[string]FederatedSettings{"github": {"client_id": "client"}}}, "GitHub", true, "client:token"},
+		{"not enabled", AppData{FederatedProviders: map[string]FederatedSettings{"facebook": {}}}, "github", false, ""},
+		{"apps without the list", AppData{}, "github", false, ""},
+		{"unknown", AppData{FederatedProviders: map[string]FederatedSettings{"github": {}}}, "twitter", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, settings, enabled := r.AppProvider(tt.app, tt.provider)
+			if enabled != tt.wantEnabled {
+				t.Fatalf("AppProvider() enabled = %v, want %v", enabled, tt.wantEnabled)
+			}
+			if !enabled {
+				return
+			}
+			profile, err := p.UserProfile(settings, FederatedCredential{AccessToken: "token"})
+			if err != nil || profile.ID != tt.wantID {
+				t.Errorf("UserProfile() = %v, %v, want %v", profile.ID, err, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestAppDataFederatedProviderSettings(t *testing.T) {
+	app := AppData{AppleInfo: &AppleInfo{ClientID: "apple", ClientSecret: "secret"}}
+
+	if _, ok := app.FederatedProviderSettings(FacebookIDProvider); !ok {
+		t.Error("Facebook should be enabled for apps without the list")
+	}
+	if _, ok := app.FederatedProviderSettings(GoogleIDProvider); ok {
+		t.Error("Google should not be enabled without Google info")
+	}
+
+	settings, ok := app.FederatedProviderSettings(AppleIDProvider)
+	var appleInfo AppleInfo
+	if !ok || settings.Decode(&appleInfo) != nil || appleInfo != *app.AppleInfo {
+		t.Errorf("Apple settings = %v, %v, want Apple info", settings, ok)
+	}
+
+	if settings, ok := (AppData{}).FederatedProviderSettings(AppleIDProvider); !ok || len(settings) != 0 {
+		t.Errorf("Apple settings without Apple info = %v, %v, want empty settings", settings, ok)
+	}
+}
+
+func TestOIDCIDProvider(t *testing.T) {
+	okta := OIDCIDProvider("https://Example.okta.com/")
+	if okta != OIDCIDProvider("https://example.okta.com") {
+		t.Errorf("OIDCIDProvider() differs for the same issuer")
+	}
+	if okta == OIDCIDProvider("https://another.okta.com") {
+		t.Errorf("OIDCIDProvider() is the same for different issuers")
+	}
+	if !okta.IsValid() || !okta.IsOIDC() {
+		t.Errorf("OIDCIDProvider() = %v is not a valid OpenID Connect provider", okta)
+	}
+	if identities := (User{FederatedIDs: []string{string(okta) + ":user1"}}).FederatedIdentities(); len(identities) != 1 || identities[0].Provider != okta || identities[0].ID != "user1" {
+		t.Errorf("FederatedIdentities() = %v, want %v user1", identities, okta)
+	}
+}
+
+func TestTrustsFederatedProvider(t *testing.T) {
+	trusted := []string{"google", "https://example.okta.com/"}
+
+	tests := []struct {
+		name     string
+		provider FederatedIdentityProvider
+		want     bool
+	}{
+		{"built-in by name", GoogleIDProvider, true},
+		{"OpenID Connect by issuer", OIDCIDProvider("https://Example.okta.com"), true},
+		{"not trusted built-in", FacebookIDProvider, false},
+		{"not trusted OpenID Connect", OIDCIDProvider("https://another.okta.com"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TrustsFederatedProvider(trusted, tt.provider); got != tt.want {
+				t.Errorf("TrustsFederatedProvider() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if TrustsFederatedProvider(nil, GoogleIDProvider) {
+		t.Error("TrustsFederatedProvider() of empty list = true, want false")
+	}
+}
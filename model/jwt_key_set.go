@@ -0,0 +1,154 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	ijwt "github.com/madappgang/identifo/jwt"
+)
+
+// ErrKeyRotationScheduled is when the key set already has the key waiting for activation.
+var ErrKeyRotationScheduled = errors.New("Key rotation is already scheduled")
+
+// KeyState is a state of the key in the key set.
+type KeyState string
+
+const (
+	// KeyStateNext is a key published for verification, which is not used for signing yet.
+	KeyStateNext KeyState = "next"
+	// KeyStateActive is a key used for verification. The latest active key is used for signing.
+	KeyStateActive KeyState = "active"
+	// KeyStateRetired is a key which is neither used nor published anymore.
+	KeyStateRetired KeyState = "retired"
+)
+
+// JWTKey is a versioned key pair of the key set.
+// Next key becomes active at ActivatesAt, and active key becomes retired at RetiresAt, if these are set.
+type JWTKey struct {
+	Version     int                          `json:"version"`
+	State       KeyState                     `json:"state"`
+	Algorithm   ijwt.TokenSignatureAlgorithm `json:"algorithm"`
+	CreatedAt   time.Time                    `json:"created_at"`
+	ActivatesAt time.Time                    `json:"activates_at"`
+	RetiresAt   time.Time                    `json:"retires_at"`
+	Private     interface{}                  `json:"-"`
+	Public      interface{}                  `json:"-"`
+}
+
+// ID returns key ID, which is put into the "kid" header of the tokens signed with this key.
+func (k JWTKey) ID() string {
+	return ijwt.KeyID(k.Public)
+}
+
+// StateAt returns key state at the given time, taking scheduled activation and retirement into account.
+func (k JWTKey) StateAt(t time.Time) KeyState {
+	if k.State == KeyStateRetired || (!k.RetiresAt.IsZero() && !t.Before(k.RetiresAt)) {
+		return KeyStateRetired
+	}
+	if k.State == KeyStateNext && !k.ActivatesAt.IsZero() && !t.Before(k.ActivatesAt) {
+		return KeyStateActive
+	}
+	return k.State
+}
+
+// JWTKeySet is a versioned set of keys used for signing and verifying JSON web tokens.
+// It lets us rotate keys without invalidating the tokens signed with the previous key.
+type JWTKeySet struct {
+	Keys []JWTKey `json:"keys"`
+}
+
+// NewJWTKeySet creates the key set with the only active key.
+func NewJWTKeySet(keys *JWTKeys) (*JWTKeySet, error) {
+	if keys == nil || keys.Private == nil || keys.Public == nil {
+		return nil, fmt.Errorf("One of the keys is empty, or both")
+	}
+
+	alg, ok := keys.Algorithm.(ijwt.TokenSignatureAlgorithm)
+	if !ok || alg == ijwt.TokenSignatureAlgorithmAuto {
+		return nil, fmt.Errorf("Unknown token service algorithm %s ", keys.Algorithm)
+	}
+
+	return &JWTKeySet{Keys: []JWTKey{{
+		Version:   1,
+		State:     KeyStateActive,
+		Algorithm: alg,
+		Private:   keys.Private,
+		Public:    keys.Public,
+	}}}, nil
+}
+
+// SigningKey returns the active key with the latest version.
+func (ks *JWTKeySet) SigningKey(t time.Time) (JWTKey, bool) {
+	var signingKey JWTKey
+	found := false
+	for _, k := range ks.Keys {
+		if k.StateAt(t) == KeyStateActive && (!found || k.Version > signingKey.Version) {
+			signingKey, found = k, true
+		}
+	}
+	return signingKey, found
+}
+
+// VerificationKeys returns next and active keys.
+func (ks *JWTKeySet) VerificationKeys(t time.Time) []JWTKey {
+	keys := []JWTKey{}
+	for _, k := range ks.Keys {
+		if k.StateAt(t) != KeyStateRetired {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// VerificationKey returns the next or active key by its ID.
+func (ks *JWTKeySet) VerificationKey(kid string, t time.Time) (JWTKey, bool) {
+	for _, k := range ks.VerificationKeys(t) {
+		if k.ID() == kid {
+			return k, true
+		}
+	}
+	return JWTKey{}, false
+}
+
+// Rotate adds the new key, which becomes active at activatesAt,
+// and schedules retirement of the currently active keys at retiresAt.
+// Retirement time should leave enough time for the tokens signed with the current keys to expire.
+func (ks *JWTKeySet) Rotate(private, public interface{}, activatesAt, retiresAt time.Time) error {
+	now := time.Now()
+	if retiresAt.Before(activatesAt) {
+		return fmt.Errorf("Current keys cannot retire before the new key activates")
+	}
+
+	signingKey, ok := ks.SigningKey(now)
+	if !ok {
+		return fmt.Errorf("Key set has no active key")
+	}
+
+	version := 0
+	for i, k := range ks.Keys {
+		// Persist effective states, so the key set reads well in storage.
+		switch ks.Keys[i].State = k.StateAt(now); ks.Keys[i].State {
+		case KeyStateNext:
+			return ErrKeyRotationScheduled
+		case KeyStateActive:
+			if k.RetiresAt.IsZero() || k.RetiresAt.After(retiresAt) {
+				ks.Keys[i].RetiresAt = retiresAt
+			}
+		}
+		if k.Version > version {
+			version = k.Version
+		}
+	}
+
+	ks.Keys = append(ks.Keys, JWTKey{
+		Version:     version + 1,
+		State:       KeyStateNext,
+		Algorithm:   signingKey.Algorithm,
+		CreatedAt:   now,
+		ActivatesAt: activatesAt,
+		Private:     private,
+		Public:      public,
+	})
+	return nil
+}
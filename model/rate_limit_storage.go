@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+// RateLimitStorage keeps the request counters of the rate limiter.
+type RateLimitStorage interface {
+	// Increment counts one more request with the key and returns the number of them.
+	// The counter is dropped at expireAt, which is the same for every request in the key's window.
+	Increment(key string, expireAt time.Time) (int, error)
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"io"
+	"strings"
+)
+
+// RecoveryCodesCount is the number of recovery codes the user gets when they enable TFA.
+const RecoveryCodesCount = 10
+
+// recoveryCodeEncoding is lowercase base32 without padding, which is easy to type.
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// NewRecoveryCodes generates the recovery codes to show to the user, and their hashes to store in the TFA info.
+func NewRecoveryCodes() (codes, hashes []string, err error) {
+	codes = make([]string, 0, RecoveryCodesCount)
+	hashes = make([]string, 0, RecoveryCodesCount)
+	for i := 0; i < RecoveryCodesCount; i++ {
+		b := make([]byte, 5)
+		if _, err = io.ReadFull(rand.Reader, b); err != nil {
+			return nil, nil, err
+		}
+		code := recoveryCodeEncoding.EncodeToString(b)
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, recoveryCodeHash(code))
+	}
+	return codes, hashes, nil
+}
+
+// UseRecoveryCode removes the recovery code from the TFA info, if it has it, so the code could be used once.
+// Codes are compared regardless of case and dashes.
+func (i *TFAInfo) UseRecoveryCode(code string) bool {
+	hash := recoveryCodeHash(code)
+	for n, h := range i.RecoveryCodes {
+		if h == hash {
+			i.RecoveryCodes = append(i.RecoveryCodes[:n:n], i.RecoveryCodes[n+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// recoveryCodeHash hashes the normalized code. Codes are random, so they do not need the slow password hash.
+func recoveryCodeHash(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes() error = %v", err)
+	}
+	if len(codes) != RecoveryCodesCount || len(hashes) != RecoveryCodesCount {
+		t.Fatalf("NewRecoveryCodes() = %d codes, %d hashes, want %d", len(codes), len(hashes), RecoveryCodesCount)
+	}
+	for i, c := range codes {
+		if hashes[i] == c || strings.Contains(hashes[i], c) {
+			t.Errorf("NewRecoveryCodes() hash %q should not contain the code %q", hashes[i], c)
+		}
+	}
+
+	info := TFAInfo{RecoveryCodes: hashes}
+	if info.UseRecoveryCode("123456") {
+		t.Error("UseRecoveryCode() accepted unknown code")
+	}
+	if !info.UseRecoveryCode(strings.ToUpper(strings.Replace(codes[3], "-", "", 1))) {
+		t.Error("UseRecoveryCode() should ignore case and dashes")
+	}
+	if info.UseRecoveryCode(codes[3]) {
+		t.Error("UseRecoveryCode() accepted used code")
+	}
+	if len(info.RecoveryCodes) != RecoveryCodesCount-1 || hashes[RecoveryCodesCount-1] != info.RecoveryCodes[RecoveryCodesCount-2] {
+		t.Errorf("UseRecoveryCode() left %d codes, want %d", len(info.RecoveryCodes), RecoveryCodesCount-1)
+	}
+}
+
+func TestRecoveryCodesNotServed(t *testing.T) {
+	_, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes() error = %v", err)
+	}
+	user := User{Email: "user@example.com", TFAInfo: TFAInfo{IsEnabled: true, RecoveryCodes: hashes}}
+
+	// /me serves the masked user to preauth token holders.
+	me, err := json.Marshal(user.SanitizedTFA())
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	for _, h := range hashes {
+		if strings.Contains(string(me), h) {
+			t.Fatalf("SanitizedTFA() = %s, should not contain recovery code hash %q", me, h)
+		}
+	}
+}
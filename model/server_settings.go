@@ -17,6 +17,7 @@ type ServerSettings struct {
 	ExternalServices     ExternalServicesSettings     `yaml:"externalServices,omitempty" json:"external_services,omitempty"`
 	Login                LoginSettings                `yaml:"login,omitempty" json:"login,omitempty"`
 	Logger               LoggerSettings               `yaml:"logger,omitempty" json:"logger,omitempty"`
+	RateLimit            RateLimitSettings            `yaml:"rateLimit,omitempty" json:"rate_limit,omitempty"`
 }
 
 // GeneralServerSettings are general server settings.
@@ -40,15 +41,17 @@ type StorageSettings struct {
 	TokenBlacklist          DatabaseSettings `yaml:"tokenBlacklist,omitempty" json:"token_blacklist,omitempty"`
 	VerificationCodeStorage DatabaseSettings `yaml:"verificationCodeStorage,omitempty" json:"verification_code_storage,omitempty"`
 	InviteStorage           DatabaseSettings `yaml:"inviteStorage,omitempty" json:"invite_storage,omitempty"`
+	DeviceCodeStorage       DatabaseSettings `yaml:"deviceCodeStorage,omitempty" json:"device_code_storage,omitempty"`
 }
 
 // DatabaseSettings holds together all settings applicable to a particular database.
 type DatabaseSettings struct {
-	Type     DatabaseType `yaml:"type,omitempty" json:"type,omitempty"`
-	Name     string       `yaml:"name,omitempty" json:"name,omitempty"`
-	Endpoint string       `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
-	Region   string       `yaml:"region,omitempty" json:"region,omitempty"`
-	Path     string       `yaml:"path,omitempty" json:"path,omitempty"`
+	Type     DatabaseType  `yaml:"type,omitempty" json:"type,omitempty"`
+	Name     string        `yaml:"name,omitempty" json:"name,omitempty"`
+	Endpoint string        `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	Region   string        `yaml:"region,omitempty" json:"region,omitempty"`
+	Path     string        `yaml:"path,omitempty" json:"path,omitempty"`
+	LDAP     *LDAPSettings `yaml:"ldap,omitempty" json:"ldap,omitempty"`
 }
 
 // DatabaseType is a type of database.
@@ -59,8 +62,41 @@ const (
 	DBTypeMongoDB  DatabaseType = "mongodb"  // DBTypeMongoDB is for MongoDB.
 	DBTypeDynamoDB DatabaseType = "dynamodb" // DBTypeDynamoDB is for DynamoDB.
 	DBTypeFake     DatabaseType = "fake"     // DBTypeFake is for in-memory storage.
+	DBTypeLDAP     DatabaseType = "ldap"     // DBTypeLDAP is for LDAP or Active Directory in front of the primary user storage.
 )
 
+// LDAPSettings are settings of the LDAP directory users sign in with.
+// The directory checks the passwords, and the primary storage keeps everything else, like TFA and login metadata.
+type LDAPSettings struct {
+	BindDN             string               `yaml:"bindDN,omitempty" json:"bind_dn,omitempty"`             // BindDN is the service account to search users with. Anonymous search if empty.
+	BindPassword       string               `yaml:"bindPassword,omitempty" json:"bind_password,omitempty"` // BindPassword is the service account password.
+	BaseDN             string               `yaml:"baseDN,omitempty" json:"base_dn,omitempty"`             // BaseDN is where to search users.
+	UserFilter         string               `yaml:"userFilter,omitempty" json:"user_filter,omitempty"`     // UserFilter finds the user by name, like "(&(objectClass=user)(sAMAccountName=%s))".
+	StartTLS           bool                 `yaml:"startTLS,omitempty" json:"start_tls,omitempty"`
+	InsecureSkipVerify bool                 `yaml:"insecureSkipVerify,omitempty" json:"insecure_skip_verify,omitempty"`
+	Attributes         LDAPAttributeMapping `yaml:"attributes,omitempty" json:"attributes,omitempty"`
+	GroupRoles         []LDAPGroupRole      `yaml:"groupRoles,omitempty" json:"group_roles,omitempty"` // GroupRoles map groups to access roles, the first matching group wins.
+	DefaultRole        string               `yaml:"defaultRole,omitempty" json:"default_role,omitempty"`
+	ProvisionUsers     bool                 `yaml:"provisionUsers,omitempty" json:"provision_users,omitempty"`     // ProvisionUsers creates the user in the primary storage on the first login.
+	LinkUsersByName    bool                 `yaml:"linkUsersByName,omitempty" json:"link_users_by_name,omitempty"` // LinkUsersByName links the existing user with the same name and no password on the first login.
+	PrimaryStorage     DatabaseSettings     `yaml:"primaryStorage,omitempty" json:"primary_storage,omitempty"`
+}
+
+// LDAPAttributeMapping are the names of the directory attributes user fields are taken from.
+type LDAPAttributeMapping struct {
+	ID       string `yaml:"id,omitempty" json:"id,omitempty"` // ID is the unique attribute, like "objectGUID" or "entryUUID". DN if empty.
+	Username string `yaml:"username,omitempty" json:"username,omitempty"`
+	Email    string `yaml:"email,omitempty" json:"email,omitempty"`
+	Phone    string `yaml:"phone,omitempty" json:"phone,omitempty"`
+	Groups   string `yaml:"groups,omitempty" json:"groups,omitempty"`
+}
+
+// LDAPGroupRole maps members of the group to the access role.
+type LDAPGroupRole struct {
+	Group string `yaml:"group,omitempty" json:"group,omitempty"` // Group is the group DN or CN.
+	Role  string `yaml:"role,omitempty" json:"role,omitempty"`
+}
+
 // StaticFilesStorageSettings are settings for static files storage.
 type StaticFilesStorageSettings struct {
 	Type             StaticFilesStorageType `yaml:"type,omitempty" json:"type,omitempty"`
@@ -129,6 +165,47 @@ const (
 	SessionStorageDynamoDB = "dynamodb"
 )
 
+// RateLimitSettings are settings of the rate limiter, which protects authentication endpoints from brute force.
+type RateLimitSettings struct {
+	Type       RateLimitStorageType   `yaml:"type,omitempty" json:"type,omitempty"` // Type is where to keep request counters. Requests are not limited if it is empty.
+	Address    string                 `yaml:"address,omitempty" json:"address,omitempty"`
+	Password   string                 `yaml:"password,omitempty" json:"password,omitempty"`
+	DB         int                    `yaml:"db,omitempty" json:"db,omitempty"`
+	Region     string                 `yaml:"region,omitempty" json:"region,omitempty"`
+	Endpoint   string                 `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	TrustProxy bool                   `yaml:"trustProxy,omitempty" json:"trust_proxy,omitempty"` // TrustProxy takes client IP from the X-Forwarded-For header the load balancer sets.
+	Policies   map[string][]RateLimit `yaml:"policies,omitempty" json:"policies,omitempty"`      // Policies replace the default limits of the endpoints, by the policy name, like "login".
+}
+
+// RateLimit allows the number of requests with the same key in the window.
+type RateLimit struct {
+	Key      RateLimitKey `yaml:"key" json:"key"`
+	Requests int          `yaml:"requests" json:"requests"`
+	Window   int          `yaml:"window" json:"window"` // Window is the length of the window in seconds.
+}
+
+// RateLimitKey is what the requests are counted by.
+type RateLimitKey string
+
+const (
+	RateLimitKeyIP    RateLimitKey = "ip"    // RateLimitKeyIP counts requests from the client IP address.
+	RateLimitKeyUser  RateLimitKey = "user"  // RateLimitKeyUser counts requests for the username or email.
+	RateLimitKeyPhone RateLimitKey = "phone" // RateLimitKeyPhone counts requests for the phone number.
+	RateLimitKeyApp   RateLimitKey = "app"   // RateLimitKeyApp counts requests from all the clients of the app.
+)
+
+// RateLimitStorageType is where to keep request counters.
+type RateLimitStorageType string
+
+const (
+	// RateLimitStorageMem keeps counters in memory, it is for a single node.
+	RateLimitStorageMem = "memory"
+	// RateLimitStorageRedis keeps counters in Redis, shared by the cluster.
+	RateLimitStorageRedis = "redis"
+	// RateLimitStorageDynamoDB keeps counters in DynamoDB, shared by the cluster.
+	RateLimitStorageDynamoDB = "dynamodb"
+)
+
 // KeyStorageSettings are settings for the key storage.
 type KeyStorageSettings struct {
 	Type   KeyStorageType `yaml:"type,omitempty" json:"type,omitempty"`
@@ -207,8 +284,19 @@ const (
 
 // LoginSettings are settings of login.
 type LoginSettings struct {
-	LoginWith LoginWith `yaml:"loginWith,omitempty" json:"login_with,omitempty"`
-	TFAType   TFAType   `yaml:"tfaType,omitempty" json:"tfa_type,omitempty"`
+	LoginWith LoginWith   `yaml:"loginWith,omitempty" json:"login_with,omitempty"`
+	TFAType   TFAType     `yaml:"tfaType,omitempty" json:"tfa_type,omitempty"`
+	TFA       TFASettings `yaml:"tfa,omitempty" json:"tfa,omitempty"`
+	// TrustedFederatedProviders are the providers, whose verified emails link federated identities to the existing users in the apps that link them by email.
+	// Built-in providers are set by name, like "google", upstream OpenID Connect providers by issuer.
+	TrustedFederatedProviders []string `yaml:"trustedFederatedProviders,omitempty" json:"trusted_federated_providers,omitempty"`
+}
+
+// TFASettings are settings of one-time password checks.
+type TFASettings struct {
+	TOTPWindow      int `yaml:"totpWindow,omitempty" json:"totp_window,omitempty"`           // TOTPWindow is the number of time steps before and after the current one, which codes are accepted for, to allow for clock drift.
+	MaxAttempts     int `yaml:"maxAttempts,omitempty" json:"max_attempts,omitempty"`         // MaxAttempts is the number of failed attempts, after which the user's TFA challenge is locked. 0 means no limit.
+	LockoutDuration int `yaml:"lockoutDuration,omitempty" json:"lockout_duration,omitempty"` // LockoutDuration is how long the challenge is locked, in seconds.
 }
 
 // LoginWith is a type for configuring supported login ways.
@@ -216,15 +304,18 @@ type LoginWith struct {
 	Username  bool `yaml:"username" json:"username,omitempty"`
 	Phone     bool `yaml:"phone" json:"phone,omitempty"`
 	Federated bool `yaml:"federated" json:"federated,omitempty"`
+	WebAuthn  bool `yaml:"webauthn" json:"webauthn,omitempty"`
+	Email     bool `yaml:"email" json:"email,omitempty"`
 }
 
 // TFAType is a type of two-factor authentication for apps that support it.
 type TFAType string
 
 const (
-	TFATypeApp   TFAType = "app"   // TFATypeApp is an app (like Google Authenticator).
-	TFATypeSMS   TFAType = "sms"   // TFATypeSMS is an SMS.
-	TFATypeEmail TFAType = "email" // TFATypeEmail is an email.
+	TFATypeApp      TFAType = "app"      // TFATypeApp is an app (like Google Authenticator).
+	TFATypeSMS      TFAType = "sms"      // TFATypeSMS is an SMS.
+	TFATypeEmail    TFAType = "email"    // TFATypeEmail is an email.
+	TFATypeWebAuthn TFAType = "webauthn" // TFATypeWebAuthn is a security key or a platform authenticator.
 )
 
 // GetPort returns port on which host listens to incoming connections.
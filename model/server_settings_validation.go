@@ -33,6 +33,9 @@ func (ss *ServerSettings) Validate() error {
 	if err := ss.ExternalServices.Validate(); err != nil {
 		return err
 	}
+	if err := ss.RateLimit.Validate(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -106,6 +109,22 @@ func (dbs *DatabaseSettings) Validate() error {
 		if len(dbs.Name) == 0 {
 			return fmt.Errorf("Empty database name")
 		}
+	case DBTypeLDAP:
+		if _, err := url.ParseRequestURI(dbs.Endpoint); err != nil {
+			return fmt.Errorf("Invalid endpoint. %s", err)
+		}
+		if dbs.LDAP == nil {
+			return fmt.Errorf("Empty LDAP settings")
+		}
+		if len(dbs.LDAP.BaseDN) == 0 {
+			return fmt.Errorf("Empty LDAP base DN")
+		}
+		if dbs.LDAP.PrimaryStorage.Type == DBTypeLDAP {
+			return fmt.Errorf("LDAP primary storage should not be LDAP")
+		}
+		if err := dbs.LDAP.PrimaryStorage.Validate(); err != nil {
+			return fmt.Errorf("LDAP primary storage: %s", err)
+		}
 	default:
 		return fmt.Errorf("%s. Unknown type", subject)
 	}
@@ -143,6 +162,42 @@ func (sss *SessionStorageSettings) Validate() error {
 	return nil
 }
 
+// Validate validates rate limiter settings.
+func (rls *RateLimitSettings) Validate() error {
+	subject := "RateLimitSettings"
+	if rls == nil {
+		return fmt.Errorf("Nil %s", subject)
+	}
+
+	switch rls.Type {
+	case "", RateLimitStorageMem:
+	case RateLimitStorageRedis:
+		if len(rls.Address) == 0 {
+			return fmt.Errorf("%s. Empty Redis address", subject)
+		}
+	case RateLimitStorageDynamoDB:
+		if len(rls.Region) == 0 {
+			return fmt.Errorf("%s. Empty AWS region", subject)
+		}
+	default:
+		return fmt.Errorf("%s. Unknown type", subject)
+	}
+
+	for policy, limits := range rls.Policies {
+		for _, l := range limits {
+			switch l.Key {
+			case RateLimitKeyIP, RateLimitKeyUser, RateLimitKeyPhone, RateLimitKeyApp:
+			default:
+				return fmt.Errorf("%s. Unknown key '%s' in policy '%s'", subject, l.Key, policy)
+			}
+			if l.Requests <= 0 || l.Window <= 0 {
+				return fmt.Errorf("%s. Requests and window must be positive in policy '%s'", subject, policy)
+			}
+		}
+	}
+	return nil
+}
+
 const identifoConfigBucketEnvName = "IDENTIFO_CONFIG_BUCKET"
 
 // Validate validates configuration storage settings.
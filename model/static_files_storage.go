@@ -45,12 +45,15 @@ const (
 
 // StaticPagesNames are the names of html pages.
 var StaticPagesNames = StaticPages{
+	Device:                "device.html",
 	DisableTFA:            "disable-tfa.html",
 	DisableTFASuccess:     "disable-tfa-success.html",
+	EmailLink:             "email-link.html",
 	ForgotPassword:        "forgot-password.html",
 	ForgotPasswordSuccess: "forgot-password-success.html",
 	InviteEmail:           "invite-email.html",
 	Login:                 "login.html",
+	MagicLinkEmail:        "magic-link-email.html",
 	Misconfiguration:      "misconfiguration.html",
 	Registration:          "registration.html",
 	ResetPassword:         "reset-password.html",
@@ -58,21 +61,27 @@ var StaticPagesNames = StaticPages{
 	ResetPasswordSuccess:  "reset-password-success.html",
 	ResetTFA:              "reset-tfa.html",
 	ResetTFASuccess:       "reset-tfa-success.html",
+	TFAChallenge:          "tfa-challenge.html",
 	TFAEmail:              "tfa-email.html",
+	TFAEnroll:             "tfa-enroll.html",
 	TokenError:            "token-error.html",
 	VerifyEmail:           "verify-email.html",
+	WebAuthn:              "webauthn.html",
 	WebMessage:            "web-message.html",
 	WelcomeEmail:          "welcome-email.html",
 }
 
 // StaticPages holds together all paths to static pages.
 type StaticPages struct {
+	Device                string
 	DisableTFA            string
 	DisableTFASuccess     string
+	EmailLink             string
 	ForgotPassword        string
 	ForgotPasswordSuccess string
 	InviteEmail           string
 	Login                 string
+	MagicLinkEmail        string
 	Misconfiguration      string
 	Registration          string
 	ResetPassword         string
@@ -80,9 +89,12 @@ type StaticPages struct {
 	ResetPasswordSuccess  string
 	ResetTFA              string
 	ResetTFASuccess       string
+	TFAChallenge          string
 	TFAEmail              string
+	TFAEnroll             string
 	TokenError            string
 	VerifyEmail           string
+	WebAuthn              string
 	WebMessage            string
 	WelcomeEmail          string
 }
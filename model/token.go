@@ -1,11 +1,16 @@
 package model
 
 const (
-	TokenTypeInvite      = "invite"      // TokenTypeInvite is an invite token type value.
-	TokenTypeReset       = "reset"       // TokenTypeReset is an reset token type value.
-	TokenTypeWebCookie   = "web-cookie"  // TokenTypeWebCookie is a web-cookie token type value.
-	TokenTypeAccess      = "access"      // TokenTypeAccess is an access token type.
-	TokenTypeRefresh     = "refresh"     // TokenTypeRefresh is a refresh token type.
-	TokenTypeTFAPreauth  = "2fa-preauth" // TokenTypeTFAPreauth is an 2fa preauth token type.
-	TokenTFAPreauthScope = "2fa"         // TokenTFAPreauthScope preauth token scope for first step of TFA
+	TokenTypeInvite        = "invite"         // TokenTypeInvite is an invite token type value.
+	TokenTypeReset         = "reset"          // TokenTypeReset is an reset token type value.
+	TokenTypeWebCookie     = "web-cookie"     // TokenTypeWebCookie is a web-cookie token type value.
+	TokenTypeAccess        = "access"         // TokenTypeAccess is an access token type.
+	TokenTypeRefresh       = "refresh"        // TokenTypeRefresh is a refresh token type.
+	TokenTypeTFAPreauth    = "2fa-preauth"    // TokenTypeTFAPreauth is an 2fa preauth token type.
+	TokenTypeAuthCode      = "auth-code"      // TokenTypeAuthCode is an OAuth 2.0 authorization code type.
+	TokenTypeWebAuthn      = "webauthn"       // TokenTypeWebAuthn is a WebAuthn ceremony session type.
+	TokenTypeTFAChallenge  = "tfa-challenge"  // TokenTypeTFAChallenge is a type of the token of the user who passes the second factor on the web login page.
+	TokenTypeTrustedDevice = "trusted-device" // TokenTypeTrustedDevice is a type of the token that lets the device skip two-factor authentication.
+	TokenTypeMagicLink     = "magic-link"     // TokenTypeMagicLink is a type of the token in the link, which signs the user in by email.
+	TokenTFAPreauthScope   = "2fa"            // TokenTFAPreauthScope preauth token scope for first step of TFA
 )
@@ -1,17 +1,40 @@
 package model
 
+import "time"
+
 // TokenStorage is a storage for issued refresh tokens.
 type TokenStorage interface {
 	SaveToken(token string) error
 	HasToken(token string) bool
 	DeleteToken(token string) error
+	SaveTokenFamily(family TokenFamily) error
+	// SwapTokenFamily replaces the family, if it has not been revoked and its current token is still currentToken.
+	// Otherwise, it returns ErrorNotFound, as another exchange or revocation has happened in between.
+	SwapTokenFamily(family TokenFamily, currentToken string) error
+	TokenFamily(familyID string) (TokenFamily, error)
 	Close()
 }
 
+// TokenFamily is a chain of refresh tokens, each one issued in exchange for the previous one.
+// Only the current token of the family could be exchanged. When the earlier one is presented,
+// it could have been stolen, so the whole family gets revoked.
+type TokenFamily struct {
+	ID           string    `json:"id" bson:"_id"`
+	UserID       string    `json:"user_id" bson:"user_id"`
+	AppID        string    `json:"app_id" bson:"app_id"`
+	CurrentToken string    `json:"current_token,omitempty" bson:"current_token,omitempty"`
+	Revoked      bool      `json:"revoked" bson:"revoked"`
+	UpdatedAt    time.Time `json:"updated_at" bson:"updated_at"`
+	ExpiresAt    time.Time `json:"expires_at" bson:"expires_at"`
+}
+
 // TokenBlacklist is a storage for blacklisted tokens.
 type TokenBlacklist interface {
 	IsBlacklisted(token string) bool
 	Add(token string) error
+	// Consume blacklists the single-use token, like the authorization code. Only one of the concurrent callers succeeds,
+	// the others get ErrorNotFound, as the token has been used already.
+	Consume(token string) error
 	Close()
 }
 
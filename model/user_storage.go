@@ -27,6 +27,7 @@ type UserStorage interface {
 	AddUserByPhone(phone, role string) (User, error)
 	UserByID(id string) (User, error)
 	UserByEmail(email string) (User, error)
+	AddUserByEmail(email, role string) (User, error)
 	IDByName(name string) (string, error)
 	AttachDeviceToken(id, token string) error
 	DetachDeviceToken(token string) error
@@ -35,6 +36,13 @@ type UserStorage interface {
 	UserExists(name string) bool
 	UserByFederatedID(provider FederatedIdentityProvider, id string) (User, error)
 	AddUserWithFederatedID(provider FederatedIdentityProvider, id, role string) (User, error)
+	AddFederatedID(userID string, provider FederatedIdentityProvider, id string) (User, error)
+	RemoveFederatedID(userID string, provider FederatedIdentityProvider, id string) (User, error)
+	AddWebAuthnCredential(userID string, credential WebAuthnCredential) (User, error)
+	UpdateWebAuthnCredential(userID string, credential WebAuthnCredential) error
+	RemoveWebAuthnCredential(userID, credentialID string) (User, error)
+	AddTrustedDevice(userID string, device TrustedDevice) (User, error)
+	RemoveTrustedDevice(userID, deviceID string) (User, error)
 	UpdateUser(userID string, newUser User) (User, error)
 	ResetPassword(id, password string) error
 	DeleteUser(id string) error
@@ -44,24 +52,102 @@ type UserStorage interface {
 	Scopes() []string
 	ImportJSON(data []byte) error
 	UpdateLoginMetadata(userID string)
+	IncrementTFAFailedAttempts(userID string) (int, error)
+	// LockTFA locks the user's TFA challenge until the given time, and resets the failed attempts.
+	// It leaves the rest of the TFA info as is, so the codes accepted in between are not lost.
+	LockTFA(userID string, until time.Time) error
+	// SwapTFAInfo replaces the user's TFA info, if its factors and recovery codes are still those of current.
+	// Otherwise, it returns ErrorNotFound, as another code has been accepted in between.
+	SwapTFAInfo(userID string, info, current TFAInfo) error
 	Close()
 }
 
 // User is an abstract representation of the user in auth layer.
 // Everything can be User, we do not depend on any particular implementation.
 type User struct {
-	ID              string   `json:"id,omitempty" bson:"_id,omitempty"`
-	Username        string   `json:"username,omitempty" bson:"username,omitempty"`
-	Email           string   `json:"email,omitempty" bson:"email,omitempty"`
-	Phone           string   `json:"phone,omitempty" bson:"phone,omitempty"`
-	Pswd            string   `json:"pswd,omitempty" bson:"pswd,omitempty"`
-	Active          bool     `json:"active,omitempty" bson:"active,omitempty"`
-	TFAInfo         TFAInfo  `json:"tfa_info,omitempty" bson:"tfa_info,omitempty"`
-	NumOfLogins     int      `json:"num_of_logins,omitempty" bson:"num_of_logins,omitempty"`
-	LatestLoginTime int64    `json:"latest_login_time,omitempty" bson:"latest_login_time,omitempty"`
-	AccessRole      string   `json:"access_role,omitempty" bson:"access_role,omitempty"`
-	Anonymous       bool     `json:"anonymous,omitempty" bson:"anonymous,omitempty"`
-	FederatedIDs    []string `json:"federated_ids,omitempty" bson:"federated_i_ds,omitempty"`
+	ID                  string               `json:"id,omitempty" bson:"_id,omitempty"`
+	Username            string               `json:"username,omitempty" bson:"username,omitempty"`
+	Email               string               `json:"email,omitempty" bson:"email,omitempty"`
+	Phone               string               `json:"phone,omitempty" bson:"phone,omitempty"`
+	EmailVerified       bool                 `json:"email_verified,omitempty" bson:"email_verified,omitempty"`
+	PhoneVerified       bool                 `json:"phone_verified,omitempty" bson:"phone_verified,omitempty"`
+	Pswd                string               `json:"pswd,omitempty" bson:"pswd,omitempty"`
+	Active              bool                 `json:"active,omitempty" bson:"active,omitempty"`
+	TFAInfo             TFAInfo              `json:"tfa_info,omitempty" bson:"tfa_info,omitempty"`
+	NumOfLogins         int                  `json:"num_of_logins,omitempty" bson:"num_of_logins,omitempty"`
+	LatestLoginTime     int64                `json:"latest_login_time,omitempty" bson:"latest_login_time,omitempty"`
+	AccessRole          string               `json:"access_role,omitempty" bson:"access_role,omitempty"`
+	Anonymous           bool                 `json:"anonymous,omitempty" bson:"anonymous,omitempty"`
+	FederatedIDs        []string             `json:"federated_ids,omitempty" bson:"federated_ids,omitempty"`
+	WebAuthnCredentials []WebAuthnCredential `json:"webauthn_credentials,omitempty" bson:"webauthn_credentials,omitempty"`
+	TrustedDevices      []TrustedDevice      `json:"trusted_devices,omitempty" bson:"trusted_devices,omitempty"`
+}
+
+// WebAuthnCredential is the user's public key credential, like a security key or a passkey.
+type WebAuthnCredential struct {
+	ID              string   `json:"id" bson:"id"` // ID is a base64url encoded credential ID.
+	Name            string   `json:"name,omitempty" bson:"name,omitempty"`
+	PublicKey       []byte   `json:"public_key,omitempty" bson:"public_key,omitempty"`
+	AttestationType string   `json:"attestation_type,omitempty" bson:"attestation_type,omitempty"`
+	AAGUID          []byte   `json:"aaguid,omitempty" bson:"aaguid,omitempty"`
+	SignCount       uint32   `json:"sign_count,omitempty" bson:"sign_count,omitempty"`
+	Transports      []string `json:"transports,omitempty" bson:"transports,omitempty"`
+	CreatedAt       int64    `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	LastUsedAt      int64    `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+}
+
+// WebAuthnCredential returns the user's credential with the ID.
+func (u User) WebAuthnCredential(id string) (WebAuthnCredential, bool) {
+	for _, c := range u.WebAuthnCredentials {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return WebAuthnCredential{}, false
+}
+
+// TrustedDevice is the device where the user passed two-factor authentication and asked not to be asked again.
+type TrustedDevice struct {
+	ID        string `json:"id" bson:"id"`
+	Name      string `json:"name,omitempty" bson:"name,omitempty"`
+	AppID     string `json:"app_id,omitempty" bson:"app_id,omitempty"`
+	CreatedAt int64  `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+}
+
+// Expired tells if the device is not trusted anymore.
+func (d TrustedDevice) Expired() bool {
+	return d.ExpiresAt <= time.Now().Unix()
+}
+
+// TrustedDevice returns the user's trusted device with the ID.
+func (u User) TrustedDevice(id string) (TrustedDevice, bool) {
+	for _, d := range u.TrustedDevices {
+		if d.ID == id {
+			return d, true
+		}
+	}
+	return TrustedDevice{}, false
+}
+
+// FederatedIdentity is the user's identity at the federated provider.
+type FederatedIdentity struct {
+	Provider FederatedIdentityProvider `json:"provider"`
+	ID       string                    `json:"id"`
+}
+
+// FederatedIdentities returns the user's linked federated identities.
+func (u User) FederatedIdentities() []FederatedIdentity {
+	identities := make([]FederatedIdentity, 0, len(u.FederatedIDs))
+	for _, sid := range u.FederatedIDs {
+		// Federated IDs are stored as "PROVIDER:id", provider does not have colons.
+		parts := strings.SplitN(sid, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		identities = append(identities, FederatedIdentity{Provider: FederatedIdentityProvider(parts[0]), ID: parts[1]})
+	}
+	return identities
 }
 
 func maskLeft(s string, hideFraction int) string {
@@ -78,12 +164,16 @@ func (u User) Sanitized() User {
 	u.TFAInfo.Secret = ""
 	u.TFAInfo.HOTPCounter = 0
 	u.TFAInfo.HOTPExpiredAt = time.Time{}
+	u.TFAInfo.RecoveryCodes = nil
+	u.TFAInfo.Factors = u.TFAInfo.sanitizedFactors()
+	u.WebAuthnCredentials = nil
+	u.TrustedDevices = nil
 	return u
 }
 
 // SanitizedTFA returns data structure with masked sensitive data
 func (u User) SanitizedTFA() User {
-	u.Sanitized()
+	u = u.Sanitized()
 	if len(u.Email) > 0 {
 		emailParts := strings.Split(u.Email, "@")
 		u.Email = maskLeft(emailParts[0], 2) + "@" + maskLeft(emailParts[1], 2)
@@ -102,11 +192,81 @@ func (u User) Deanonimized() User {
 }
 
 // TFAInfo encapsulates two-factor authentication user info.
+// Secret, HOTPCounter and HOTPExpiredAt are the only factor of the server-wide TFA type,
+// which users had before they could enroll several factors. They are moved to Factors on the first factor update.
 type TFAInfo struct {
-	IsEnabled     bool      `json:"is_enabled,omitempty" bson:"is_enabled,omitempty"`
+	IsEnabled     bool        `json:"is_enabled,omitempty" bson:"is_enabled,omitempty"`
+	HOTPCounter   int         `json:"hotp_counter,omitempty" bson:"hotp_counter,omitempty"`
+	HOTPExpiredAt time.Time   `json:"hotp_expired_at,omitempty" bson:"hotp_expired_at,omitempty"`
+	Secret        string      `json:"secret,omitempty" bson:"secret,omitempty"`
+	Factors       []TFAFactor `json:"factors,omitempty" bson:"factors,omitempty"`
+	RecoveryCodes []string    `json:"recovery_codes,omitempty" bson:"recovery_codes,omitempty"` // RecoveryCodes are hashes of unused recovery codes.
+	// FailedAttempts is the number of wrong codes in a row, the TFA challenge is locked until LockedUntil after too many.
+	FailedAttempts int       `json:"failed_attempts,omitempty" bson:"failed_attempts,omitempty"`
+	LockedUntil    time.Time `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+}
+
+// TFAFactor is the second factor the user has enrolled.
+type TFAFactor struct {
+	Type          TFAType   `json:"type" bson:"type"`
+	Secret        string    `json:"secret,omitempty" bson:"secret,omitempty"`
 	HOTPCounter   int       `json:"hotp_counter,omitempty" bson:"hotp_counter,omitempty"`
 	HOTPExpiredAt time.Time `json:"hotp_expired_at,omitempty" bson:"hotp_expired_at,omitempty"`
-	Secret        string    `json:"secret,omitempty" bson:"secret,omitempty"`
+	LastTOTPStep  int64     `json:"last_totp_step,omitempty" bson:"last_totp_step,omitempty"` // LastTOTPStep is the time step of the last accepted code, codes of this and earlier steps are not accepted again.
+}
+
+// AllFactors returns the user's factors. The factor of the users who enrolled it before factors has the legacy type.
+func (i TFAInfo) AllFactors(legacyType TFAType) []TFAFactor {
+	if len(i.Factors) > 0 || len(i.Secret) == 0 {
+		return i.Factors
+	}
+	return []TFAFactor{{Type: legacyType, Secret: i.Secret, HOTPCounter: i.HOTPCounter, HOTPExpiredAt: i.HOTPExpiredAt}}
+}
+
+// Factor returns the user's factor of the type.
+func (i TFAInfo) Factor(t, legacyType TFAType) (TFAFactor, bool) {
+	for _, f := range i.AllFactors(legacyType) {
+		if f.Type == t {
+			return f, true
+		}
+	}
+	return TFAFactor{}, false
+}
+
+// SetFactor adds the factor, or replaces the factor of the same type.
+// The legacy factor is moved to the factors list.
+func (i *TFAInfo) SetFactor(factor TFAFactor, legacyType TFAType) {
+	factors := make([]TFAFactor, 0, len(i.Factors)+1)
+	for _, f := range i.AllFactors(legacyType) {
+		if f.Type != factor.Type {
+			factors = append(factors, f)
+		}
+	}
+	i.Factors = append(factors, factor)
+	i.Secret, i.HOTPCounter, i.HOTPExpiredAt = "", 0, time.Time{}
+}
+
+// SameCodes tells if both infos have the same factors and recovery codes, so no code has been accepted in between.
+func (i TFAInfo) SameCodes(other TFAInfo) bool {
+	type codes struct {
+		Factors       []TFAFactor
+		RecoveryCodes []string
+	}
+	a, errA := json.Marshal(codes{Factors: i.Factors, RecoveryCodes: i.RecoveryCodes})
+	b, errB := json.Marshal(codes{Factors: other.Factors, RecoveryCodes: other.RecoveryCodes})
+	return errA == nil && errB == nil && string(a) == string(b)
+}
+
+// sanitizedFactors returns the types of the factors without their secrets.
+func (i TFAInfo) sanitizedFactors() []TFAFactor {
+	if len(i.Factors) == 0 {
+		return nil
+	}
+	factors := make([]TFAFactor, 0, len(i.Factors))
+	for _, f := range i.Factors {
+		factors = append(factors, TFAFactor{Type: f.Type})
+	}
+	return factors
 }
 
 // UserFromJSON deserialize user data from JSON.
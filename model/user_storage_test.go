@@ -0,0 +1,56 @@
+package model
+
+import "testing"
+
+func TestTFAInfoFactors(t *testing.T) {
+	// The factor enrolled before users could have several has the server-wide type.
+	info := TFAInfo{IsEnabled: true, Secret: "legacy", HOTPCounter: 3}
+	if f, ok := info.Factor(TFATypeSMS, TFATypeSMS); !ok || f.Secret != "legacy" || f.HOTPCounter != 3 {
+		t.Errorf("Factor() = %+v, %v, want legacy sms factor", f, ok)
+	}
+	if _, ok := info.Factor(TFATypeApp, TFATypeSMS); ok {
+		t.Error("Factor() returned factor the user has not enrolled")
+	}
+
+	info.SetFactor(TFAFactor{Type: TFATypeApp, Secret: "totp"}, TFATypeSMS)
+	if info.Secret != "" || len(info.Factors) != 2 {
+		t.Fatalf("SetFactor() = %+v, want legacy factor moved to factors", info)
+	}
+	info.SetFactor(TFAFactor{Type: TFATypeSMS, Secret: "legacy", HOTPCounter: 4}, TFATypeApp)
+	if f, _ := info.Factor(TFATypeSMS, TFATypeApp); len(info.Factors) != 2 || f.HOTPCounter != 4 {
+		t.Errorf("SetFactor() = %+v, want sms factor replaced", info.Factors)
+	}
+
+	user := User{TFAInfo: info}.Sanitized()
+	for _, f := range user.TFAInfo.Factors {
+		if f.Secret != "" || f.HOTPCounter != 0 {
+			t.Errorf("Sanitized() factor = %+v, want type only", f)
+		}
+	}
+	if info.Factors[0].Secret == "" {
+		t.Error("Sanitized() changed the original user factors")
+	}
+}
+
+func TestUserSanitizedTFA(t *testing.T) {
+	info := TFAInfo{IsEnabled: true}
+	info.SetFactor(TFAFactor{Type: TFATypeApp, Secret: "totp"}, TFATypeApp)
+	info.SetFactor(TFAFactor{Type: TFATypeSMS, Secret: "sms", HOTPCounter: 2}, TFATypeApp)
+	info.SetFactor(TFAFactor{Type: TFATypeEmail, Secret: "email"}, TFATypeApp)
+
+	user := User{Email: "user@example.com", Phone: "+123456789", Pswd: "hash", TFAInfo: info}.SanitizedTFA()
+	if user.Pswd != "" {
+		t.Error("SanitizedTFA() kept the password hash")
+	}
+	if len(user.TFAInfo.Factors) != 3 {
+		t.Fatalf("SanitizedTFA() factors = %+v, want 3", user.TFAInfo.Factors)
+	}
+	for _, f := range user.TFAInfo.Factors {
+		if f.Secret != "" || f.HOTPCounter != 0 {
+			t.Errorf("SanitizedTFA() factor = %+v, want type only", f)
+		}
+	}
+	if user.Email == "user@example.com" || user.Phone == "+123456789" {
+		t.Errorf("SanitizedTFA() = %q, %q, want masked email and phone", user.Email, user.Phone)
+	}
+}
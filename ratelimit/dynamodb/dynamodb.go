@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/madappgang/identifo/model"
+)
+
+const (
+	rateLimitsTableName = "RateLimits"
+)
+
+// DynamoDBRateLimitStorage is a DynamoDB-backed storage for rate limit counters, shared by all the nodes.
+type DynamoDBRateLimitStorage struct {
+	db *dynamodb.DynamoDB
+}
+
+// NewRateLimitStorage creates new DynamoDB rate limit storage.
+func NewRateLimitStorage(settings model.RateLimitSettings) (model.RateLimitStorage, error) {
+	if len(settings.Region) == 0 {
+		return nil, errors.New("Empty region string")
+	}
+	config := &aws.Config{
+		Region:   aws.String(settings.Region),
+		Endpoint: aws.String(settings.Endpoint),
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	rls := &DynamoDBRateLimitStorage{db: dynamodb.New(sess)}
+	err = rls.ensureTable()
+	return rls, err
+}
+
+// Increment counts one more request with the key and returns the number of them.
+// Keys are unique for the window, so the counter is not reset, and DynamoDB drops it after expiration time.
+func (rls *DynamoDBRateLimitStorage) Increment(key string, expireAt time.Time) (int, error) {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(rateLimitsTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(key)},
+		},
+		UpdateExpression: aws.String("ADD #count :one SET expire_at = if_not_exists(expire_at, :expire_at)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#count": aws.String("count"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":       {N: aws.String("1")},
+			":expire_at": {N: aws.String(strconv.FormatInt(expireAt.Unix(), 10))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueUpdatedNew),
+	}
+
+	result, err := rls.db.UpdateItem(input)
+	if err != nil {
+		return 0, fmt.Errorf("Error incrementing rate limit counter: %s", err)
+	}
+
+	count, ok := result.Attributes["count"]
+	if !ok || count.N == nil {
+		return 0, errors.New("Rate limit counter is missing in the response")
+	}
+	return strconv.Atoi(*count.N)
+}
+
+// ensureTable ensures that rate limits table exists in database.
+func (rls *DynamoDBRateLimitStorage) ensureTable() error {
+	exists, err := rls.isTableExists(rateLimitsTableName)
+	if err != nil {
+		log.Println("Error checking rate limits table existence:", err)
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(rateLimitsTableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("key"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("key"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		BillingMode: aws.String("PAY_PER_REQUEST"),
+	}
+
+	if _, err = rls.db.CreateTable(input); err != nil {
+		return fmt.Errorf("Cannot create rate limits table: %s", err)
+	}
+
+	ttlInput := &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(rateLimitsTableName),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String("expire_at"),
+			Enabled:       aws.Bool(true),
+		},
+	}
+
+	if _, err = rls.db.UpdateTimeToLive(ttlInput); err != nil {
+		if awsErrorNotFound(err) {
+			// Then table must be in creating status. Let's give it some time.
+			for i := 0; i < 5; i++ {
+				time.Sleep(5 * time.Second)
+				log.Println("Retry setting expiration time...")
+				if _, err = rls.db.UpdateTimeToLive(ttlInput); err == nil {
+					log.Println("Expiration time successfully set")
+					break
+				}
+			}
+		}
+	}
+
+	return err
+}
+
+// isTableExists checks if table exists.
+func (rls *DynamoDBRateLimitStorage) isTableExists(table string) (bool, error) {
+	input := &dynamodb.DescribeTableInput{
+		TableName: aws.String(table),
+	}
+
+	_, err := rls.db.DescribeTable(input)
+	if awsErrorNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// awsErrorNotFound checks if error has type dynamodb.ErrCodeResourceNotFoundException.
+func awsErrorNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		if aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/madappgang/identifo/model"
+)
+
+// cleanupInterval is how often expired counters are dropped.
+const cleanupInterval = time.Minute
+
+type counter struct {
+	count    int
+	expireAt time.Time
+}
+
+type memoryStorage struct {
+	sync.Mutex
+	counters    map[string]counter
+	lastCleanup time.Time
+}
+
+// NewRateLimitStorage creates an in-memory rate limit storage. Every node counts its own requests.
+func NewRateLimitStorage() (model.RateLimitStorage, error) {
+	return &memoryStorage{
+		counters:    make(map[string]counter),
+		lastCleanup: time.Now(),
+	}, nil
+}
+
+func (m *memoryStorage) Increment(key string, expireAt time.Time) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastCleanup) > cleanupInterval {
+		for k, c := range m.counters {
+			if !now.Before(c.expireAt) {
+				delete(m.counters, k)
+			}
+		}
+		m.lastCleanup = now
+	}
+
+	c, ok := m.counters[key]
+	if !ok || !now.Before(c.expireAt) {
+		c = counter{expireAt: expireAt}
+	}
+	c.count++
+	m.counters[key] = c
+	return c.count, nil
+}
@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/madappgang/identifo/model"
+)
+
+// keyPrefix separates rate limit counters from the other keys in the database.
+const keyPrefix = "ratelimit:"
+
+// RedisRateLimitStorage is a Redis-backed storage for rate limit counters, shared by all the nodes.
+type RedisRateLimitStorage struct {
+	client *redis.Client
+}
+
+// NewRateLimitStorage creates new Redis rate limit storage.
+func NewRateLimitStorage(settings model.RateLimitSettings) (model.RateLimitStorage, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     settings.Address,
+		Password: settings.Password,
+		DB:       settings.DB,
+	})
+
+	if _, err := client.Ping().Result(); err != nil {
+		return nil, err
+	}
+
+	return &RedisRateLimitStorage{client: client}, nil
+}
+
+// Increment counts one more request with the key and returns the number of them.
+func (r *RedisRateLimitStorage) Increment(key string, expireAt time.Time) (int, error) {
+	pipe := r.client.TxPipeline()
+	incr := pipe.Incr(keyPrefix + key)
+	pipe.ExpireAt(keyPrefix+key, expireAt)
+	if _, err := pipe.Exec(); err != nil {
+		return 0, err
+	}
+	return int(incr.Val()), nil
+}
@@ -16,6 +16,7 @@ func NewComposer(settings model.ServerSettings) (*DatabaseComposer, error) {
 		newTokenBlacklist:          boltdb.NewTokenBlacklist,
 		newVerificationCodeStorage: boltdb.NewVerificationCodeStorage,
 		newInviteStorage:           boltdb.NewInviteStorage,
+		newDeviceCodeStorage:       boltdb.NewDeviceCodeStorage,
 	}
 	return &c, nil
 }
@@ -29,6 +30,7 @@ type DatabaseComposer struct {
 	newTokenBlacklist          func(*bolt.DB) (model.TokenBlacklist, error)
 	newVerificationCodeStorage func(*bolt.DB) (model.VerificationCodeStorage, error)
 	newInviteStorage           func(db *bolt.DB) (model.InviteStorage, error)
+	newDeviceCodeStorage       func(db *bolt.DB) (model.DeviceCodeStorage, error)
 }
 
 // Compose composes all services with BoltDB support.
@@ -39,45 +41,51 @@ func (dc *DatabaseComposer) Compose() (
 	model.TokenBlacklist,
 	model.VerificationCodeStorage,
 	model.InviteStorage,
+	model.DeviceCodeStorage,
 	error,
 ) {
 	// We assume that all BoltDB-backed storages share the same filepath, so we can pick any of them.
 	db, err := boltdb.InitDB(dc.settings.Storage.AppStorage.Path)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	appStorage, err := dc.newAppStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	userStorage, err := dc.newUserStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	tokenStorage, err := dc.newTokenStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	tokenBlacklist, err := dc.newTokenBlacklist(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	verificationCodeStorage, err := dc.newVerificationCodeStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	inviteStorage, err := dc.newInviteStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
-	return appStorage, userStorage, tokenStorage, tokenBlacklist, verificationCodeStorage, inviteStorage, nil
+	deviceCodeStorage, err := dc.newDeviceCodeStorage(db)
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, nil, err
+	}
+
+	return appStorage, userStorage, tokenStorage, tokenBlacklist, verificationCodeStorage, inviteStorage, deviceCodeStorage, nil
 }
 
 // NewPartialComposer returns new partial composer with BoltDB support.
@@ -116,6 +124,11 @@ func NewPartialComposer(settings model.StorageSettings, options ...func(*Partial
 		dbPath = settings.InviteStorage.Path
 	}
 
+	if settings.DeviceCodeStorage.Type == model.DBTypeBoltDB {
+		pc.newDeviceCodeStorage = boltdb.NewDeviceCodeStorage
+		dbPath = settings.DeviceCodeStorage.Path
+	}
+
 	db, err := boltdb.InitDB(dbPath)
 	if err != nil {
 		return nil, err
@@ -139,6 +152,7 @@ type PartialDatabaseComposer struct {
 	newTokenBlacklist          func(*bolt.DB) (model.TokenBlacklist, error)
 	newVerificationCodeStorage func(*bolt.DB) (model.VerificationCodeStorage, error)
 	newInviteStorage           func(db *bolt.DB) (model.InviteStorage, error)
+	newDeviceCodeStorage       func(db *bolt.DB) (model.DeviceCodeStorage, error)
 }
 
 // AppStorageComposer returns app storage composer.
@@ -200,3 +214,13 @@ func (pc *PartialDatabaseComposer) InviteStorageComposer() func() (model.InviteS
 	}
 	return nil
 }
+
+// DeviceCodeStorageComposer returns device code storage composer.
+func (pc *PartialDatabaseComposer) DeviceCodeStorageComposer() func() (model.DeviceCodeStorage, error) {
+	if pc.newDeviceCodeStorage != nil {
+		return func() (model.DeviceCodeStorage, error) {
+			return pc.newDeviceCodeStorage(pc.db)
+		}
+	}
+	return nil
+}
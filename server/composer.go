@@ -1,7 +1,10 @@
 package server
 
 import (
+	"fmt"
+
 	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/storage/ldap"
 )
 
 // DatabaseComposer inits database stack.
@@ -13,6 +16,7 @@ type DatabaseComposer interface {
 		model.TokenBlacklist,
 		model.VerificationCodeStorage,
 		model.InviteStorage,
+		model.DeviceCodeStorage,
 		error,
 	)
 }
@@ -25,6 +29,7 @@ type PartialDatabaseComposer interface {
 	TokenBlacklistComposer() func() (model.TokenBlacklist, error)
 	VerificationCodeStorageComposer() func() (model.VerificationCodeStorage, error)
 	InviteStorageComposer() func() (model.InviteStorage, error)
+	DeviceCodeStorageComposer() func() (model.DeviceCodeStorage, error)
 }
 
 // Composer is a service composer which is agnostic to particular database implementations.
@@ -36,6 +41,7 @@ type Composer struct {
 	newTokenBlacklist          func() (model.TokenBlacklist, error)
 	newVerificationCodeStorage func() (model.VerificationCodeStorage, error)
 	newInviteStorage           func() (model.InviteStorage, error)
+	newDeviceCodeStorage       func() (model.DeviceCodeStorage, error)
 }
 
 // Compose composes all services.
@@ -46,39 +52,45 @@ func (c *Composer) Compose() (
 	model.TokenBlacklist,
 	model.VerificationCodeStorage,
 	model.InviteStorage,
+	model.DeviceCodeStorage,
 	error,
 ) {
 	appStorage, err := c.newAppStorage()
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	userStorage, err := c.newUserStorage()
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	tokenStorage, err := c.newTokenStorage()
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	tokenBlacklist, err := c.newTokenBlacklist()
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	verificationCodeStorage, err := c.newVerificationCodeStorage()
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	inviteStorage, err := c.newInviteStorage()
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
+	}
+
+	deviceCodeStorage, err := c.newDeviceCodeStorage()
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
-	return appStorage, userStorage, tokenStorage, tokenBlacklist, verificationCodeStorage, inviteStorage, nil
+	return appStorage, userStorage, tokenStorage, tokenBlacklist, verificationCodeStorage, inviteStorage, deviceCodeStorage, nil
 }
 
 // NewComposer returns new database composer based on passed server settings.
@@ -104,6 +116,9 @@ func NewComposer(settings model.ServerSettings, partialComposers []PartialDataba
 		if pc.InviteStorageComposer() != nil {
 			c.newInviteStorage = pc.InviteStorageComposer()
 		}
+		if pc.DeviceCodeStorageComposer() != nil {
+			c.newDeviceCodeStorage = pc.DeviceCodeStorageComposer()
+		}
 	}
 
 	for _, option := range options {
@@ -113,3 +128,22 @@ func NewComposer(settings model.ServerSettings, partialComposers []PartialDataba
 	}
 	return c, nil
 }
+
+// LDAPUserStorageOption puts the LDAP directory in front of the user storage the partial composers have composed.
+func LDAPUserStorageOption(settings model.DatabaseSettings) func(*Composer) error {
+	return func(c *Composer) error {
+		newPrimary := c.newUserStorage
+		if newPrimary == nil {
+			return fmt.Errorf("Unknown LDAP primary storage type: %s", settings.LDAP.PrimaryStorage.Type)
+		}
+
+		c.newUserStorage = func() (model.UserStorage, error) {
+			primary, err := newPrimary()
+			if err != nil {
+				return nil, err
+			}
+			return ldap.NewUserStorage(settings, primary)
+		}
+		return nil
+	}
+}
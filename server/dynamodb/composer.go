@@ -15,6 +15,7 @@ func NewComposer(settings model.ServerSettings) (*DatabaseComposer, error) {
 		newTokenBlacklist:          dynamodb.NewTokenBlacklist,
 		newVerificationCodeStorage: dynamodb.NewVerificationCodeStorage,
 		newInviteStorage:           dynamodb.NewInviteStorage,
+		newDeviceCodeStorage:       dynamodb.NewDeviceCodeStorage,
 	}
 	return &c, nil
 }
@@ -28,6 +29,7 @@ type DatabaseComposer struct {
 	newTokenBlacklist          func(*dynamodb.DB) (model.TokenBlacklist, error)
 	newVerificationCodeStorage func(*dynamodb.DB) (model.VerificationCodeStorage, error)
 	newInviteStorage           func(db *dynamodb.DB) (model.InviteStorage, error)
+	newDeviceCodeStorage       func(db *dynamodb.DB) (model.DeviceCodeStorage, error)
 }
 
 // Compose composes all services with DynamoDB support.
@@ -38,45 +40,51 @@ func (dc *DatabaseComposer) Compose() (
 	model.TokenBlacklist,
 	model.VerificationCodeStorage,
 	model.InviteStorage,
+	model.DeviceCodeStorage,
 	error,
 ) {
 	// We assume that all DynamoDB-backed storages share the same endpoint and region, so we can pick any of them.
 	db, err := dynamodb.NewDB(dc.settings.Storage.AppStorage.Endpoint, dc.settings.Storage.AppStorage.Region)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	appStorage, err := dc.newAppStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	userStorage, err := dc.newUserStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	tokenStorage, err := dc.newTokenStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	tokenBlacklist, err := dc.newTokenBlacklist(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	verificationCodeStorage, err := dc.newVerificationCodeStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	inviteStorage, err := dc.newInviteStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
-	return appStorage, userStorage, tokenStorage, tokenBlacklist, verificationCodeStorage, inviteStorage, nil
+	deviceCodeStorage, err := dc.newDeviceCodeStorage(db)
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, nil, err
+	}
+
+	return appStorage, userStorage, tokenStorage, tokenBlacklist, verificationCodeStorage, inviteStorage, deviceCodeStorage, nil
 }
 
 // NewPartialComposer returns new partial composer with DynamoDB support.
@@ -121,6 +129,12 @@ func NewPartialComposer(settings model.StorageSettings, options ...func(*Partial
 		dbRegion = settings.InviteStorage.Region
 	}
 
+	if settings.DeviceCodeStorage.Type == model.DBTypeDynamoDB {
+		pc.newVerificationCodeStorage = dynamodb.NewVerificationCodeStorage
+		dbEndpoint = settings.DeviceCodeStorage.Endpoint
+		dbRegion = settings.DeviceCodeStorage.Region
+	}
+
 	db, err := dynamodb.NewDB(dbEndpoint, dbRegion)
 	if err != nil {
 		return nil, err
@@ -144,6 +158,7 @@ type PartialDatabaseComposer struct {
 	newTokenBlacklist          func(*dynamodb.DB) (model.TokenBlacklist, error)
 	newVerificationCodeStorage func(*dynamodb.DB) (model.VerificationCodeStorage, error)
 	newInviteStorage           func(db *dynamodb.DB) (model.InviteStorage, error)
+	newDeviceCodeStorage       func(db *dynamodb.DB) (model.DeviceCodeStorage, error)
 }
 
 // AppStorageComposer returns app storage composer.
@@ -205,3 +220,13 @@ func (pc *PartialDatabaseComposer) InviteStorageComposer() func() (model.InviteS
 	}
 	return nil
 }
+
+// DeviceCodeStorageComposer returns device code storage composer.
+func (pc *PartialDatabaseComposer) DeviceCodeStorageComposer() func() (model.DeviceCodeStorage, error) {
+	if pc.newDeviceCodeStorage != nil {
+		return func() (model.DeviceCodeStorage, error) {
+			return pc.newDeviceCodeStorage(pc.db)
+		}
+	}
+	return nil
+}
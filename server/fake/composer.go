@@ -15,6 +15,7 @@ func NewComposer(settings model.ServerSettings) (*DatabaseComposer, error) {
 		newTokenBlacklist:          mem.NewTokenBlacklist,
 		newVerificationCodeStorage: mem.NewVerificationCodeStorage,
 		newInviteStorage:           mem.NewInviteStorage,
+		newDeviceCodeStorage:       mem.NewDeviceCodeStorage,
 	}
 	return &c, nil
 }
@@ -28,6 +29,7 @@ type DatabaseComposer struct {
 	newTokenBlacklist          func() (model.TokenBlacklist, error)
 	newVerificationCodeStorage func() (model.VerificationCodeStorage, error)
 	newInviteStorage           func() (model.InviteStorage, error)
+	newDeviceCodeStorage       func() (model.DeviceCodeStorage, error)
 }
 
 // Compose composes all services with in-memory storage support.
@@ -38,39 +40,45 @@ func (dc *DatabaseComposer) Compose() (
 	model.TokenBlacklist,
 	model.VerificationCodeStorage,
 	model.InviteStorage,
+	model.DeviceCodeStorage,
 	error,
 ) {
 	appStorage, err := dc.newAppStorage()
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	userStorage, err := dc.newUserStorage()
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	tokenStorage, err := dc.newTokenStorage()
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	tokenBlacklist, err := dc.newTokenBlacklist()
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	verificationCodeStorage, err := dc.newVerificationCodeStorage()
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	inviteStorage, err := dc.newInviteStorage()
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
-	return appStorage, userStorage, tokenStorage, tokenBlacklist, verificationCodeStorage, inviteStorage, nil
+	deviceCodeStorage, err := dc.newDeviceCodeStorage()
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, nil, err
+	}
+
+	return appStorage, userStorage, tokenStorage, tokenBlacklist, verificationCodeStorage, inviteStorage, deviceCodeStorage, nil
 }
 
 // NewPartialComposer returns new partial composer with in-memory storage support.
@@ -101,6 +109,10 @@ func NewPartialComposer(settings model.StorageSettings, options ...func(*Partial
 		pc.newInviteStorage = mem.NewInviteStorage
 	}
 
+	if settings.DeviceCodeStorage.Type == model.DBTypeFake {
+		pc.newDeviceCodeStorage = mem.NewDeviceCodeStorage
+	}
+
 	for _, option := range options {
 		if err := option(pc); err != nil {
 			return nil, err
@@ -117,6 +129,7 @@ type PartialDatabaseComposer struct {
 	newTokenBlacklist          func() (model.TokenBlacklist, error)
 	newVerificationCodeStorage func() (model.VerificationCodeStorage, error)
 	newInviteStorage           func() (model.InviteStorage, error)
+	newDeviceCodeStorage       func() (model.DeviceCodeStorage, error)
 }
 
 // AppStorageComposer returns app storage composer.
@@ -178,3 +191,13 @@ func (pc *PartialDatabaseComposer) InviteStorageComposer() func() (model.InviteS
 	}
 	return nil
 }
+
+// DeviceCodeStorageComposer returns device code storage composer.
+func (pc *PartialDatabaseComposer) DeviceCodeStorageComposer() func() (model.DeviceCodeStorage, error) {
+	if pc.newDeviceCodeStorage != nil {
+		return func() (model.DeviceCodeStorage, error) {
+			return pc.newDeviceCodeStorage()
+		}
+	}
+	return nil
+}
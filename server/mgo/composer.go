@@ -15,6 +15,7 @@ func NewComposer(settings model.ServerSettings) (*DatabaseComposer, error) {
 		newTokenBlacklist:          mongo.NewTokenBlacklist,
 		newVerificationCodeStorage: mongo.NewVerificationCodeStorage,
 		newInviteStorage:           mongo.NewInviteStorage,
+		newDeviceCodeStorage:       mongo.NewDeviceCodeStorage,
 	}
 	return &c, nil
 }
@@ -28,6 +29,7 @@ type DatabaseComposer struct {
 	newTokenBlacklist          func(*mongo.DB) (model.TokenBlacklist, error)
 	newVerificationCodeStorage func(*mongo.DB) (model.VerificationCodeStorage, error)
 	newInviteStorage           func(*mongo.DB) (model.InviteStorage, error)
+	newDeviceCodeStorage       func(*mongo.DB) (model.DeviceCodeStorage, error)
 }
 
 // Compose composes all services with MongoDB support.
@@ -38,45 +40,51 @@ func (dc *DatabaseComposer) Compose() (
 	model.TokenBlacklist,
 	model.VerificationCodeStorage,
 	model.InviteStorage,
+	model.DeviceCodeStorage,
 	error,
 ) {
 	// We assume that all MongoDB-backed storages share the same database name and connection string, so we can pick any of them.
 	db, err := mongo.NewDB(dc.settings.Storage.AppStorage.Endpoint, dc.settings.Storage.AppStorage.Name)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	appStorage, err := dc.newAppStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	userStorage, err := dc.newUserStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	tokenStorage, err := dc.newTokenStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	tokenBlacklist, err := dc.newTokenBlacklist(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	verificationCodeStorage, err := dc.newVerificationCodeStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
 	inviteStorage, err := dc.newInviteStorage(db)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, nil, err
 	}
 
-	return appStorage, userStorage, tokenStorage, tokenBlacklist, verificationCodeStorage, inviteStorage, nil
+	deviceCodeStorage, err := dc.newDeviceCodeStorage(db)
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, nil, err
+	}
+
+	return appStorage, userStorage, tokenStorage, tokenBlacklist, verificationCodeStorage, inviteStorage, deviceCodeStorage, nil
 }
 
 // NewPartialComposer returns new partial composer with MongoDB support.
@@ -121,6 +129,12 @@ func NewPartialComposer(settings model.StorageSettings, options ...func(*Partial
 		dbName = settings.InviteStorage.Name
 	}
 
+	if settings.DeviceCodeStorage.Type == model.DBTypeMongoDB {
+		pc.newDeviceCodeStorage = mongo.NewDeviceCodeStorage
+		dbEndpoint = settings.DeviceCodeStorage.Endpoint
+		dbName = settings.DeviceCodeStorage.Name
+	}
+
 	db, err := mongo.NewDB(dbEndpoint, dbName)
 	if err != nil {
 		return nil, err
@@ -144,6 +158,7 @@ type PartialDatabaseComposer struct {
 	newTokenBlacklist          func(*mongo.DB) (model.TokenBlacklist, error)
 	newVerificationCodeStorage func(*mongo.DB) (model.VerificationCodeStorage, error)
 	newInviteStorage           func(*mongo.DB) (model.InviteStorage, error)
+	newDeviceCodeStorage       func(*mongo.DB) (model.DeviceCodeStorage, error)
 }
 
 // AppStorageComposer returns app storage composer.
@@ -205,3 +220,13 @@ func (pc *PartialDatabaseComposer) InviteStorageComposer() func() (model.InviteS
 	}
 	return nil
 }
+
+// DeviceCodeStorageComposer returns device code storage composer.
+func (pc *PartialDatabaseComposer) DeviceCodeStorageComposer() func() (model.DeviceCodeStorage, error) {
+	if pc.newDeviceCodeStorage != nil {
+		return func() (model.DeviceCodeStorage, error) {
+			return pc.newDeviceCodeStorage(pc.db)
+		}
+	}
+	return nil
+}
@@ -20,6 +20,9 @@ import (
 	ijwt "github.com/madappgang/identifo/jwt"
 	jwtService "github.com/madappgang/identifo/jwt/service"
 	"github.com/madappgang/identifo/model"
+	rateLimitDynamoDB "github.com/madappgang/identifo/ratelimit/dynamodb"
+	rateLimitMem "github.com/madappgang/identifo/ratelimit/mem"
+	rateLimitRedis "github.com/madappgang/identifo/ratelimit/redis"
 	"github.com/madappgang/identifo/server/utils/originchecker"
 	dynamodb "github.com/madappgang/identifo/sessions/dynamodb"
 	mem "github.com/madappgang/identifo/sessions/mem"
@@ -46,7 +49,7 @@ func NewServer(settings model.ServerSettings, db DatabaseComposer, configuration
 		}
 	}
 
-	appStorage, userStorage, tokenStorage, tokenBlacklist, verificationCodeStorage, inviteStorage, err := db.Compose()
+	appStorage, userStorage, tokenStorage, tokenBlacklist, verificationCodeStorage, inviteStorage, deviceCodeStorage, err := db.Compose()
 	if err != nil {
 		return nil, err
 	}
@@ -67,6 +70,7 @@ func NewServer(settings model.ServerSettings, db DatabaseComposer, configuration
 		tokenStorage:            tokenStorage,
 		tokenBlacklist:          tokenBlacklist,
 		verificationCodeStorage: verificationCodeStorage,
+		deviceCodeStorage:       deviceCodeStorage,
 		configurationStorage:    configurationStorage,
 		staticFilesStorage:      staticFilesStorage,
 	}
@@ -77,6 +81,11 @@ func NewServer(settings model.ServerSettings, db DatabaseComposer, configuration
 	}
 	sessionService := model.NewSessionManager(settings.SessionStorage.SessionDuration, sessionStorage)
 
+	rateLimitStorage, err := initRateLimitStorage(settings.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+
 	ms, err := initEmailService(settings.ExternalServices.EmailService, staticFilesStorage)
 	if err != nil {
 		return nil, err
@@ -109,6 +118,7 @@ func NewServer(settings model.ServerSettings, db DatabaseComposer, configuration
 		UserStorage:             userStorage,
 		TokenStorage:            tokenStorage,
 		VerificationCodeStorage: verificationCodeStorage,
+		DeviceCodeStorage:       deviceCodeStorage,
 		TokenService:            tokenService,
 		TokenBlacklist:          tokenBlacklist,
 		InviteStorage:           inviteStorage,
@@ -121,13 +131,20 @@ func NewServer(settings model.ServerSettings, db DatabaseComposer, configuration
 		EmailService:            ms,
 		WebRouterSettings: []func(*html.Router) error{
 			html.HostOption(hostName),
+			html.SupportedLoginWaysOption(settings.Login.LoginWith),
+			html.TFAOption(settings.Login.TFAType, settings.Login.TFA),
+			html.TrustedFederatedProvidersOption(settings.Login.TrustedFederatedProviders),
 			html.CorsOption(cors),
+			html.RateLimitOption(rateLimitStorage, settings.RateLimit),
 		},
 		APIRouterSettings: []func(*api.Router) error{
 			api.HostOption(hostName),
 			api.SupportedLoginWaysOption(settings.Login.LoginWith),
 			api.TFATypeOption(settings.Login.TFAType),
+			api.TFASettingsOption(settings.Login.TFA),
+			api.TrustedFederatedProvidersOption(settings.Login.TrustedFederatedProviders),
 			api.CorsOption(cors, originChecker),
+			api.RateLimitOption(rateLimitStorage, settings.RateLimit),
 		},
 		AdminRouterSettings: []func(*admin.Router) error{
 			admin.HostOption(hostName),
@@ -162,6 +179,7 @@ type Server struct {
 	tokenBlacklist          model.TokenBlacklist
 	staticFilesStorage      model.StaticFilesStorage
 	verificationCodeStorage model.VerificationCodeStorage
+	deviceCodeStorage       model.DeviceCodeStorage
 }
 
 // Router returns server's main router.
@@ -198,6 +216,11 @@ func (s *Server) VerificationCodeStorage() model.VerificationCodeStorage {
 	return s.verificationCodeStorage
 }
 
+// DeviceCodeStorage returns server's device code storage.
+func (s *Server) DeviceCodeStorage() model.DeviceCodeStorage {
+	return s.deviceCodeStorage
+}
+
 // ConfigurationStorage returns server's configuration storage.
 func (s *Server) ConfigurationStorage() model.ConfigurationStorage {
 	return s.configurationStorage
@@ -215,6 +238,7 @@ func (s *Server) Close() {
 	s.TokenStorage().Close()
 	s.TokenBlacklist().Close()
 	s.VerificationCodeStorage().Close()
+	s.DeviceCodeStorage().Close()
 	s.StaticFilesStorage().Close()
 }
 
@@ -237,13 +261,13 @@ func initTokenService(generalSettings model.GeneralServerSettings, configStorage
 		return nil, fmt.Errorf("Unknown token service algorithm %s", generalSettings.Algorithm)
 	}
 
-	keys, err := configStorage.LoadKeys(tokenServiceAlg)
+	keySet, err := configStorage.LoadKeySet(tokenServiceAlg)
 	if err != nil {
 		return nil, err
 	}
 
-	tokenService, err := jwtService.NewJWTokenService(
-		keys,
+	tokenService, err := jwtService.NewJWTokenServiceWithKeySet(
+		keySet,
 		generalSettings.Issuer,
 		tokenStorage,
 		appStorage,
@@ -264,6 +288,21 @@ func initSessionStorage(settings model.SessionStorageSettings) (model.SessionSto
 	return nil, fmt.Errorf("Session storage of type '%s' is not supported", settings.Type)
 }
 
+// initRateLimitStorage returns the storage of request counters, nil if requests are not limited.
+func initRateLimitStorage(settings model.RateLimitSettings) (model.RateLimitStorage, error) {
+	switch settings.Type {
+	case "":
+		return nil, nil
+	case model.RateLimitStorageMem:
+		return rateLimitMem.NewRateLimitStorage()
+	case model.RateLimitStorageRedis:
+		return rateLimitRedis.NewRateLimitStorage(settings)
+	case model.RateLimitStorageDynamoDB:
+		return rateLimitDynamoDB.NewRateLimitStorage(settings)
+	}
+	return nil, fmt.Errorf("Rate limit storage of type '%s' is not supported", settings.Type)
+}
+
 func initStaticFilesStorage(settings model.StaticFilesStorageSettings) (model.StaticFilesStorage, error) {
 	localStaticFilesStorage, err := staticStoreLocal.NewStaticFilesStorage(settings)
 	if err != nil {
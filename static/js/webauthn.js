@@ -0,0 +1,141 @@
+// Passkeys and security keys on the login, security keys and two-factor authentication pages.
+// The server sends the binary fields base64 encoded, and expects them base64url encoded back.
+(function () {
+  function decode(value) {
+    var s = value.replace(/-/g, '+').replace(/_/g, '/');
+    while (s.length % 4) {
+      s += '=';
+    }
+    var raw = atob(s);
+    var bytes = new Uint8Array(raw.length);
+    for (var i = 0; i < raw.length; i++) {
+      bytes[i] = raw.charCodeAt(i);
+    }
+    return bytes;
+  }
+
+  function encode(buffer) {
+    var bytes = new Uint8Array(buffer);
+    var raw = '';
+    for (var i = 0; i < bytes.length; i++) {
+      raw += String.fromCharCode(bytes[i]);
+    }
+    return btoa(raw).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+  }
+
+  function decodeDescriptors(list) {
+    return (list || []).map(function (c) {
+      c.id = decode(c.id);
+      return c;
+    });
+  }
+
+  function post(url, body) {
+    return fetch(url, {
+      method: 'POST',
+      credentials: 'same-origin',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body),
+    }).then(function (response) {
+      return response.json().then(function (data) {
+        if (!response.ok) {
+          throw new Error(data.error || 'Something went wrong');
+        }
+        return data;
+      });
+    });
+  }
+
+  function showError(err) {
+    var el = document.getElementById('error');
+    if (el) {
+      el.textContent = err.message;
+    }
+  }
+
+  // getAssertion signs the challenge with one of the user's credentials, the path tells the ceremony.
+  function getAssertion(url, query, body) {
+    return post(url + '/begin' + query, body).then(function (options) {
+      var publicKey = options.publicKey;
+      publicKey.challenge = decode(publicKey.challenge);
+      publicKey.allowCredentials = decodeDescriptors(publicKey.allowCredentials);
+      return navigator.credentials.get({ publicKey: publicKey }).then(function (credential) {
+        var response = {
+          clientDataJSON: encode(credential.response.clientDataJSON),
+          authenticatorData: encode(credential.response.authenticatorData),
+          signature: encode(credential.response.signature),
+        };
+        if (credential.response.userHandle) {
+          response.userHandle = encode(credential.response.userHandle);
+        }
+        return post(url + '/finish' + query, {
+          session: options.session,
+          credential: { id: credential.id, rawId: encode(credential.rawId), type: credential.type, response: response },
+        });
+      });
+    });
+  }
+
+  function login(prefix, appId) {
+    var username = document.getElementById('email').value;
+    var query = '?appId=' + encodeURIComponent(appId);
+    return getAssertion(prefix + '/webauthn/login', query, { username: username }).then(function () {
+      // The login page redirects to the app with the web cookie set.
+      window.location.reload();
+    });
+  }
+
+  function tfa(prefix, appId, next) {
+    var query = '?appId=' + encodeURIComponent(appId);
+    return getAssertion(prefix + '/tfa/webauthn', query, {}).then(function () {
+      // The login page redirects to the app, the web cookie is set after the second factor.
+      window.location.href = next;
+    });
+  }
+
+  function register(prefix, appId) {
+    var name = document.getElementById('name').value;
+    var query = '?appId=' + encodeURIComponent(appId);
+    return post(prefix + '/webauthn/register/begin' + query, {})
+      .then(function (options) {
+        var publicKey = options.publicKey;
+        publicKey.challenge = decode(publicKey.challenge);
+        publicKey.user.id = decode(publicKey.user.id);
+        publicKey.excludeCredentials = decodeDescriptors(publicKey.excludeCredentials);
+        return navigator.credentials.create({ publicKey: publicKey }).then(function (credential) {
+          return post(prefix + '/webauthn/register/finish' + query, {
+            session: options.session,
+            name: name,
+            transports: credential.response.getTransports ? credential.response.getTransports() : [],
+            credential: {
+              id: credential.id,
+              rawId: encode(credential.rawId),
+              type: credential.type,
+              response: {
+                clientDataJSON: encode(credential.response.clientDataJSON),
+                attestationObject: encode(credential.response.attestationObject),
+              },
+            },
+          });
+        });
+      })
+      .then(function () {
+        window.location.reload();
+      });
+  }
+
+  var button = document.getElementById('webauthn');
+  if (!button) {
+    return;
+  }
+  if (!window.PublicKeyCredential) {
+    button.hidden = true;
+    return;
+  }
+  button.addEventListener('click', function (e) {
+    e.preventDefault();
+    var ceremonies = { login: login, register: register, tfa: tfa };
+    var ceremony = ceremonies[button.dataset.ceremony] || login;
+    ceremony(button.dataset.prefix, button.dataset.appId, button.dataset.next).catch(showError);
+  });
+}());
@@ -0,0 +1,181 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/madappgang/identifo/model"
+)
+
+const (
+	// DeviceCodesBucket is a bucket with device codes.
+	DeviceCodesBucket = "DeviceCodes"
+	// DeviceUserCodesBucket is a bucket with user codes, mapped to device codes.
+	DeviceUserCodesBucket = "DeviceUserCodes"
+)
+
+// NewDeviceCodeStorage creates and inits BoltDB device code storage.
+func NewDeviceCodeStorage(db *bolt.DB) (model.DeviceCodeStorage, error) {
+	dcs := &DeviceCodeStorage{db: db}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(DeviceCodesBucket)); err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(DeviceUserCodesBucket)); err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return dcs, nil
+}
+
+// DeviceCodeStorage implements device code storage interface.
+type DeviceCodeStorage struct {
+	db *bolt.DB
+}
+
+// CreateDeviceCode saves new device code.
+func (dcs *DeviceCodeStorage) CreateDeviceCode(dc model.DeviceCode) error {
+	return dcs.db.Update(func(tx *bolt.Tx) error {
+		dcb := tx.Bucket([]byte(DeviceCodesBucket))
+		ucb := tx.Bucket([]byte(DeviceUserCodesBucket))
+
+		if dcb.Get([]byte(dc.DeviceCode)) != nil || ucb.Get([]byte(dc.UserCode)) != nil {
+			return model.ErrorWrongDataFormat
+		}
+
+		data, err := json.Marshal(dc)
+		if err != nil {
+			return err
+		}
+		if err := dcb.Put([]byte(dc.DeviceCode), data); err != nil {
+			return err
+		}
+		return ucb.Put([]byte(dc.UserCode), []byte(dc.DeviceCode))
+	})
+}
+
+// DeviceCodeByDeviceCode returns device code by its device code.
+func (dcs *DeviceCodeStorage) DeviceCodeByDeviceCode(deviceCode string) (model.DeviceCode, error) {
+	var res model.DeviceCode
+	err := dcs.db.View(func(tx *bolt.Tx) error {
+		var err error
+		res, err = deviceCodeByKey(tx, []byte(deviceCode))
+		return err
+	})
+	return res, err
+}
+
+// DeviceCodeByUserCode returns device code by its user code.
+func (dcs *DeviceCodeStorage) DeviceCodeByUserCode(userCode string) (model.DeviceCode, error) {
+	var res model.DeviceCode
+	err := dcs.db.View(func(tx *bolt.Tx) error {
+		ucb := tx.Bucket([]byte(DeviceUserCodesBucket))
+		deviceCode := ucb.Get([]byte(userCode))
+		if deviceCode == nil {
+			return model.ErrorNotFound
+		}
+
+		var err error
+		res, err = deviceCodeByKey(tx, deviceCode)
+		return err
+	})
+	return res, err
+}
+
+// UpdateDeviceCode updates existing device code.
+func (dcs *DeviceCodeStorage) UpdateDeviceCode(dc model.DeviceCode) error {
+	return dcs.db.Update(func(tx *bolt.Tx) error {
+		dcb := tx.Bucket([]byte(DeviceCodesBucket))
+		if dcb.Get([]byte(dc.DeviceCode)) == nil {
+			return model.ErrorNotFound
+		}
+
+		data, err := json.Marshal(dc)
+		if err != nil {
+			return err
+		}
+		return dcb.Put([]byte(dc.DeviceCode), data)
+	})
+}
+
+// UpdateDeviceCodePoll updates polling fields of the pending device code.
+func (dcs *DeviceCodeStorage) UpdateDeviceCodePoll(deviceCode string, interval int64, lastPolledAt time.Time) error {
+	return dcs.db.Update(func(tx *bolt.Tx) error {
+		dc, err := deviceCodeByKey(tx, []byte(deviceCode))
+		if err != nil {
+			return err
+		}
+		if dc.Status != model.DeviceCodeStatusPending {
+			return model.ErrorNotFound
+		}
+
+		dc.Interval = interval
+		dc.LastPolledAt = lastPolledAt
+		data, err := json.Marshal(dc)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(DeviceCodesBucket)).Put([]byte(deviceCode), data)
+	})
+}
+
+// ConsumeDeviceCode deletes the approved device code along with its user code.
+func (dcs *DeviceCodeStorage) ConsumeDeviceCode(deviceCode string) error {
+	return dcs.db.Update(func(tx *bolt.Tx) error {
+		dc, err := deviceCodeByKey(tx, []byte(deviceCode))
+		if err != nil {
+			return err
+		}
+		if dc.Status != model.DeviceCodeStatusApproved {
+			return model.ErrorNotFound
+		}
+		return deleteDeviceCode(tx, dc)
+	})
+}
+
+// DeleteDeviceCode deletes device code along with its user code.
+func (dcs *DeviceCodeStorage) DeleteDeviceCode(deviceCode string) error {
+	return dcs.db.Update(func(tx *bolt.Tx) error {
+		dc, err := deviceCodeByKey(tx, []byte(deviceCode))
+		if err == model.ErrorNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return deleteDeviceCode(tx, dc)
+	})
+}
+
+// Close closes underlying database.
+func (dcs *DeviceCodeStorage) Close() {
+	if err := dcs.db.Close(); err != nil {
+		log.Printf("Error closing device code storage: %s\n", err)
+	}
+}
+
+func deviceCodeByKey(tx *bolt.Tx, deviceCode []byte) (model.DeviceCode, error) {
+	var dc model.DeviceCode
+
+	data := tx.Bucket([]byte(DeviceCodesBucket)).Get(deviceCode)
+	if data == nil {
+		return dc, model.ErrorNotFound
+	}
+	err := json.Unmarshal(data, &dc)
+	return dc, err
+}
+
+func deleteDeviceCode(tx *bolt.Tx, dc model.DeviceCode) error {
+	if err := tx.Bucket([]byte(DeviceUserCodesBucket)).Delete([]byte(dc.UserCode)); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(DeviceCodesBucket)).Delete([]byte(dc.DeviceCode))
+}
@@ -0,0 +1,59 @@
+package boltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/madappgang/identifo/model"
+)
+
+func TestDeviceCodeStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identifo-device-codes")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := InitDB(filepath.Join(dir, "db.db"))
+	if err != nil {
+		t.Fatalf("Unable to open database %v", err)
+	}
+	defer db.Close()
+	dcs, err := NewDeviceCodeStorage(db)
+	if err != nil {
+		t.Fatalf("Unable to create device code storage %v", err)
+	}
+
+	dc := model.DeviceCode{DeviceCode: "device", UserCode: "BCDFGHJK", Status: model.DeviceCodeStatusPending, Interval: 5}
+	if err = dcs.CreateDeviceCode(dc); err != nil {
+		t.Fatalf("CreateDeviceCode() error = %v", err)
+	}
+	if err = dcs.ConsumeDeviceCode(dc.DeviceCode); err != model.ErrorNotFound {
+		t.Errorf("ConsumeDeviceCode() of pending code error = %v, want %v", err, model.ErrorNotFound)
+	}
+
+	dc.Status = model.DeviceCodeStatusApproved
+	dc.UserID = "user1"
+	if err = dcs.UpdateDeviceCode(dc); err != nil {
+		t.Fatalf("UpdateDeviceCode() error = %v", err)
+	}
+	// Device polls with the stale status after the approval.
+	if err = dcs.UpdateDeviceCodePoll(dc.DeviceCode, 10, time.Now()); err != model.ErrorNotFound {
+		t.Errorf("UpdateDeviceCodePoll() of approved code error = %v, want %v", err, model.ErrorNotFound)
+	}
+	if stored, _ := dcs.DeviceCodeByDeviceCode(dc.DeviceCode); stored.Status != model.DeviceCodeStatusApproved || stored.UserID != "user1" {
+		t.Errorf("DeviceCodeByDeviceCode() = %+v, want approved by user1", stored)
+	}
+
+	if err = dcs.ConsumeDeviceCode(dc.DeviceCode); err != nil {
+		t.Errorf("ConsumeDeviceCode() error = %v", err)
+	}
+	if err = dcs.ConsumeDeviceCode(dc.DeviceCode); err != model.ErrorNotFound {
+		t.Errorf("ConsumeDeviceCode() of used code error = %v, want %v", err, model.ErrorNotFound)
+	}
+	if _, err = dcs.DeviceCodeByUserCode(dc.UserCode); err != model.ErrorNotFound {
+		t.Errorf("DeviceCodeByUserCode() of used code error = %v, want %v", err, model.ErrorNotFound)
+	}
+}
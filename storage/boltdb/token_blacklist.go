@@ -41,6 +41,17 @@ func (tb *TokenBlacklist) Add(token string) error {
 	})
 }
 
+// Consume blacklists the single-use token, if it is not blacklisted yet.
+func (tb *TokenBlacklist) Consume(token string) error {
+	return tb.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BlacklistedTokenBucket))
+		if b.Get([]byte(token)) != nil {
+			return model.ErrorNotFound
+		}
+		return b.Put([]byte(token), []byte(token))
+	})
+}
+
 // IsBlacklisted returns true if the token is blacklisted.
 func (tb *TokenBlacklist) IsBlacklisted(token string) bool {
 	var res bool
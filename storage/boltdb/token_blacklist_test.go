@@ -0,0 +1,44 @@
+package boltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/madappgang/identifo/model"
+)
+
+func TestTokenBlacklistConsume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identifo-blacklist")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := InitDB(filepath.Join(dir, "db.db"))
+	if err != nil {
+		t.Fatalf("Unable to open database %v", err)
+	}
+	defer db.Close()
+	tb, err := NewTokenBlacklist(db)
+	if err != nil {
+		t.Fatalf("Unable to create token blacklist %v", err)
+	}
+
+	if err = tb.Consume("code"); err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	if !tb.IsBlacklisted("code") {
+		t.Error("IsBlacklisted() of consumed token = false")
+	}
+	if err = tb.Consume("code"); err != model.ErrorNotFound {
+		t.Errorf("Consume() of used token error = %v, want %v", err, model.ErrorNotFound)
+	}
+
+	if err = tb.Add("access"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err = tb.Consume("access"); err != model.ErrorNotFound {
+		t.Errorf("Consume() of blacklisted token error = %v, want %v", err, model.ErrorNotFound)
+	}
+}
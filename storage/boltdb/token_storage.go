@@ -1,6 +1,7 @@
 package boltdb
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 
@@ -11,6 +12,8 @@ import (
 const (
 	// TokenBucket is a name for bucket with tokens.
 	TokenBucket = "Tokens"
+	// TokenFamilyBucket is a name for bucket with refresh token families.
+	TokenFamilyBucket = "TokenFamilies"
 )
 
 // NewTokenStorage creates a BoltDB token storage.
@@ -21,6 +24,9 @@ func NewTokenStorage(db *bolt.DB) (model.TokenStorage, error) {
 		if _, err := tx.CreateBucketIfNotExists([]byte(TokenBucket)); err != nil {
 			return fmt.Errorf("create bucket: %s", err)
 		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(TokenFamilyBucket)); err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
 		return nil
 	}); err != nil {
 		return nil, err
@@ -66,6 +72,62 @@ func (ts *TokenStorage) DeleteToken(token string) error {
 	})
 }
 
+// SaveTokenFamily creates or updates token family.
+func (ts *TokenStorage) SaveTokenFamily(family model.TokenFamily) error {
+	if len(family.ID) == 0 {
+		return model.ErrorWrongDataFormat
+	}
+
+	data, err := json.Marshal(family)
+	if err != nil {
+		return err
+	}
+
+	return ts.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(TokenFamilyBucket))
+		return b.Put([]byte(family.ID), data)
+	})
+}
+
+// SwapTokenFamily replaces token family, if it is not revoked and its current token is still currentToken.
+func (ts *TokenStorage) SwapTokenFamily(family model.TokenFamily, currentToken string) error {
+	data, err := json.Marshal(family)
+	if err != nil {
+		return err
+	}
+
+	return ts.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(TokenFamilyBucket))
+		stored := b.Get([]byte(family.ID))
+		if stored == nil {
+			return model.ErrorNotFound
+		}
+
+		var old model.TokenFamily
+		if err := json.Unmarshal(stored, &old); err != nil {
+			return err
+		}
+		if old.Revoked || old.CurrentToken != currentToken {
+			return model.ErrorNotFound
+		}
+		return b.Put([]byte(family.ID), data)
+	})
+}
+
+// TokenFamily returns token family by its ID.
+func (ts *TokenStorage) TokenFamily(familyID string) (model.TokenFamily, error) {
+	var family model.TokenFamily
+	err := ts.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(TokenFamilyBucket))
+		data := b.Get([]byte(familyID))
+		if data == nil {
+			return model.ErrorNotFound
+		}
+		return json.Unmarshal(data, &family)
+	})
+	return family, err
+}
+
 // Close closes underlying database.
 func (ts *TokenStorage) Close() {
 	if err := ts.db.Close(); err != nil {
@@ -72,9 +72,33 @@ func (us *UserStorage) UserByID(id string) (model.User, error) {
 }
 
 // UserByEmail returns user by its email.
+// There is no index by email, so it looks through all the users.
 func (us *UserStorage) UserByEmail(email string) (model.User, error) {
-	// TODO: implement boltdb UserByEmail
-	return model.User{}, errors.New("Not implemented. ")
+	if email == "" {
+		return model.User{}, model.ErrorWrongDataFormat
+	}
+
+	var res model.User
+	err := us.db.View(func(tx *bolt.Tx) error {
+		ub := tx.Bucket([]byte(UserBucket))
+		return ub.ForEach(func(k, v []byte) error {
+			user, err := model.UserFromJSON(v)
+			if err != nil {
+				return err
+			}
+			if strings.EqualFold(user.Email, email) {
+				res = user
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return model.User{}, err
+	}
+	if len(res.ID) == 0 {
+		return model.User{}, model.ErrUserNotFound
+	}
+	return res, nil
 }
 
 // DeleteUser deletes user by ID.
@@ -308,6 +332,42 @@ func (us *UserStorage) AddUserByPhone(phone, role string) (model.User, error) {
 	return u, err
 }
 
+// AddUserByEmail registers new user with email address and no password.
+func (us *UserStorage) AddUserByEmail(email, role string) (model.User, error) {
+	if us.UserExists(email) {
+		return model.User{}, model.ErrorUserExists
+	}
+
+	u := model.User{
+		ID:          xid.New().String(),
+		Username:    email,
+		Email:       email,
+		Active:      true,
+		AccessRole:  role,
+		NumOfLogins: 0,
+	}
+
+	err := us.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+
+		ub := tx.Bucket([]byte(UserBucket))
+		if err := ub.Put([]byte(u.ID), data); err != nil {
+			return err
+		}
+
+		// We use email as a username, so the user could set the password later.
+		unpb := tx.Bucket([]byte(UserByNameAndPassword))
+		return unpb.Put([]byte(email), []byte(u.ID))
+	})
+	if err != nil {
+		return model.User{}, err
+	}
+	return u, nil
+}
+
 // AddUserWithFederatedID adds new user with social ID.
 func (us *UserStorage) AddUserWithFederatedID(provider model.FederatedIdentityProvider, federatedID, role string) (model.User, error) {
 	sid := string(provider) + ":" + federatedID
@@ -317,11 +377,12 @@ func (us *UserStorage) AddUserWithFederatedID(provider model.FederatedIdentityPr
 	}
 
 	user := model.User{
-		ID:          sid, // not sure it's a good idea
-		Active:      true,
-		Username:    sid,
-		AccessRole:  role,
-		NumOfLogins: 0,
+		ID:           sid, // not sure it's a good idea
+		Active:       true,
+		Username:     sid,
+		AccessRole:   role,
+		NumOfLogins:  0,
+		FederatedIDs: []string{sid},
 	}
 
 	err := us.db.Update(func(tx *bolt.Tx) error {
@@ -344,6 +405,192 @@ func (us *UserStorage) AddUserWithFederatedID(provider model.FederatedIdentityPr
 	return user, nil
 }
 
+// AddFederatedID links federated ID to the user.
+func (us *UserStorage) AddFederatedID(userID string, provider model.FederatedIdentityProvider, federatedID string) (model.User, error) {
+	sid := string(provider) + ":" + federatedID
+
+	var res model.User
+	err := us.db.Update(func(tx *bolt.Tx) error {
+		usib := tx.Bucket([]byte(UserBySocialIDBucket))
+		if linkedID := usib.Get([]byte(sid)); linkedID != nil && string(linkedID) != userID {
+			return model.ErrorUserExists
+		}
+
+		ub := tx.Bucket([]byte(UserBucket))
+		u := ub.Get([]byte(userID))
+		if u == nil {
+			return model.ErrUserNotFound
+		}
+
+		var err error
+		if res, err = model.UserFromJSON(u); err != nil {
+			return err
+		}
+		if !contains(res.FederatedIDs, sid) {
+			res.FederatedIDs = append(res.FederatedIDs, sid)
+		}
+
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		if err = ub.Put([]byte(userID), data); err != nil {
+			return err
+		}
+		return usib.Put([]byte(sid), []byte(userID))
+	})
+	if err != nil {
+		return model.User{}, err
+	}
+	return res, nil
+}
+
+// RemoveFederatedID unlinks federated ID from the user.
+func (us *UserStorage) RemoveFederatedID(userID string, provider model.FederatedIdentityProvider, federatedID string) (model.User, error) {
+	sid := string(provider) + ":" + federatedID
+
+	var res model.User
+	err := us.db.Update(func(tx *bolt.Tx) error {
+		usib := tx.Bucket([]byte(UserBySocialIDBucket))
+		if linkedID := usib.Get([]byte(sid)); linkedID == nil || string(linkedID) != userID {
+			return model.ErrorNotFound
+		}
+
+		ub := tx.Bucket([]byte(UserBucket))
+		u := ub.Get([]byte(userID))
+		if u == nil {
+			return model.ErrUserNotFound
+		}
+
+		var err error
+		if res, err = model.UserFromJSON(u); err != nil {
+			return err
+		}
+		federatedIDs := []string{}
+		for _, id := range res.FederatedIDs {
+			if id != sid {
+				federatedIDs = append(federatedIDs, id)
+			}
+		}
+		res.FederatedIDs = federatedIDs
+
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		if err = ub.Put([]byte(userID), data); err != nil {
+			return err
+		}
+		return usib.Delete([]byte(sid))
+	})
+	if err != nil {
+		return model.User{}, err
+	}
+	return res, nil
+}
+
+// AddWebAuthnCredential adds the public key credential to the user.
+func (us *UserStorage) AddWebAuthnCredential(userID string, credential model.WebAuthnCredential) (model.User, error) {
+	return us.updateUserTx(userID, func(user *model.User) error {
+		if _, ok := user.WebAuthnCredential(credential.ID); !ok {
+			user.WebAuthnCredentials = append(user.WebAuthnCredentials, credential)
+		}
+		return nil
+	})
+}
+
+// UpdateWebAuthnCredential saves the credential state, like the signature counter, after the user signs in with it.
+func (us *UserStorage) UpdateWebAuthnCredential(userID string, credential model.WebAuthnCredential) error {
+	_, err := us.updateUserTx(userID, func(user *model.User) error {
+		for i, c := range user.WebAuthnCredentials {
+			if c.ID == credential.ID {
+				user.WebAuthnCredentials[i] = credential
+				return nil
+			}
+		}
+		return model.ErrorNotFound
+	})
+	return err
+}
+
+// RemoveWebAuthnCredential removes the public key credential from the user.
+func (us *UserStorage) RemoveWebAuthnCredential(userID, credentialID string) (model.User, error) {
+	return us.updateUserTx(userID, func(user *model.User) error {
+		if _, ok := user.WebAuthnCredential(credentialID); !ok {
+			return model.ErrorNotFound
+		}
+		credentials := []model.WebAuthnCredential{}
+		for _, c := range user.WebAuthnCredentials {
+			if c.ID != credentialID {
+				credentials = append(credentials, c)
+			}
+		}
+		user.WebAuthnCredentials = credentials
+		return nil
+	})
+}
+
+// AddTrustedDevice adds the device to the user's trusted devices, and forgets the expired ones.
+func (us *UserStorage) AddTrustedDevice(userID string, device model.TrustedDevice) (model.User, error) {
+	return us.updateUserTx(userID, func(user *model.User) error {
+		devices := []model.TrustedDevice{device}
+		for _, d := range user.TrustedDevices {
+			if d.ID != device.ID && !d.Expired() {
+				devices = append(devices, d)
+			}
+		}
+		user.TrustedDevices = devices
+		return nil
+	})
+}
+
+// RemoveTrustedDevice revokes the user's trusted device.
+func (us *UserStorage) RemoveTrustedDevice(userID, deviceID string) (model.User, error) {
+	return us.updateUserTx(userID, func(user *model.User) error {
+		if _, ok := user.TrustedDevice(deviceID); !ok {
+			return model.ErrorNotFound
+		}
+		devices := []model.TrustedDevice{}
+		for _, d := range user.TrustedDevices {
+			if d.ID != deviceID {
+				devices = append(devices, d)
+			}
+		}
+		user.TrustedDevices = devices
+		return nil
+	})
+}
+
+// updateUserTx changes the user in one transaction.
+func (us *UserStorage) updateUserTx(userID string, update func(*model.User) error) (model.User, error) {
+	var res model.User
+	err := us.db.Update(func(tx *bolt.Tx) error {
+		ub := tx.Bucket([]byte(UserBucket))
+		u := ub.Get([]byte(userID))
+		if u == nil {
+			return model.ErrUserNotFound
+		}
+
+		var err error
+		if res, err = model.UserFromJSON(u); err != nil {
+			return err
+		}
+		if err = update(&res); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		return ub.Put([]byte(userID), data)
+	})
+	if err != nil {
+		return model.User{}, err
+	}
+	return res, nil
+}
+
 // AddUserByNameAndPassword creates new user and saves it in the database.
 func (us *UserStorage) AddUserByNameAndPassword(username, password, role string, isAnonymous bool) (model.User, error) {
 	if us.UserExists(username) {
@@ -387,9 +634,15 @@ func (us *UserStorage) UpdateUser(userID string, user model.User) (model.User, e
 			if user.Pswd == "" {
 				user.Pswd = oldUser.Pswd
 			}
-			if user.TFAInfo.Secret == "" {
+			if user.TFAInfo.Secret == "" && len(user.TFAInfo.Factors) == 0 {
 				user.TFAInfo.Secret = oldUser.TFAInfo.Secret
 			}
+			if user.WebAuthnCredentials == nil {
+				user.WebAuthnCredentials = oldUser.WebAuthnCredentials
+			}
+			if user.TrustedDevices == nil {
+				user.TrustedDevices = oldUser.TrustedDevices
+			}
 		}
 
 		data, err := json.Marshal(user)
@@ -559,9 +812,49 @@ func (us *UserStorage) UpdateLoginMetadata(userID string) {
 	}
 }
 
+// IncrementTFAFailedAttempts counts the failed TFA attempt, and returns the number of attempts in a row.
+func (us *UserStorage) IncrementTFAFailedAttempts(userID string) (int, error) {
+	user, err := us.updateUserTx(userID, func(u *model.User) error {
+		u.TFAInfo.FailedAttempts++
+		return nil
+	})
+	return user.TFAInfo.FailedAttempts, err
+}
+
+// LockTFA locks the user's TFA challenge until the given time, and resets the failed attempts.
+func (us *UserStorage) LockTFA(userID string, until time.Time) error {
+	_, err := us.updateUserTx(userID, func(u *model.User) error {
+		u.TFAInfo.FailedAttempts = 0
+		u.TFAInfo.LockedUntil = until
+		return nil
+	})
+	return err
+}
+
+// SwapTFAInfo replaces the user's TFA info, if its factors and recovery codes are still those of current.
+func (us *UserStorage) SwapTFAInfo(userID string, info, current model.TFAInfo) error {
+	_, err := us.updateUserTx(userID, func(u *model.User) error {
+		if !u.TFAInfo.SameCodes(current) {
+			return model.ErrorNotFound
+		}
+		u.TFAInfo = info
+		return nil
+	})
+	return err
+}
+
 // Close closes underlying database.
 func (us *UserStorage) Close() {
 	if err := us.db.Close(); err != nil {
 		log.Printf("Error closing user storage: %s\n", err)
 	}
 }
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package boltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/madappgang/identifo/model"
+)
+
+func TestUserStorageSwapTFAInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identifo-users")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := InitDB(filepath.Join(dir, "db.db"))
+	if err != nil {
+		t.Fatalf("Unable to open database %v", err)
+	}
+	defer db.Close()
+	us, err := NewUserStorage(db)
+	if err != nil {
+		t.Fatalf("Unable to create user storage %v", err)
+	}
+
+	user, err := us.AddUserByNameAndPassword("user", "password", "user", false)
+	if err != nil {
+		t.Fatalf("AddUserByNameAndPassword() error = %v", err)
+	}
+	codes, hashes, err := model.NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes() error = %v", err)
+	}
+	user.TFAInfo = model.TFAInfo{IsEnabled: true, RecoveryCodes: hashes}
+	user.TFAInfo.SetFactor(model.TFAFactor{Type: model.TFATypeApp, Secret: "totp"}, model.TFATypeApp)
+	if user, err = us.UpdateUser(user.ID, user); err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+
+	// Two requests accept the same code, only the first one wins.
+	current := user.TFAInfo
+	info := current
+	info.SetFactor(model.TFAFactor{Type: model.TFATypeApp, Secret: "totp", LastTOTPStep: 10}, model.TFATypeApp)
+	if err = us.SwapTFAInfo(user.ID, info, current); err != nil {
+		t.Fatalf("SwapTFAInfo() error = %v", err)
+	}
+	if err = us.SwapTFAInfo(user.ID, info, current); err != model.ErrorNotFound {
+		t.Errorf("SwapTFAInfo() of stale info error = %v, want %v", err, model.ErrorNotFound)
+	}
+
+	current = info
+	info.UseRecoveryCode(codes[0])
+	if err = us.SwapTFAInfo(user.ID, info, current); err != nil {
+		t.Fatalf("SwapTFAInfo() error = %v", err)
+	}
+	if err = us.SwapTFAInfo(user.ID, info, current); err != model.ErrorNotFound {
+		t.Errorf("SwapTFAInfo() of used recovery code error = %v, want %v", err, model.ErrorNotFound)
+	}
+
+	if user, err = us.UserByID(user.ID); err != nil {
+		t.Fatalf("UserByID() error = %v", err)
+	}
+	if f, _ := user.TFAInfo.Factor(model.TFATypeApp, model.TFATypeApp); f.LastTOTPStep != 10 || len(user.TFAInfo.RecoveryCodes) != model.RecoveryCodesCount-1 {
+		t.Errorf("UserByID() TFA info = %+v, want swapped info", user.TFAInfo)
+	}
+}
+
+func TestUserStorageLockTFA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identifo-users")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := InitDB(filepath.Join(dir, "db.db"))
+	if err != nil {
+		t.Fatalf("Unable to open database %v", err)
+	}
+	defer db.Close()
+	us, err := NewUserStorage(db)
+	if err != nil {
+		t.Fatalf("Unable to create user storage %v", err)
+	}
+
+	user, err := us.AddUserByNameAndPassword("user", "password", "user", false)
+	if err != nil {
+		t.Fatalf("AddUserByNameAndPassword() error = %v", err)
+	}
+	user.TFAInfo = model.TFAInfo{IsEnabled: true}
+	user.TFAInfo.SetFactor(model.TFAFactor{Type: model.TFATypeSMS, Secret: "hotp"}, model.TFATypeSMS)
+	if user, err = us.UpdateUser(user.ID, user); err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+	if _, err = us.IncrementTFAFailedAttempts(user.ID); err != nil {
+		t.Fatalf("IncrementTFAFailedAttempts() error = %v", err)
+	}
+
+	// The code sent in between is kept, the lock does not overwrite it.
+	info := user.TFAInfo
+	info.SetFactor(model.TFAFactor{Type: model.TFATypeSMS, Secret: "hotp", HOTPCounter: 1}, model.TFATypeSMS)
+	if err = us.SwapTFAInfo(user.ID, info, user.TFAInfo); err != nil {
+		t.Fatalf("SwapTFAInfo() error = %v", err)
+	}
+	until := time.Now().Add(time.Minute)
+	if err = us.LockTFA(user.ID, until); err != nil {
+		t.Fatalf("LockTFA() error = %v", err)
+	}
+
+	if user, err = us.UserByID(user.ID); err != nil {
+		t.Fatalf("UserByID() error = %v", err)
+	}
+	if !user.TFAInfo.LockedUntil.Equal(until) || user.TFAInfo.FailedAttempts != 0 {
+		t.Errorf("UserByID() TFA lock = %v, %d attempts, want %v, 0 attempts", user.TFAInfo.LockedUntil, user.TFAInfo.FailedAttempts, until)
+	}
+	if f, _ := user.TFAInfo.Factor(model.TFATypeSMS, model.TFATypeSMS); f.HOTPCounter != 1 {
+		t.Errorf("UserByID() HOTP counter = %d, want 1", f.HOTPCounter)
+	}
+}
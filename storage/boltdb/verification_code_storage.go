@@ -1,6 +1,7 @@
 package boltdb
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"log"
 
@@ -35,27 +36,31 @@ type VerificationCodeStorage struct {
 }
 
 // IsVerificationCodeFound checks whether verification code can be found.
-func (vcs *VerificationCodeStorage) IsVerificationCodeFound(phone, code string) (bool, error) {
-	err := vcs.db.View(func(tx *bolt.Tx) error {
+// The code is deleted when found, so it is used once.
+func (vcs *VerificationCodeStorage) IsVerificationCodeFound(identifier, code string) (bool, error) {
+	err := vcs.db.Update(func(tx *bolt.Tx) error {
 		vcb := tx.Bucket([]byte(VerificationCodesBucket))
-		code := vcb.Get([]byte(phone))
-		if code == nil {
+		stored := vcb.Get([]byte(identifier))
+		if stored == nil || subtle.ConstantTimeCompare(stored, []byte(code)) != 1 {
 			return model.ErrorNotFound
 		}
-		return nil
+		return vcb.Delete([]byte(identifier))
 	})
-	return err == nil, nil
+	if err == model.ErrorNotFound {
+		return false, nil
+	}
+	return err == nil, err
 }
 
 // CreateVerificationCode inserts new verification code to the database.
-func (vcs *VerificationCodeStorage) CreateVerificationCode(phone, code string) error {
+func (vcs *VerificationCodeStorage) CreateVerificationCode(identifier, code string) error {
 	err := vcs.db.Update(func(tx *bolt.Tx) error {
 		vcb := tx.Bucket([]byte(VerificationCodesBucket))
-		if err := vcb.Delete([]byte(phone)); err != nil {
+		if err := vcb.Delete([]byte(identifier)); err != nil {
 			return err
 		}
 
-		return vcb.Put([]byte(phone), []byte(code))
+		return vcb.Put([]byte(identifier), []byte(code))
 	})
 	return err
 }
@@ -0,0 +1,40 @@
+package boltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerificationCodeStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identifo-codes")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := InitDB(filepath.Join(dir, "db.db"))
+	if err != nil {
+		t.Fatalf("Unable to open database %v", err)
+	}
+	defer db.Close()
+	vcs, err := NewVerificationCodeStorage(db)
+	if err != nil {
+		t.Fatalf("Unable to create verification code storage %v", err)
+	}
+
+	for _, identifier := range []string{"+380501234567", "alice@example.com"} {
+		if err = vcs.CreateVerificationCode(identifier, "123456"); err != nil {
+			t.Fatalf("CreateVerificationCode(%v) error = %v", identifier, err)
+		}
+		if found, err := vcs.IsVerificationCodeFound(identifier, "654321"); found || err != nil {
+			t.Errorf("IsVerificationCodeFound(%v) with wrong code = %v, %v", identifier, found, err)
+		}
+		if found, err := vcs.IsVerificationCodeFound(identifier, "123456"); !found || err != nil {
+			t.Errorf("IsVerificationCodeFound(%v) = %v, %v, want found", identifier, found, err)
+		}
+		if found, _ := vcs.IsVerificationCodeFound(identifier, "123456"); found {
+			t.Errorf("IsVerificationCodeFound(%v) with used code = %v", identifier, found)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package dynamodb
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/madappgang/identifo/model"
+)
+
+const (
+	// deviceCodesTableName is a table name for device codes.
+	deviceCodesTableName = "DeviceCodes"
+	// deviceUserCodeIndexName is a device codes table global index to access device codes by user codes.
+	deviceUserCodeIndexName = "user-code-index"
+
+	deviceCodeField = "device_code"
+	userCodeField   = "user_code"
+	// deviceCodeTTLField holds expiration time as a Unix timestamp, as DynamoDB TTL requires.
+	deviceCodeTTLField = "ttl"
+)
+
+// NewDeviceCodeStorage creates and provisions new DynamoDB device code storage.
+func NewDeviceCodeStorage(db *DB) (model.DeviceCodeStorage, error) {
+	dcs := &DeviceCodeStorage{db: db}
+	err := dcs.ensureTable()
+	return dcs, err
+}
+
+// DeviceCodeStorage implements device code storage interface.
+type DeviceCodeStorage struct {
+	db *DB
+}
+
+// CreateDeviceCode saves new device code.
+func (dcs *DeviceCodeStorage) CreateDeviceCode(dc model.DeviceCode) error {
+	// Secondary indices are not unique in DynamoDB, so we check user code manually.
+	if _, err := dcs.DeviceCodeByUserCode(dc.UserCode); err == nil {
+		return model.ErrorWrongDataFormat
+	} else if err != model.ErrorNotFound {
+		return err
+	}
+	return dcs.put(dc, "attribute_not_exists(device_code)")
+}
+
+// DeviceCodeByDeviceCode returns device code by its device code.
+func (dcs *DeviceCodeStorage) DeviceCodeByDeviceCode(deviceCode string) (model.DeviceCode, error) {
+	result, err := dcs.db.C.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(deviceCodesTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			deviceCodeField: {S: aws.String(deviceCode)},
+		},
+	})
+	if err != nil {
+		log.Println("Error getting device code:", err)
+		return model.DeviceCode{}, ErrorInternalError
+	}
+	if result.Item == nil {
+		return model.DeviceCode{}, model.ErrorNotFound
+	}
+
+	var dc model.DeviceCode
+	if err = dynamodbattribute.UnmarshalMap(result.Item, &dc); err != nil {
+		log.Println("Error unmarshalling device code:", err)
+		return model.DeviceCode{}, ErrorInternalError
+	}
+	return dc, nil
+}
+
+// DeviceCodeByUserCode returns device code by its user code.
+func (dcs *DeviceCodeStorage) DeviceCodeByUserCode(userCode string) (model.DeviceCode, error) {
+	result, err := dcs.db.C.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(deviceCodesTableName),
+		IndexName:              aws.String(deviceUserCodeIndexName),
+		KeyConditionExpression: aws.String("user_code = :c"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": {S: aws.String(userCode)},
+		},
+	})
+	if err != nil {
+		log.Println("Error querying for device code:", err)
+		return model.DeviceCode{}, ErrorInternalError
+	}
+	if len(result.Items) == 0 {
+		return model.DeviceCode{}, model.ErrorNotFound
+	}
+
+	var dc model.DeviceCode
+	if err = dynamodbattribute.UnmarshalMap(result.Items[0], &dc); err != nil {
+		log.Println("Error unmarshalling device code:", err)
+		return model.DeviceCode{}, ErrorInternalError
+	}
+	return dc, nil
+}
+
+// UpdateDeviceCode updates existing device code.
+func (dcs *DeviceCodeStorage) UpdateDeviceCode(dc model.DeviceCode) error {
+	return dcs.put(dc, "attribute_exists(device_code)")
+}
+
+// UpdateDeviceCodePoll updates polling fields of the pending device code.
+func (dcs *DeviceCodeStorage) UpdateDeviceCodePoll(deviceCode string, interval int64, lastPolledAt time.Time) error {
+	polledAt, err := dynamodbattribute.Marshal(lastPolledAt)
+	if err != nil {
+		log.Println("Error marshalling device code polling time:", err)
+		return ErrorInternalError
+	}
+
+	if _, err = dcs.db.C.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(deviceCodesTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			deviceCodeField: {S: aws.String(deviceCode)},
+		},
+		ConditionExpression: aws.String("#status = :pending"),
+		UpdateExpression:    aws.String("SET #interval = :interval, last_polled_at = :polled_at"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status":   aws.String("status"),
+			"#interval": aws.String("interval"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pending":   {S: aws.String(string(model.DeviceCodeStatusPending))},
+			":interval":  {N: aws.String(strconv.FormatInt(interval, 10))},
+			":polled_at": polledAt,
+		},
+	}); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return model.ErrorNotFound
+		}
+		log.Println("Error updating device code:", err)
+		return ErrorInternalError
+	}
+	return nil
+}
+
+// ConsumeDeviceCode deletes the approved device code.
+func (dcs *DeviceCodeStorage) ConsumeDeviceCode(deviceCode string) error {
+	if _, err := dcs.db.C.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(deviceCodesTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			deviceCodeField: {S: aws.String(deviceCode)},
+		},
+		ConditionExpression:      aws.String("#status = :approved"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":approved": {S: aws.String(string(model.DeviceCodeStatusApproved))},
+		},
+	}); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return model.ErrorNotFound
+		}
+		log.Println("Error deleting device code:", err)
+		return ErrorInternalError
+	}
+	return nil
+}
+
+// DeleteDeviceCode deletes device code.
+func (dcs *DeviceCodeStorage) DeleteDeviceCode(deviceCode string) error {
+	if _, err := dcs.db.C.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(deviceCodesTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			deviceCodeField: {S: aws.String(deviceCode)},
+		},
+	}); err != nil {
+		log.Println("Error deleting device code:", err)
+		return ErrorInternalError
+	}
+	return nil
+}
+
+// Close does nothing here.
+func (dcs *DeviceCodeStorage) Close() {}
+
+// put puts device code to the table if the condition is met.
+func (dcs *DeviceCodeStorage) put(dc model.DeviceCode, condition string) error {
+	item, err := dynamodbattribute.MarshalMap(dc)
+	if err != nil {
+		log.Println("Error marshalling device code:", err)
+		return ErrorInternalError
+	}
+	item[deviceCodeTTLField] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(dc.ExpiresAt.Unix(), 10))}
+
+	if _, err = dcs.db.C.PutItem(&dynamodb.PutItemInput{
+		Item:                item,
+		TableName:           aws.String(deviceCodesTableName),
+		ConditionExpression: aws.String(condition),
+	}); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			if condition == "attribute_exists(device_code)" {
+				return model.ErrorNotFound
+			}
+			return model.ErrorWrongDataFormat
+		}
+		log.Println("Error putting device code to database:", err)
+		return ErrorInternalError
+	}
+	return nil
+}
+
+// ensureTable ensures that device code storage table exists in the database.
+func (dcs *DeviceCodeStorage) ensureTable() error {
+	exists, err := dcs.db.IsTableExists(deviceCodesTableName)
+	if err != nil {
+		log.Println("Error checking for device codes table existence:", err)
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	createTableInput := &dynamodb.CreateTableInput{
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String(deviceCodeField),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String(userCodeField),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String(deviceCodeField),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String(deviceUserCodeIndexName),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String(userCodeField),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				Projection: &dynamodb.Projection{
+					ProjectionType: aws.String("ALL"),
+				},
+			},
+		},
+		BillingMode: aws.String("PAY_PER_REQUEST"),
+		TableName:   aws.String(deviceCodesTableName),
+	}
+
+	if _, err = dcs.db.C.CreateTable(createTableInput); err != nil {
+		log.Println("Error creating device codes table:", err)
+		return err
+	}
+
+	ttlInput := &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(deviceCodesTableName),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(deviceCodeTTLField),
+			Enabled:       aws.Bool(true),
+		},
+	}
+
+	if _, err = dcs.db.C.UpdateTimeToLive(ttlInput); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			// Then device codes table must be in creating status. Let's give it some time.
+			for i := 0; i < 5; i++ {
+				time.Sleep(5 * time.Second)
+				log.Println("Retry setting expiration time...")
+				if _, err = dcs.db.C.UpdateTimeToLive(ttlInput); err == nil {
+					break
+				}
+			}
+		}
+	}
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/madappgang/identifo/model"
@@ -85,6 +86,34 @@ func (tb *TokenBlacklist) Add(token string) error {
 	return nil
 }
 
+// Consume blacklists the single-use token, if it is not blacklisted yet.
+func (tb *TokenBlacklist) Consume(token string) error {
+	if len(token) == 0 {
+		return model.ErrorWrongDataFormat
+	}
+
+	t, err := dynamodbattribute.MarshalMap(Token{Token: token})
+	if err != nil {
+		log.Println(err)
+		return ErrorInternalError
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:                t,
+		TableName:           aws.String(blacklistedTokensTableName),
+		ConditionExpression: aws.String("attribute_not_exists(token)"),
+	}
+
+	if _, err = tb.db.C.PutItem(input); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return model.ErrorNotFound
+		}
+		log.Println("Error while putting token to blacklist:", err)
+		return ErrorInternalError
+	}
+	return nil
+}
+
 // IsBlacklisted returns true if token is blacklisted.
 func (tb *TokenBlacklist) IsBlacklisted(token string) bool {
 	if len(token) == 0 {
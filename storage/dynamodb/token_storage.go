@@ -2,19 +2,30 @@ package dynamodb
 
 import (
 	"log"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/madappgang/identifo/model"
 )
 
-const tokensTableName = "RefreshTokens"
+const (
+	tokensTableName        = "RefreshTokens"
+	tokenFamiliesTableName = "RefreshTokenFamilies"
+	// tokenFamilyTTLField holds expiration time as a Unix timestamp, as DynamoDB TTL requires.
+	tokenFamilyTTLField = "ttl"
+)
 
 // NewTokenStorage creates new DynamoDB token storage.
 func NewTokenStorage(db *DB) (model.TokenStorage, error) {
 	ts := &TokenStorage{db: db}
-	err := ts.ensureTable()
+	if err := ts.ensureTable(); err != nil {
+		return ts, err
+	}
+	err := ts.ensureFamiliesTable()
 	return ts, err
 }
 
@@ -58,6 +69,62 @@ func (ts *TokenStorage) ensureTable() error {
 	return nil
 }
 
+// ensureFamiliesTable ensures that token families table exists in the database.
+func (ts *TokenStorage) ensureFamiliesTable() error {
+	exists, err := ts.db.IsTableExists(tokenFamiliesTableName)
+	if err != nil {
+		log.Printf("Error while checking if %s exists: %v", tokenFamiliesTableName, err)
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	input := &dynamodb.CreateTableInput{
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		BillingMode: aws.String("PAY_PER_REQUEST"),
+		TableName:   aws.String(tokenFamiliesTableName),
+	}
+
+	if _, err = ts.db.C.CreateTable(input); err != nil {
+		log.Printf("Error while creating %s table: %v", tokenFamiliesTableName, err)
+		return err
+	}
+
+	ttlInput := &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(tokenFamiliesTableName),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(tokenFamilyTTLField),
+			Enabled:       aws.Bool(true),
+		},
+	}
+
+	if _, err = ts.db.C.UpdateTimeToLive(ttlInput); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+			// Then token families table must be in creating status. Let's give it some time.
+			for i := 0; i < 5; i++ {
+				time.Sleep(5 * time.Second)
+				log.Println("Retry setting expiration time...")
+				if _, err = ts.db.C.UpdateTimeToLive(ttlInput); err == nil {
+					break
+				}
+			}
+		}
+	}
+	return err
+}
+
 // SaveToken saves token in the database.
 func (ts *TokenStorage) SaveToken(token string) error {
 	if len(token) == 0 {
@@ -129,6 +196,75 @@ func (ts *TokenStorage) DeleteToken(token string) error {
 	return nil
 }
 
+// SaveTokenFamily creates or updates token family.
+func (ts *TokenStorage) SaveTokenFamily(family model.TokenFamily) error {
+	if len(family.ID) == 0 {
+		return model.ErrorWrongDataFormat
+	}
+
+	return ts.putTokenFamily(family, &dynamodb.PutItemInput{})
+}
+
+// SwapTokenFamily replaces token family, if it is not revoked and its current token is still currentToken.
+func (ts *TokenStorage) SwapTokenFamily(family model.TokenFamily, currentToken string) error {
+	return ts.putTokenFamily(family, &dynamodb.PutItemInput{
+		ConditionExpression: aws.String("current_token = :current AND revoked = :revoked"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":current": {S: aws.String(currentToken)},
+			":revoked": {BOOL: aws.Bool(false)},
+		},
+	})
+}
+
+// putTokenFamily puts token family to the table, with the condition of the input if it has one.
+func (ts *TokenStorage) putTokenFamily(family model.TokenFamily, input *dynamodb.PutItemInput) error {
+	item, err := dynamodbattribute.MarshalMap(family)
+	if err != nil {
+		log.Println("Error marshalling token family:", err)
+		return ErrorInternalError
+	}
+	item[tokenFamilyTTLField] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(family.ExpiresAt.Unix(), 10))}
+
+	input.Item = item
+	input.TableName = aws.String(tokenFamiliesTableName)
+	if _, err = ts.db.C.PutItem(input); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return model.ErrorNotFound
+		}
+		log.Println("Error while putting token family to db:", err)
+		return ErrorInternalError
+	}
+	return nil
+}
+
+// TokenFamily returns token family by its ID.
+func (ts *TokenStorage) TokenFamily(familyID string) (model.TokenFamily, error) {
+	if len(familyID) == 0 {
+		return model.TokenFamily{}, model.ErrorNotFound
+	}
+
+	result, err := ts.db.C.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tokenFamiliesTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(familyID)},
+		},
+	})
+	if err != nil {
+		log.Println("Error while fetching token family from db:", err)
+		return model.TokenFamily{}, ErrorInternalError
+	}
+	if result.Item == nil {
+		return model.TokenFamily{}, model.ErrorNotFound
+	}
+
+	var family model.TokenFamily
+	if err = dynamodbattribute.UnmarshalMap(result.Item, &family); err != nil {
+		log.Println("Error unmarshalling token family:", err)
+		return model.TokenFamily{}, ErrorInternalError
+	}
+	return family, nil
+}
+
 // Close does nothing here.
 func (ts *TokenStorage) Close() {}
 
@@ -2,13 +2,13 @@ package dynamodb
 
 import (
 	"encoding/json"
-	"errors"
 	"log"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/madappgang/identifo/model"
@@ -21,8 +21,13 @@ const (
 	usersFederatedIDTableName  = "UsersByFederatedID" // usersFederatedIDTableName is a table to store federated ids.
 	userTableUsernameIndexName = "username-index"     // userTableUsernameIndexName is a user table global index name to access by users by username.
 	usersPhoneNumbersIndexName = "phone-index"        // usersPhoneNumbersIndexName is a table global index to access users by phone numbers.
+
+	listUpdateAttempts = 5 // listUpdateAttempts is how many times the user's list is updated before giving up on concurrent updates.
 )
 
+// errListChanged means the user's list has been updated concurrently.
+var errListChanged = Error("List has been changed concurrently")
+
 // userIndexByNameData represents username index projected user data.
 type userIndexByNameData struct {
 	ID       string `json:"id,omitempty"`
@@ -87,9 +92,40 @@ func (us *UserStorage) UserByID(id string) (model.User, error) {
 }
 
 // UserByEmail returns user by its email.
+// There is no index by email, so it scans the users table.
 func (us *UserStorage) UserByEmail(email string) (model.User, error) {
-	// TODO: implement dynamodb UserByEmail
-	return model.User{}, errors.New("not implemented")
+	if email == "" {
+		return model.User{}, model.ErrorWrongDataFormat
+	}
+
+	var item map[string]*dynamodb.AttributeValue
+	err := us.db.C.ScanPages(&dynamodb.ScanInput{
+		TableName:        aws.String(usersTableName),
+		FilterExpression: aws.String("email = :e"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":e": {S: aws.String(email)},
+		},
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		if len(page.Items) > 0 {
+			item = page.Items[0]
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		log.Println("error querying for user by email: ", err)
+		return model.User{}, ErrorInternalError
+	}
+	if item == nil {
+		return model.User{}, model.ErrUserNotFound
+	}
+
+	userdata := model.User{}
+	if err = dynamodbattribute.UnmarshalMap(item, &userdata); err != nil {
+		log.Println("error while unmarshal user: ", err)
+		return model.User{}, ErrorInternalError
+	}
+	return userdata, nil
 }
 
 func (us *UserStorage) userIDByFederatedID(provider model.FederatedIdentityProvider, id string) (string, error) {
@@ -357,7 +393,7 @@ func (us *UserStorage) AddUserWithFederatedID(provider model.FederatedIdentityPr
 		return model.User{}, err
 	} else if err == model.ErrUserNotFound {
 		// no such user, let's create it
-		uData := model.User{Username: fid, AccessRole: role, Active: true}
+		uData := model.User{Username: fid, AccessRole: role, Active: true, FederatedIDs: []string{fid}}
 		u, creationErr := us.AddNewUser(uData, "")
 		if creationErr != nil {
 			log.Println("error adding new user: ", creationErr)
@@ -389,10 +425,269 @@ func (us *UserStorage) AddUserWithFederatedID(provider model.FederatedIdentityPr
 		return model.User{}, ErrorInternalError
 	}
 
-	udata := model.User{ID: user.ID, Username: user.Username, Active: true}
+	udata := model.User{ID: user.ID, Username: user.Username, Active: true, FederatedIDs: []string{fid}}
 	return udata, nil
 }
 
+// AddFederatedID links federated ID to the user.
+func (us *UserStorage) AddFederatedID(userID string, provider model.FederatedIdentityProvider, federatedID string) (model.User, error) {
+	linkedID, err := us.userIDByFederatedID(provider, federatedID)
+	if err != nil && err != model.ErrUserNotFound {
+		return model.User{}, err
+	} else if err == nil && linkedID != userID {
+		return model.User{}, model.ErrorUserExists
+	}
+
+	user, err := us.UserByID(userID)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	fid := string(provider) + ":" + federatedID
+	fedInputData, err := dynamodbattribute.MarshalMap(federatedUserID{FederatedID: fid, UserID: userID})
+	if err != nil {
+		log.Println("error marshalling federated data: ", err)
+		return model.User{}, ErrorInternalError
+	}
+	// The identity could have been linked to another user since the check above.
+	if _, err = us.db.C.PutItem(&dynamodb.PutItemInput{
+		Item:                fedInputData,
+		TableName:           aws.String(usersFederatedIDTableName),
+		ConditionExpression: aws.String("attribute_not_exists(federated_id) OR user_id = :uid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uid": {S: aws.String(userID)},
+		},
+	}); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return model.User{}, model.ErrorUserExists
+		}
+		log.Println("error putting item: ", err)
+		return model.User{}, ErrorInternalError
+	}
+
+	for _, id := range user.FederatedIDs {
+		if id == fid {
+			return user, nil
+		}
+	}
+	user.FederatedIDs = append(user.FederatedIDs, fid)
+	return user, us.updateFederatedIDs(user)
+}
+
+// RemoveFederatedID unlinks federated ID from the user.
+func (us *UserStorage) RemoveFederatedID(userID string, provider model.FederatedIdentityProvider, federatedID string) (model.User, error) {
+	linkedID, err := us.userIDByFederatedID(provider, federatedID)
+	if err == model.ErrUserNotFound || (err == nil && linkedID != userID) {
+		return model.User{}, model.ErrorNotFound
+	} else if err != nil {
+		return model.User{}, err
+	}
+
+	user, err := us.UserByID(userID)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	fid := string(provider) + ":" + federatedID
+	if _, err = us.db.C.DeleteItem(&dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"federated_id": {S: aws.String(fid)},
+		},
+		TableName: aws.String(usersFederatedIDTableName),
+	}); err != nil {
+		log.Println("error deleting federated id: ", err)
+		return model.User{}, ErrorInternalError
+	}
+
+	federatedIDs := []string{}
+	for _, id := range user.FederatedIDs {
+		if id != fid {
+			federatedIDs = append(federatedIDs, id)
+		}
+	}
+	user.FederatedIDs = federatedIDs
+	return user, us.updateFederatedIDs(user)
+}
+
+// updateFederatedIDs saves the user's federated IDs.
+func (us *UserStorage) updateFederatedIDs(user model.User) error {
+	federatedIDs, err := dynamodbattribute.Marshal(user.FederatedIDs)
+	if err != nil {
+		log.Println("error marshalling federated ids: ", err)
+		return ErrorInternalError
+	}
+
+	if _, err = us.db.C.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(usersTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(user.ID)},
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":f": federatedIDs,
+		},
+		UpdateExpression: aws.String("set federated_ids = :f"),
+		ReturnValues:     aws.String("NONE"),
+	}); err != nil {
+		log.Println("error updating federated ids: ", err)
+		return ErrorInternalError
+	}
+	return nil
+}
+
+// AddWebAuthnCredential adds the public key credential to the user.
+func (us *UserStorage) AddWebAuthnCredential(userID string, credential model.WebAuthnCredential) (model.User, error) {
+	return us.updateUserList(userID, "webauthn_credentials", webAuthnCredentials, func(user *model.User) (bool, error) {
+		if _, ok := user.WebAuthnCredential(credential.ID); ok {
+			return false, nil
+		}
+		user.WebAuthnCredentials = append(user.WebAuthnCredentials, credential)
+		return true, nil
+	})
+}
+
+// UpdateWebAuthnCredential saves the credential state, like the signature counter, after the user signs in with it.
+func (us *UserStorage) UpdateWebAuthnCredential(userID string, credential model.WebAuthnCredential) error {
+	_, err := us.updateUserList(userID, "webauthn_credentials", webAuthnCredentials, func(user *model.User) (bool, error) {
+		for i, c := range user.WebAuthnCredentials {
+			if c.ID == credential.ID {
+				user.WebAuthnCredentials[i] = credential
+				return true, nil
+			}
+		}
+		return false, model.ErrorNotFound
+	})
+	return err
+}
+
+// RemoveWebAuthnCredential removes the public key credential from the user.
+func (us *UserStorage) RemoveWebAuthnCredential(userID, credentialID string) (model.User, error) {
+	return us.updateUserList(userID, "webauthn_credentials", webAuthnCredentials, func(user *model.User) (bool, error) {
+		if _, ok := user.WebAuthnCredential(credentialID); !ok {
+			return false, model.ErrorNotFound
+		}
+
+		credentials := []model.WebAuthnCredential{}
+		for _, c := range user.WebAuthnCredentials {
+			if c.ID != credentialID {
+				credentials = append(credentials, c)
+			}
+		}
+		user.WebAuthnCredentials = credentials
+		return true, nil
+	})
+}
+
+// AddTrustedDevice adds the device to the user's trusted devices, and forgets the expired ones.
+func (us *UserStorage) AddTrustedDevice(userID string, device model.TrustedDevice) (model.User, error) {
+	return us.updateUserList(userID, "trusted_devices", trustedDevices, func(user *model.User) (bool, error) {
+		devices := []model.TrustedDevice{device}
+		for _, d := range user.TrustedDevices {
+			if d.ID != device.ID && !d.Expired() {
+				devices = append(devices, d)
+			}
+		}
+		user.TrustedDevices = devices
+		return true, nil
+	})
+}
+
+// RemoveTrustedDevice revokes the user's trusted device.
+func (us *UserStorage) RemoveTrustedDevice(userID, deviceID string) (model.User, error) {
+	return us.updateUserList(userID, "trusted_devices", trustedDevices, func(user *model.User) (bool, error) {
+		if _, ok := user.TrustedDevice(deviceID); !ok {
+			return false, model.ErrorNotFound
+		}
+
+		devices := []model.TrustedDevice{}
+		for _, d := range user.TrustedDevices {
+			if d.ID != deviceID {
+				devices = append(devices, d)
+			}
+		}
+		user.TrustedDevices = devices
+		return true, nil
+	})
+}
+
+func webAuthnCredentials(user model.User) interface{} { return user.WebAuthnCredentials }
+
+func trustedDevices(user model.User) interface{} { return user.TrustedDevices }
+
+// updateUserList reads the user, updates it, and saves the list attribute, if update says it has changed the list.
+// The list is saved only if it is still the one that has been read, otherwise the user is read and updated again,
+// so the concurrent update, like revocation of the device, is not lost.
+func (us *UserStorage) updateUserList(userID, attribute string, list func(model.User) interface{}, update func(*model.User) (bool, error)) (model.User, error) {
+	for i := 0; i < listUpdateAttempts; i++ {
+		user, err := us.UserByID(userID)
+		if err != nil {
+			return model.User{}, err
+		}
+
+		old := list(user)
+		changed, err := update(&user)
+		if err != nil {
+			return model.User{}, err
+		}
+		if !changed {
+			return user, nil
+		}
+
+		err = us.swapUserList(userID, attribute, old, list(user))
+		if err == errListChanged {
+			continue
+		}
+		if err != nil {
+			return model.User{}, err
+		}
+		return user, nil
+	}
+	log.Printf("error updating %s of user %s: too many concurrent updates\n", attribute, userID)
+	return model.User{}, ErrorInternalError
+}
+
+// swapUserList sets the list attribute to the new list, if it is still the old one. It returns errListChanged otherwise.
+func (us *UserStorage) swapUserList(userID, attribute string, old, new interface{}) error {
+	newValue, err := dynamodbattribute.Marshal(new)
+	if err != nil {
+		log.Printf("error marshalling %s: %s\n", attribute, err)
+		return ErrorInternalError
+	}
+	oldValue, err := dynamodbattribute.Marshal(old)
+	if err != nil {
+		log.Printf("error marshalling %s: %s\n", attribute, err)
+		return ErrorInternalError
+	}
+
+	// Empty lists are either omitted or saved as null.
+	values := map[string]*dynamodb.AttributeValue{":new": newValue}
+	condition := "attribute_exists(id) AND (attribute_not_exists(#list) OR attribute_type(#list, :null))"
+	if oldValue.NULL == nil {
+		condition = "#list = :old"
+		values[":old"] = oldValue
+	} else {
+		values[":null"] = &dynamodb.AttributeValue{S: aws.String("NULL")}
+	}
+
+	if _, err = us.db.C.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(usersTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(userID)},
+		},
+		ExpressionAttributeNames:  map[string]*string{"#list": aws.String(attribute)},
+		ExpressionAttributeValues: values,
+		ConditionExpression:       aws.String(condition),
+		UpdateExpression:          aws.String("set #list = :new"),
+		ReturnValues:              aws.String("NONE"),
+	}); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return errListChanged
+		}
+		log.Printf("error updating %s: %s\n", attribute, err)
+		return ErrorInternalError
+	}
+	return nil
+}
+
 // AddUserByPhone registers new user with phone number.
 func (us *UserStorage) AddUserByPhone(phone, role string) (model.User, error) {
 	_, err := us.userIdxByPhone(phone)
@@ -414,6 +709,27 @@ func (us *UserStorage) AddUserByPhone(phone, role string) (model.User, error) {
 	return us.AddNewUser(u, "")
 }
 
+// AddUserByEmail registers new user with email address and no password.
+func (us *UserStorage) AddUserByEmail(email, role string) (model.User, error) {
+	_, err := us.userIdxByName(email)
+	if err != nil && err != model.ErrUserNotFound {
+		log.Println(err)
+		return model.User{}, err
+	} else if err == nil {
+		return model.User{}, model.ErrorUserExists
+	}
+
+	u := model.User{
+		ID:          xid.New().String(),
+		Username:    email,
+		Active:      true,
+		Email:       email,
+		AccessRole:  role,
+		NumOfLogins: 0,
+	}
+	return us.AddNewUser(u, "")
+}
+
 // UpdateUser updates user in DynamoDB storage.
 func (us *UserStorage) UpdateUser(userID string, user model.User) (model.User, error) {
 	if _, err := xid.FromString(userID); err != nil {
@@ -589,6 +905,133 @@ func (us *UserStorage) UpdateLoginMetadata(userID string) {
 	}
 }
 
+// IncrementTFAFailedAttempts counts the failed TFA attempt, and returns the number of attempts in a row.
+func (us *UserStorage) IncrementTFAFailedAttempts(userID string) (int, error) {
+	if _, err := xid.FromString(userID); err != nil {
+		log.Println("Incorrect userID: ", userID)
+		return 0, model.ErrorWrongDataFormat
+	}
+
+	// Users with TFA have the info map, so the counter is added to it.
+	result, err := us.db.C.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(usersTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(userID)},
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":zero": {N: aws.String("0")},
+			":one":  {N: aws.String("1")},
+		},
+		ConditionExpression: aws.String("attribute_exists(tfa_info)"),
+		UpdateExpression:    aws.String("set tfa_info.failed_attempts = if_not_exists(tfa_info.failed_attempts, :zero) + :one"),
+		ReturnValues:        aws.String("UPDATED_NEW"),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return 0, model.ErrUserNotFound
+		}
+		log.Println("Error updating TFA failed attempts:", err)
+		return 0, ErrorInternalError
+	}
+
+	var updated struct {
+		TFAInfo model.TFAInfo `json:"tfa_info"`
+	}
+	if err = dynamodbattribute.UnmarshalMap(result.Attributes, &updated); err != nil {
+		log.Println("Error unmarshalling TFA failed attempts:", err)
+		return 0, ErrorInternalError
+	}
+	return updated.TFAInfo.FailedAttempts, nil
+}
+
+// LockTFA locks the user's TFA challenge until the given time, and resets the failed attempts.
+func (us *UserStorage) LockTFA(userID string, until time.Time) error {
+	if _, err := xid.FromString(userID); err != nil {
+		log.Println("Incorrect userID: ", userID)
+		return model.ErrorWrongDataFormat
+	}
+
+	untilValue, err := dynamodbattribute.Marshal(until)
+	if err != nil {
+		log.Println("Error marshalling TFA lock:", err)
+		return ErrorInternalError
+	}
+
+	_, err = us.db.C.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(usersTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(userID)},
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":until": untilValue,
+		},
+		ConditionExpression: aws.String("attribute_exists(tfa_info)"),
+		UpdateExpression:    aws.String("set tfa_info.locked_until = :until remove tfa_info.failed_attempts"),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return model.ErrUserNotFound
+		}
+		log.Println("Error locking TFA:", err)
+		return ErrorInternalError
+	}
+	return nil
+}
+
+// SwapTFAInfo replaces the user's TFA info, if its factors and recovery codes are still those of current.
+func (us *UserStorage) SwapTFAInfo(userID string, info, current model.TFAInfo) error {
+	if _, err := xid.FromString(userID); err != nil {
+		log.Println("Incorrect userID: ", userID)
+		return model.ErrorWrongDataFormat
+	}
+
+	infoValue, err := dynamodbattribute.Marshal(info)
+	if err != nil {
+		log.Println("Error marshalling TFA info:", err)
+		return ErrorInternalError
+	}
+	values := map[string]*dynamodb.AttributeValue{":info": infoValue}
+
+	// Empty lists are omitted, so the attribute is missing until the user enrolls factors or gets codes.
+	conditions := []string{"attribute_exists(id)"}
+	lists := map[string]interface{}{}
+	if len(current.Factors) > 0 {
+		lists["factors"] = current.Factors
+	} else {
+		conditions = append(conditions, "attribute_not_exists(tfa_info.factors)")
+	}
+	if len(current.RecoveryCodes) > 0 {
+		lists["recovery_codes"] = current.RecoveryCodes
+	} else {
+		conditions = append(conditions, "attribute_not_exists(tfa_info.recovery_codes)")
+	}
+	for name, list := range lists {
+		if values[":"+name], err = dynamodbattribute.Marshal(list); err != nil {
+			log.Println("Error marshalling TFA info:", err)
+			return ErrorInternalError
+		}
+		conditions = append(conditions, "tfa_info."+name+" = :"+name)
+	}
+
+	_, err = us.db.C.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(usersTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(userID)},
+		},
+		ExpressionAttributeValues: values,
+		ConditionExpression:       aws.String(strings.Join(conditions, " AND ")),
+		UpdateExpression:          aws.String("set tfa_info = :info"),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return model.ErrorNotFound
+		}
+		log.Println("Error swapping TFA info:", err)
+		return ErrorInternalError
+	}
+	return nil
+}
+
 // ensureTable ensures that user storage table exists in the database.
 // I'm hiding it in the end of the file, because AWS devs, you are killing me with this API.
 func (us *UserStorage) ensureTable() error {
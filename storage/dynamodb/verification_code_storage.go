@@ -1,10 +1,12 @@
 package dynamodb
 
 import (
+	"crypto/subtle"
 	"log"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/madappgang/identifo/model"
@@ -17,8 +19,8 @@ const (
 	// verificationCodesExpirationTime specifies time before deleting records.
 	verificationCodesExpirationTime = 5 * time.Minute
 
+	// phoneField is the key with the phone number or email the code is sent to.
 	phoneField     = "phone"
-	codeField      = "code"
 	expiresAtField = "expiresAt"
 )
 
@@ -34,32 +36,67 @@ type VerificationCodeStorage struct {
 	db *DB
 }
 
+// verificationCode is the code sent to the identifier.
+type verificationCode struct {
+	Identifier string `json:"phone"`
+	Code       string `json:"code"`
+	ExpiresAt  int64  `json:"expiresAt"`
+}
+
 // IsVerificationCodeFound checks whether verification code can be found.
-func (vcs *VerificationCodeStorage) IsVerificationCodeFound(phone, code string) (bool, error) {
-	result, err := vcs.db.C.Query(&dynamodb.QueryInput{
-		TableName:              aws.String(verificationCodesTableName),
-		KeyConditionExpression: aws.String("phone = :phone"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":phone": {S: aws.String(phone)},
+// The code is deleted when found, so it is used once.
+func (vcs *VerificationCodeStorage) IsVerificationCodeFound(identifier, code string) (bool, error) {
+	result, err := vcs.db.C.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(verificationCodesTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			phoneField: {S: aws.String(identifier)},
 		},
+		ConsistentRead: aws.Bool(true),
 	})
-
 	if err != nil {
 		log.Println("Error querying for verification code:", err)
 		return false, ErrorInternalError
 	}
-	if len(result.Items) == 0 {
+	if result.Item == nil {
+		return false, nil
+	}
+
+	vc := verificationCode{}
+	if err = dynamodbattribute.UnmarshalMap(result.Item, &vc); err != nil {
+		log.Println("Error unmarshalling verification code:", err)
+		return false, nil
+	}
+	// Expired items could be kept for a while before DynamoDB deletes them.
+	if vc.ExpiresAt < time.Now().Unix() || subtle.ConstantTimeCompare([]byte(vc.Code), []byte(code)) != 1 {
 		return false, nil
 	}
+
+	if _, err = vcs.db.C.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(verificationCodesTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			phoneField: {S: aws.String(identifier)},
+		},
+		ConditionExpression: aws.String("code = :code"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":code": {S: aws.String(code)},
+		},
+	}); err != nil {
+		// The code has been used or replaced in the meantime.
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		log.Println("Error deleting verification code:", err)
+		return false, ErrorInternalError
+	}
 	return true, nil
 }
 
 // CreateVerificationCode inserts new verification code to the database.
-func (vcs *VerificationCodeStorage) CreateVerificationCode(phone, code string) error {
+func (vcs *VerificationCodeStorage) CreateVerificationCode(identifier, code string) error {
 	// Remove old item first.
 	delInput := &dynamodb.DeleteItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
-			phoneField: {S: aws.String(phone)},
+			phoneField: {S: aws.String(identifier)},
 		},
 		TableName: aws.String(verificationCodesTableName),
 	}
@@ -70,10 +107,11 @@ func (vcs *VerificationCodeStorage) CreateVerificationCode(phone, code string) e
 	}
 
 	// Then put a new one.
-	item, err := dynamodbattribute.MarshalMap(map[string]interface{}{
-		phoneField:     phone,
-		codeField:      code,
-		expiresAtField: time.Now().Add(verificationCodesExpirationTime),
+	// Expiration time is a number, the format DynamoDB time to live works with.
+	item, err := dynamodbattribute.MarshalMap(verificationCode{
+		Identifier: identifier,
+		Code:       code,
+		ExpiresAt:  time.Now().Add(verificationCodesExpirationTime).Unix(),
 	})
 	if err != nil {
 		log.Println("Error marshalling verification code:", err)
@@ -0,0 +1,18 @@
+package ldap
+
+// Error - domain level error type
+type Error string
+
+// Error - implementation of std.Error protocol
+func (e Error) Error() string { return string(e) }
+
+const (
+	// ErrorEmptySettings means LDAP settings are not set.
+	ErrorEmptySettings = Error("Empty LDAP settings")
+	// ErrorEmptyPrimaryStorage means there is no primary storage to keep users in.
+	ErrorEmptyPrimaryStorage = Error("Empty LDAP primary storage")
+	// ErrorPasswordManagedByDirectory means the user changes the password in the directory.
+	ErrorPasswordManagedByDirectory = Error("Password is managed by the LDAP directory")
+	// ErrorNotInDirectory means the user linked to the directory entry has been removed from the directory, or does not match the filter anymore.
+	ErrorNotInDirectory = Error("User is not in the LDAP directory")
+)
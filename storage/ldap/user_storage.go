@@ -0,0 +1,390 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/madappgang/identifo/model"
+)
+
+// FederatedProvider links users in the primary storage to their directory entries.
+const FederatedProvider model.FederatedIdentityProvider = "LDAP"
+
+const (
+	defaultUserFilter        = "(uid=%s)"
+	defaultUsernameAttribute = "uid"
+	defaultEmailAttribute    = "mail"
+	defaultGroupsAttribute   = "memberOf"
+)
+
+// NewUserStorage creates user storage, which checks passwords in the LDAP directory.
+// Users are kept in the primary storage, which also serves everything the directory does not, like TFA and login metadata.
+func NewUserStorage(settings model.DatabaseSettings, primary model.UserStorage) (model.UserStorage, error) {
+	if settings.LDAP == nil {
+		return nil, ErrorEmptySettings
+	}
+	if primary == nil {
+		return nil, ErrorEmptyPrimaryStorage
+	}
+
+	s := *settings.LDAP
+	if len(s.UserFilter) == 0 {
+		s.UserFilter = defaultUserFilter
+	}
+	if len(s.Attributes.Username) == 0 {
+		s.Attributes.Username = defaultUsernameAttribute
+	}
+	if len(s.Attributes.Email) == 0 {
+		s.Attributes.Email = defaultEmailAttribute
+	}
+	if len(s.Attributes.Groups) == 0 {
+		s.Attributes.Groups = defaultGroupsAttribute
+	}
+
+	return &UserStorage{UserStorage: primary, endpoint: settings.Endpoint, settings: s}, nil
+}
+
+// UserStorage checks passwords with LDAP bind, and keeps shadow users in the primary storage.
+type UserStorage struct {
+	model.UserStorage
+	endpoint string
+	settings model.LDAPSettings
+}
+
+// entry is the user's directory entry.
+type entry struct {
+	DN       string
+	ID       string
+	Username string
+	Email    string
+	Phone    string
+	Groups   []string
+}
+
+// UserByNamePassword checks the password with LDAP bind, and returns the user from the primary storage.
+// Users who are not in the directory, like the ones registered in the app, are checked by the primary storage.
+func (us *UserStorage) UserByNamePassword(name, password string) (model.User, error) {
+	// Bind with empty password is anonymous, and always succeeds.
+	if len(name) == 0 || len(password) == 0 {
+		return model.User{}, model.ErrUserNotFound
+	}
+
+	conn, err := us.dial()
+	if err != nil {
+		return model.User{}, err
+	}
+	defer conn.Close()
+
+	e, err := us.userEntry(conn, name)
+	if err == model.ErrUserNotFound {
+		return us.UserStorage.UserByNamePassword(name, password)
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+
+	if err = conn.Bind(e.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return model.User{}, model.ErrUserNotFound
+		}
+		return model.User{}, err
+	}
+	return us.shadowUser(e)
+}
+
+// UserExists checks if the user is in the directory or in the primary storage.
+// The name is considered taken if the directory cannot be checked.
+func (us *UserStorage) UserExists(name string) bool {
+	if us.UserStorage.UserExists(name) {
+		return true
+	}
+	found, err := us.inDirectory(name)
+	return found || err != nil
+}
+
+// AddUserByNameAndPassword registers the user in the primary storage, unless the name is taken in the directory.
+func (us *UserStorage) AddUserByNameAndPassword(username, password, role string, isAnonymous bool) (model.User, error) {
+	found, err := us.inDirectory(username)
+	if err != nil {
+		return model.User{}, err
+	}
+	if found {
+		return model.User{}, model.ErrorUserExists
+	}
+	return us.UserStorage.AddUserByNameAndPassword(username, password, role, isAnonymous)
+}
+
+// AddUserByEmail registers the user in the primary storage, unless the email is taken as a name in the directory.
+func (us *UserStorage) AddUserByEmail(email, role string) (model.User, error) {
+	found, err := us.inDirectory(email)
+	if err != nil {
+		return model.User{}, err
+	}
+	if found {
+		return model.User{}, model.ErrorUserExists
+	}
+	return us.UserStorage.AddUserByEmail(email, role)
+}
+
+// UserByEmail returns the user by email. Users linked to the directory are returned while their entry is there and still has the email,
+// as passwordless logins sign users in by email without asking the directory.
+func (us *UserStorage) UserByEmail(email string) (model.User, error) {
+	user, err := us.UserStorage.UserByEmail(email)
+	if err != nil {
+		return model.User{}, err
+	}
+	if user, err = us.checkDirectory(user); err != nil {
+		return model.User{}, err
+	}
+	if !strings.EqualFold(user.Email, email) {
+		return model.User{}, ErrorNotInDirectory
+	}
+	return user, nil
+}
+
+// UserByPhone returns the user by phone number. Users linked to the directory are returned while their entry is there and still has the number.
+func (us *UserStorage) UserByPhone(phone string) (model.User, error) {
+	user, err := us.UserStorage.UserByPhone(phone)
+	if err != nil {
+		return model.User{}, err
+	}
+	if user, err = us.checkDirectory(user); err != nil {
+		return model.User{}, err
+	}
+	if user.Phone != phone {
+		return model.User{}, ErrorNotInDirectory
+	}
+	return user, nil
+}
+
+// ResetPassword resets the password of the users who are not in the directory.
+func (us *UserStorage) ResetPassword(id, password string) error {
+	user, err := us.UserStorage.UserByID(id)
+	if err != nil {
+		return err
+	}
+	if _, linked := directoryID(user); linked {
+		return ErrorPasswordManagedByDirectory
+	}
+	return us.UserStorage.ResetPassword(id, password)
+}
+
+// checkDirectory returns the user as is, if it is not linked to the directory.
+// Otherwise, it looks the entry up, and returns the user updated with its attributes, or ErrorNotInDirectory if the entry is gone.
+func (us *UserStorage) checkDirectory(user model.User) (model.User, error) {
+	id, linked := directoryID(user)
+	if !linked {
+		return user, nil
+	}
+
+	conn, err := us.dial()
+	if err != nil {
+		return model.User{}, err
+	}
+	defer conn.Close()
+
+	e, err := us.userEntry(conn, user.Username)
+	if err == model.ErrUserNotFound || (err == nil && e.ID != id) {
+		return model.User{}, ErrorNotInDirectory
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+	return us.shadowUser(e)
+}
+
+// directoryID returns the ID of the directory entry the user is linked to.
+func directoryID(user model.User) (string, bool) {
+	for _, identity := range user.FederatedIdentities() {
+		if identity.Provider == FederatedProvider {
+			return identity.ID, true
+		}
+	}
+	return "", false
+}
+
+// shadowUser returns the user linked to the entry, and updates it with the directory attributes.
+// On the first login, the user is linked or created if the settings say so.
+func (us *UserStorage) shadowUser(e entry) (model.User, error) {
+	user, err := us.UserStorage.UserByFederatedID(FederatedProvider, e.ID)
+	if err == model.ErrUserNotFound {
+		user, err = us.linkUser(e)
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+
+	updated := user
+	if len(e.Username) > 0 {
+		updated.Username = e.Username
+	}
+	if len(e.Email) > 0 {
+		updated.Email = e.Email
+	}
+	if len(e.Phone) > 0 {
+		updated.Phone = e.Phone
+	}
+	if len(us.settings.GroupRoles) > 0 {
+		updated.AccessRole = us.role(e)
+	}
+	if updated.Username == user.Username && updated.Email == user.Email && updated.Phone == user.Phone && updated.AccessRole == user.AccessRole {
+		return user, nil
+	}
+	return us.UserStorage.UpdateUser(user.ID, updated)
+}
+
+// linkUser links the entry to the existing user with the same name, or creates the user.
+// Users with the password are never linked, as the directory entry would take over the account somebody has registered in the app.
+func (us *UserStorage) linkUser(e entry) (model.User, error) {
+	id, err := us.UserStorage.IDByName(e.Username)
+	if err != nil {
+		if !us.settings.ProvisionUsers {
+			return model.User{}, model.ErrUserNotFound
+		}
+		return us.UserStorage.AddUserWithFederatedID(FederatedProvider, e.ID, us.role(e))
+	}
+
+	if !us.settings.LinkUsersByName {
+		return model.User{}, model.ErrorUserExists
+	}
+	existing, err := us.UserStorage.UserByID(id)
+	if err != nil {
+		return model.User{}, err
+	}
+	if len(existing.Pswd) > 0 {
+		return model.User{}, model.ErrorUserExists
+	}
+	return us.UserStorage.AddFederatedID(id, FederatedProvider, e.ID)
+}
+
+// role returns the role of the first group the user is member of, or the default role.
+func (us *UserStorage) role(e entry) string {
+	for _, gr := range us.settings.GroupRoles {
+		for _, group := range e.Groups {
+			if strings.EqualFold(group, gr.Group) || strings.EqualFold(commonName(group), gr.Group) {
+				return gr.Role
+			}
+		}
+	}
+	return us.settings.DefaultRole
+}
+
+// inDirectory checks if the user is in the directory.
+func (us *UserStorage) inDirectory(name string) (bool, error) {
+	conn, err := us.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	_, err = us.userEntry(conn, name)
+	if err == model.ErrUserNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// dial connects to the directory, and binds with the service account.
+func (us *UserStorage) dial() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: us.settings.InsecureSkipVerify}
+	conn, err := ldap.DialURL(us.endpoint, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	if us.settings.StartTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if len(us.settings.BindDN) > 0 {
+		if err = conn.Bind(us.settings.BindDN, us.settings.BindPassword); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// userEntry searches the user by name. The name should match exactly one entry.
+func (us *UserStorage) userEntry(conn *ldap.Conn, name string) (entry, error) {
+	a := us.settings.Attributes
+	attributes := []string{a.Username, a.Email, a.Groups}
+	if len(a.ID) > 0 {
+		attributes = append(attributes, a.ID)
+	}
+	if len(a.Phone) > 0 {
+		attributes = append(attributes, a.Phone)
+	}
+
+	req := ldap.NewSearchRequest(
+		us.settings.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(us.settings.UserFilter, ldap.EscapeFilter(name)),
+		attributes, nil,
+	)
+	res, err := conn.Search(req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) || ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return entry{}, model.ErrUserNotFound
+	}
+	if err != nil {
+		return entry{}, err
+	}
+	if len(res.Entries) != 1 {
+		return entry{}, model.ErrUserNotFound
+	}
+
+	e := res.Entries[0]
+	result := entry{
+		DN:       e.DN,
+		ID:       e.DN,
+		Username: e.GetAttributeValue(a.Username),
+		Email:    e.GetAttributeValue(a.Email),
+		Groups:   e.GetAttributeValues(a.Groups),
+	}
+	if len(a.ID) > 0 {
+		result.ID = attributeString(e.GetRawAttributeValue(a.ID))
+	}
+	if len(a.Phone) > 0 {
+		result.Phone = e.GetAttributeValue(a.Phone)
+	}
+	if len(result.ID) == 0 {
+		return entry{}, model.ErrUserNotFound
+	}
+	if len(result.Username) == 0 {
+		result.Username = name
+	}
+	return result, nil
+}
+
+// attributeString returns the attribute value as is if it is printable, and in hex otherwise, like Active Directory objectGUID.
+func attributeString(value []byte) string {
+	if !utf8.Valid(value) {
+		return hex.EncodeToString(value)
+	}
+	for _, r := range string(value) {
+		if !unicode.IsPrint(r) {
+			return hex.EncodeToString(value)
+		}
+	}
+	return string(value)
+}
+
+// commonName returns the common name of the group DN, like "admins" for "cn=admins,ou=groups,dc=example,dc=com".
+func commonName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return ""
+	}
+	for _, a := range parsed.RDNs[0].Attributes {
+		if strings.EqualFold(a.Type, "cn") {
+			return a.Value
+		}
+	}
+	return ""
+}
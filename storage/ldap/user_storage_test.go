@@ -0,0 +1,282 @@
+package ldap
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/storage/boltdb"
+)
+
+// testDirectory is a local stand-in of the LDAP server, which supports simple bind and search.
+// Entries match the search filter if it has any of their attributes, like "(uid=alice)".
+type testDirectory struct {
+	net.Listener
+	mu        sync.RWMutex
+	passwords map[string]string
+	entries   map[string]map[string][]string
+}
+
+func newTestDirectory(t *testing.T) *testDirectory {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen %v", err)
+	}
+	d := &testDirectory{
+		Listener: ln,
+		passwords: map[string]string{
+			"cn=service,dc=example,dc=com":          "service-secret",
+			"uid=alice,ou=people,dc=example,dc=com": "alice-secret",
+			"uid=bob,ou=people,dc=example,dc=com":   "bob-secret",
+			"uid=dave,ou=people,dc=example,dc=com":  "dave-secret",
+		},
+		entries: map[string]map[string][]string{
+			"uid=alice,ou=people,dc=example,dc=com": {
+				"uid":       {"alice"},
+				"entryUUID": {"a1"},
+				"mail":      {"alice@example.com"},
+				"memberOf":  {"cn=staff,ou=groups,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com"},
+			},
+			"uid=bob,ou=people,dc=example,dc=com": {
+				"uid":       {"bob"},
+				"entryUUID": {"b2"},
+				"memberOf":  {"cn=staff,ou=groups,dc=example,dc=com"},
+			},
+			"uid=dave,ou=people,dc=example,dc=com": {
+				"uid":       {"dave"},
+				"entryUUID": {"d4"},
+			},
+		},
+	}
+	go d.serve()
+	return d
+}
+
+// remove deletes the entry, like the administrator does when the user leaves.
+func (d *testDirectory) remove(dn string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.entries, dn)
+}
+
+func (d *testDirectory) serve() {
+	for {
+		conn, err := d.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *testDirectory) handle(conn net.Conn) {
+	defer conn.Close()
+	bound := false
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := op.Children[1].Value.(string), op.Children[2].Data.String()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if p, ok := d.passwords[dn]; ok && p == password {
+				code, bound = ldap.LDAPResultSuccess, true
+			}
+			conn.Write(response(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				conn.Write(response(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights).Bytes())
+				continue
+			}
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			d.mu.RLock()
+			for dn, attributes := range d.entries {
+				if matches(filter, attributes) {
+					conn.Write(searchEntry(id, dn, attributes).Bytes())
+				}
+			}
+			d.mu.RUnlock()
+			conn.Write(response(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func matches(filter string, attributes map[string][]string) bool {
+	for name, values := range attributes {
+		for _, v := range values {
+			if strings.Contains(filter, "("+name+"="+ldap.EscapeFilter(v)+")") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func message(id int64, op *ber.Packet) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	p.AppendChild(op)
+	return p
+}
+
+func response(id int64, tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(code), "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return message(id, op)
+}
+
+func searchEntry(id int64, dn string, attributes map[string][]string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "objectName"))
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, values := range attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+		}
+		attribute.AppendChild(set)
+		list.AppendChild(attribute)
+	}
+	op.AppendChild(list)
+	return message(id, op)
+}
+
+func TestUserStorage(t *testing.T) {
+	d := newTestDirectory(t)
+	defer d.Close()
+
+	dir, err := ioutil.TempDir("", "identifo-ldap")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := boltdb.InitDB(filepath.Join(dir, "db.db"))
+	if err != nil {
+		t.Fatalf("Unable to open database %v", err)
+	}
+	defer db.Close()
+	primary, err := boltdb.NewUserStorage(db)
+	if err != nil {
+		t.Fatalf("Unable to create primary storage %v", err)
+	}
+
+	settings := model.DatabaseSettings{
+		Type:     model.DBTypeLDAP,
+		Endpoint: "ldap://" + d.Addr().String(),
+		LDAP: &model.LDAPSettings{
+			BindDN:         "cn=service,dc=example,dc=com",
+			BindPassword:   "service-secret",
+			BaseDN:         "ou=people,dc=example,dc=com",
+			UserFilter:     "(&(objectClass=person)(uid=%s))",
+			Attributes:     model.LDAPAttributeMapping{ID: "entryUUID"},
+			GroupRoles:     []model.LDAPGroupRole{{Group: "admins", Role: "admin"}, {Group: "cn=staff,ou=groups,dc=example,dc=com", Role: "staff"}},
+			DefaultRole:    "user",
+			ProvisionUsers: true,
+		},
+	}
+	us, err := NewUserStorage(settings, primary)
+	if err != nil {
+		t.Fatalf("NewUserStorage() error = %v", err)
+	}
+
+	if _, err = us.UserByNamePassword("alice", "wrong"); err != model.ErrUserNotFound {
+		t.Errorf("UserByNamePassword() with wrong password error = %v", err)
+	}
+	if _, err = us.UserByNamePassword("alice", ""); err != model.ErrUserNotFound {
+		t.Errorf("UserByNamePassword() with empty password error = %v", err)
+	}
+
+	alice, err := us.UserByNamePassword("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("UserByNamePassword() error = %v", err)
+	}
+	if alice.Username != "alice" || alice.Email != "alice@example.com" || alice.AccessRole != "admin" {
+		t.Errorf("UserByNamePassword() = %+v", alice)
+	}
+	if again, err := us.UserByNamePassword("alice", "alice-secret"); err != nil || again.ID != alice.ID {
+		t.Errorf("UserByNamePassword() second login = %+v, %v, want the same user", again, err)
+	}
+	if err = us.ResetPassword(alice.ID, "new-secret"); err != ErrorPasswordManagedByDirectory {
+		t.Errorf("ResetPassword() error = %v", err)
+	}
+
+	// Existing users without password are linked by name, if the settings say so.
+	bob, err := primary.AddUserByEmail("bob", "")
+	if err != nil {
+		t.Fatalf("AddUserByEmail() error = %v", err)
+	}
+	if _, err = us.UserByNamePassword("bob", "bob-secret"); err != model.ErrorUserExists {
+		t.Errorf("UserByNamePassword() with linking disabled error = %v, want %v", err, model.ErrorUserExists)
+	}
+	linkSettings := *settings.LDAP
+	linkSettings.LinkUsersByName = true
+	settings.LDAP = &linkSettings
+	if us, err = NewUserStorage(settings, primary); err != nil {
+		t.Fatalf("NewUserStorage() error = %v", err)
+	}
+	if user, err := us.UserByNamePassword("bob", "bob-secret"); err != nil || user.ID != bob.ID || user.AccessRole != "staff" {
+		t.Errorf("UserByNamePassword() = %+v, %v, want linked user with staff role", user, err)
+	}
+
+	// Users with password are never linked.
+	if _, err = primary.AddUserByNameAndPassword("dave", "local-secret", "", false); err != nil {
+		t.Fatalf("AddUserByNameAndPassword() error = %v", err)
+	}
+	if _, err = us.UserByNamePassword("dave", "dave-secret"); err != model.ErrorUserExists {
+		t.Errorf("UserByNamePassword() of user with password error = %v, want %v", err, model.ErrorUserExists)
+	}
+	if _, err = us.UserByNamePassword("dave", "local-secret"); err != model.ErrUserNotFound {
+		t.Errorf("UserByNamePassword() with primary storage password error = %v", err)
+	}
+
+	// Users who are not in the directory are checked by the primary storage.
+	if _, err = us.AddUserByNameAndPassword("alice", "password", "", false); err != model.ErrorUserExists {
+		t.Errorf("AddUserByNameAndPassword() with directory name error = %v", err)
+	}
+	if _, err = us.AddUserByNameAndPassword("carol", "carol-secret", "", false); err != nil {
+		t.Fatalf("AddUserByNameAndPassword() error = %v", err)
+	}
+	if user, err := us.UserByNamePassword("carol", "carol-secret"); err != nil || user.Username != "carol" {
+		t.Errorf("UserByNamePassword() = %+v, %v, want primary storage user", user, err)
+	}
+
+	// Passwordless logins find the directory users while they are in the directory only.
+	if user, err := us.UserByEmail("alice@example.com"); err != nil || user.ID != alice.ID {
+		t.Errorf("UserByEmail() = %+v, %v, want directory user", user, err)
+	}
+	d.remove("uid=alice,ou=people,dc=example,dc=com")
+	if _, err = us.UserByEmail("alice@example.com"); err != ErrorNotInDirectory {
+		t.Errorf("UserByEmail() of removed entry error = %v, want %v", err, ErrorNotInDirectory)
+	}
+	if _, err = us.UserByNamePassword("alice", "alice-secret"); err != model.ErrUserNotFound {
+		t.Errorf("UserByNamePassword() of removed entry error = %v, want %v", err, model.ErrUserNotFound)
+	}
+
+	// Nobody registers while the directory is down, as the name may be taken there.
+	d.Close()
+	if _, err = us.AddUserByNameAndPassword("erin", "erin-secret", "", false); err == nil {
+		t.Errorf("AddUserByNameAndPassword() with directory down error = nil")
+	}
+	if !us.UserExists("erin") {
+		t.Errorf("UserExists() with directory down = false, want true")
+	}
+}
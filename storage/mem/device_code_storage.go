@@ -0,0 +1,121 @@
+package mem
+
+import (
+	"sync"
+	"time"
+
+	"github.com/madappgang/identifo/model"
+)
+
+// NewDeviceCodeStorage creates an in-memory device code storage.
+func NewDeviceCodeStorage() (model.DeviceCodeStorage, error) {
+	return &DeviceCodeStorage{storage: make(map[string]model.DeviceCode)}, nil
+}
+
+// DeviceCodeStorage is an in-memory device code storage.
+// Please do not use it in production, it has no disk swap or persistent cache support.
+type DeviceCodeStorage struct {
+	sync.RWMutex
+	storage map[string]model.DeviceCode
+}
+
+// CreateDeviceCode saves new device code.
+func (dcs *DeviceCodeStorage) CreateDeviceCode(dc model.DeviceCode) error {
+	dcs.Lock()
+	defer dcs.Unlock()
+
+	if _, ok := dcs.storage[dc.DeviceCode]; ok {
+		return model.ErrorWrongDataFormat
+	}
+	for _, c := range dcs.storage {
+		if c.UserCode == dc.UserCode {
+			return model.ErrorWrongDataFormat
+		}
+	}
+	dcs.storage[dc.DeviceCode] = dc
+	return nil
+}
+
+// DeviceCodeByDeviceCode returns device code by its device code.
+func (dcs *DeviceCodeStorage) DeviceCodeByDeviceCode(deviceCode string) (model.DeviceCode, error) {
+	dcs.RLock()
+	defer dcs.RUnlock()
+
+	dc, ok := dcs.storage[deviceCode]
+	if !ok {
+		return model.DeviceCode{}, model.ErrorNotFound
+	}
+	return dc, nil
+}
+
+// DeviceCodeByUserCode returns device code by its user code.
+func (dcs *DeviceCodeStorage) DeviceCodeByUserCode(userCode string) (model.DeviceCode, error) {
+	dcs.RLock()
+	defer dcs.RUnlock()
+
+	for _, dc := range dcs.storage {
+		if dc.UserCode == userCode {
+			return dc, nil
+		}
+	}
+	return model.DeviceCode{}, model.ErrorNotFound
+}
+
+// UpdateDeviceCode updates existing device code.
+func (dcs *DeviceCodeStorage) UpdateDeviceCode(dc model.DeviceCode) error {
+	dcs.Lock()
+	defer dcs.Unlock()
+
+	if _, ok := dcs.storage[dc.DeviceCode]; !ok {
+		return model.ErrorNotFound
+	}
+	dcs.storage[dc.DeviceCode] = dc
+	return nil
+}
+
+// UpdateDeviceCodePoll updates polling fields of the pending device code.
+func (dcs *DeviceCodeStorage) UpdateDeviceCodePoll(deviceCode string, interval int64, lastPolledAt time.Time) error {
+	dcs.Lock()
+	defer dcs.Unlock()
+
+	dc, ok := dcs.storage[deviceCode]
+	if !ok || dc.Status != model.DeviceCodeStatusPending {
+		return model.ErrorNotFound
+	}
+	dc.Interval = interval
+	dc.LastPolledAt = lastPolledAt
+	dcs.storage[deviceCode] = dc
+	return nil
+}
+
+// ConsumeDeviceCode deletes the approved device code.
+func (dcs *DeviceCodeStorage) ConsumeDeviceCode(deviceCode string) error {
+	dcs.Lock()
+	defer dcs.Unlock()
+
+	dc, ok := dcs.storage[deviceCode]
+	if !ok || dc.Status != model.DeviceCodeStatusApproved {
+		return model.ErrorNotFound
+	}
+	delete(dcs.storage, deviceCode)
+	return nil
+}
+
+// DeleteDeviceCode deletes device code.
+func (dcs *DeviceCodeStorage) DeleteDeviceCode(deviceCode string) error {
+	dcs.Lock()
+	defer dcs.Unlock()
+
+	delete(dcs.storage, deviceCode)
+	return nil
+}
+
+// Close clears storage.
+func (dcs *DeviceCodeStorage) Close() {
+	dcs.Lock()
+	defer dcs.Unlock()
+
+	for k := range dcs.storage {
+		delete(dcs.storage, k)
+	}
+}
@@ -1,6 +1,8 @@
 package mem
 
 import (
+	"sync"
+
 	"github.com/madappgang/identifo/model"
 )
 
@@ -12,23 +14,45 @@ func NewTokenBlacklist() (model.TokenBlacklist, error) {
 // TokenBlacklist is an in-memory token storage.
 // Please do not use it in production, it has no disk swap or persistent cache support.
 type TokenBlacklist struct {
+	sync.RWMutex
 	storage map[string]bool
 }
 
 // Add blacklists token.
 func (tb *TokenBlacklist) Add(token string) error {
+	tb.Lock()
+	defer tb.Unlock()
+
+	tb.storage[token] = true
+	return nil
+}
+
+// Consume blacklists the single-use token, if it is not blacklisted yet.
+func (tb *TokenBlacklist) Consume(token string) error {
+	tb.Lock()
+	defer tb.Unlock()
+
+	if tb.storage[token] {
+		return model.ErrorNotFound
+	}
 	tb.storage[token] = true
 	return nil
 }
 
 // IsBlacklisted returns true if the token is blacklisted.
 func (tb *TokenBlacklist) IsBlacklisted(token string) bool {
+	tb.RLock()
+	defer tb.RUnlock()
+
 	has := tb.storage[token]
 	return has
 }
 
 // Close clears storage.
 func (tb *TokenBlacklist) Close() {
+	tb.Lock()
+	defer tb.Unlock()
+
 	for k := range tb.storage {
 		delete(tb.storage, k)
 	}
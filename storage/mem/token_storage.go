@@ -1,28 +1,38 @@
 package mem
 
 import (
+	"sync"
+
 	"github.com/madappgang/identifo/model"
 )
 
 // NewTokenStorage creates an in-memory token storage.
 func NewTokenStorage() (model.TokenStorage, error) {
-	return &TokenStorage{storage: make(map[string]bool)}, nil
+	return &TokenStorage{storage: make(map[string]bool), families: make(map[string]model.TokenFamily)}, nil
 }
 
 // TokenStorage is an in-memory token storage.
 // Please do not use it in production, it has no disk swap or persistent cache support.
 type TokenStorage struct {
-	storage map[string]bool
+	sync.RWMutex
+	storage  map[string]bool
+	families map[string]model.TokenFamily
 }
 
 // SaveToken saves token in memory.
 func (ts *TokenStorage) SaveToken(token string) error {
+	ts.Lock()
+	defer ts.Unlock()
+
 	ts.storage[token] = true
 	return nil
 }
 
 // HasToken returns true if the token is present in the storage.
 func (ts *TokenStorage) HasToken(token string) bool {
+	ts.RLock()
+	defer ts.RUnlock()
+
 	has := ts.storage[token]
 	return has
 }
@@ -30,13 +40,60 @@ func (ts *TokenStorage) HasToken(token string) bool {
 // DeleteToken removes token from memory storage.
 // Actually, just marks it as deleted.
 func (ts *TokenStorage) DeleteToken(token string) error {
+	ts.Lock()
+	defer ts.Unlock()
+
 	ts.storage[token] = false
 	return nil
 }
 
+// SaveTokenFamily creates or updates token family.
+func (ts *TokenStorage) SaveTokenFamily(family model.TokenFamily) error {
+	if len(family.ID) == 0 {
+		return model.ErrorWrongDataFormat
+	}
+
+	ts.Lock()
+	defer ts.Unlock()
+
+	ts.families[family.ID] = family
+	return nil
+}
+
+// SwapTokenFamily replaces token family, if it is not revoked and its current token is still currentToken.
+func (ts *TokenStorage) SwapTokenFamily(family model.TokenFamily, currentToken string) error {
+	ts.Lock()
+	defer ts.Unlock()
+
+	old, ok := ts.families[family.ID]
+	if !ok || old.Revoked || old.CurrentToken != currentToken {
+		return model.ErrorNotFound
+	}
+	ts.families[family.ID] = family
+	return nil
+}
+
+// TokenFamily returns token family by its ID.
+func (ts *TokenStorage) TokenFamily(familyID string) (model.TokenFamily, error) {
+	ts.RLock()
+	defer ts.RUnlock()
+
+	family, ok := ts.families[familyID]
+	if !ok {
+		return model.TokenFamily{}, model.ErrorNotFound
+	}
+	return family, nil
+}
+
 // Close clears storage.
 func (ts *TokenStorage) Close() {
+	ts.Lock()
+	defer ts.Unlock()
+
 	for k := range ts.storage {
 		delete(ts.storage, k)
 	}
+	for k := range ts.families {
+		delete(ts.families, k)
+	}
 }
@@ -1,6 +1,8 @@
 package mem
 
 import (
+	"time"
+
 	"github.com/madappgang/identifo/model"
 	"github.com/pallinder/go-randomdata"
 )
@@ -75,6 +77,11 @@ func (us *UserStorage) AddUserByPhone(phone, role string) (model.User, error) {
 	return randUser(), nil
 }
 
+// AddUserByEmail returns randomly generated user.
+func (us *UserStorage) AddUserByEmail(email, role string) (model.User, error) {
+	return randUser(), nil
+}
+
 // UserByFederatedID returns randomly generated user.
 func (us *UserStorage) UserByFederatedID(provider model.FederatedIdentityProvider, id string) (model.User, error) {
 	return randUser(), nil
@@ -85,6 +92,41 @@ func (us *UserStorage) AddUserWithFederatedID(provider model.FederatedIdentityPr
 	return randUser(), nil
 }
 
+// AddFederatedID returns randomly generated user.
+func (us *UserStorage) AddFederatedID(userID string, provider model.FederatedIdentityProvider, id string) (model.User, error) {
+	return randUser(), nil
+}
+
+// RemoveFederatedID returns randomly generated user.
+func (us *UserStorage) RemoveFederatedID(userID string, provider model.FederatedIdentityProvider, id string) (model.User, error) {
+	return randUser(), nil
+}
+
+// AddWebAuthnCredential returns randomly generated user.
+func (us *UserStorage) AddWebAuthnCredential(userID string, credential model.WebAuthnCredential) (model.User, error) {
+	return randUser(), nil
+}
+
+// UpdateWebAuthnCredential does nothing here.
+func (us *UserStorage) UpdateWebAuthnCredential(userID string, credential model.WebAuthnCredential) error {
+	return nil
+}
+
+// RemoveWebAuthnCredential returns randomly generated user.
+func (us *UserStorage) RemoveWebAuthnCredential(userID, credentialID string) (model.User, error) {
+	return randUser(), nil
+}
+
+// AddTrustedDevice returns randomly generated user.
+func (us *UserStorage) AddTrustedDevice(userID string, device model.TrustedDevice) (model.User, error) {
+	return randUser(), nil
+}
+
+// RemoveTrustedDevice returns randomly generated user.
+func (us *UserStorage) RemoveTrustedDevice(userID, deviceID string) (model.User, error) {
+	return randUser(), nil
+}
+
 // UpdateUser returns what it receives.
 func (us *UserStorage) UpdateUser(userID string, newUser model.User) (model.User, error) {
 	return newUser, nil
@@ -108,6 +150,21 @@ func (us *UserStorage) DeleteUser(id string) error {
 // UpdateLoginMetadata does nothing here.
 func (us *UserStorage) UpdateLoginMetadata(userID string) {}
 
+// IncrementTFAFailedAttempts does nothing here.
+func (us *UserStorage) IncrementTFAFailedAttempts(userID string) (int, error) {
+	return 0, nil
+}
+
+// LockTFA does nothing here.
+func (us *UserStorage) LockTFA(userID string, until time.Time) error {
+	return nil
+}
+
+// SwapTFAInfo does nothing here.
+func (us *UserStorage) SwapTFAInfo(userID string, info, current model.TFAInfo) error {
+	return nil
+}
+
 // FetchUsers returns randomly generated user enclosed in slice.
 func (us *UserStorage) FetchUsers(filterString string, skip, limit int) ([]model.User, int, error) {
 	return []model.User{randUser()}, 1, nil
@@ -0,0 +1,143 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/madappgang/identifo/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
+)
+
+const deviceCodesCollectionName = "DeviceCodes"
+
+// NewDeviceCodeStorage creates and inits MongoDB device code storage.
+func NewDeviceCodeStorage(db *DB) (model.DeviceCodeStorage, error) {
+	coll := db.Database.Collection(deviceCodesCollectionName)
+	dcs := &DeviceCodeStorage{coll: coll, timeout: 30 * time.Second}
+
+	userCodeIndexOptions := &options.IndexOptions{}
+	userCodeIndexOptions.SetUnique(true)
+
+	userCodeIndex := &mongo.IndexModel{
+		Keys:    bsonx.Doc{{Key: "user_code", Value: bsonx.Int32(int32(1))}},
+		Options: userCodeIndexOptions,
+	}
+
+	// Expired device codes are removed by MongoDB itself.
+	expiresAtOptions := &options.IndexOptions{}
+	expiresAtOptions.SetExpireAfterSeconds(0)
+
+	expiresAtIndex := &mongo.IndexModel{
+		Keys:    bsonx.Doc{{Key: "expires_at", Value: bsonx.Int32(int32(1))}},
+		Options: expiresAtOptions,
+	}
+
+	err := db.EnsureCollectionIndices(deviceCodesCollectionName, []mongo.IndexModel{*userCodeIndex, *expiresAtIndex})
+	return dcs, err
+}
+
+// DeviceCodeStorage implements device code storage interface.
+type DeviceCodeStorage struct {
+	coll    *mongo.Collection
+	timeout time.Duration
+}
+
+// CreateDeviceCode saves new device code.
+func (dcs *DeviceCodeStorage) CreateDeviceCode(dc model.DeviceCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dcs.timeout)
+	defer cancel()
+
+	if _, err := dcs.coll.InsertOne(ctx, dc); err != nil {
+		if isErrDuplication(err) {
+			return model.ErrorWrongDataFormat
+		}
+		return err
+	}
+	return nil
+}
+
+// DeviceCodeByDeviceCode returns device code by its device code.
+func (dcs *DeviceCodeStorage) DeviceCodeByDeviceCode(deviceCode string) (model.DeviceCode, error) {
+	return dcs.findOne(bson.M{"_id": deviceCode})
+}
+
+// DeviceCodeByUserCode returns device code by its user code.
+func (dcs *DeviceCodeStorage) DeviceCodeByUserCode(userCode string) (model.DeviceCode, error) {
+	return dcs.findOne(bson.M{"user_code": userCode})
+}
+
+// UpdateDeviceCode updates existing device code.
+func (dcs *DeviceCodeStorage) UpdateDeviceCode(dc model.DeviceCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dcs.timeout)
+	defer cancel()
+
+	res, err := dcs.coll.ReplaceOne(ctx, bson.M{"_id": dc.DeviceCode}, dc)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return model.ErrorNotFound
+	}
+	return nil
+}
+
+// UpdateDeviceCodePoll updates polling fields of the pending device code.
+func (dcs *DeviceCodeStorage) UpdateDeviceCodePoll(deviceCode string, interval int64, lastPolledAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dcs.timeout)
+	defer cancel()
+
+	filter := bson.M{"_id": deviceCode, "status": model.DeviceCodeStatusPending}
+	update := bson.M{"$set": bson.M{"interval": interval, "last_polled_at": lastPolledAt}}
+	res, err := dcs.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return model.ErrorNotFound
+	}
+	return nil
+}
+
+// ConsumeDeviceCode deletes the approved device code.
+func (dcs *DeviceCodeStorage) ConsumeDeviceCode(deviceCode string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dcs.timeout)
+	defer cancel()
+
+	res, err := dcs.coll.DeleteOne(ctx, bson.M{"_id": deviceCode, "status": model.DeviceCodeStatusApproved})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return model.ErrorNotFound
+	}
+	return nil
+}
+
+// DeleteDeviceCode deletes device code.
+func (dcs *DeviceCodeStorage) DeleteDeviceCode(deviceCode string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dcs.timeout)
+	defer cancel()
+
+	_, err := dcs.coll.DeleteOne(ctx, bson.M{"_id": deviceCode})
+	return err
+}
+
+// Close is a no-op here.
+func (dcs *DeviceCodeStorage) Close() {}
+
+func (dcs *DeviceCodeStorage) findOne(filter bson.M) (model.DeviceCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dcs.timeout)
+	defer cancel()
+
+	var dc model.DeviceCode
+	if err := dcs.coll.FindOne(ctx, filter).Decode(&dc); err != nil {
+		if isErrNotFound(err) {
+			return model.DeviceCode{}, model.ErrorNotFound
+		}
+		return model.DeviceCode{}, err
+	}
+	return dc, nil
+}
@@ -2,12 +2,16 @@ package mongo
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 
 	"github.com/madappgang/identifo/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
 )
 
 const blacklistedTokensCollectionName = "BlacklistedTokens"
@@ -15,7 +19,19 @@ const blacklistedTokensCollectionName = "BlacklistedTokens"
 // NewTokenBlacklist creates new MongoDB-backed token blacklist.
 func NewTokenBlacklist(db *DB) (model.TokenBlacklist, error) {
 	coll := db.Database.Collection(blacklistedTokensCollectionName)
-	return &TokenBlacklist{coll: coll, timeout: 30 * time.Second}, nil
+
+	// Single-use tokens are blacklisted once, so only one of the concurrent callers consumes them.
+	singleUseIndexOptions := &options.IndexOptions{}
+	singleUseIndexOptions.SetUnique(true)
+	singleUseIndexOptions.SetSparse(true)
+
+	singleUseIndex := &mongo.IndexModel{
+		Keys:    bsonx.Doc{{Key: "single_use", Value: bsonx.Int32(int32(1))}},
+		Options: singleUseIndexOptions,
+	}
+
+	err := db.EnsureCollectionIndices(blacklistedTokensCollectionName, []mongo.IndexModel{*singleUseIndex})
+	return &TokenBlacklist{coll: coll, timeout: 30 * time.Second}, err
 }
 
 // TokenBlacklist is a MongoDB-backed token blacklist.
@@ -38,6 +54,32 @@ func (tb *TokenBlacklist) Add(token string) error {
 	return err
 }
 
+// Consume blacklists the single-use token, if it is not blacklisted yet.
+// Tokens blacklisted with Add are matched by the token, and the concurrent upserts of the new one by the unique hash,
+// as the token could be too long for the index key.
+func (tb *TokenBlacklist) Consume(token string) error {
+	if len(token) == 0 {
+		return model.ErrorWrongDataFormat
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tb.timeout)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(token))
+	update := bson.M{"$setOnInsert": bson.M{"token": token, "single_use": hex.EncodeToString(hash[:])}}
+	res, err := tb.coll.UpdateOne(ctx, bson.M{"token": token}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		if isErrDuplication(err) {
+			return model.ErrorNotFound
+		}
+		return err
+	}
+	if res.UpsertedCount == 0 {
+		return model.ErrorNotFound
+	}
+	return nil
+}
+
 // IsBlacklisted returns true if the token is present in the blacklist.
 func (tb *TokenBlacklist) IsBlacklisted(token string) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), tb.timeout)
@@ -8,20 +8,39 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx"
 )
 
-const tokensCollectionName = "RefreshTokens"
+const (
+	tokensCollectionName        = "RefreshTokens"
+	tokenFamiliesCollectionName = "RefreshTokenFamilies"
+)
 
 // NewTokenStorage creates a MongoDB token storage.
 func NewTokenStorage(db *DB) (model.TokenStorage, error) {
 	coll := db.Database.Collection(tokensCollectionName)
-	return &TokenStorage{coll: coll, timeout: 30 * time.Second}, nil
+	families := db.Database.Collection(tokenFamiliesCollectionName)
+	ts := &TokenStorage{coll: coll, families: families, timeout: 30 * time.Second}
+
+	// Expired token families are removed by MongoDB itself.
+	expiresAtOptions := &options.IndexOptions{}
+	expiresAtOptions.SetExpireAfterSeconds(0)
+
+	expiresAtIndex := &mongo.IndexModel{
+		Keys:    bsonx.Doc{{Key: "expires_at", Value: bsonx.Int32(int32(1))}},
+		Options: expiresAtOptions,
+	}
+
+	err := db.EnsureCollectionIndices(tokenFamiliesCollectionName, []mongo.IndexModel{*expiresAtIndex})
+	return ts, err
 }
 
 // TokenStorage is a MongoDB token storage.
 type TokenStorage struct {
-	coll    *mongo.Collection
-	timeout time.Duration
+	coll     *mongo.Collection
+	families *mongo.Collection
+	timeout  time.Duration
 }
 
 // SaveToken saves token in the database.
@@ -62,11 +81,56 @@ func (ts *TokenStorage) DeleteToken(token string) error {
 	return err
 }
 
+// SaveTokenFamily creates or updates token family.
+func (ts *TokenStorage) SaveTokenFamily(family model.TokenFamily) error {
+	if len(family.ID) == 0 {
+		return model.ErrorWrongDataFormat
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ts.timeout)
+	defer cancel()
+
+	_, err := ts.families.ReplaceOne(ctx, bson.M{"_id": family.ID}, family, options.Replace().SetUpsert(true))
+	return err
+}
+
+// SwapTokenFamily replaces token family, if it is not revoked and its current token is still currentToken.
+func (ts *TokenStorage) SwapTokenFamily(family model.TokenFamily, currentToken string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ts.timeout)
+	defer cancel()
+
+	filter := bson.M{"_id": family.ID, "current_token": currentToken, "revoked": false}
+	res, err := ts.families.ReplaceOne(ctx, filter, family)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return model.ErrorNotFound
+	}
+	return nil
+}
+
+// TokenFamily returns token family by its ID.
+func (ts *TokenStorage) TokenFamily(familyID string) (model.TokenFamily, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ts.timeout)
+	defer cancel()
+
+	var family model.TokenFamily
+	if err := ts.families.FindOne(ctx, bson.M{"_id": familyID}).Decode(&family); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return model.TokenFamily{}, model.ErrorNotFound
+		}
+		return model.TokenFamily{}, err
+	}
+	return family, nil
+}
+
 // Close is a no-op.
 func (ts *TokenStorage) Close() {}
 
 // Token is struct to store tokens in database.
 type Token struct {
-	ID    primitive.ObjectID `bson:"_id,omitempty"` // TODO: Make use of jti claim.
-	Token string             `bson:"token,omitempty"`
+	ID        primitive.ObjectID `bson:"_id,omitempty"` // TODO: Make use of jti claim.
+	Token     string             `bson:"token,omitempty"`
+	SingleUse string             `bson:"single_use,omitempty"` // SingleUse is the hash of the single-use token, it is unique.
 }
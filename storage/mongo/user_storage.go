@@ -51,10 +51,51 @@ func NewUserStorage(db *DB) (model.UserStorage, error) {
 		Options: phoneIndexOptions,
 	}
 
-	err := db.EnsureCollectionIndices(usersCollectionName, []mongo.IndexModel{*userNameIndex, *emailIndex, *phoneIndex})
+	// Federated ID is linked to one user only, so the concurrent links of the same identity could not both succeed.
+	federatedIDsIndexOptions := &options.IndexOptions{}
+	federatedIDsIndexOptions.SetUnique(true)
+	federatedIDsIndexOptions.SetSparse(true)
+
+	federatedIDsIndex := &mongo.IndexModel{
+		Keys:    bsonx.Doc{{Key: "federated_ids", Value: bsonx.Int32(int32(1))}},
+		Options: federatedIDsIndexOptions,
+	}
+
+	if err := us.renameFederatedIDsField(); err != nil {
+		return nil, err
+	}
+	if err := us.unsetEmptyFederatedIDs(bson.M{}); err != nil {
+		return nil, err
+	}
+
+	err := db.EnsureCollectionIndices(usersCollectionName, []mongo.IndexModel{*userNameIndex, *emailIndex, *phoneIndex, *federatedIDsIndex})
 	return us, err
 }
 
+// renameFederatedIDsField moves federated IDs to the field we query them by.
+// They used to be saved to "federated_i_ds", so the users could not be found by federated ID.
+func (us *UserStorage) renameFederatedIDsField() error {
+	ctx, cancel := context.WithTimeout(context.Background(), us.timeout)
+	defer cancel()
+
+	_, err := us.coll.UpdateMany(ctx,
+		bson.M{"federated_i_ds": bson.M{"$exists": true}, "federated_ids": bson.M{"$exists": false}},
+		bson.M{"$rename": bson.M{"federated_i_ds": "federated_ids"}},
+	)
+	return err
+}
+
+// unsetEmptyFederatedIDs removes empty federated IDs of the users matching the filter.
+// Sparse index skips the users without the field only, and the unique one does not allow several users with empty IDs.
+func (us *UserStorage) unsetEmptyFederatedIDs(filter bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), us.timeout)
+	defer cancel()
+
+	filter["federated_ids"] = bson.M{"$size": 0}
+	_, err := us.coll.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"federated_ids": ""}})
+	return err
+}
+
 // UserStorage implements user storage interface.
 type UserStorage struct {
 	coll    *mongo.Collection
@@ -90,6 +131,9 @@ func (us *UserStorage) UserByEmail(email string) (model.User, error) {
 
 	var u model.User
 	if err := us.coll.FindOne(ctx, bson.M{"email": email}).Decode(&u); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return model.User{}, model.ErrUserNotFound
+		}
 		return model.User{}, err
 	}
 	return u, nil
@@ -228,6 +272,17 @@ func (us *UserStorage) AddUserByPhone(phone, role string) (model.User, error) {
 	return u, nil
 }
 
+// AddUserByEmail registers new user with email address and no password.
+func (us *UserStorage) AddUserByEmail(email, role string) (model.User, error) {
+	u := model.User{
+		Username:   email,
+		Active:     true,
+		Email:      email,
+		AccessRole: role,
+	}
+	return us.AddNewUser(u, "")
+}
+
 // AddUserByNameAndPassword registers new user.
 func (us *UserStorage) AddUserByNameAndPassword(username, password, role string, isAnonymous bool) (model.User, error) {
 	u := model.User{
@@ -264,6 +319,190 @@ func (us *UserStorage) AddUserWithFederatedID(provider model.FederatedIdentityPr
 	return us.AddNewUser(u, "")
 }
 
+// AddFederatedID links federated ID to the user.
+func (us *UserStorage) AddFederatedID(userID string, provider model.FederatedIdentityProvider, federatedID string) (model.User, error) {
+	hexID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return model.User{}, err
+	}
+	if u, err := us.UserByFederatedID(provider, federatedID); err == nil && u.ID != userID {
+		return model.User{}, model.ErrorUserExists
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), us.timeout)
+	defer cancel()
+
+	sid := string(provider) + ":" + federatedID
+	update := bson.M{"$addToSet": bson.M{"federated_ids": sid}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var ud model.User
+	if err := us.coll.FindOneAndUpdate(ctx, bson.M{"_id": hexID}, update, opts).Decode(&ud); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return model.User{}, model.ErrUserNotFound
+		}
+		if isErrDuplication(err) {
+			return model.User{}, model.ErrorUserExists
+		}
+		return model.User{}, err
+	}
+	return ud, nil
+}
+
+// RemoveFederatedID unlinks federated ID from the user.
+func (us *UserStorage) RemoveFederatedID(userID string, provider model.FederatedIdentityProvider, federatedID string) (model.User, error) {
+	hexID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), us.timeout)
+	defer cancel()
+
+	sid := string(provider) + ":" + federatedID
+	update := bson.M{"$pull": bson.M{"federated_ids": sid}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var ud model.User
+	if err := us.coll.FindOneAndUpdate(ctx, bson.M{"_id": hexID, "federated_ids": sid}, update, opts).Decode(&ud); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return model.User{}, model.ErrorNotFound
+		}
+		return model.User{}, err
+	}
+	if len(ud.FederatedIDs) == 0 {
+		if err := us.unsetEmptyFederatedIDs(bson.M{"_id": hexID}); err != nil {
+			return model.User{}, err
+		}
+	}
+	return ud, nil
+}
+
+// AddWebAuthnCredential adds the public key credential to the user.
+func (us *UserStorage) AddWebAuthnCredential(userID string, credential model.WebAuthnCredential) (model.User, error) {
+	hexID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), us.timeout)
+	defer cancel()
+
+	// Filter out users who already have the credential, so it is added only once.
+	filter := bson.M{"_id": hexID, "webauthn_credentials.id": bson.M{"$ne": credential.ID}}
+	update := bson.M{"$push": bson.M{"webauthn_credentials": credential}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var ud model.User
+	if err := us.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&ud); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return us.UserByID(userID)
+		}
+		return model.User{}, err
+	}
+	return ud, nil
+}
+
+// UpdateWebAuthnCredential saves the credential state, like the signature counter, after the user signs in with it.
+func (us *UserStorage) UpdateWebAuthnCredential(userID string, credential model.WebAuthnCredential) error {
+	hexID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), us.timeout)
+	defer cancel()
+
+	filter := bson.M{"_id": hexID, "webauthn_credentials.id": credential.ID}
+	update := bson.M{"$set": bson.M{"webauthn_credentials.$": credential}}
+
+	res, err := us.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return model.ErrorNotFound
+	}
+	return nil
+}
+
+// RemoveWebAuthnCredential removes the public key credential from the user.
+func (us *UserStorage) RemoveWebAuthnCredential(userID, credentialID string) (model.User, error) {
+	hexID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), us.timeout)
+	defer cancel()
+
+	filter := bson.M{"_id": hexID, "webauthn_credentials.id": credentialID}
+	update := bson.M{"$pull": bson.M{"webauthn_credentials": bson.M{"id": credentialID}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var ud model.User
+	if err := us.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&ud); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return model.User{}, model.ErrorNotFound
+		}
+		return model.User{}, err
+	}
+	return ud, nil
+}
+
+// AddTrustedDevice adds the device to the user's trusted devices, and forgets the expired ones.
+func (us *UserStorage) AddTrustedDevice(userID string, device model.TrustedDevice) (model.User, error) {
+	hexID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), us.timeout)
+	defer cancel()
+
+	// The same field cannot be pulled and pushed in one update.
+	expired := bson.M{"$pull": bson.M{"trusted_devices": bson.M{"expires_at": bson.M{"$lte": time.Now().Unix()}}}}
+	if _, err := us.coll.UpdateOne(ctx, bson.M{"_id": hexID}, expired); err != nil {
+		return model.User{}, err
+	}
+
+	update := bson.M{"$push": bson.M{"trusted_devices": device}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var ud model.User
+	if err := us.coll.FindOneAndUpdate(ctx, bson.M{"_id": hexID}, update, opts).Decode(&ud); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return model.User{}, model.ErrorNotFound
+		}
+		return model.User{}, err
+	}
+	return ud, nil
+}
+
+// RemoveTrustedDevice revokes the user's trusted device.
+func (us *UserStorage) RemoveTrustedDevice(userID, deviceID string) (model.User, error) {
+	hexID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), us.timeout)
+	defer cancel()
+
+	filter := bson.M{"_id": hexID, "trusted_devices.id": deviceID}
+	update := bson.M{"$pull": bson.M{"trusted_devices": bson.M{"id": deviceID}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var ud model.User
+	if err := us.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&ud); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return model.User{}, model.ErrorNotFound
+		}
+		return model.User{}, err
+	}
+	return ud, nil
+}
+
 // UpdateUser updates user in MongoDB storage.
 func (us *UserStorage) UpdateUser(userID string, newUser model.User) (model.User, error) {
 	hexID, err := primitive.ObjectIDFromHex(userID)
@@ -426,5 +665,74 @@ func (us *UserStorage) UpdateLoginMetadata(userID string) {
 	}
 }
 
+// IncrementTFAFailedAttempts counts the failed TFA attempt, and returns the number of attempts in a row.
+func (us *UserStorage) IncrementTFAFailedAttempts(userID string) (int, error) {
+	hexID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), us.timeout)
+	defer cancel()
+
+	update := bson.M{"$inc": bson.M{"tfa_info.failed_attempts": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var ud model.User
+	if err := us.coll.FindOneAndUpdate(ctx, bson.M{"_id": hexID}, update, opts).Decode(&ud); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, model.ErrUserNotFound
+		}
+		return 0, err
+	}
+	return ud.TFAInfo.FailedAttempts, nil
+}
+
+// LockTFA locks the user's TFA challenge until the given time, and resets the failed attempts.
+func (us *UserStorage) LockTFA(userID string, until time.Time) error {
+	hexID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), us.timeout)
+	defer cancel()
+
+	update := bson.M{
+		"$set":   bson.M{"tfa_info.locked_until": until},
+		"$unset": bson.M{"tfa_info.failed_attempts": ""},
+	}
+	res, err := us.coll.UpdateOne(ctx, bson.M{"_id": hexID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return model.ErrUserNotFound
+	}
+	return nil
+}
+
+// SwapTFAInfo replaces the user's TFA info, if its factors and recovery codes are still those of current.
+func (us *UserStorage) SwapTFAInfo(userID string, info, current model.TFAInfo) error {
+	hexID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), us.timeout)
+	defer cancel()
+
+	// Missing arrays match nil ones, so the users who have not enrolled factors or codes yet match too.
+	filter := bson.M{"_id": hexID, "tfa_info.factors": current.Factors, "tfa_info.recovery_codes": current.RecoveryCodes}
+	res, err := us.coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"tfa_info": info}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return model.ErrorNotFound
+	}
+	return nil
+}
+
 // Close is a no-op.
 func (us *UserStorage) Close() {}
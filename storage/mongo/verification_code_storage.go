@@ -15,6 +15,8 @@ const (
 	verificationCodesCollectionName = "VerificationCodes"
 	// verificationCodesExpirationTime specifies time before deleting records.
 	verificationCodesExpirationTime = 5 * time.Minute
+	// identifierField is the field with the phone number or email the code is sent to.
+	identifierField = "phone"
 )
 
 // NewVerificationCodeStorage creates and inits MongoDB verification code storage.
@@ -22,16 +24,18 @@ func NewVerificationCodeStorage(db *DB) (model.VerificationCodeStorage, error) {
 	coll := db.Database.Collection(verificationCodesCollectionName)
 	vcs := &VerificationCodeStorage{coll: coll, timeout: 30 * time.Second}
 
+	// Codes are keyed by the "phone" field, which holds any identifier, so the existing indices keep working.
 	phoneIndexOptions := &options.IndexOptions{}
 	phoneIndexOptions.SetUnique(true)
 
 	phoneIndex := &mongo.IndexModel{
-		Keys:    bsonx.Doc{{Key: "phone", Value: bsonx.Int32(int32(1))}},
+		Keys:    bsonx.Doc{{Key: identifierField, Value: bsonx.Int32(int32(1))}},
 		Options: phoneIndexOptions,
 	}
 
+	// Different identifiers could get the same code at the same time.
 	codeIndexOptions := &options.IndexOptions{}
-	codeIndexOptions.SetUnique(true)
+	codeIndexOptions.SetUnique(false)
 
 	codeIndex := &mongo.IndexModel{
 		Keys:    bsonx.Doc{{Key: "code", Value: bsonx.Int32(int32(1))}},
@@ -39,7 +43,7 @@ func NewVerificationCodeStorage(db *DB) (model.VerificationCodeStorage, error) {
 	}
 
 	createdAtOptions := &options.IndexOptions{}
-	createdAtOptions.SetUnique(true)
+	createdAtOptions.SetUnique(false)
 	createdAtOptions.SetExpireAfterSeconds(int32(verificationCodesExpirationTime.Seconds()))
 
 	createdAtIndex := &mongo.IndexModel{
@@ -58,12 +62,13 @@ type VerificationCodeStorage struct {
 }
 
 // IsVerificationCodeFound checks whether verification code can be found.
-func (vcs *VerificationCodeStorage) IsVerificationCodeFound(phone, code string) (bool, error) {
+// The code is deleted when found, so it is used once.
+func (vcs *VerificationCodeStorage) IsVerificationCodeFound(identifier, code string) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	var c interface{}
-	if err := vcs.coll.FindOneAndDelete(ctx, bson.M{"phone": phone, "code": code}).Decode(&c); err != nil {
+	if err := vcs.coll.FindOneAndDelete(ctx, bson.M{identifierField: identifier, "code": code}).Decode(&c); err != nil {
 		if isErrNotFound(err) {
 			return false, nil
 		}
@@ -73,15 +78,15 @@ func (vcs *VerificationCodeStorage) IsVerificationCodeFound(phone, code string)
 }
 
 // CreateVerificationCode inserts new verification code to the database.
-func (vcs *VerificationCodeStorage) CreateVerificationCode(phone, code string) error {
+func (vcs *VerificationCodeStorage) CreateVerificationCode(identifier, code string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if _, err := vcs.coll.DeleteMany(ctx, bson.M{"phone": phone}); err != nil {
+	if _, err := vcs.coll.DeleteMany(ctx, bson.M{identifierField: identifier}); err != nil {
 		return err
 	}
 
-	_, err := vcs.coll.InsertOne(ctx, bson.M{"phone": phone, "code": code, "createdAt": time.Now()})
+	_, err := vcs.coll.InsertOne(ctx, bson.M{identifierField: identifier, "code": code, "createdAt": time.Now()})
 	return err
 }
 
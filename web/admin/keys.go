@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	ijwt "github.com/madappgang/identifo/jwt"
+	jwtService "github.com/madappgang/identifo/jwt/service"
+	"github.com/madappgang/identifo/model"
+)
+
+// defaultKeyActivationDelay lets clients, which cache JWKS, fetch the next key before it is used for signing.
+const defaultKeyActivationDelay = int64(60 * 60) // one hour
+
+type keyView struct {
+	ID          string                       `json:"kid"`
+	Version     int                          `json:"version"`
+	State       model.KeyState               `json:"state"`
+	Algorithm   ijwt.TokenSignatureAlgorithm `json:"algorithm"`
+	CreatedAt   time.Time                    `json:"created_at"`
+	ActivatesAt time.Time                    `json:"activates_at"`
+	RetiresAt   time.Time                    `json:"retires_at"`
+}
+
+// FetchKeys returns the signing key set, without private keys.
+func (ar *Router) FetchKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keySet, err := ar.loadKeySet()
+		if err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, keySetView(keySet))
+	}
+}
+
+// RotateKeys generates the next signing key and schedules the key rotation.
+// The next key is published in JWKS right away and becomes active after activate_in seconds.
+// Currently active keys retire retire_in seconds after that, which defaults to the refresh token lifespan,
+// so the tokens signed with them stay valid. All the server instances reload the key set.
+func (ar *Router) RotateKeys() http.HandlerFunc {
+	type rotationRequest struct {
+		ActivateIn *int64 `json:"activate_in,omitempty" validate:"omitempty,min=0"`
+		RetireIn   *int64 `json:"retire_in,omitempty" validate:"omitempty,min=0"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rr rotationRequest
+		if r.ContentLength != 0 {
+			if ar.mustParseJSON(w, r, &rr) != nil {
+				return
+			}
+		}
+
+		activateIn := defaultKeyActivationDelay
+		if rr.ActivateIn != nil {
+			activateIn = *rr.ActivateIn
+		}
+		retireIn := jwtService.RefreshTokenLifespan
+		if rr.RetireIn != nil {
+			retireIn = *rr.RetireIn
+		}
+
+		keySet, err := ar.loadKeySet()
+		if err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+			return
+		}
+
+		signingKey, ok := keySet.SigningKey(time.Now())
+		if !ok {
+			ar.Error(w, fmt.Errorf("Key set has no active key"), http.StatusInternalServerError, "")
+			return
+		}
+
+		privateKey, publicKey, err := ijwt.GenerateKeys(signingKey.Algorithm)
+		if err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+			return
+		}
+
+		activatesAt := time.Now().Add(time.Duration(activateIn) * time.Second)
+		retiresAt := activatesAt.Add(time.Duration(retireIn) * time.Second)
+		if err := keySet.Rotate(privateKey, publicKey, activatesAt, retiresAt); err != nil {
+			if err == model.ErrKeyRotationScheduled {
+				ar.Error(w, err, http.StatusConflict, "")
+				return
+			}
+			ar.Error(w, err, http.StatusBadRequest, "")
+			return
+		}
+
+		if err := ar.configurationStorage.InsertKeySet(keySet); err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+			return
+		}
+
+		// Server instances reload the key set when the configuration changes.
+		if err := ar.configurationStorage.InsertConfig(ar.ServerSettings.ConfigurationStorage.SettingsKey, ar.ServerSettings); err != nil {
+			ar.logger.Println("Cannot insert settings into configuration storage:", err)
+			ar.Error(w, err, http.StatusInternalServerError, "")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, keySetView(keySet))
+	}
+}
+
+func (ar *Router) loadKeySet() (*model.JWTKeySet, error) {
+	alg, ok := ijwt.StrToTokenSignAlg[ar.ServerSettings.General.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("Unknown token service algorithm %s", ar.ServerSettings.General.Algorithm)
+	}
+	return ar.configurationStorage.LoadKeySet(alg)
+}
+
+func keySetView(keySet *model.JWTKeySet) []keyView {
+	now := time.Now()
+	keys := make([]keyView, len(keySet.Keys))
+	for i, k := range keySet.Keys {
+		keys[i] = keyView{
+			Version:     k.Version,
+			State:       k.StateAt(now),
+			Algorithm:   k.Algorithm,
+			CreatedAt:   k.CreatedAt,
+			ActivatesAt: k.ActivatesAt,
+			RetiresAt:   k.RetiresAt,
+		}
+		// Retired keys are not loaded.
+		if k.Public != nil {
+			keys[i].ID = k.ID()
+		}
+	}
+	return keys
+}
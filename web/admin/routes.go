@@ -115,6 +115,15 @@ func (ar *Router) initRoutes() {
 	invites.Path("{id:[a-zA-Z0-9]+}").HandlerFunc(ar.GetInviteByID()).Methods(http.MethodGet)
 	invites.Path("{id:[a-zA-Z0-9]+}").HandlerFunc(ar.ArchiveInviteByID()).Methods(http.MethodDelete)
 
+	ar.router.Path(`/{keys:keys/?}`).Handler(negroni.New(
+		ar.Session(),
+		negroni.WrapFunc(ar.FetchKeys()),
+	)).Methods("GET")
+	ar.router.Path(`/{keys/rotate:keys/rotate/?}`).Handler(negroni.New(
+		ar.Session(),
+		negroni.WrapFunc(ar.RotateKeys()),
+	)).Methods("POST")
+
 	static := mux.NewRouter().PathPrefix("/static").Subrouter()
 	ar.router.PathPrefix("/static").Handler(negroni.New(
 		ar.Session(),
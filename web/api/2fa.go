@@ -2,16 +2,19 @@ package api
 
 import (
 	"encoding/base64"
-	"errors"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"time"
 
 	jwtService "github.com/madappgang/identifo/jwt/service"
 	"github.com/madappgang/identifo/model"
 	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/tfa"
 	qrcode "github.com/skip2/go-qrcode"
 	"github.com/xlzd/gotp"
 )
@@ -19,12 +22,24 @@ import (
 // EnableTFA enables two-factor authentication for the user.
 func (ar *Router) EnableTFA() http.HandlerFunc {
 	type tfaSecret struct {
-		AccessToken     string `json:"access_token,omitempty"`
-		ProvisioningURI string `json:"provisioning_uri,omitempty"`
-		ProvisioningQR  string `json:"provisioning_qr,omitempty"`
+		AccessToken     string   `json:"access_token,omitempty"`
+		ProvisioningURI string   `json:"provisioning_uri,omitempty"`
+		ProvisioningQR  string   `json:"provisioning_qr,omitempty"`
+		RecoveryCodes   []string `json:"recovery_codes,omitempty"`
+	}
+
+	type requestBody struct {
+		TFAType model.TFAType `json:"tfa_type,omitempty"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		// Body is optional, the first factor the app supports is enrolled by default.
+		d := requestBody{}
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil && err != io.EOF {
+			ar.Error(w, ErrorAPIRequestBodyInvalid, http.StatusBadRequest, err.Error(), "EnableTFA.Decode")
+			return
+		}
+
 		app := middleware.AppFromContext(r.Context())
 		if len(app.ID) == 0 {
 			ar.Error(w, ErrorAPIRequestAppIDInvalid, http.StatusBadRequest, "App is not in context.", "EnableTFA.AppFromContext")
@@ -55,23 +70,58 @@ func (ar *Router) EnableTFA() http.HandlerFunc {
 			return
 		}
 
-		if tfaInfo := user.TFAInfo; tfaInfo.IsEnabled && tfaInfo.Secret != "" {
+		tfaType := d.TFAType
+		if len(tfaType) == 0 {
+			tfaType = ar.tfaService.AppTypes(app)[0]
+		}
+		if !tfa.ContainsType(ar.tfaService.AppTypes(app), tfaType) {
+			ar.Error(w, ErrorAPIRequestTFATypeNotAllowed, http.StatusBadRequest, fmt.Sprintf("Factor '%s' is not supported by this app", tfaType), "EnableTFA.appTFATypes")
+			return
+		}
+
+		// Users could enroll the first factor with preauth token, but not add the factor to pass TFA instead of the one they have.
+		if user.TFAInfo.IsEnabled && len(ar.tfaService.Factors(app, user)) > 0 && isPreauthToken(tokenFromContext(r.Context())) {
+			ar.Error(w, ErrorAPIRequestTFAAlreadyEnabled, http.StatusForbidden, "Please pass two-factor authentication before enrolling another factor", "EnableTFA.isPreauthToken")
+			return
+		}
+		if _, ok := user.TFAInfo.Factor(tfaType, ar.tfaType); ok && user.TFAInfo.IsEnabled {
 			ar.Error(w, ErrorAPIRequestTFAAlreadyEnabled, http.StatusBadRequest, "TFA already enabled for this user", "EnableTFA.alreadyEnabled")
 			return
 		}
 
-		if ar.tfaType == model.TFATypeSMS && user.Phone == "" {
+		if tfaType == model.TFATypeSMS && user.Phone == "" {
 			ar.Error(w, ErrorAPIRequestPleaseSetPhoneForTFA, http.StatusBadRequest, "Please specify your phone number to be able to receive one-time passwords", "EnableTFA.setPhone")
 			return
 		}
-		if ar.tfaType == model.TFATypeEmail && user.Email == "" {
+		if tfaType == model.TFATypeEmail && user.Email == "" {
 			ar.Error(w, ErrorAPIRequestPleaseSetEmailForTFA, http.StatusBadRequest, "Please specify your email address to be able to receive one-time passwords", "EnableTFA.setEmail")
 			return
 		}
+		if tfaType == model.TFATypeWebAuthn && len(user.WebAuthnCredentials) == 0 {
+			ar.Error(w, ErrorAPIRequestPleaseRegisterWebAuthnForTFA, http.StatusBadRequest, "Please register security key to be able to use it for two-factor authentication", "EnableTFA.registerWebAuthn")
+			return
+		}
+
+		factor := model.TFAFactor{Type: tfaType}
+		if tfaType != model.TFATypeWebAuthn {
+			factor.Secret = gotp.RandomSecret(16)
+		}
+		if !user.TFAInfo.IsEnabled {
+			// Factors left from the time TFA was disabled are not valid anymore.
+			user.TFAInfo = model.TFAInfo{}
+		}
+		user.TFAInfo.IsEnabled = true
+		user.TFAInfo.SetFactor(factor, ar.tfaType)
 
-		user.TFAInfo = model.TFAInfo{
-			IsEnabled: true,
-			Secret:    gotp.RandomSecret(16),
+		// Recovery codes are shown once, only their hashes are stored. They are issued with the first factor.
+		var recoveryCodes []string
+		if len(user.TFAInfo.RecoveryCodes) == 0 {
+			var recoveryCodeHashes []string
+			if recoveryCodes, recoveryCodeHashes, err = model.NewRecoveryCodes(); err != nil {
+				ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "EnableTFA.NewRecoveryCodes")
+				return
+			}
+			user.TFAInfo.RecoveryCodes = recoveryCodeHashes
 		}
 
 		if _, err := ar.userStorage.UpdateUser(userID, user); err != nil {
@@ -85,15 +135,15 @@ func (ar *Router) EnableTFA() http.HandlerFunc {
 			return
 		}
 
-		accessToken, _, err := ar.loginUser(user, []string{}, app, false, true, tokenPayload)
+		accessToken, _, err := ar.loginUser(user, []string{}, app, false, true, tokenPayload, authContext(tokenFromContext(r.Context())))
 		if err != nil {
 			ar.Error(w, ErrorAPIAppAccessTokenNotCreated, http.StatusInternalServerError, err.Error(), "EnableTFA.accessToken")
 			return
 		}
 
-		switch ar.tfaType {
+		switch tfaType {
 		case model.TFATypeApp:
-			uri := gotp.NewDefaultTOTP(user.TFAInfo.Secret).ProvisioningUri(user.Username, app.Name)
+			uri := gotp.NewDefaultTOTP(factor.Secret).ProvisioningUri(user.Username, app.Name)
 
 			var png []byte
 			png, err := qrcode.Encode(uri, qrcode.Medium, 256)
@@ -103,26 +153,33 @@ func (ar *Router) EnableTFA() http.HandlerFunc {
 			}
 			encoded := base64.StdEncoding.EncodeToString(png)
 
-			ar.ServeJSON(w, http.StatusOK, &tfaSecret{ProvisioningURI: uri, ProvisioningQR: encoded, AccessToken: accessToken})
+			ar.ServeJSON(w, http.StatusOK, &tfaSecret{ProvisioningURI: uri, ProvisioningQR: encoded, AccessToken: accessToken, RecoveryCodes: recoveryCodes})
 			return
 		case model.TFATypeSMS, model.TFATypeEmail:
-			if err := ar.sendOTPCode(user); err != nil {
+			if err := ar.tfaService.SendCode(user, tfaType); err != nil {
 				ar.Error(w, ErrorAPIRequestUnableToSendOTP, http.StatusInternalServerError, err.Error(), "EnableTFA.sendOTP")
 				return
 			}
 
-			ar.ServeJSON(w, http.StatusOK, &tfaSecret{AccessToken: accessToken})
+			ar.ServeJSON(w, http.StatusOK, &tfaSecret{AccessToken: accessToken, RecoveryCodes: recoveryCodes})
+			return
+		case model.TFATypeWebAuthn:
+			// User passes TFA with the security key they have registered.
+			ar.ServeJSON(w, http.StatusOK, &tfaSecret{AccessToken: accessToken, RecoveryCodes: recoveryCodes})
 			return
 		}
-		ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, fmt.Sprintf("Unknown tfa type '%s'", ar.tfaType), "switch.tfaType")
+		ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, fmt.Sprintf("Unknown tfa type '%s'", tfaType), "switch.tfaType")
 	}
 }
 
 // FinalizeTFA finalizes two-factor authentication.
 func (ar *Router) FinalizeTFA() http.HandlerFunc {
 	type requestBody struct {
-		TFACode string   `json:"tfa_code"`
-		Scopes  []string `json:"scopes"`
+		TFACode     string        `json:"tfa_code"`
+		TFAType     model.TFAType `json:"tfa_type,omitempty"`
+		Scopes      []string      `json:"scopes"`
+		TrustDevice bool          `json:"trust_device,omitempty"`
+		DeviceName  string        `json:"device_name,omitempty"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -160,20 +217,24 @@ func (ar *Router) FinalizeTFA() http.HandlerFunc {
 			ar.Error(w, ErrorAPIRequestAppIDInvalid, http.StatusBadRequest, "App is not in context.", "FinalizeTFA.AppFromContext")
 			return
 		}
-
-		otpVerified, err := ar.verifyOTPCode(user, d.TFACode)
-		if err != nil {
-			ar.Error(w, ErrorAPIRequestScopesForbidden, http.StatusForbidden, err.Error(), "FinalizeTFA.OTP_Invalid")
+		if d.TrustDevice && app.TrustedDeviceLifespan <= 0 {
+			ar.Error(w, ErrorAPIAppTrustedDevicesDisabled, http.StatusBadRequest, "", "FinalizeTFA.TrustedDeviceLifespan")
 			return
 		}
 
-		dontNeedVerification := app.DebugTFACode != "" && d.TFACode == app.DebugTFACode
-
-		if !(otpVerified || dontNeedVerification) {
-			ar.Error(w, ErrorAPIRequestTFACodeInvalid, http.StatusUnauthorized, "", "FinalizeTFA.OTP_Invalid")
+		user, method, ok := ar.verifyTFACode(w, app, user, d.TFACode, d.TFAType, "FinalizeTFA")
+		if !ok {
 			return
 		}
 
+		var trustedDeviceToken string
+		if d.TrustDevice {
+			if trustedDeviceToken, err = ar.trustDevice(app, user, d.DeviceName); err != nil {
+				ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "FinalizeTFA.trustDevice")
+				return
+			}
+		}
+
 		// Issue new access, and, if requested, refresh token, and then invalidate the old one.
 		scopes, err := ar.userStorage.RequestScopes(user.ID, d.Scopes)
 		if err != nil {
@@ -188,12 +249,19 @@ func (ar *Router) FinalizeTFA() http.HandlerFunc {
 		}
 
 		offline := contains(scopes, jwtService.OfflineScope)
-		accessToken, refreshToken, err := ar.loginUser(user, d.Scopes, app, offline, false, tokenPayload)
+		authCtx := authContext(tokenFromContext(r.Context())).WithSecondFactor(method)
+		accessToken, refreshToken, err := ar.loginUser(user, d.Scopes, app, offline, false, tokenPayload, authCtx)
 		if err != nil {
 			ar.Error(w, ErrorAPIAppAccessTokenNotCreated, http.StatusInternalServerError, err.Error(), "FinalizeTFA.loginUser")
 			return
 		}
 
+		idToken, err := ar.newIDToken(user, scopes, app, accessToken, "", time.Now().Unix())
+		if err != nil {
+			ar.Error(w, ErrorAPIAppAccessTokenNotCreated, http.StatusInternalServerError, err.Error(), "FinalizeTFA.newIDToken")
+			return
+		}
+
 		// Blacklist old access token.
 		if err := ar.tokenBlacklist.Add(oldAccessTokenString); err != nil {
 			ar.logger.Printf("Cannot blacklist old access token: %s\n", err)
@@ -201,9 +269,11 @@ func (ar *Router) FinalizeTFA() http.HandlerFunc {
 
 		user = user.Sanitized()
 		result := &AuthResponse{
-			AccessToken:  accessToken,
-			RefreshToken: refreshToken,
-			User:         user,
+			AccessToken:        accessToken,
+			RefreshToken:       refreshToken,
+			IDToken:            idToken,
+			User:               user,
+			TrustedDeviceToken: trustedDeviceToken,
 		}
 
 		ar.userStorage.UpdateLoginMetadata(user.ID)
@@ -211,19 +281,152 @@ func (ar *Router) FinalizeTFA() http.HandlerFunc {
 	}
 }
 
-func (ar *Router) verifyOTPCode(user model.User, otp string) (bool, error) {
-	result := false
-	if ar.tfaType == model.TFATypeApp {
-		totp := gotp.NewDefaultTOTP(user.TFAInfo.Secret)
-		result = totp.Verify(otp, int(time.Now().Unix()))
-	} else {
-		if user.TFAInfo.HOTPExpiredAt.Before(time.Now()) {
-			return false, errors.New("OTP token expired, please get the new one and try again")
-		}
-		hotp := gotp.NewDefaultHOTP(user.TFAInfo.Secret)
-		result = hotp.Verify(otp, user.TFAInfo.HOTPCounter)
+// verifyTFACode checks the one-time password or the recovery code, counting the failed attempts and locking the user out after too many of them.
+// It returns the updated user and the authentication method they have passed. It writes the error and returns false otherwise.
+func (ar *Router) verifyTFACode(w http.ResponseWriter, app model.AppData, user model.User, code string, tfaType model.TFAType, where string) (model.User, string, bool) {
+	if lockedFor := time.Until(user.TFAInfo.LockedUntil); lockedFor > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(lockedFor.Seconds())+1))
+		ar.Error(w, ErrorAPIRequestTFALocked, http.StatusTooManyRequests, "", where+".LockedUntil")
+		return model.User{}, "", false
+	}
+
+	// Recovery code replaces the one-time password when the user has lost their device.
+	current := user.TFAInfo
+	method := model.AMROTP
+	if !user.TFAInfo.UseRecoveryCode(code) {
+		// The factor could be omitted if the user has only one.
+		if factors := ar.tfaService.Factors(app, user); len(tfaType) == 0 && len(factors) == 1 {
+			tfaType = factors[0].Type
+		}
+		if len(tfaType) == 0 && !(app.DebugTFACode != "" && code == app.DebugTFACode) {
+			ar.Error(w, ErrorAPIRequestTFATypeRequired, http.StatusBadRequest, "", where+".tfaType")
+			return model.User{}, "", false
+		}
+
+		factor, otpVerified, err := ar.tfaService.VerifyCode(app, user, code, tfaType)
+		if err != nil {
+			ar.Error(w, ErrorAPIRequestScopesForbidden, http.StatusForbidden, err.Error(), where+".OTP_Invalid")
+			return model.User{}, "", false
+		}
+
+		dontNeedVerification := app.DebugTFACode != "" && code == app.DebugTFACode
+
+		if !(otpVerified || dontNeedVerification) {
+			if err := ar.tfaService.Fail(user); err != nil {
+				ar.logger.Printf("Cannot count failed TFA attempt of user %s: %s\n", user.ID, err)
+			}
+			ar.Error(w, ErrorAPIRequestTFACodeInvalid, http.StatusUnauthorized, "", where+".OTP_Invalid")
+			return model.User{}, "", false
+		}
+		if otpVerified {
+			// Keep the factor's last accepted code, so it could not be replayed.
+			user.TFAInfo.SetFactor(factor, ar.tfaType)
+		}
+		method = model.TFAMethod(tfaType)
+	}
+
+	user.TFAInfo.FailedAttempts = 0
+	user.TFAInfo.LockedUntil = time.Time{}
+	// The code is accepted only if no concurrent request has accepted it, or another one, in between.
+	if err := ar.userStorage.SwapTFAInfo(user.ID, user.TFAInfo, current); err == model.ErrorNotFound {
+		ar.Error(w, ErrorAPIRequestTFACodeInvalid, http.StatusUnauthorized, "", where+".SwapTFAInfo")
+		return model.User{}, "", false
+	} else if err != nil {
+		ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), where+".SwapTFAInfo")
+		return model.User{}, "", false
+	}
+	return user, method, true
+}
+
+// TFARecoveryCodes returns the number of the user's unused recovery codes.
+func (ar *Router) TFARecoveryCodes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := ar.tfaRecoveryCodesUser(w, r, "TFARecoveryCodes")
+		if !ok {
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, &recoveryCodesResponse{Remaining: len(user.TFAInfo.RecoveryCodes)})
+	}
+}
+
+// RegenerateTFARecoveryCodes replaces the user's recovery codes with the new ones.
+func (ar *Router) RegenerateTFARecoveryCodes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := ar.tfaRecoveryCodesUser(w, r, "RegenerateTFARecoveryCodes")
+		if !ok {
+			return
+		}
+
+		codes, hashes, err := model.NewRecoveryCodes()
+		if err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "RegenerateTFARecoveryCodes.NewRecoveryCodes")
+			return
+		}
+
+		user.TFAInfo.RecoveryCodes = hashes
+		if _, err = ar.userStorage.UpdateUser(user.ID, user); err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "RegenerateTFARecoveryCodes.UpdateUser")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, &recoveryCodesResponse{RecoveryCodes: codes, Remaining: len(codes)})
+	}
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	Remaining     int      `json:"remaining"`
+}
+
+// tfaRecoveryCodesUser returns the user who has passed TFA. It writes the error and returns false otherwise.
+func (ar *Router) tfaRecoveryCodesUser(w http.ResponseWriter, r *http.Request, where string) (model.User, bool) {
+	token := tokenFromContext(r.Context())
+	if isPreauthToken(token) {
+		ar.Error(w, ErrorAPIRequestScopesForbidden, http.StatusForbidden, "Please pass two-factor authentication to manage recovery codes", where+".isPreauthToken")
+		return model.User{}, false
+	}
+
+	user, err := ar.userStorage.UserByID(token.UserID())
+	if err != nil {
+		ar.Error(w, ErrorAPIUserNotFound, http.StatusUnauthorized, err.Error(), where+".UserByID")
+		return model.User{}, false
+	}
+	if !user.TFAInfo.IsEnabled {
+		ar.Error(w, ErrorAPIRequestTFANotEnabled, http.StatusBadRequest, "", where+".IsEnabled")
+		return model.User{}, false
+	}
+	return user, true
+}
+
+// RequestTFACode sends the one-time password for the factor the user has chosen to pass TFA with.
+func (ar *Router) RequestTFACode() http.HandlerFunc {
+	type requestBody struct {
+		TFAType model.TFAType `json:"tfa_type" validate:"required"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := requestBody{}
+		if ar.MustParseJSON(w, r, &d) != nil {
+			return
+		}
+
+		user, err := ar.userStorage.UserByID(tokenFromContext(r.Context()).UserID())
+		if err != nil {
+			ar.Error(w, ErrorAPIUserNotFound, http.StatusUnauthorized, err.Error(), "RequestTFACode.UserByID")
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		if !tfa.ContainsType(tfa.Types(ar.tfaService.Factors(app, user)), d.TFAType) {
+			ar.Error(w, ErrorAPIRequestTFATypeNotAllowed, http.StatusBadRequest, fmt.Sprintf("User could not pass TFA with '%s' in this app", d.TFAType), "RequestTFACode.tfaFactors")
+			return
+		}
+
+		if err := ar.tfaService.SendCode(user, d.TFAType); err != nil {
+			ar.Error(w, ErrorAPIRequestUnableToSendOTP, http.StatusInternalServerError, err.Error(), "RequestTFACode.sendOTP")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, map[string]string{"result": "ok"})
 	}
-	return result, nil
 }
 
 // RequestDisabledTFA requests link for disabling TFA.
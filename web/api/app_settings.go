@@ -3,18 +3,21 @@ package api
 import (
 	"net/http"
 
+	"github.com/madappgang/identifo/model"
+
 	"github.com/madappgang/identifo/web/middleware"
 )
 
 type appSettings struct {
-	AnonymousResitrationAllowed bool   `json:"anonymousResitrationAllowed"`
-	Active                      bool   `json:"active"`
-	Description                 string `json:"description"`
-	ID                          string `json:"id"`
-	NewUserDefaultRole          string `json:"newUserDefaultRole"`
-	Offline                     bool   `json:"offline"`
-	RegistrationForbidden       bool   `json:"registrationForbidden"`
-	TfaType                     string `json:"tfaType"`
+	AnonymousResitrationAllowed bool            `json:"anonymousResitrationAllowed"`
+	Active                      bool            `json:"active"`
+	Description                 string          `json:"description"`
+	ID                          string          `json:"id"`
+	NewUserDefaultRole          string          `json:"newUserDefaultRole"`
+	Offline                     bool            `json:"offline"`
+	RegistrationForbidden       bool            `json:"registrationForbidden"`
+	TfaType                     string          `json:"tfaType"`
+	TfaTypes                    []model.TFAType `json:"tfaTypes"`
 }
 
 // GetAppSettings return app settings
@@ -36,6 +39,7 @@ func (ar *Router) GetAppSettings() http.HandlerFunc {
 			Offline:                     app.Offline,
 			RegistrationForbidden:       app.RegistrationForbidden,
 			TfaType:                     string(ar.tfaType),
+			TfaTypes:                    ar.tfaService.AppTypes(app),
 		}
 
 		ar.ServeJSON(w, http.StatusOK, result)
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/web/authorization"
+	"github.com/madappgang/identifo/web/magiclink"
+	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/ratelimit"
+)
+
+type emailLinkRequestData struct {
+	Email       string `json:"email,omitempty"`
+	RedirectURI string `json:"redirect_uri,omitempty"`
+}
+
+type emailLinkLoginData struct {
+	Token              string   `json:"token,omitempty"`
+	TrustedDeviceToken string   `json:"trusted_device_token,omitempty"`
+	Scopes             []string `json:"scopes,omitempty"`
+}
+
+// RequestEmailLink emails the link, which signs the user in without password.
+// The link leads to the redirect URI of the app with the token in the query, and the app logs the user in with EmailLinkLogin.
+func (ar *Router) RequestEmailLink() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ar.SupportedLoginWays.Email {
+			ar.Error(w, ErrorAPIAppEmailLoginNotSupported, http.StatusBadRequest, "Application does not support login with email", "RequestEmailLink.supportedLoginWays")
+			return
+		}
+
+		d := emailLinkRequestData{}
+		if ar.MustParseJSON(w, r, &d) != nil {
+			return
+		}
+		if !model.EmailRegexp.MatchString(d.Email) {
+			ar.Error(w, ErrorAPIRequestBodyParamsInvalid, http.StatusBadRequest, "Invalid email", "RequestEmailLink.emailRegexp_MatchString")
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		if ar.rateLimited(w, r, ratelimit.PolicyEmailCode, ratelimit.Request{User: d.Email, App: app.ID}, "RequestEmailLink") {
+			return
+		}
+		if !contains(app.RedirectURLs, d.RedirectURI) {
+			ar.Error(w, ErrorAPIMagicLinkRedirectURIInvalid, http.StatusBadRequest, "", "RequestEmailLink.RedirectURLs")
+			return
+		}
+		link, err := url.Parse(d.RedirectURI)
+		if err != nil {
+			ar.Error(w, ErrorAPIMagicLinkRedirectURIInvalid, http.StatusBadRequest, err.Error(), "RequestEmailLink.URL_parse")
+			return
+		}
+
+		if err = ar.magicLink.Send(app, d.Email, *link); err != nil {
+			ar.Error(w, ErrorAPIEmailNotSent, http.StatusInternalServerError, "Email sending error: "+err.Error(), "RequestEmailLink.Send")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+	}
+}
+
+// EmailLinkLogin logs in the user with the token from the link, and registers them if they are new.
+// User passes two-factor authentication after that, if the app requires it.
+func (ar *Router) EmailLinkLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ar.SupportedLoginWays.Email {
+			ar.Error(w, ErrorAPIAppEmailLoginNotSupported, http.StatusBadRequest, "Application does not support login with email", "EmailLinkLogin.supportedLoginWays")
+			return
+		}
+
+		d := emailLinkLoginData{}
+		if ar.MustParseJSON(w, r, &d) != nil {
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		user, err := ar.magicLink.Login(app, d.Token)
+		switch err {
+		case nil:
+		case magiclink.ErrorInvalidLink:
+			ar.Error(w, ErrorAPIMagicLinkInvalid, http.StatusUnauthorized, err.Error(), "EmailLinkLogin.Login")
+			return
+		case magiclink.ErrorRegistrationForbidden:
+			ar.Error(w, ErrorAPIAppRegistrationForbidden, http.StatusForbidden, err.Error(), "EmailLinkLogin.Login")
+			return
+		default:
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "EmailLinkLogin.Login")
+			return
+		}
+
+		// Authorize user if the app requires authorization.
+		azi := authorization.AuthzInfo{
+			App:         app,
+			UserRole:    user.AccessRole,
+			ResourceURI: r.RequestURI,
+			Method:      r.Method,
+		}
+		if err := ar.Authorizer.Authorize(azi); err != nil {
+			ar.Error(w, ErrorAPIAppAccessDenied, http.StatusForbidden, err.Error(), "EmailLinkLogin.Authorizer")
+			return
+		}
+
+		authResult, err := ar.loginFlow(app, user, d.Scopes, d.TrustedDeviceToken, model.NewAuthContext(model.AMROTP))
+		if err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "EmailLinkLogin.LoginFlowError")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, authResult)
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/web/authorization"
+	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/ratelimit"
+)
+
+const emailVerificationCodeLength = 6
+
+// EmailLogin is used to parse input data from the client during email login.
+type EmailLogin struct {
+	Email              string   `json:"email"`
+	Code               string   `json:"code"`
+	TrustedDeviceToken string   `json:"trusted_device_token,omitempty"`
+	Scopes             []string `json:"scopes"`
+}
+
+func (l *EmailLogin) validateCodeAndEmail() error {
+	if len(l.Code) == 0 {
+		return errors.New("Verification code is too short or missing. ")
+	}
+	return l.validateEmail()
+}
+
+func (l *EmailLogin) validateEmail() error {
+	if !model.EmailRegexp.MatchString(l.Email) {
+		return errors.New("Email is not valid. ")
+	}
+	return nil
+}
+
+// RequestEmailCode emails the verification code, which signs the user in without password.
+func (ar *Router) RequestEmailCode() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ar.SupportedLoginWays.Email {
+			ar.Error(w, ErrorAPIAppEmailLoginNotSupported, http.StatusBadRequest, "Application does not support login with email", "RequestEmailCode.supportedLoginWays")
+			return
+		}
+
+		var authData EmailLogin
+		if err := json.NewDecoder(r.Body).Decode(&authData); err != nil {
+			ar.Error(w, ErrorAPIRequestBodyInvalid, http.StatusBadRequest, err.Error(), "RequestEmailCode.Unmarshal")
+			return
+		}
+
+		if err := authData.validateEmail(); err != nil {
+			ar.Error(w, ErrorAPIRequestBodyParamsInvalid, http.StatusBadRequest, err.Error(), "RequestEmailCode.validateEmail")
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		if ar.rateLimited(w, r, ratelimit.PolicyEmailCode, ratelimit.Request{User: authData.Email, App: app.ID}, "RequestEmailCode") {
+			return
+		}
+
+		code := randStringBytes(emailVerificationCodeLength)
+		if err := ar.verificationCodeStorage.CreateVerificationCode(authData.Email, code); err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "RequestEmailCode.CreateVerificationCode")
+			return
+		}
+
+		if err := ar.emailService.SendTFAEmail("Verification code", authData.Email, code); err != nil {
+			ar.Error(w, ErrorAPIEmailNotSent, http.StatusInternalServerError, fmt.Sprintf("Unable to send email. %s", err), "RequestEmailCode.SendTFAEmail")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, map[string]string{"message": "Email code is sent"})
+	}
+}
+
+// EmailLogin authenticates user with email and verification code.
+// If user does not exist - register them, unless the app forbids registration.
+// User passes two-factor authentication after that, if the app requires it.
+func (ar *Router) EmailLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ar.SupportedLoginWays.Email {
+			ar.Error(w, ErrorAPIAppEmailLoginNotSupported, http.StatusBadRequest, "Application does not support login with email", "EmailLogin.supportedLoginWays")
+			return
+		}
+
+		var authData EmailLogin
+		if err := json.NewDecoder(r.Body).Decode(&authData); err != nil {
+			ar.Error(w, ErrorAPIRequestBodyInvalid, http.StatusBadRequest, err.Error(), "EmailLogin.Unmarshal")
+			return
+		}
+		if err := authData.validateCodeAndEmail(); err != nil {
+			ar.Error(w, ErrorAPIRequestBodyParamsInvalid, http.StatusBadRequest, err.Error(), "EmailLogin.validateCodeAndEmail")
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		if len(app.ID) == 0 {
+			ar.logger.Println("Error getting App")
+			ar.Error(w, ErrorAPIRequestAppIDInvalid, http.StatusBadRequest, "App is not in context.", "EmailLogin.AppFromContext")
+			return
+		}
+
+		if ar.rateLimited(w, r, ratelimit.PolicyEmailLogin, ratelimit.Request{User: authData.Email, App: app.ID}, "EmailLogin") {
+			return
+		}
+
+		needVerification := app.DebugTFACode == "" || authData.Code != app.DebugTFACode
+		if needVerification { // check verification code
+			if exists, err := ar.verificationCodeStorage.IsVerificationCodeFound(authData.Email, authData.Code); err != nil {
+				ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "EmailLogin.IsVerificationCodeFound.error")
+				return
+			} else if !exists {
+				ar.Error(w, ErrorAPIVerificationCodeInvalid, http.StatusUnauthorized, "Invalid email or verification code", "EmailLogin.IsVerificationCodeFound.not_exists")
+				return
+			}
+		}
+
+		user, err := ar.userStorage.UserByEmail(authData.Email)
+		if err == model.ErrUserNotFound {
+			if app.RegistrationForbidden {
+				ar.Error(w, ErrorAPIAppRegistrationForbidden, http.StatusForbidden, "Registration is forbidden in app.", "EmailLogin.RegistrationForbidden")
+				return
+			}
+			user, err = ar.userStorage.AddUserByEmail(authData.Email, app.NewUserDefaultRole)
+		}
+		if err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "EmailLogin.UserByEmail")
+			return
+		}
+
+		// User has just confirmed the email with the verification code.
+		if !user.EmailVerified {
+			user.EmailVerified = true
+			if user, err = ar.userStorage.UpdateUser(user.ID, user); err != nil {
+				ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "EmailLogin.UpdateUser")
+				return
+			}
+		}
+
+		// Authorize user if the app requires authorization.
+		azi := authorization.AuthzInfo{
+			App:         app,
+			UserRole:    user.AccessRole,
+			ResourceURI: r.RequestURI,
+			Method:      r.Method,
+		}
+		if err := ar.Authorizer.Authorize(azi); err != nil {
+			ar.Error(w, ErrorAPIAppAccessDenied, http.StatusForbidden, err.Error(), "EmailLogin.Authorizer")
+			return
+		}
+
+		authResult, err := ar.loginFlow(app, user, authData.Scopes, authData.TrustedDeviceToken, model.NewAuthContext(model.AMROTP))
+		if err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "EmailLogin.LoginFlowError")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, authResult)
+	}
+}
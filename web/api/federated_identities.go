@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/web/middleware"
+)
+
+// federatedIdentitiesResponse is the list of the user's linked federated identities.
+type federatedIdentitiesResponse struct {
+	Identities []model.FederatedIdentity `json:"identities"`
+}
+
+// FederatedIdentities returns the user's linked federated identities.
+func (ar *Router) FederatedIdentities() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := tokenFromContext(r.Context()).UserID()
+		user, err := ar.userStorage.UserByID(userID)
+		if err != nil {
+			ar.Error(w, ErrorAPIUserNotFound, http.StatusUnauthorized, err.Error(), "FederatedIdentities.UserByID")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, federatedIdentitiesResponse{Identities: user.FederatedIdentities()})
+	}
+}
+
+// LinkFederatedIdentity links the federated identity to the user.
+// The user presents the provider credential, like they sign in with the provider.
+// The identity should not be linked to another user.
+func (ar *Router) LinkFederatedIdentity() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ar.SupportedLoginWays.Federated {
+			ar.Error(w, ErrorAPIAppFederatedLoginNotSupported, http.StatusBadRequest, "Application does not support federated login", "LinkFederatedIdentity.supportedLoginWays")
+			return
+		}
+
+		// Otherwise the one who knows the password could link their own identity, and sign in with it skipping TFA.
+		token := tokenFromContext(r.Context())
+		if isPreauthToken(token) {
+			ar.Error(w, ErrorAPIRequestScopesForbidden, http.StatusForbidden, "Please pass two-factor authentication to link federated identities", "LinkFederatedIdentity.isPreauthToken")
+			return
+		}
+
+		d := FederatedLoginData{}
+		if ar.MustParseJSON(w, r, &d) != nil {
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		provider, profile, ok := ar.federatedProfile(w, app, d, "LinkFederatedIdentity")
+		if !ok {
+			return
+		}
+
+		userID := token.UserID()
+		user, err := ar.userStorage.AddFederatedID(userID, provider.ID(), profile.ID)
+		if err == model.ErrorUserExists {
+			ar.Error(w, ErrorAPIFederatedIdentityLinked, http.StatusBadRequest, err.Error(), "LinkFederatedIdentity.AddFederatedID")
+			return
+		}
+		if err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "LinkFederatedIdentity.AddFederatedID")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, federatedIdentitiesResponse{Identities: user.FederatedIdentities()})
+	}
+}
+
+// UnlinkFederatedIdentity unlinks the federated identity from the user.
+// The last login method cannot be unlinked, otherwise the user would not be able to sign in anymore.
+func (ar *Router) UnlinkFederatedIdentity() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := tokenFromContext(r.Context())
+		if isPreauthToken(token) {
+			ar.Error(w, ErrorAPIRequestScopesForbidden, http.StatusForbidden, "Please pass two-factor authentication to unlink federated identities", "UnlinkFederatedIdentity.isPreauthToken")
+			return
+		}
+
+		fid := model.FederatedIdentityProvider(mux.Vars(r)["provider"])
+		federatedID := mux.Vars(r)["id"]
+
+		userID := token.UserID()
+		user, err := ar.userStorage.UserByID(userID)
+		if err != nil {
+			ar.Error(w, ErrorAPIUserNotFound, http.StatusUnauthorized, err.Error(), "UnlinkFederatedIdentity.UserByID")
+			return
+		}
+
+		linked := false
+		for _, identity := range user.FederatedIdentities() {
+			if identity.Provider == fid && identity.ID == federatedID {
+				linked = true
+				break
+			}
+		}
+		if !linked {
+			ar.Error(w, ErrorAPIFederatedIdentityNotFound, http.StatusNotFound, "", "UnlinkFederatedIdentity.FederatedIdentities")
+			return
+		}
+		if loginMethods(user) < 2 {
+			ar.Error(w, ErrorAPIFederatedIdentityLastLogin, http.StatusBadRequest, "", "UnlinkFederatedIdentity.loginMethods")
+			return
+		}
+
+		if user, err = ar.userStorage.RemoveFederatedID(userID, fid, federatedID); err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "UnlinkFederatedIdentity.RemoveFederatedID")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, federatedIdentitiesResponse{Identities: user.FederatedIdentities()})
+	}
+}
+
+// loginMethods returns the number of ways the user could sign in with: password, phone number, federated identities and security keys.
+func loginMethods(user model.User) int {
+	n := len(user.FederatedIdentities()) + len(user.WebAuthnCredentials)
+	if len(user.Pswd) > 0 {
+		n++
+	}
+	if len(user.Phone) > 0 {
+		n++
+	}
+	return n
+}
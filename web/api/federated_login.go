@@ -3,9 +3,7 @@ package api
 import (
 	"fmt"
 	"net/http"
-	"strings"
 
-	jwtService "github.com/madappgang/identifo/jwt/service"
 	"github.com/madappgang/identifo/model"
 	"github.com/madappgang/identifo/web/authorization"
 	"github.com/madappgang/identifo/web/middleware"
@@ -17,7 +15,24 @@ type FederatedLoginData struct {
 	AccessToken         string   `json:"access_token,omitempty"`
 	RegisterIfNew       bool     `json:"register_if_new,omitempty"`
 	Scopes              []string `json:"scopes,omitempty"`
-	AuthorizationCode   string   `json:"authorization_code,omitempty"` // Specific for Sign In with Apple.
+	AuthorizationCode   string   `json:"authorization_code,omitempty"` // Sign In with Apple, OpenID Connect and other providers with authorization code flow.
+	IDToken             string   `json:"id_token,omitempty"`           // Google Sign-In and OpenID Connect providers.
+	RedirectURI         string   `json:"redirect_uri,omitempty"`       // Redirect URI the authorization code has been sent to, for OpenID Connect providers.
+	CodeVerifier        string   `json:"code_verifier,omitempty"`      // PKCE code verifier, if the authorization code has been requested with code challenge.
+	Nonce               string   `json:"nonce,omitempty"`              // Nonce, if ID token has been requested with it.
+	TrustedDeviceToken  string   `json:"trusted_device_token,omitempty"`
+}
+
+// credential is what the provider needs to identify the user.
+func (d FederatedLoginData) credential() model.FederatedCredential {
+	return model.FederatedCredential{
+		AccessToken:       d.AccessToken,
+		AuthorizationCode: d.AuthorizationCode,
+		IDToken:           d.IDToken,
+		RedirectURI:       d.RedirectURI,
+		CodeVerifier:      d.CodeVerifier,
+		Nonce:             d.Nonce,
+	}
 }
 
 // FederatedLogin provides login/registration with federated identity.
@@ -27,14 +42,8 @@ type FederatedLoginData struct {
 // If there is no user with such identity, function returns 404 (user not found).
 // If register_if_new presents - function creates new user without username/password,
 // there is a dedicated endpoint to link username/password to federated account.
+// User passes two-factor authentication after that, if the app requires it, like with the other login ways.
 func (ar *Router) FederatedLogin() http.HandlerFunc {
-	federatedProviders := map[string]bool{
-		strings.ToLower(string(model.FacebookIDProvider)): true,
-		strings.ToLower(string(model.AppleIDProvider)):    true,
-		strings.ToLower(string(model.GoogleIDProvider)):   false, // TODO: add later
-		strings.ToLower(string(model.TwitterIDProvider)):  false, // TODO: add later
-	}
-
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !ar.SupportedLoginWays.Federated {
 			ar.Error(w, ErrorAPIAppFederatedLoginNotSupported, http.StatusBadRequest, "Application does not support federated login", "FederatedLogin.supportedLoginWays")
@@ -46,12 +55,6 @@ func (ar *Router) FederatedLogin() http.HandlerFunc {
 			return
 		}
 
-		if !federatedProviders[strings.ToLower(d.FederatedIDProvider)] {
-			ar.logger.Println("Federated provider is not supported:", d.FederatedIDProvider)
-			ar.Error(w, ErrorAPIAppFederatedProviderNotSupported, http.StatusBadRequest, fmt.Sprintf("UnsupportedProvider: %v", d.FederatedIDProvider), "FederatedLogin.federatedProviders[]")
-			return
-		}
-
 		app := middleware.AppFromContext(r.Context())
 		if len(app.ID) == 0 {
 			ar.logger.Println("Error getting App")
@@ -59,36 +62,16 @@ func (ar *Router) FederatedLogin() http.HandlerFunc {
 			return
 		}
 
-		var federatedID string
-		var err error
-
-		fid := model.FederatedIdentityProvider(strings.ToUpper(d.FederatedIDProvider))
-		switch fid {
-		case model.FacebookIDProvider:
-			federatedID, err = ar.FacebookUserID(d.AccessToken)
-		case model.AppleIDProvider:
-			if app.AppleInfo == nil {
-				ar.logger.Println("Empty apple info")
-				ar.Error(w, ErrorAPIAppFederatedProviderEmptyAppleInfo, http.StatusBadRequest, "App does not have Apple info.", "FederatedLogin.switch_providers_apple")
-				return
-			}
-			federatedID, err = ar.AppleUserID(d.AuthorizationCode, app.AppleInfo)
-		default:
-			ar.Error(w, ErrorAPIAppFederatedProviderNotSupported, http.StatusBadRequest, fmt.Sprintf("UnsupportedProvider: %v", fid), "FederatedLogin.switch_providers_default")
-			return
-		}
-
-		if err != nil {
-			ar.logger.Println("Error getting federated user ID:", err)
-			ar.Error(w, ErrorAPIAppFederatedProviderEmptyUserID, http.StatusBadRequest, err.Error(), "FederatedLogin.switch_providers.err")
+		provider, profile, ok := ar.federatedProfile(w, app, d, "FederatedLogin")
+		if !ok {
 			return
 		}
 
+		fid, federatedID := provider.ID(), profile.ID
 		user, err := ar.userStorage.UserByFederatedID(fid, federatedID)
 		// Check error not found, create new user.
 		if err == model.ErrUserNotFound && d.RegisterIfNew {
-			user, err = ar.userStorage.AddUserWithFederatedID(fid, federatedID, app.NewUserDefaultRole)
-			if err != nil {
+			if user, err = ar.registerFederatedUser(app, fid, profile); err != nil {
 				ar.Error(w, ErrorAPIUserUnableToCreate, http.StatusInternalServerError, err.Error(), "FederatedLogin.UserByFederatedID.RegisterNew")
 				return
 			}
@@ -119,50 +102,69 @@ func (ar *Router) FederatedLogin() http.HandlerFunc {
 			return
 		}
 
-		// Request token payload
-		tokenPayload, err := ar.getTokenPayloadForApp(app, user)
+		// User passes two-factor authentication after that, if the app requires it.
+		authResult, err := ar.loginFlow(app, user, scopes, d.TrustedDeviceToken, model.NewAuthContext(model.AMRFederated))
 		if err != nil {
-			ar.Error(w, ErrorAPIAppAccessTokenNotCreated, http.StatusInternalServerError, err.Error(), "LoginWithPassword.loginUser")
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "FederatedLogin.LoginFlowError")
 			return
 		}
+		ar.ServeJSON(w, http.StatusOK, authResult)
+	}
+}
 
-		// Generate access token.
-		token, err := ar.tokenService.NewAccessToken(user, scopes, app, false, tokenPayload)
-		if err != nil {
-			ar.Error(w, ErrorAPIAppAccessTokenNotCreated, http.StatusUnauthorized, err.Error(), "FederatedLogin.tokenService_NewToken")
-			return
-		}
-		//check token payload data
+// federatedProfile returns the provider and the user's profile there.
+// If the app has not enabled the provider, or the credential is invalid, it writes the error and returns false.
+func (ar *Router) federatedProfile(w http.ResponseWriter, app model.AppData, d FederatedLoginData, where string) (model.FederatedProvider, model.FederatedProfile, bool) {
+	provider, settings, ok := ar.federatedProviders.AppProvider(app, d.FederatedIDProvider)
+	if !ok {
+		ar.logger.Println("Federated provider is not supported:", d.FederatedIDProvider)
+		ar.Error(w, ErrorAPIAppFederatedProviderNotSupported, http.StatusBadRequest, fmt.Sprintf("UnsupportedProvider: %v", d.FederatedIDProvider), where+".AppProvider")
+		return nil, model.FederatedProfile{}, false
+	}
 
-		tokenString, err := ar.tokenService.String(token)
-		if err != nil {
-			ar.Error(w, ErrorAPIAppAccessTokenNotCreated, http.StatusInternalServerError, err.Error(), "FederatedLogin.tokenService_String")
-			return
+	profile, err := provider.UserProfile(settings, d.credential())
+	if err == model.ErrFederatedProviderNotConfigured {
+		// Apple keeps its own error, as clients handle it since before the other providers could be configured.
+		var messageID MessageID = ErrorAPIAppFederatedProviderNotConfigured
+		if provider.ID() == model.AppleIDProvider {
+			messageID = ErrorAPIAppFederatedProviderEmptyAppleInfo
 		}
+		ar.Error(w, messageID, http.StatusBadRequest, err.Error(), where+".UserProfile")
+		return nil, model.FederatedProfile{}, false
+	}
+	if err == nil && len(profile.ID) == 0 {
+		err = fmt.Errorf("%v user id is not accessible", provider.ID())
+	}
+	if err != nil {
+		ar.logger.Println("Error getting federated user ID:", err)
+		ar.Error(w, ErrorAPIAppFederatedProviderEmptyUserID, http.StatusBadRequest, err.Error(), where+".UserProfile")
+		return nil, model.FederatedProfile{}, false
+	}
+	return provider, profile, true
+}
 
-		refreshString := ""
-		// requesting offline access ?
-		if contains(scopes, jwtService.OfflineScope) {
-			refresh, err := ar.tokenService.NewRefreshToken(user, scopes, app)
-			if err != nil {
-				ar.Error(w, ErrorAPIAppRefreshTokenNotCreated, http.StatusInternalServerError, err.Error(), "FederatedLogin.tokenService_NewRefreshToken")
-				return
-			}
-			refreshString, err = ar.tokenService.String(refresh)
-			if err != nil {
-				ar.Error(w, ErrorAPIAppRefreshTokenNotCreated, http.StatusInternalServerError, err.Error(), "FederatedLogin.tokenService_String")
-				return
-			}
+// registerFederatedUser creates new user with the federated identity.
+// If the app links federated identities by email, the provider is trusted server-wide, and there is the user with the same verified email,
+// the identity is linked to them instead.
+func (ar *Router) registerFederatedUser(app model.AppData, fid model.FederatedIdentityProvider, profile model.FederatedProfile) (model.User, error) {
+	if app.LinkFederatedByEmail {
+		if user, err := model.LinkFederatedIDByEmail(ar.userStorage, ar.trustedFederated, fid, profile); err == nil {
+			return user, nil
 		}
+	}
 
-		user = user.Sanitized()
-		result := AuthResponse{
-			AccessToken:  tokenString,
-			RefreshToken: refreshString,
-			User:         user,
-		}
+	user, err := ar.userStorage.AddUserWithFederatedID(fid, profile.ID, app.NewUserDefaultRole)
+	if err != nil {
+		return model.User{}, err
+	}
 
-		ar.userStorage.UpdateLoginMetadata(user.ID)
-		ar.ServeJSON(w, http.StatusOK, result)
+	// Email is saved only if nobody else has it, users should not be able to take the emails of the others.
+	if len(profile.Email) == 0 {
+		return user, nil
+	}
+	if _, err := ar.userStorage.UserByEmail(profile.Email); err == nil {
+		return user, nil
 	}
+	user.Email, user.EmailVerified = profile.Email, profile.EmailVerified
+	return ar.userStorage.UpdateUser(user.ID, user)
 }
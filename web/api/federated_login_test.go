@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/madappgang/identifo/identity_providers/registry"
+	"github.com/madappgang/identifo/model"
+)
+
+func Test_federatedProfile(t *testing.T) {
+	ar := &Router{
+		logger:             log.New(ioutil.Discard, "", 0),
+		federatedProviders: registry.NewDefault(""),
+	}
+
+	tests := []struct {
+		name string
+		app  model.AppData
+		want MessageID
+	}{
+		{"no apple info", model.AppData{ID: "app"}, ErrorAPIAppFederatedProviderEmptyAppleInfo},
+		{"incomplete apple info", model.AppData{ID: "app", AppleInfo: &model.AppleInfo{ClientID: "apple"}}, ErrorAPIAppFederatedProviderEmptyAppleInfo},
+		{"provider not enabled", model.AppData{ID: "app", FederatedProviders: map[string]model.FederatedSettings{"facebook": {}}}, ErrorAPIAppFederatedProviderNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			d := FederatedLoginData{FederatedIDProvider: "apple", AuthorizationCode: "code"}
+			if _, _, ok := ar.federatedProfile(w, tt.app, d, "Test"); ok {
+				t.Fatal("federatedProfile() succeeded, want error")
+			}
+
+			var resp struct {
+				Error struct {
+					ID MessageID `json:"id"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if w.Code != http.StatusBadRequest || resp.Error.ID != tt.want {
+				t.Errorf("federatedProfile() = %d %v, want %d %v", w.Code, resp.Error.ID, http.StatusBadRequest, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -11,35 +10,30 @@ import (
 	thp "github.com/madappgang/identifo/user_payload_provider/http"
 	"github.com/madappgang/identifo/web/authorization"
 	"github.com/madappgang/identifo/web/middleware"
-	"github.com/xlzd/gotp"
-)
-
-var (
-	errPleaseEnableTFA   = fmt.Errorf("please enable two-factor authentication to be able to use this app")
-	errPleaseSetPhoneTFA = fmt.Errorf("please set phone for two-factor authentication to be able to use this app")
-	errPleaseSetEmailTFA = fmt.Errorf("please set email for two-factor authentication to be able to use this app")
-	errPleaseDisableTFA  = fmt.Errorf("please disable two-factor authentication to be able to use this app")
-)
-
-const (
-	smsTFACode        = "%v is your one-time password!"
-	hotpLifespanHours = 12 // One time code expiration in hours, default value is 30 secs for TOTP and 12 hours for HOTP
+	"github.com/madappgang/identifo/web/ratelimit"
+	"github.com/madappgang/identifo/web/tfa"
 )
 
 // AuthResponse is a response with successful auth data.
 type AuthResponse struct {
 	AccessToken  string     `json:"access_token,omitempty" bson:"access_token,omitempty"`
 	RefreshToken string     `json:"refresh_token,omitempty" bson:"refresh_token,omitempty"`
+	IDToken      string     `json:"id_token,omitempty" bson:"id_token,omitempty"`
 	User         model.User `json:"user,omitempty" bson:"user,omitempty"`
 	Require2FA   bool       `json:"require_2fa" bson:"require_2fa"`
 	Enabled2FA   bool       `json:"enabled_2fa" bson:"enabled_2fa"`
+	// TFATypes are the factors the user could pass two-factor authentication with.
+	TFATypes []model.TFAType `json:"tfa_types,omitempty" bson:"tfa_types,omitempty"`
+	// TrustedDeviceToken lets the device skip two-factor authentication next time the user logs in.
+	TrustedDeviceToken string `json:"trusted_device_token,omitempty" bson:"trusted_device_token,omitempty"`
 }
 
 type loginData struct {
-	Username    string   `json:"username,omitempty"`
-	Password    string   `json:"password,omitempty"`
-	DeviceToken string   `json:"device_token,omitempty"`
-	Scopes      []string `json:"scopes,omitempty"`
+	Username           string   `json:"username,omitempty"`
+	Password           string   `json:"password,omitempty"`
+	DeviceToken        string   `json:"device_token,omitempty"`
+	TrustedDeviceToken string   `json:"trusted_device_token,omitempty"`
+	Scopes             []string `json:"scopes,omitempty"`
 }
 
 func (ld *loginData) validate() error {
@@ -72,12 +66,6 @@ func (ar *Router) LoginWithPassword() http.HandlerFunc {
 			return
 		}
 
-		user, err := ar.userStorage.UserByNamePassword(ld.Username, ld.Password)
-		if err != nil {
-			ar.Error(w, ErrorAPIRequestIncorrectEmailOrPassword, http.StatusUnauthorized, err.Error(), "LoginWithPassword.UserByNamePassword")
-			return
-		}
-
 		app := middleware.AppFromContext(r.Context())
 		if len(app.ID) == 0 {
 			ar.logger.Println("Error getting App")
@@ -85,6 +73,16 @@ func (ar *Router) LoginWithPassword() http.HandlerFunc {
 			return
 		}
 
+		if ar.rateLimited(w, r, ratelimit.PolicyLogin, ratelimit.Request{User: ld.Username, App: app.ID}, "LoginWithPassword") {
+			return
+		}
+
+		user, err := ar.userStorage.UserByNamePassword(ld.Username, ld.Password)
+		if err != nil {
+			ar.Error(w, ErrorAPIRequestIncorrectEmailOrPassword, http.StatusUnauthorized, err.Error(), "LoginWithPassword.UserByNamePassword")
+			return
+		}
+
 		// Authorize user if the app requires authorization.
 		azi := authorization.AuthzInfo{
 			App:         app,
@@ -97,7 +95,7 @@ func (ar *Router) LoginWithPassword() http.HandlerFunc {
 			return
 		}
 
-		authResult, err := ar.loginFlow(app, user, ld.Scopes)
+		authResult, err := ar.loginFlow(app, user, ld.Scopes, ld.TrustedDeviceToken, model.NewAuthContext(model.AMRPassword))
 		if err != nil {
 			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "LoginWithPassword.LoginFlowError")
 			return
@@ -107,31 +105,6 @@ func (ar *Router) LoginWithPassword() http.HandlerFunc {
 	}
 }
 
-func (ar *Router) sendOTPCode(user model.User) error {
-	// we don't need to send any code for FTA Type App, it uses TOTP and generated on client side with the app
-	if ar.tfaType != model.TFATypeApp {
-
-		// increment hotp code seed
-		otp := gotp.NewDefaultHOTP(user.TFAInfo.Secret).At(user.TFAInfo.HOTPCounter + 1)
-		tfa := user.TFAInfo
-		tfa.HOTPCounter++
-		tfa.HOTPExpiredAt = time.Now().Add(time.Hour * hotpLifespanHours)
-		user.TFAInfo = tfa
-		if _, err := ar.userStorage.UpdateUser(user.ID, user); err != nil {
-			return err
-		}
-		switch ar.tfaType {
-		case model.TFATypeSMS:
-			return ar.sendTFACodeInSMS(user.Phone, otp)
-		case model.TFATypeEmail:
-			return ar.sendTFACodeOnEmail(user.Email, otp)
-		}
-
-	}
-
-	return nil
-}
-
 // IsLoggedIn is for checking whether user is logged in or not.
 // In fact, all needed work is done in Token middleware.
 // If we reached this code, user is logged in (presented valid and not blacklisted access token).
@@ -178,8 +151,9 @@ func (ar *Router) getTokenPayloadForApp(app model.AppData, user model.User) (map
 
 // loginUser creates and returns access token for a user.
 // createRefreshToken boolean param tells if we should issue refresh token as well.
-func (ar *Router) loginUser(user model.User, scopes []string, app model.AppData, createRefreshToken, require2FA bool, tokenPayload map[string]interface{}) (accessTokenString, refreshTokenString string, err error) {
-	token, err := ar.tokenService.NewAccessToken(user, scopes, app, require2FA, tokenPayload)
+// authCtx tells how the user has authenticated, it goes to the amr, acr and auth_time claims.
+func (ar *Router) loginUser(user model.User, scopes []string, app model.AppData, createRefreshToken, require2FA bool, tokenPayload map[string]interface{}, authCtx model.AuthContext) (accessTokenString, refreshTokenString string, err error) {
+	token, err := ar.tokenService.NewAccessToken(user, scopes, app, require2FA, tokenPayload, authCtx)
 	if err != nil {
 		return
 	}
@@ -203,60 +177,10 @@ func (ar *Router) loginUser(user model.User, scopes []string, app model.AppData,
 	return
 }
 
-// check2FA checks correspondence between app's TFAstatus and user's TFAInfo,
-// and decides if we require two-factor authentication after all checks are successfully passed.
-func (ar *Router) check2FA(appTFAStatus model.TFAStatus, serverTFAType model.TFAType, user model.User) (bool, bool, error) {
-	if appTFAStatus == model.TFAStatusMandatory && !user.TFAInfo.IsEnabled {
-		return true, false, errPleaseEnableTFA
-	}
-
-	if appTFAStatus == model.TFAStatusDisabled && user.TFAInfo.IsEnabled {
-		return false, true, errPleaseDisableTFA
-	}
-
-	// Request two-factor auth if user enabled it and app supports it.
-	if user.TFAInfo.IsEnabled && appTFAStatus != model.TFAStatusDisabled {
-		if user.Phone == "" && serverTFAType == model.TFATypeSMS {
-			// Server required sms tfa but user phone is empty
-			return true, false, errPleaseSetPhoneTFA
-		}
-		if user.Email == "" && serverTFAType == model.TFATypeEmail {
-			// Server required email tfa but user email is empty
-			return true, false, errPleaseSetEmailTFA
-		}
-		if user.TFAInfo.Secret == "" {
-			// Then admin must have enabled TFA for this user manually.
-			// User must obtain TFA secret, i.e send EnableTFA request.
-			return true, false, errPleaseEnableTFA
-		}
-		return true, true, nil
-	}
-	return false, false, nil
-}
-
-func (ar *Router) sendTFACodeInSMS(phone, otp string) error {
-	if phone == "" {
-		return errors.New("unable to send SMS OTP, user has no phone number")
-	}
-
-	if err := ar.smsService.SendSMS(phone, fmt.Sprintf(smsTFACode, otp)); err != nil {
-		return fmt.Errorf("unable to send sms. %s", err)
-	}
-	return nil
-}
-
-func (ar *Router) sendTFACodeOnEmail(email, otp string) error {
-	if email == "" {
-		return errors.New("unable to send email OTP, user has no email")
-	}
-
-	if err := ar.emailService.SendTFAEmail("One-time password", email, otp); err != nil {
-		return fmt.Errorf("unable to send email with OTP with error: %s", err)
-	}
-	return nil
-}
-
-func (ar *Router) loginFlow(app model.AppData, user model.User, scopes []string) (AuthResponse, error) {
+// loginFlow issues the tokens to the user who has passed the first factor.
+// Trusted device token lets the user skip the second one.
+// The preauth token, issued when the user has to pass the second factor, keeps the first factor's auth context for FinalizeTFA.
+func (ar *Router) loginFlow(app model.AppData, user model.User, scopes []string, trustedDeviceToken string, authCtx model.AuthContext) (AuthResponse, error) {
 	// Do login flow.
 	scopes, err := ar.userStorage.RequestScopes(user.ID, scopes)
 	if err != nil {
@@ -264,10 +188,13 @@ func (ar *Router) loginFlow(app model.AppData, user model.User, scopes []string)
 	}
 
 	// Check if we should require user to authenticate with 2FA.
-	require2FA, enabled2FA, err := ar.check2FA(app.TFAStatus, ar.tfaType, user)
+	require2FA, enabled2FA, err := ar.tfaService.Check(app, user)
 	if !require2FA && enabled2FA && err != nil {
 		return AuthResponse{}, err
 	}
+	if require2FA && enabled2FA && ar.isTrustedDevice(app, user, trustedDeviceToken) {
+		require2FA = false
+	}
 
 	offline := contains(scopes, jwtService.OfflineScope)
 
@@ -276,7 +203,7 @@ func (ar *Router) loginFlow(app model.AppData, user model.User, scopes []string)
 		return AuthResponse{}, err
 	}
 
-	accessToken, refreshToken, err := ar.loginUser(user, scopes, app, offline, require2FA, tokenPayload)
+	accessToken, refreshToken, err := ar.loginUser(user, scopes, app, offline, require2FA, tokenPayload, authCtx)
 	if err != nil {
 		return AuthResponse{}, err
 	}
@@ -288,10 +215,22 @@ func (ar *Router) loginFlow(app model.AppData, user model.User, scopes []string)
 		Enabled2FA:   enabled2FA,
 	}
 
-	if require2FA && enabled2FA {
-		if err := ar.sendOTPCode(user); err != nil {
+	if !require2FA {
+		result.IDToken, err = ar.newIDToken(user, scopes, app, accessToken, "", time.Now().Unix())
+		if err != nil {
 			return AuthResponse{}, err
 		}
+	}
+
+	if require2FA && enabled2FA {
+		// The code is sent right away if the user has one factor, otherwise they choose the factor to get the code with.
+		factors := ar.tfaService.Factors(app, user)
+		result.TFATypes = tfa.Types(factors)
+		if len(factors) == 1 {
+			if err := ar.tfaService.SendCode(user, factors[0].Type); err != nil {
+				return AuthResponse{}, err
+			}
+		}
 	} else {
 		ar.userStorage.UpdateLoginMetadata(user.ID)
 	}
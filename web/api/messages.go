@@ -9,50 +9,70 @@ func GetMessage(id MessageID) string {
 }
 
 var messages = map[MessageID]string{
-	ErrorAPIInternalServerError:                "Internal server error",
-	ErrorAPIUserUnableToCreate:                 "Unable to create use. Try again or contact support team",
-	ErrorAPIVerificationCodeInvalid:            "Sorry, the code you entered is invalid or has expired. Please get a new one.",
-	ErrorAPIUserNotFound:                       "Specified user not found",
-	ErrorAPIUsernameTaken:                      "Username is taken. Try to choose another one",
-	ErrorAPIEmailTaken:                         "Email is taken. Try to choose another one",
-	ErrorAPIInviteTokenServerError:             "Unable to create invite token. Try again or contact support team",
-	ErrorAPIInviteUnableToInvalidate:           "Unable to invalidate invite. Try again or contact support team",
-	ErrorAPIInviteUnableToSave:                 "Unable to save invite. Try again or contact support team",
-	ErrorAPIInviteUnableToGet:                  "Unable to get invites. Try again or contact support team",
-	ErrorAPIEmailNotSent:                       "Unable to send email. Try again or contact support team",
-	ErrorAPIRequestPasswordWeak:                "Password is not strong enough",
-	ErrorAPIRequestIncorrectEmailOrPassword:    "Incorrect email or password",
-	ErrorAPIRequestScopesForbidden:             "Requested scopes are forbidden",
-	ErrorAPIRequestBodyInvalid:                 "Wrong input data",
-	ErrorAPIRequestBodyParamsInvalid:           "Input data does not pass validation. Please specify valid params",
-	ErrorAPIRequestBodyOldPasswordInvalid:      "Old password is invalid. Please check it again",
-	ErrorAPIRequestBodyEmailInvalid:            "Specified email is invalid or empty",
-	ErrorAPIRequestSignatureInvalid:            "Incorrect or empty request signature",
-	ErrorAPIRequestAppIDInvalid:                "Incorrect or empty application ID",
-	ErrorAPIRequestTokenInvalid:                "Incorrect or empty Bearer token",
-	ErrorAPIRequestTFACodeEmpty:                "Empty two-factor authentication code",
-	ErrorAPIRequestTFACodeInvalid:              "Invalid two-factor authentication code",
-	ErrorAPIRequestTFAAlreadyEnabled:           "Two-factor authentication already enabled",
-	ErrorAPIRequestPleaseEnableTFA:             "Please enable two-factor authenticaton",
-	ErrorAPIRequestPleaseDisableTFA:            "Please disable two-factor authenticaton",
-	ErrorAPIRequestMandatoryTFA:                "Two-factor authentication is mandatory for this app",
-	ErrorAPIRequestDisabledTFA:                 "Two-factor authentication is disabled for this app",
-	ErrorAPIRequestPleaseSetPhoneForTFA:        "Please specify your phone number to be able to receive one-time passwords",
-	ErrorAPIRequestPleaseSetEmailForTFA:        "Please specify your email address to be able to receive one-time passwords",
-	ErrorAPIAppInactive:                        "Requesting app is inactive",
-	ErrorAPIAppRegistrationForbidden:           "Registration in this app is forbidden",
-	ErrorAPIAppResetTokenNotCreated:            "Unable to create reset token",
-	ErrorAPIAppAccessTokenNotCreated:           "Unable to create access token",
-	ErrorAPIAppRefreshTokenNotCreated:          "Unable to create refresh token",
-	ErrorAPIAppCannotExtractTokenSubject:       "Unable to extract Subject claim from token",
-	ErrorAPIAppCannotInitAuthorizer:            "Unable to init internal authorizer",
-	ErrorAPIAppFederatedProviderNotSupported:   "Federated provider is not supported",
-	ErrorAPIAppFederatedProviderEmptyUserID:    "Federated provider returns empty user ID",
-	ErrorAPIAppFederatedProviderEmptyAppleInfo: "Application does not have Apple info",
-	ErrorAPIAppFederatedLoginNotSupported:      "Login with federated identity provider is not supported by app",
-	ErrorAPIAppLoginWithUsernameNotSupported:   "Login with username is not supported by app",
-	ErrorAPIAppPhoneLoginNotSupported:          "Login with phone number is not supported by app",
-	ErrorAPIAppAccessDenied:                    "Access denied",
+	ErrorAPIInternalServerError:                 "Internal server error",
+	ErrorAPIUserUnableToCreate:                  "Unable to create use. Try again or contact support team",
+	ErrorAPIVerificationCodeInvalid:             "Sorry, the code you entered is invalid or has expired. Please get a new one.",
+	ErrorAPIUserNotFound:                        "Specified user not found",
+	ErrorAPIUsernameTaken:                       "Username is taken. Try to choose another one",
+	ErrorAPIEmailTaken:                          "Email is taken. Try to choose another one",
+	ErrorAPIInviteTokenServerError:              "Unable to create invite token. Try again or contact support team",
+	ErrorAPIInviteUnableToInvalidate:            "Unable to invalidate invite. Try again or contact support team",
+	ErrorAPIInviteUnableToSave:                  "Unable to save invite. Try again or contact support team",
+	ErrorAPIInviteUnableToGet:                   "Unable to get invites. Try again or contact support team",
+	ErrorAPIEmailNotSent:                        "Unable to send email. Try again or contact support team",
+	ErrorAPIRequestPasswordWeak:                 "Password is not strong enough",
+	ErrorAPIRequestIncorrectEmailOrPassword:     "Incorrect email or password",
+	ErrorAPIRequestScopesForbidden:              "Requested scopes are forbidden",
+	ErrorAPIRequestBodyInvalid:                  "Wrong input data",
+	ErrorAPIRequestBodyParamsInvalid:            "Input data does not pass validation. Please specify valid params",
+	ErrorAPIRequestBodyOldPasswordInvalid:       "Old password is invalid. Please check it again",
+	ErrorAPIRequestBodyEmailInvalid:             "Specified email is invalid or empty",
+	ErrorAPIRequestSignatureInvalid:             "Incorrect or empty request signature",
+	ErrorAPIRequestAppIDInvalid:                 "Incorrect or empty application ID",
+	ErrorAPIRequestTokenInvalid:                 "Incorrect or empty Bearer token",
+	ErrorAPIRequestTFACodeEmpty:                 "Empty two-factor authentication code",
+	ErrorAPIRequestTFACodeInvalid:               "Invalid two-factor authentication code",
+	ErrorAPIRequestTFAAlreadyEnabled:            "Two-factor authentication already enabled",
+	ErrorAPIRequestTFATypeNotAllowed:            "Two-factor authentication method is not supported",
+	ErrorAPIRequestTFATypeRequired:              "Please choose two-factor authentication method",
+	ErrorAPIRequestTFALocked:                    "Too many failed attempts, please try again later",
+	ErrorAPIRequestRateLimited:                  "Too many requests, please try again later",
+	ErrorAPIRequestTFANotEnabled:                "Two-factor authentication is not enabled",
+	ErrorAPIRequestPleaseEnableTFA:              "Please enable two-factor authenticaton",
+	ErrorAPIRequestPleaseDisableTFA:             "Please disable two-factor authenticaton",
+	ErrorAPIRequestMandatoryTFA:                 "Two-factor authentication is mandatory for this app",
+	ErrorAPIRequestDisabledTFA:                  "Two-factor authentication is disabled for this app",
+	ErrorAPIRequestPleaseSetPhoneForTFA:         "Please specify your phone number to be able to receive one-time passwords",
+	ErrorAPIRequestPleaseSetEmailForTFA:         "Please specify your email address to be able to receive one-time passwords",
+	ErrorAPIRequestPleaseRegisterWebAuthnForTFA: "Please register security key to be able to use it for two-factor authentication",
+	ErrorAPIAppInactive:                         "Requesting app is inactive",
+	ErrorAPIAppRegistrationForbidden:            "Registration in this app is forbidden",
+	ErrorAPIAppResetTokenNotCreated:             "Unable to create reset token",
+	ErrorAPIAppAccessTokenNotCreated:            "Unable to create access token",
+	ErrorAPIAppRefreshTokenNotCreated:           "Unable to create refresh token",
+	ErrorAPIAppCannotExtractTokenSubject:        "Unable to extract Subject claim from token",
+	ErrorAPIAppCannotInitAuthorizer:             "Unable to init internal authorizer",
+	ErrorAPIAppFederatedProviderNotSupported:    "Federated provider is not supported",
+	ErrorAPIAppFederatedProviderEmptyUserID:     "Federated provider returns empty user ID",
+	ErrorAPIAppFederatedProviderEmptyAppleInfo:  "Application does not have Apple info",
+	ErrorAPIAppFederatedProviderNotConfigured:   "Application has incomplete settings of the federated provider",
+	ErrorAPIFederatedIdentityLinked:             "This identity is linked to another user",
+	ErrorAPIFederatedIdentityNotFound:           "This identity is not linked to the user",
+	ErrorAPIFederatedIdentityLastLogin:          "Unable to unlink the last login method",
+	ErrorAPIAppFederatedLoginNotSupported:       "Login with federated identity provider is not supported by app",
+	ErrorAPIAppLoginWithUsernameNotSupported:    "Login with username is not supported by app",
+	ErrorAPIAppPhoneLoginNotSupported:           "Login with phone number is not supported by app",
+	ErrorAPIAppEmailLoginNotSupported:           "Login with email is not supported by app",
+	ErrorAPIAppWebAuthnLoginNotSupported:        "Login with security key is not supported by app",
+	ErrorAPIWebAuthnNoCredentials:               "User has no security keys",
+	ErrorAPIWebAuthnCredentialNotFound:          "This security key is not registered for the user",
+	ErrorAPIWebAuthnVerificationFailed:          "Unable to verify security key",
+	ErrorAPIWebAuthnLastTFAKey:                  "Unable to remove the last security key while two-factor authentication is enabled",
+	ErrorAPIAppAccessDenied:                     "Access denied",
+	ErrorAPITrustedDeviceNotFound:               "This device is not trusted by the user",
+	ErrorAPIAppTrustedDevicesDisabled:           "Trusted devices are disabled for this app",
+	ErrorAPIMagicLinkInvalid:                    "Sorry, the link is invalid or has expired. Please get a new one.",
+	ErrorAPIMagicLinkRedirectURIInvalid:         "This redirect URI is not allowed for the app",
 }
 
 const (
@@ -108,6 +128,16 @@ const (
 	ErrorAPIRequestTFACodeInvalid = "error.api.request.2fa_code.invalid"
 	// ErrorAPIRequestTFAAlreadyEnabled means that 2FA is already enabled for the user.
 	ErrorAPIRequestTFAAlreadyEnabled = "error.api.request.2fa.already_enabled"
+	// ErrorAPIRequestTFATypeNotAllowed means that the app does not support the factor, or the user has not enrolled it.
+	ErrorAPIRequestTFATypeNotAllowed = "error.api.request.2fa.type.not_allowed"
+	// ErrorAPIRequestTFATypeRequired means that the user has several factors, and should choose one.
+	ErrorAPIRequestTFATypeRequired = "error.api.request.2fa.type.required"
+	// ErrorAPIRequestTFALocked means that the user's TFA challenge is locked after too many failed attempts.
+	ErrorAPIRequestTFALocked = "error.api.request.2fa.locked"
+	// ErrorAPIRequestRateLimited means that the client has sent too many requests to the endpoint, and should retry after a while.
+	ErrorAPIRequestRateLimited = "error.api.request.rate_limited"
+	// ErrorAPIRequestTFANotEnabled means that 2FA is not enabled for the user.
+	ErrorAPIRequestTFANotEnabled = "error.api.request.2fa.not_enabled"
 	// ErrorAPIRequestPleaseEnableTFA means that user must request TFA and obtain TFA secret to be able to use the app.
 	ErrorAPIRequestPleaseEnableTFA = "error.api.request.2fa.please_enable"
 	// ErrorAPIRequestPleaseDisableTFA means that user must disable TFA to be able to use the app.
@@ -120,6 +150,8 @@ const (
 	ErrorAPIRequestPleaseSetPhoneForTFA = "error.api.request.2fa.set_phone"
 	// ErrorAPIRequestPleaseSetEmailForTFA means that user must set up their email address to be able to receive OTPs on the email.
 	ErrorAPIRequestPleaseSetEmailForTFA = "error.api.request.2fa.set_email"
+	// ErrorAPIRequestPleaseRegisterWebAuthnForTFA means that user must register security key to be able to pass TFA with it.
+	ErrorAPIRequestPleaseRegisterWebAuthnForTFA = "error.api.request.2fa.register_webauthn"
 	// ErrorAPIRequestUnableToSendOTP means that there is error sending the otp code while login to user
 	ErrorAPIRequestUnableToSendOTP = "error.api.request.2fa.unable to send OTP code to email or sms"
 
@@ -144,6 +176,15 @@ const (
 	ErrorAPIAppFederatedProviderEmptyUserID = "api.app.federated.provider.empty_user_id"
 	// ErrorAPIAppFederatedProviderEmptyAppleInfo means that application does not have clientID and clientSecret needed for Sign In with Apple.
 	ErrorAPIAppFederatedProviderEmptyAppleInfo = "api.app.federated.provider.empty_apple_info"
+	// ErrorAPIAppFederatedProviderNotConfigured means that application does not have the settings needed for the federated provider.
+	ErrorAPIAppFederatedProviderNotConfigured = "api.app.federated.provider.not_configured"
+
+	// ErrorAPIFederatedIdentityLinked means that the federated identity is linked to another user.
+	ErrorAPIFederatedIdentityLinked = "api.federated.identity.linked"
+	// ErrorAPIFederatedIdentityNotFound means that the federated identity is not linked to the user.
+	ErrorAPIFederatedIdentityNotFound = "api.federated.identity.not_found"
+	// ErrorAPIFederatedIdentityLastLogin means that the user cannot unlink the federated identity, because they would not be able to sign in without it.
+	ErrorAPIFederatedIdentityLastLogin = "api.federated.identity.last_login_method"
 
 	// ErrorAPIAppFederatedLoginNotSupported means that the app does not support federated login.
 	ErrorAPIAppFederatedLoginNotSupported = "api.app.federated.login.not_supported"
@@ -151,4 +192,27 @@ const (
 	ErrorAPIAppLoginWithUsernameNotSupported = "api.app.username.login.not_supported"
 	// ErrorAPIAppPhoneLoginNotSupported means that the app does not support login by phone number.
 	ErrorAPIAppPhoneLoginNotSupported = "api.app.phone.login.not_supported"
+	// ErrorAPIAppEmailLoginNotSupported means that the app does not support passwordless login by email.
+	ErrorAPIAppEmailLoginNotSupported = "api.app.email.login.not_supported"
+	// ErrorAPIAppWebAuthnLoginNotSupported means that the app does not support passwordless login with security keys.
+	ErrorAPIAppWebAuthnLoginNotSupported = "api.app.webauthn.login.not_supported"
+
+	// ErrorAPIWebAuthnNoCredentials means that the user has not registered any security key.
+	ErrorAPIWebAuthnNoCredentials = "api.webauthn.credentials.empty"
+	// ErrorAPIWebAuthnCredentialNotFound means that the security key is not registered for the user.
+	ErrorAPIWebAuthnCredentialNotFound = "api.webauthn.credential.not_found"
+	// ErrorAPIWebAuthnVerificationFailed means that the security key response does not match the ceremony, or the ceremony session is invalid.
+	ErrorAPIWebAuthnVerificationFailed = "api.webauthn.verification.failed"
+	// ErrorAPIWebAuthnLastTFAKey means that the user cannot remove the security key, because they would not be able to pass TFA without it.
+	ErrorAPIWebAuthnLastTFAKey = "api.webauthn.credential.last_tfa_key"
+
+	// ErrorAPITrustedDeviceNotFound means that the user has not trusted the device, or it has been revoked.
+	ErrorAPITrustedDeviceNotFound = "api.trusted_device.not_found"
+	// ErrorAPIAppTrustedDevicesDisabled means that the app does not let users skip two-factor authentication on trusted devices.
+	ErrorAPIAppTrustedDevicesDisabled = "api.app.trusted_devices.disabled"
+
+	// ErrorAPIMagicLinkInvalid means that the sign-in link is expired, used, or issued for another app.
+	ErrorAPIMagicLinkInvalid = "api.magic_link.invalid"
+	// ErrorAPIMagicLinkRedirectURIInvalid means that the sign-in link could not lead to the redirect URI, as the app does not allow it.
+	ErrorAPIMagicLinkRedirectURIInvalid = "api.magic_link.redirect_uri.invalid"
 )
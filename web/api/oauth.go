@@ -0,0 +1,465 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	jwt "github.com/form3tech-oss/jwt-go"
+	jwtService "github.com/madappgang/identifo/jwt/service"
+	jwtValidator "github.com/madappgang/identifo/jwt/validator"
+	"github.com/madappgang/identifo/model"
+)
+
+const (
+	// GrantTypeAuthorizationCode is an OAuth 2.0 authorization code grant type.
+	GrantTypeAuthorizationCode = "authorization_code"
+	// GrantTypeClientCredentials is an OAuth 2.0 client credentials grant type.
+	GrantTypeClientCredentials = "client_credentials"
+	// GrantTypeRefreshToken is an OAuth 2.0 refresh token grant type.
+	GrantTypeRefreshToken = "refresh_token"
+	// ClientAssertionTypeJWTBearer is a client assertion type for JWT client authentication (RFC 7523, section 2.2).
+	ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// CodeChallengeMethodS256 is the only supported PKCE code challenge method.
+	CodeChallengeMethodS256 = "S256"
+)
+
+// OAuth 2.0 error codes, as described in RFC 6749, section 5.2.
+const (
+	oauthErrorInvalidRequest       = "invalid_request"
+	oauthErrorInvalidClient        = "invalid_client"
+	oauthErrorInvalidGrant         = "invalid_grant"
+	oauthErrorInvalidScope         = "invalid_scope"
+	oauthErrorUnauthorizedClient   = "unauthorized_client"
+	oauthErrorUnsupportedGrantType = "unsupported_grant_type"
+	oauthErrorServerError          = "server_error"
+)
+
+var (
+	errOAuthClientUnknown          = errors.New("Unknown or inactive client")
+	errOAuthClientSecretMismatch   = errors.New("Client secret mismatch")
+	errOAuthClientAssertionInvalid = errors.New("Client assertion is invalid")
+	errOAuthClientAssertionReused  = errors.New("Client assertion has already been used")
+	errOAuthClientAuthRequired     = errors.New("Client authentication is required")
+)
+
+// oauthTokenResponse is a successful token endpoint response.
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// OAuthAuthorize forwards authorization requests to the web router,
+// where the user can sign in with the hosted login page.
+func (ar *Router) OAuthAuthorize() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		redirectURL := path.Join(ar.WebRouterPrefix, "/oauth/authorize")
+		if r.URL.RawQuery != "" {
+			redirectURL += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+	}
+}
+
+// OAuthToken is an OAuth 2.0 token endpoint.
+// It exchanges authorization codes, obtained with PKCE, and approved device codes for access and refresh tokens,
+// rotates refresh tokens, and issues access tokens to service apps with client credentials grant.
+func (ar *Router) OAuthToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+
+		if err := r.ParseForm(); err != nil {
+			ar.oauthError(w, oauthErrorInvalidRequest, http.StatusBadRequest, err.Error(), "OAuthToken.ParseForm")
+			return
+		}
+
+		switch grantType := r.PostFormValue("grant_type"); grantType {
+		case GrantTypeAuthorizationCode:
+			ar.exchangeAuthorizationCode(w, r)
+		case GrantTypeClientCredentials:
+			ar.issueClientCredentialsToken(w, r)
+		case GrantTypeDeviceCode:
+			ar.exchangeDeviceCode(w, r)
+		case GrantTypeRefreshToken:
+			ar.exchangeRefreshToken(w, r)
+		default:
+			ar.oauthError(w, oauthErrorUnsupportedGrantType, http.StatusBadRequest, "Grant type '"+grantType+"' is not supported", "OAuthToken.grant_type")
+		}
+	}
+}
+
+func (ar *Router) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request) {
+	app, authenticated, err := ar.oauthClient(r)
+	if err != nil {
+		ar.oauthError(w, oauthErrorInvalidClient, http.StatusUnauthorized, err.Error(), "exchangeAuthorizationCode.oauthClient")
+		return
+	}
+	// Confidential clients prove their identity, PKCE alone is enough for public clients only.
+	if app.Secret != "" && !authenticated {
+		ar.oauthError(w, oauthErrorInvalidClient, http.StatusUnauthorized, errOAuthClientAuthRequired.Error(), "exchangeAuthorizationCode.authenticated")
+		return
+	}
+
+	code := strings.TrimSpace(r.PostFormValue("code"))
+	if code == "" {
+		ar.oauthError(w, oauthErrorInvalidRequest, http.StatusBadRequest, "Authorization code is empty", "exchangeAuthorizationCode.code")
+		return
+	}
+
+	codeToken, err := ar.tokenService.Parse(code)
+	if err != nil || codeToken.Type() != model.TokenTypeAuthCode {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, "Authorization code is invalid or expired", "exchangeAuthorizationCode.Parse")
+		return
+	}
+
+	if !contains(codeToken.Audience(), app.ID) {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, "Authorization code was issued to another client", "exchangeAuthorizationCode.Audience")
+		return
+	}
+
+	payload := codeToken.Payload()
+	if redirectURI, _ := payload[jwtService.PayloadRedirectURI].(string); redirectURI != r.PostFormValue("redirect_uri") {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, "Redirect URI does not match the authorization request", "exchangeAuthorizationCode.redirect_uri")
+		return
+	}
+
+	challenge, _ := payload[jwtService.PayloadCodeChallenge].(string)
+	if !verifyCodeChallenge(r.PostFormValue("code_verifier"), challenge) {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, "PKCE code verifier does not match the code challenge", "exchangeAuthorizationCode.verifyCodeChallenge")
+		return
+	}
+
+	// Authorization code is single use, so only one of the concurrent requests consumes it.
+	if err := ar.tokenBlacklist.Consume(code); err == model.ErrorNotFound {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, "Authorization code has already been used", "exchangeAuthorizationCode.Consume")
+		return
+	} else if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "exchangeAuthorizationCode.Consume")
+		return
+	}
+
+	user, err := ar.userStorage.UserByID(codeToken.Subject())
+	if err != nil {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, err.Error(), "exchangeAuthorizationCode.UserByID")
+		return
+	}
+
+	tokenPayload, err := ar.getTokenPayloadForApp(app, user)
+	if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "exchangeAuthorizationCode.getTokenPayloadForApp")
+		return
+	}
+
+	scopes := strings.Fields(codeToken.Scopes())
+	offline := app.Offline && contains(scopes, jwtService.OfflineScope)
+
+	// The web session tells how and when the user has authenticated.
+	authCtx := codeAuthContext(payload)
+	accessToken, refreshToken, err := ar.loginUser(user, scopes, app, offline, false, tokenPayload, authCtx)
+	if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "exchangeAuthorizationCode.loginUser")
+		return
+	}
+
+	nonce, _ := payload[jwtService.PayloadNonce].(string)
+	idToken, err := ar.newIDToken(user, scopes, app, accessToken, nonce, authCtx.Time)
+	if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "exchangeAuthorizationCode.newIDToken")
+		return
+	}
+
+	ar.userStorage.UpdateLoginMetadata(user.ID)
+	ar.ServeJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    accessTokenLifespan(app),
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
+
+// issueClientCredentialsToken issues access token for the service app itself.
+// There is no refresh token for this grant, the app just requests the new access token when the old one expires.
+func (ar *Router) issueClientCredentialsToken(w http.ResponseWriter, r *http.Request) {
+	app, authenticated, err := ar.oauthClient(r)
+	if err != nil {
+		ar.oauthError(w, oauthErrorInvalidClient, http.StatusUnauthorized, err.Error(), "issueClientCredentialsToken.oauthClient")
+		return
+	}
+
+	if !authenticated || app.Type != model.Service {
+		ar.oauthError(w, oauthErrorUnauthorizedClient, http.StatusBadRequest, "Client credentials grant is available only for authenticated service apps", "issueClientCredentialsToken.Type")
+		return
+	}
+
+	// If client omits the scope, it gets all the scopes allowed for the app.
+	scopes := strings.Fields(r.PostFormValue("scope"))
+	if len(scopes) == 0 {
+		scopes = app.Scopes
+	}
+
+	token, err := ar.tokenService.NewClientAccessToken(app, scopes)
+	if err == jwtService.ErrInvalidScope {
+		ar.oauthError(w, oauthErrorInvalidScope, http.StatusBadRequest, err.Error(), "issueClientCredentialsToken.NewClientAccessToken")
+		return
+	}
+	if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "issueClientCredentialsToken.NewClientAccessToken")
+		return
+	}
+
+	tokenString, err := ar.tokenService.String(token)
+	if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "issueClientCredentialsToken.String")
+		return
+	}
+
+	ar.ServeJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken: tokenString,
+		TokenType:   "Bearer",
+		ExpiresIn:   accessTokenLifespan(app),
+		Scope:       strings.Join(scopes, " "),
+	})
+}
+
+// exchangeRefreshToken issues new access token, and new refresh token in the family of the old one (RFC 6749, section 6).
+// The old refresh token is invalidated, and its reuse revokes the family, like with RefreshTokens.
+// Client may narrow down the scopes of the access token, the new refresh token keeps the scopes of the old one.
+func (ar *Router) exchangeRefreshToken(w http.ResponseWriter, r *http.Request) {
+	app, _, err := ar.oauthClient(r)
+	if err != nil {
+		ar.oauthError(w, oauthErrorInvalidClient, http.StatusUnauthorized, err.Error(), "exchangeRefreshToken.oauthClient")
+		return
+	}
+
+	if !app.Offline {
+		ar.oauthError(w, oauthErrorUnauthorizedClient, http.StatusBadRequest, "Refresh tokens are not available for the app", "exchangeRefreshToken.Offline")
+		return
+	}
+
+	refreshTokenString := strings.TrimSpace(r.PostFormValue("refresh_token"))
+	if refreshTokenString == "" {
+		ar.oauthError(w, oauthErrorInvalidRequest, http.StatusBadRequest, "Refresh token is empty", "exchangeRefreshToken.refresh_token")
+		return
+	}
+
+	refreshToken, err := ar.tokenService.Parse(refreshTokenString)
+	if err != nil {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, "Refresh token is invalid or expired", "exchangeRefreshToken.Parse")
+		return
+	}
+	v := jwtValidator.NewValidator(
+		[]string{app.ID},
+		[]string{ar.tokenService.Issuer()},
+		[]string{},
+		[]string{model.TokenTypeRefresh},
+	)
+	if err := v.Validate(refreshToken); err != nil {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, "Refresh token is invalid or was issued to another client", "exchangeRefreshToken.Validate")
+		return
+	}
+
+	if ar.tokenBlacklist.IsBlacklisted(refreshTokenString) {
+		// Exchanged refresh token should never be presented again, its family gets revoked if it is.
+		ar.checkRefreshTokenFamily(refreshToken, refreshTokenString, app)
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, "Refresh token has already been used", "exchangeRefreshToken.IsBlacklisted")
+		return
+	}
+	if err := ar.checkRefreshTokenFamily(refreshToken, refreshTokenString, app); err != nil {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, err.Error(), "exchangeRefreshToken.checkRefreshTokenFamily")
+		return
+	}
+
+	// Requested scopes must not exceed the ones the user has granted, and are the same if omitted.
+	grantedScopes := strings.Fields(refreshToken.Scopes())
+	scopes := strings.Fields(r.PostFormValue("scope"))
+	if len(scopes) == 0 {
+		scopes = grantedScopes
+	}
+	for _, s := range scopes {
+		if !contains(grantedScopes, s) {
+			ar.oauthError(w, oauthErrorInvalidScope, http.StatusBadRequest, "Scope '"+s+"' has not been granted", "exchangeRefreshToken.scope")
+			return
+		}
+	}
+
+	user, err := ar.userStorage.UserByID(refreshToken.Subject())
+	if err != nil || !user.Active {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, "The user cannot obtain the new token", "exchangeRefreshToken.UserByID")
+		return
+	}
+
+	// Move the family on first, so only one of the concurrent exchanges of the token gets new tokens.
+	newRefreshToken, err := ar.rotateRefreshToken(refreshToken, refreshTokenString, grantedScopes, app)
+	if err == jwtService.ErrTokenFamilyRevoked {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, err.Error(), "exchangeRefreshToken.rotateRefreshToken")
+		return
+	} else if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "exchangeRefreshToken.rotateRefreshToken")
+		return
+	}
+	ar.invalidateOldRefreshToken(refreshTokenString)
+
+	tokenPayload, err := ar.getTokenPayloadForApp(app, user)
+	if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "exchangeRefreshToken.getTokenPayloadForApp")
+		return
+	}
+
+	accessToken, _, err := ar.loginUser(user, scopes, app, false, false, tokenPayload, model.AuthContext{})
+	if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "exchangeRefreshToken.loginUser")
+		return
+	}
+
+	ar.ServeJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    accessTokenLifespan(app),
+		RefreshToken: newRefreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
+
+// oauthClient returns the app that makes a token request, and whether the app has proven its identity.
+// Client authenticates either with the secret, passed in the basic auth header or in the form,
+// or with the JWT assertion signed with the secret (client_secret_jwt).
+// Public clients just send their ID.
+func (ar *Router) oauthClient(r *http.Request) (model.AppData, bool, error) {
+	if r.PostFormValue("client_assertion_type") == ClientAssertionTypeJWTBearer {
+		app, err := ar.verifyClientAssertion(r.PostFormValue("client_assertion"))
+		if err != nil {
+			return model.AppData{}, false, err
+		}
+		return app, true, nil
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostFormValue("client_id")
+		clientSecret = r.PostFormValue("client_secret")
+	}
+
+	app, err := ar.appStorage.ActiveAppByID(strings.TrimSpace(clientID))
+	if err != nil {
+		return model.AppData{}, false, errOAuthClientUnknown
+	}
+
+	if clientSecret == "" {
+		return app, false, nil
+	}
+	if app.Secret == "" || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(app.Secret)) != 1 {
+		return model.AppData{}, false, errOAuthClientSecretMismatch
+	}
+	return app, true, nil
+}
+
+// verifyClientAssertion verifies the client authentication JWT (RFC 7523, section 3), signed with HMAC using the app secret.
+// Both issuer and subject must be the app ID, and the audience must be either Identifo issuer or its token endpoint.
+// Each assertion could be used only once.
+func (ar *Router) verifyClientAssertion(assertion string) (model.AppData, error) {
+	unverified := jwt.StandardClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(assertion, &unverified); err != nil {
+		return model.AppData{}, errOAuthClientAssertionInvalid
+	}
+
+	app, err := ar.appStorage.ActiveAppByID(unverified.Subject)
+	if err != nil {
+		return model.AppData{}, errOAuthClientUnknown
+	}
+	if app.Secret == "" {
+		return model.AppData{}, errOAuthClientAssertionInvalid
+	}
+
+	claims := jwt.StandardClaims{}
+	_, err = jwt.ParseWithClaims(assertion, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errOAuthClientAssertionInvalid
+		}
+		return []byte(app.Secret), nil
+	})
+	if err != nil {
+		return model.AppData{}, errOAuthClientAssertionInvalid
+	}
+
+	issuer := ar.tokenService.Issuer()
+	validAudience := claims.VerifyAudience(issuer, true) || claims.VerifyAudience(issuer+"/oauth/token", true)
+	if claims.Issuer != app.ID || claims.Subject != app.ID || claims.ExpiresAt == 0 || !validAudience {
+		return model.AppData{}, errOAuthClientAssertionInvalid
+	}
+
+	// Two requests may present the same assertion at once, only the one that consumes it passes.
+	if err := ar.tokenBlacklist.Consume(assertion); err == model.ErrorNotFound {
+		return model.AppData{}, errOAuthClientAssertionReused
+	} else if err != nil {
+		return model.AppData{}, err
+	}
+	return app, nil
+}
+
+// codeAuthContext returns how and when the user has authenticated, as the authorization code payload tells.
+func codeAuthContext(payload map[string]interface{}) model.AuthContext {
+	authTime, _ := payload[jwtService.PayloadAuthTime].(float64) // JSON numbers are decoded as float64.
+	acr, _ := payload[jwtService.PayloadACR].(string)
+	authCtx := model.AuthContext{ACR: acr, Time: int64(authTime)}
+	methods, _ := payload[jwtService.PayloadAMR].([]interface{})
+	for _, m := range methods {
+		if method, ok := m.(string); ok {
+			authCtx.Methods = append(authCtx.Methods, method)
+		}
+	}
+	return authCtx
+}
+
+// oauthError writes an OAuth 2.0 error response, as described in RFC 6749, section 5.2.
+func (ar *Router) oauthError(w http.ResponseWriter, code string, status int, description, where string) {
+	ar.logger.Printf("oauth error: %v (status=%v). Details: %v. Where: %v.", code, status, description, where)
+
+	// Hide error details from client if it is internal.
+	if status == http.StatusInternalServerError {
+		description = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="identifo"`)
+	}
+	w.WriteHeader(status)
+	encodeErr := json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+	if encodeErr != nil {
+		ar.logger.Printf("error writing http response: %s", code)
+	}
+}
+
+// verifyCodeChallenge checks PKCE code verifier against the S256 code challenge (RFC 7636, section 4.6).
+func verifyCodeChallenge(verifier, challenge string) bool {
+	// Verifier must be 43 to 128 characters long.
+	if len(verifier) < 43 || len(verifier) > 128 || challenge == "" {
+		return false
+	}
+	s := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(s[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// accessTokenLifespan returns access token lifespan in seconds for the app.
+func accessTokenLifespan(app model.AppData) int64 {
+	if app.TokenLifespan > 0 {
+		return app.TokenLifespan
+	}
+	return jwtService.TokenLifespan
+}
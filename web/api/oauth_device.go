@@ -0,0 +1,251 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	jwtService "github.com/madappgang/identifo/jwt/service"
+	"github.com/madappgang/identifo/model"
+)
+
+const (
+	// GrantTypeDeviceCode is an OAuth 2.0 device authorization grant type (RFC 8628).
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// deviceCodeLifespan is a time the user has to enter the user code and approve the request.
+	deviceCodeLifespan = 10 * time.Minute
+	// deviceCodePollInterval is a minimum number of seconds the device should wait between polling requests.
+	deviceCodePollInterval = int64(5)
+	// userCodeAlphabet has no vowels and look-alike characters, as recommended in RFC 8628, section 6.1.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// Device authorization grant error codes, as described in RFC 8628, section 3.5.
+const (
+	oauthErrorAuthorizationPending = "authorization_pending"
+	oauthErrorSlowDown             = "slow_down"
+	oauthErrorAccessDenied         = "access_denied"
+	oauthErrorExpiredToken         = "expired_token"
+)
+
+// deviceAuthorizationResponse is a device authorization response (RFC 8628, section 3.2).
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// OAuthDeviceAuthorization is an OAuth 2.0 device authorization endpoint (RFC 8628).
+// Input-constrained devices, like TVs and CLIs, get the user code here and ask the user
+// to enter it on the verification page, while they poll the token endpoint with the device code.
+func (ar *Router) OAuthDeviceAuthorization() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		if err := r.ParseForm(); err != nil {
+			ar.oauthError(w, oauthErrorInvalidRequest, http.StatusBadRequest, err.Error(), "OAuthDeviceAuthorization.ParseForm")
+			return
+		}
+
+		app, _, err := ar.oauthClient(r)
+		if err != nil {
+			ar.oauthError(w, oauthErrorInvalidClient, http.StatusUnauthorized, err.Error(), "OAuthDeviceAuthorization.oauthClient")
+			return
+		}
+
+		if app.Type == model.Service {
+			ar.oauthError(w, oauthErrorUnauthorizedClient, http.StatusBadRequest, "Device authorization is not available for service apps", "OAuthDeviceAuthorization.Type")
+			return
+		}
+
+		dc := model.DeviceCode{
+			AppID:     app.ID,
+			Scopes:    strings.Fields(r.PostFormValue("scope")),
+			Status:    model.DeviceCodeStatusPending,
+			Interval:  deviceCodePollInterval,
+			ExpiresAt: time.Now().Add(deviceCodeLifespan),
+		}
+
+		// User codes are short, so retry in the unlikely case of collision.
+		for i := 0; i < 3; i++ {
+			if dc.DeviceCode, err = randomDeviceCode(); err != nil {
+				break
+			}
+			if dc.UserCode, err = randomUserCode(); err != nil {
+				break
+			}
+			if err = ar.deviceCodeStorage.CreateDeviceCode(dc); err != model.ErrorWrongDataFormat {
+				break
+			}
+		}
+		if err != nil {
+			ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "OAuthDeviceAuthorization.CreateDeviceCode")
+			return
+		}
+
+		host, err := url.Parse(ar.Host)
+		if err != nil {
+			ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "OAuthDeviceAuthorization.URL_parse")
+			return
+		}
+
+		userCode := dc.UserCode[:userCodeLength/2] + "-" + dc.UserCode[userCodeLength/2:]
+		verificationURI := &url.URL{
+			Scheme: host.Scheme,
+			Host:   host.Host,
+			Path:   path.Join(ar.WebRouterPrefix, "device"),
+		}
+
+		ar.ServeJSON(w, http.StatusOK, deviceAuthorizationResponse{
+			DeviceCode:              dc.DeviceCode,
+			UserCode:                userCode,
+			VerificationURI:         verificationURI.String(),
+			VerificationURIComplete: verificationURI.String() + "?" + url.Values{"user_code": []string{userCode}}.Encode(),
+			ExpiresIn:               int64(deviceCodeLifespan.Seconds()),
+			Interval:                dc.Interval,
+		})
+	}
+}
+
+// exchangeDeviceCode issues tokens for the device code, once the user has approved the request.
+// Until then, it asks the device to keep polling, and to slow down if it polls too often.
+func (ar *Router) exchangeDeviceCode(w http.ResponseWriter, r *http.Request) {
+	app, _, err := ar.oauthClient(r)
+	if err != nil {
+		ar.oauthError(w, oauthErrorInvalidClient, http.StatusUnauthorized, err.Error(), "exchangeDeviceCode.oauthClient")
+		return
+	}
+
+	deviceCode := strings.TrimSpace(r.PostFormValue("device_code"))
+	if deviceCode == "" {
+		ar.oauthError(w, oauthErrorInvalidRequest, http.StatusBadRequest, "Device code is empty", "exchangeDeviceCode.device_code")
+		return
+	}
+
+	dc, err := ar.deviceCodeStorage.DeviceCodeByDeviceCode(deviceCode)
+	if err != nil || dc.AppID != app.ID {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, "Device code is invalid", "exchangeDeviceCode.DeviceCodeByDeviceCode")
+		return
+	}
+
+	if dc.Expired() {
+		if err := ar.deviceCodeStorage.DeleteDeviceCode(deviceCode); err != nil {
+			ar.logger.Printf("Error deleting expired device code: %s", err)
+		}
+		ar.oauthError(w, oauthErrorExpiredToken, http.StatusBadRequest, "Device code has expired", "exchangeDeviceCode.Expired")
+		return
+	}
+
+	switch dc.Status {
+	case model.DeviceCodeStatusPending:
+		ar.pollPendingDeviceCode(w, dc)
+		return
+	case model.DeviceCodeStatusDenied:
+		if err := ar.deviceCodeStorage.DeleteDeviceCode(deviceCode); err != nil {
+			ar.logger.Printf("Error deleting denied device code: %s", err)
+		}
+		ar.oauthError(w, oauthErrorAccessDenied, http.StatusBadRequest, "The user has denied the request", "exchangeDeviceCode.Denied")
+		return
+	}
+
+	// Device code is single use, so we delete it right away. Only one of the concurrent polls gets the tokens.
+	if err := ar.deviceCodeStorage.ConsumeDeviceCode(deviceCode); err == model.ErrorNotFound {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, "Device code has already been used", "exchangeDeviceCode.ConsumeDeviceCode")
+		return
+	} else if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "exchangeDeviceCode.ConsumeDeviceCode")
+		return
+	}
+
+	user, err := ar.userStorage.UserByID(dc.UserID)
+	if err != nil || !user.Active {
+		ar.oauthError(w, oauthErrorInvalidGrant, http.StatusBadRequest, "The user cannot obtain the new token", "exchangeDeviceCode.UserByID")
+		return
+	}
+
+	tokenPayload, err := ar.getTokenPayloadForApp(app, user)
+	if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "exchangeDeviceCode.getTokenPayloadForApp")
+		return
+	}
+
+	offline := app.Offline && contains(dc.Scopes, jwtService.OfflineScope)
+
+	authCtx := model.AuthContext{Methods: dc.AMR, ACR: dc.ACR, Time: dc.AuthTime}
+	accessToken, refreshToken, err := ar.loginUser(user, dc.Scopes, app, offline, false, tokenPayload, authCtx)
+	if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "exchangeDeviceCode.loginUser")
+		return
+	}
+
+	idToken, err := ar.newIDToken(user, dc.Scopes, app, accessToken, "", dc.AuthTime)
+	if err != nil {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "exchangeDeviceCode.newIDToken")
+		return
+	}
+
+	ar.userStorage.UpdateLoginMetadata(user.ID)
+	ar.ServeJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    accessTokenLifespan(app),
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+		Scope:        strings.Join(dc.Scopes, " "),
+	})
+}
+
+// pollPendingDeviceCode answers to the device polling for the request the user has not approved yet.
+// Devices polling more often than the interval are asked to slow down, and the interval is increased by 5 seconds (RFC 8628, section 3.5).
+func (ar *Router) pollPendingDeviceCode(w http.ResponseWriter, dc model.DeviceCode) {
+	now := time.Now()
+	tooFast := now.Sub(dc.LastPolledAt) < time.Duration(dc.Interval)*time.Second
+	interval := dc.Interval
+	if tooFast {
+		interval += 5
+	}
+
+	// The user may have just approved or denied the request, then the device learns it on the next poll.
+	if err := ar.deviceCodeStorage.UpdateDeviceCodePoll(dc.DeviceCode, interval, now); err != nil && err != model.ErrorNotFound {
+		ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "pollPendingDeviceCode.UpdateDeviceCodePoll")
+		return
+	}
+
+	if tooFast {
+		ar.oauthError(w, oauthErrorSlowDown, http.StatusBadRequest, "Polling too frequently", "pollPendingDeviceCode.slow_down")
+		return
+	}
+	ar.oauthError(w, oauthErrorAuthorizationPending, http.StatusBadRequest, "The user has not yet approved the request", "pollPendingDeviceCode.authorization_pending")
+}
+
+// randomDeviceCode generates the device code, which is a secret shared with the device only.
+func randomDeviceCode() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// randomUserCode generates the user code, which is short enough to be typed by the user.
+func randomUserCode() (string, error) {
+	b := make([]byte, userCodeLength)
+	for i := range b {
+		k, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = userCodeAlphabet[k.Int64()]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	ijwt "github.com/madappgang/identifo/jwt"
+	jwtValidator "github.com/madappgang/identifo/jwt/validator"
+	"github.com/madappgang/identifo/model"
+)
+
+// introspectionResponse is a token introspection response (RFC 7662, section 2.2).
+// Inactive tokens are reported with "active" field only.
+type introspectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+}
+
+// OAuthIntrospect is an OAuth 2.0 token introspection endpoint (RFC 7662).
+// It lets resource servers check if access or refresh token, issued by Identifo, is still active.
+// Only authenticated clients are allowed to introspect tokens.
+func (ar *Router) OAuthIntrospect() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		if err := r.ParseForm(); err != nil {
+			ar.oauthError(w, oauthErrorInvalidRequest, http.StatusBadRequest, err.Error(), "OAuthIntrospect.ParseForm")
+			return
+		}
+
+		if _, authenticated, err := ar.oauthClient(r); err != nil || !authenticated {
+			ar.oauthError(w, oauthErrorInvalidClient, http.StatusUnauthorized, "Client authentication is required", "OAuthIntrospect.oauthClient")
+			return
+		}
+
+		tokenString := strings.TrimSpace(r.PostFormValue("token"))
+		if tokenString == "" {
+			ar.oauthError(w, oauthErrorInvalidRequest, http.StatusBadRequest, "Token is empty", "OAuthIntrospect.token")
+			return
+		}
+
+		token, user, ok := ar.activeToken(tokenString)
+		if !ok {
+			ar.ServeJSON(w, http.StatusOK, introspectionResponse{Active: false})
+			return
+		}
+
+		ar.ServeJSON(w, http.StatusOK, introspectionResponse{
+			Active:    true,
+			Scope:     token.Scopes(),
+			ClientID:  token.Audience()[0],
+			Username:  user.Username,
+			TokenType: token.Type(),
+			Exp:       token.ExpiresAt().Unix(),
+			Iat:       token.IssuedAt().Unix(),
+			Sub:       token.Subject(),
+			Aud:       token.Audience(),
+			Iss:       token.Issuer(),
+		})
+	}
+}
+
+// OAuthRevoke is an OAuth 2.0 token revocation endpoint (RFC 7009).
+// It accepts either access or refresh token, and the token must have been issued to the requesting client.
+// Invalid and already revoked tokens are not reported as errors, as the spec requires.
+func (ar *Router) OAuthRevoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			ar.oauthError(w, oauthErrorInvalidRequest, http.StatusBadRequest, err.Error(), "OAuthRevoke.ParseForm")
+			return
+		}
+
+		app, _, err := ar.oauthClient(r)
+		if err != nil {
+			ar.oauthError(w, oauthErrorInvalidClient, http.StatusUnauthorized, err.Error(), "OAuthRevoke.oauthClient")
+			return
+		}
+
+		tokenString := strings.TrimSpace(r.PostFormValue("token"))
+		if tokenString == "" {
+			ar.oauthError(w, oauthErrorInvalidRequest, http.StatusBadRequest, "Token is empty", "OAuthRevoke.token")
+			return
+		}
+
+		token, err := ar.tokenService.Parse(tokenString)
+		if err != nil {
+			ar.logger.Printf("Revocation of invalid token has been requested: %s", err)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if !contains(token.Audience(), app.ID) {
+			ar.oauthError(w, oauthErrorUnauthorizedClient, http.StatusBadRequest, "Token was issued to another client", "OAuthRevoke.Audience")
+			return
+		}
+
+		switch token.Type() {
+		case model.TokenTypeRefresh:
+			if err := ar.tokenStorage.DeleteToken(tokenString); err != nil {
+				ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "OAuthRevoke.DeleteToken")
+				return
+			}
+		case model.TokenTypeAccess:
+			// Access tokens are not stored, blacklisting is enough.
+		default:
+			ar.oauthError(w, "unsupported_token_type", http.StatusBadRequest, "Only access and refresh tokens could be revoked", "OAuthRevoke.Type")
+			return
+		}
+
+		if err := ar.tokenBlacklist.Add(tokenString); err != nil {
+			ar.oauthError(w, oauthErrorServerError, http.StatusInternalServerError, err.Error(), "OAuthRevoke.Add")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// activeToken parses the token and checks if it is still active.
+// Token is active if it is a valid access or refresh token, which has not been revoked,
+// and both the app and the user (if any) it has been issued to are active.
+// Refresh tokens must also be present in the token storage.
+func (ar *Router) activeToken(tokenString string) (ijwt.Token, model.User, bool) {
+	token, err := ar.tokenService.Parse(tokenString)
+	if err != nil {
+		return nil, model.User{}, false
+	}
+
+	tokenType := token.Type()
+	if tokenType != model.TokenTypeAccess && tokenType != model.TokenTypeRefresh {
+		return nil, model.User{}, false
+	}
+
+	if len(token.Audience()) == 0 {
+		return nil, model.User{}, false
+	}
+
+	app, err := ar.appStorage.ActiveAppByID(token.Audience()[0])
+	if err != nil {
+		return nil, model.User{}, false
+	}
+
+	v := jwtValidator.NewValidator(
+		[]string{app.ID},
+		[]string{ar.tokenService.Issuer()},
+		[]string{},
+		[]string{tokenType},
+	)
+	if err := v.Validate(token); err != nil {
+		return nil, model.User{}, false
+	}
+
+	if ar.tokenBlacklist.IsBlacklisted(tokenString) {
+		return nil, model.User{}, false
+	}
+
+	if tokenType == model.TokenTypeRefresh {
+		if !ar.tokenStorage.HasToken(tokenString) {
+			return nil, model.User{}, false
+		}
+	}
+
+	// Tokens, issued with client credentials grant, have the app as a subject.
+	if token.Subject() == app.ID {
+		return token, model.User{}, true
+	}
+
+	user, err := ar.userStorage.UserByID(token.Subject())
+	if err != nil || !user.Active {
+		return nil, model.User{}, false
+	}
+	return token, user, true
+}
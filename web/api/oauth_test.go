@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/storage/mem"
+)
+
+// Test_verifyCodeChallenge uses the example from RFC 7636, appendix B.
+func Test_verifyCodeChallenge(t *testing.T) {
+	const (
+		verifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	)
+
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		{"valid verifier", verifier, challenge, true},
+		{"wrong verifier", "aBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", challenge, false},
+		{"plain challenge", verifier, verifier, false},
+		{"short verifier", "dBjftJeZ4CVP", challenge, false},
+		{"empty verifier", "", challenge, false},
+		{"empty challenge", verifier, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyCodeChallenge(tt.verifier, tt.challenge); got != tt.want {
+				t.Errorf("verifyCodeChallenge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test_exchangeAuthorizationCode_confidentialClient checks that apps with the secret could not redeem codes with PKCE alone.
+func Test_exchangeAuthorizationCode_confidentialClient(t *testing.T) {
+	as, _ := mem.NewAppStorage()
+	if _, err := as.CreateApp(model.AppData{ID: "confidential", Secret: "secret", Active: true}); err != nil {
+		t.Fatalf("CreateApp() error = %v", err)
+	}
+	ar := &Router{logger: log.New(ioutil.Discard, "", 0), appStorage: as}
+
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{"no secret", ""},
+		{"wrong secret", "wrong"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"grant_type": {"authorization_code"}, "client_id": {"confidential"}, "code": {"code"}, "code_verifier": {"verifier"}}
+			if tt.secret != "" {
+				form.Set("client_secret", tt.secret)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			ar.exchangeAuthorizationCode(w, r)
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if w.Code != http.StatusUnauthorized || resp["error"] != oauthErrorInvalidClient {
+				t.Errorf("exchangeAuthorizationCode() = %d %v, want %d %v", w.Code, resp["error"], http.StatusUnauthorized, oauthErrorInvalidClient)
+			}
+		})
+	}
+}
+
+// Test_codeAuthContext checks that the authorization code payload, decoded from JSON, keeps how the user has authenticated.
+func Test_codeAuthContext(t *testing.T) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"auth_time":1600000000,"amr":["pwd","otp"],"acr":"2"}`), &payload); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	got := codeAuthContext(payload)
+	want := model.AuthContext{Methods: []string{model.AMRPassword, model.AMROTP}, ACR: model.ACRMultiFactor, Time: 1600000000}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("codeAuthContext() = %+v, want %+v", got, want)
+	}
+
+	if got := codeAuthContext(map[string]interface{}{}); !reflect.DeepEqual(got, model.AuthContext{}) {
+		t.Errorf("codeAuthContext() of empty payload = %+v, want empty context", got)
+	}
+}
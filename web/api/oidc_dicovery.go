@@ -3,28 +3,43 @@ package api
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"encoding/base64"
 	"math/big"
 	"net/http"
 	"time"
 
+	jwtService "github.com/madappgang/identifo/jwt/service"
 	"github.com/madappgang/identifo/model"
 )
 
 // OIDCConfiguration describes OIDC configuration.
 // Additional info: https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata.
-// Identifo is not an OIDC provider, that's why we only provide the information for token validation.
 type OIDCConfiguration struct {
-	Issuer                 string   `json:"issuer"`
-	JwksURI                string   `json:"jwks_uri"`
-	ScopesSupported        []string `json:"scopes_supported"`
-	SupportedIDSigningAlgs []string `json:"id_token_signing_alg_values_supported"`
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	SupportedIDSigningAlgs            []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	ACRValuesSupported                []string `json:"acr_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgs      []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
 }
 
 type jwk struct {
 	Alg string   `json:"alg,omitempty"` // The "alg" (algorithm) parameter identifies the algorithm intended for use with the key.
-	Kty string   `json:"kty,omitempty"` //"EC" | "RSA".  The "kty" (key type) parameter identifies the cryptographic algorithm family used with the key, such as "RSA" or "EC".
+	Kty string   `json:"kty,omitempty"` //"EC" | "RSA" | "OKP".  The "kty" (key type) parameter identifies the cryptographic algorithm family used with the key, such as "RSA" or "EC".
 	Use string   `json:"use,omitempty"` //"sig". The "use" (public key use) parameter identifies the intended use of the public key.  The "use" parameter is employed to indicate whether a public key is used for encrypting data or verifying the signature on data.
 	X5c []string `json:"x5c,omitempty"` //The "x5c" (X.509 certificate chain) parameter contains a chain of one
 	//or more PKIX certificates [RFC5280].  The certificate chain is
@@ -40,7 +55,7 @@ type jwk struct {
 	E string `json:"e,omitempty"` //The RSA Key blinding operation [Kocher], which is a defense against
 	//some timing attacks, requires all of the RSA key values "n", "e", and
 	//"d".
-	Crv string `json:"crv,omitempty"` //P-256
+	Crv string `json:"crv,omitempty"` //P-256 | P-384 | P-521 | Ed25519
 	X   string `json:"x,omitempty"`   //It is represented as the base64url encoding of
 	//the octet string representation of the coordinate, as defined in
 	//Section 2.3.5 of SEC1 [SEC1].
@@ -58,11 +73,35 @@ type jwk struct {
 func (ar *Router) OIDCConfiguration() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if ar.oidcConfiguration == nil {
+			scopes := []string{jwtService.OpenIDScope, jwtService.ProfileScope, jwtService.EmailScope, jwtService.PhoneScope}
+			for _, s := range ar.userStorage.Scopes() {
+				if !contains(scopes, s) {
+					scopes = append(scopes, s)
+				}
+			}
+
 			ar.oidcConfiguration = &OIDCConfiguration{
-				Issuer:                 ar.tokenService.Issuer(),
-				JwksURI:                ar.tokenService.Issuer() + "/.well-known/jwks.json",
-				ScopesSupported:        ar.userStorage.Scopes(),
-				SupportedIDSigningAlgs: []string{ar.tokenService.Algorithm()},
+				Issuer:                      ar.tokenService.Issuer(),
+				AuthorizationEndpoint:       ar.tokenService.Issuer() + "/oauth/authorize",
+				TokenEndpoint:               ar.tokenService.Issuer() + "/oauth/token",
+				UserInfoEndpoint:            ar.tokenService.Issuer() + "/userinfo",
+				IntrospectionEndpoint:       ar.tokenService.Issuer() + "/oauth/introspect",
+				RevocationEndpoint:          ar.tokenService.Issuer() + "/oauth/revoke",
+				DeviceAuthorizationEndpoint: ar.tokenService.Issuer() + "/oauth/device_authorization",
+				JwksURI:                     ar.tokenService.Issuer() + "/.well-known/jwks.json",
+				ScopesSupported:             scopes,
+				ResponseTypesSupported:      []string{"code"},
+				GrantTypesSupported:         []string{GrantTypeAuthorizationCode, GrantTypeClientCredentials, GrantTypeDeviceCode, GrantTypeRefreshToken},
+				SubjectTypesSupported:       []string{"public"},
+				SupportedIDSigningAlgs:      []string{ar.tokenService.Algorithm()},
+				ClaimsSupported: []string{
+					"iss", "sub", "aud", "exp", "iat", "auth_time", "amr", "acr", "nonce", "at_hash",
+					"preferred_username", "email", "email_verified", "phone_number", "phone_number_verified",
+				},
+				ACRValuesSupported:                []string{model.ACRSingleFactor, model.ACRMultiFactor},
+				TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "client_secret_jwt", "none"},
+				TokenEndpointAuthSigningAlgs:      []string{"HS256", "HS384", "HS512"},
+				CodeChallengeMethodsSupported:     []string{CodeChallengeMethodS256},
 			}
 		}
 		ar.ServeJSON(w, http.StatusOK, ar.oidcConfiguration)
@@ -70,60 +109,69 @@ func (ar *Router) OIDCConfiguration() http.HandlerFunc {
 }
 
 // OIDCJwks returns JSON Web Keys object.
-// Identifo supports two algorithms for signing JSON Web Tokens (JWTs): RS256 and ES256.
-// RS256 and ES256 generate an asymmetric signature, which means a private key must be used to sign the JWT,
+// Identifo supports these algorithms for signing JSON Web Tokens (JWTs): ES256, ES384, ES512, RS256, PS256 and EdDSA.
+// All of them generate an asymmetric signature, which means a private key must be used to sign the JWT,
 // and a different public key must be used to verify the signature.
 //
 // At the most basic level, the JWKS is a set of keys containing the public keys that should
 // be used to verify any JWT issued by the authorization server.
 // This endpoint exposes a JWKS endpoint for each tenant, which can be found at https://YOUR_IDENTIFO_DOMAIN/.well-known/jwks.json.
-// Currently Identifo only supports a single JWK for signing,
-// however it is important to assume this endpoint technically could contain multiple JWKs.
+// During the key rotation, it contains the next key, the active key and the previous key until it retires,
+// so clients could verify tokens signed with any of them. The "kid" token header tells which key to use.
 func (ar *Router) OIDCJwks() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if ar.jwk != nil {
-			// A JSON object that represents a set of JWKs. The JSON object MUST have a keys member, which is an array of JWKs.
-			result := map[string]interface{}{"keys": []interface{}{ar.jwk}}
-			ar.ServeJSON(w, http.StatusOK, result)
-			return
+		// Key states depend on time, so the key set is not cached.
+		keys := []interface{}{}
+		for _, k := range ar.tokenService.VerificationKeys() {
+			keys = append(keys, newJWK(ar.tokenService.Algorithm(), k.ID(), k.Public))
 		}
 
-		ar.jwk = &jwk{
-			Use: "sig",
-			Alg: ar.tokenService.Algorithm(),
-			Kid: ar.tokenService.KeyID(),
-		}
+		// A JSON object that represents a set of JWKs. The JSON object MUST have a keys member, which is an array of JWKs.
+		result := map[string]interface{}{"keys": keys}
+		ar.ServeJSON(w, http.StatusOK, result)
+	}
+}
 
-		switch pub := ar.tokenService.PublicKey().(type) {
-		case *rsa.PublicKey:
-			// https://tools.ietf.org/html/rfc7518#section-6.3.1
-			ar.jwk.Kty = "RSA"
-			ar.jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
-			ar.jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
-		case *ecdsa.PublicKey:
-			// https://tools.ietf.org/html/rfc7518#section-6.2.1
-			p := pub.Curve.Params()
-			n := p.BitSize / 8
-			if p.BitSize%8 != 0 {
-				n++
-			}
-			x := pub.X.Bytes()
-			if n > len(x) {
-				x = append(make([]byte, n-len(x)), x...)
-			}
-			y := pub.Y.Bytes()
-			if n > len(y) {
-				y = append(make([]byte, n-len(y)), y...)
-			}
-			ar.jwk.Kty = "EC"
-			ar.jwk.Crv = p.Name
-			ar.jwk.X = base64.RawURLEncoding.EncodeToString(x)
-			ar.jwk.Y = base64.RawURLEncoding.EncodeToString(y)
-		}
+// newJWK makes JSON Web Key of the public key.
+func newJWK(alg, kid string, publicKey interface{}) *jwk {
+	key := &jwk{
+		Use: "sig",
+		Alg: alg,
+		Kid: kid,
+	}
 
-		result := map[string]interface{}{"keys": []interface{}{ar.jwk}}
-		ar.ServeJSON(w, http.StatusOK, result)
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		// https://tools.ietf.org/html/rfc7518#section-6.3.1
+		key.Kty = "RSA"
+		key.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// https://tools.ietf.org/html/rfc7518#section-6.2.1
+		p := pub.Curve.Params()
+		n := p.BitSize / 8
+		if p.BitSize%8 != 0 {
+			n++
+		}
+		x := pub.X.Bytes()
+		if n > len(x) {
+			x = append(make([]byte, n-len(x)), x...)
+		}
+		y := pub.Y.Bytes()
+		if n > len(y) {
+			y = append(make([]byte, n-len(y)), y...)
+		}
+		key.Kty = "EC"
+		key.Crv = p.Name
+		key.X = base64.RawURLEncoding.EncodeToString(x)
+		key.Y = base64.RawURLEncoding.EncodeToString(y)
+	case ed25519.PublicKey:
+		// https://tools.ietf.org/html/rfc8037#section-2
+		key.Kty = "OKP"
+		key.Crv = "Ed25519"
+		key.X = base64.RawURLEncoding.EncodeToString(pub)
 	}
+	return key
 }
 
 // ServeADDAFile lets Apple servers download apple-developer-domain-association.txt.
@@ -7,11 +7,13 @@ import (
 	"fmt"
 	"math/big"
 	"net/http"
+	"time"
 
 	jwtService "github.com/madappgang/identifo/jwt/service"
 	"github.com/madappgang/identifo/model"
 	"github.com/madappgang/identifo/web/authorization"
 	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/ratelimit"
 )
 
 const (
@@ -39,7 +41,11 @@ func (ar *Router) RequestVerificationCode() http.HandlerFunc {
 			return
 		}
 
-		// TODO: add limiter here. Check frequency of requests.
+		app := middleware.AppFromContext(r.Context())
+		if ar.rateLimited(w, r, ratelimit.PolicyPhoneCode, ratelimit.Request{Phone: authData.PhoneNumber, App: app.ID}, "RequestVerificationCode") {
+			return
+		}
+
 		code := randStringBytes(phoneVerificationCodeLength)
 		if err := ar.verificationCodeStorage.CreateVerificationCode(authData.PhoneNumber, code); err != nil {
 			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "RequestVerificationCode.CreateVerificationCode")
@@ -77,6 +83,10 @@ func (ar *Router) PhoneLogin() http.HandlerFunc {
 			return
 		}
 
+		if ar.rateLimited(w, r, ratelimit.PolicyPhoneLogin, ratelimit.Request{Phone: authData.PhoneNumber, App: app.ID}, "PhoneLogin") {
+			return
+		}
+
 		needVerification := app.DebugTFACode == "" || authData.Code != app.DebugTFACode
 		if needVerification { // check verification code
 			if exists, err := ar.verificationCodeStorage.IsVerificationCodeFound(authData.PhoneNumber, authData.Code); err != nil {
@@ -97,6 +107,15 @@ func (ar *Router) PhoneLogin() http.HandlerFunc {
 			return
 		}
 
+		// User has just confirmed the phone number with the verification code.
+		if !user.PhoneVerified {
+			user.PhoneVerified = true
+			if user, err = ar.userStorage.UpdateUser(user.ID, user); err != nil {
+				ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "PhoneLogin.UpdateUser")
+				return
+			}
+		}
+
 		// Authorize user if the app requires authorization.
 		azi := authorization.AuthzInfo{
 			App:         app,
@@ -122,16 +141,23 @@ func (ar *Router) PhoneLogin() http.HandlerFunc {
 		}
 
 		offline := contains(scopes, jwtService.OfflineScope)
-		accessToken, refreshToken, err := ar.loginUser(user, scopes, app, offline, false, tokenPayload)
+		accessToken, refreshToken, err := ar.loginUser(user, scopes, app, offline, false, tokenPayload, model.NewAuthContext(model.AMRSMS))
 		if err != nil {
 			ar.Error(w, ErrorAPIAppAccessTokenNotCreated, http.StatusInternalServerError, err.Error(), "PhoneLogin.loginUser")
 			return
 		}
 
+		idToken, err := ar.newIDToken(user, scopes, app, accessToken, "", time.Now().Unix())
+		if err != nil {
+			ar.Error(w, ErrorAPIAppAccessTokenNotCreated, http.StatusInternalServerError, err.Error(), "PhoneLogin.newIDToken")
+			return
+		}
+
 		user = user.Sanitized()
 		result := AuthResponse{
 			AccessToken:  accessToken,
 			RefreshToken: refreshToken,
+			IDToken:      idToken,
 			User:         user,
 		}
 
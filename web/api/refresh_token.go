@@ -1,8 +1,11 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
+	ijwt "github.com/madappgang/identifo/jwt"
 	jwtService "github.com/madappgang/identifo/jwt/service"
 	"github.com/madappgang/identifo/model"
 	"github.com/madappgang/identifo/web/middleware"
@@ -10,6 +13,7 @@ import (
 
 // RefreshTokens issues new access and, if requsted, refresh token for provided refresh token.
 // After new tokens are issued, the old refresh token gets invalidated (via blacklisting).
+// Only the current token of the refresh token family could be exchanged, reuse of the earlier one revokes the family.
 func (ar *Router) RefreshTokens() http.HandlerFunc {
 	type requestData struct {
 		Scopes []string `json:"scopes,omitempty"`
@@ -35,6 +39,27 @@ func (ar *Router) RefreshTokens() http.HandlerFunc {
 
 		// Get refresh token from context.
 		oldRefreshToken := tokenFromContext(r.Context())
+		oldRefreshTokenBytes, ok := r.Context().Value(model.TokenRawContextKey).([]byte)
+		if !ok || oldRefreshTokenBytes == nil {
+			ar.Error(w, ErrorAPIAppRefreshTokenNotCreated, http.StatusInternalServerError, "Token is empty or invalid.", "RefreshTokens.RawTokenFromContext")
+			return
+		}
+		oldRefreshTokenString := string(oldRefreshTokenBytes)
+
+		if err := ar.checkRefreshTokenFamily(oldRefreshToken, oldRefreshTokenString, app); err != nil {
+			ar.Error(w, ErrorAPIRequestTokenInvalid, http.StatusBadRequest, err.Error(), "RefreshTokens.checkRefreshTokenFamily")
+			return
+		}
+
+		// Move the family on first, so only one of the concurrent exchanges of the token gets new tokens.
+		newRefreshTokenString, err := ar.rotateRefreshToken(oldRefreshToken, oldRefreshTokenString, rd.Scopes, app)
+		if err == jwtService.ErrTokenFamilyRevoked {
+			ar.Error(w, ErrorAPIRequestTokenInvalid, http.StatusBadRequest, err.Error(), "RefreshTokens.rotateRefreshToken")
+			return
+		} else if err != nil {
+			ar.Error(w, ErrorAPIAppRefreshTokenNotCreated, http.StatusInternalServerError, err.Error(), "RefreshTokens.rotateRefreshToken")
+			return
+		}
 
 		// Issue new access token and stringify it for response.
 		accessToken, err := ar.tokenService.RefreshAccessToken(oldRefreshToken)
@@ -48,20 +73,6 @@ func (ar *Router) RefreshTokens() http.HandlerFunc {
 			return
 		}
 
-		// Stringify old refresh token and issue new one.
-		oldRefreshTokenBytes, ok := r.Context().Value(model.TokenRawContextKey).([]byte)
-		if !ok || oldRefreshTokenBytes == nil {
-			ar.Error(w, ErrorAPIAppRefreshTokenNotCreated, http.StatusInternalServerError, "Token is empty or invalid.", "RefreshTokens.RawTokenFromContext")
-			return
-		}
-		oldRefreshTokenString := string(oldRefreshTokenBytes)
-
-		newRefreshTokenString, err := ar.issueNewRefreshToken(oldRefreshTokenString, rd.Scopes, app)
-		if err != nil {
-			ar.Error(w, ErrorAPIAppRefreshTokenNotCreated, http.StatusInternalServerError, err.Error(), "RefreshToken.newRefreshTokenString")
-			return
-		}
-
 		// Invalidate old refresh token - delete it from token storage and add to blacklist.
 		ar.invalidateOldRefreshToken(oldRefreshTokenString)
 
@@ -74,7 +85,17 @@ func (ar *Router) RefreshTokens() http.HandlerFunc {
 	}
 }
 
-func (ar *Router) issueNewRefreshToken(oldRefreshTokenString string, scopes []string, app model.AppData) (string, error) {
+// rotateRefreshToken issues new refresh token in the family of the old one, if requested.
+// Otherwise, the family ends with the old token. Either way, the old token must still be the current one.
+func (ar *Router) rotateRefreshToken(oldRefreshToken ijwt.Token, oldRefreshTokenString string, scopes []string, app model.AppData) (string, error) {
+	newRefreshTokenString, err := ar.issueNewRefreshToken(oldRefreshTokenString, oldRefreshToken.FamilyID(), scopes, app)
+	if err != nil || len(newRefreshTokenString) > 0 {
+		return newRefreshTokenString, err
+	}
+	return "", ar.endRefreshTokenFamily(oldRefreshToken.FamilyID(), oldRefreshTokenString)
+}
+
+func (ar *Router) issueNewRefreshToken(oldRefreshTokenString, familyID string, scopes []string, app model.AppData) (string, error) {
 	if !contains(scopes, jwtService.OfflineScope) { // Don't issue new refresh token if not requested.
 		return "", nil
 	}
@@ -89,7 +110,7 @@ func (ar *Router) issueNewRefreshToken(oldRefreshTokenString string, scopes []st
 		return "", err
 	}
 
-	refreshToken, err := ar.tokenService.NewRefreshToken(user, scopes, app)
+	refreshToken, err := ar.tokenService.RotateRefreshToken(user, scopes, app, familyID, oldRefreshTokenString)
 	if err != nil {
 		return "", err
 	}
@@ -111,3 +132,85 @@ func (ar *Router) invalidateOldRefreshToken(oldRefreshTokenString string) {
 	}
 	ar.logger.Println("Old refresh token successfully invalidated")
 }
+
+// checkRefreshTokenFamily makes sure the refresh token is the current token of its family.
+// Otherwise, the token has already been exchanged and someone is reusing it. We cannot tell
+// the legitimate client from the attacker, so the whole family gets revoked.
+func (ar *Router) checkRefreshTokenFamily(token ijwt.Token, tokenString string, app model.AppData) error {
+	familyID := token.FamilyID()
+	if len(familyID) == 0 {
+		return nil // Token has been issued before refresh token families were introduced.
+	}
+
+	family, err := ar.tokenStorage.TokenFamily(familyID)
+	if err != nil {
+		return err
+	}
+	if family.Revoked {
+		return jwtService.ErrTokenFamilyRevoked
+	}
+	if family.CurrentToken != tokenString {
+		ar.revokeRefreshTokenFamily(family, app)
+		return jwtService.ErrTokenFamilyRevoked
+	}
+	return nil
+}
+
+// revokeRefreshTokenFamily invalidates the current token of the family, so no more tokens could be issued in it.
+func (ar *Router) revokeRefreshTokenFamily(family model.TokenFamily, app model.AppData) {
+	ar.logger.Printf("Refresh token reuse detected, revoking token family %s of user %s\n", family.ID, family.UserID)
+	if len(family.CurrentToken) > 0 {
+		ar.invalidateOldRefreshToken(family.CurrentToken)
+	}
+
+	family.Revoked = true
+	family.CurrentToken = ""
+	family.UpdatedAt = time.Now()
+	if err := ar.tokenStorage.SaveTokenFamily(family); err != nil {
+		ar.logger.Println("Cannot revoke refresh token family:", err)
+	}
+
+	if app.NotifyRefreshTokenReuse {
+		ar.notifyRefreshTokenReuse(family.UserID, app)
+	}
+}
+
+// endRefreshTokenFamily marks the family as having no current token, when the last one is exchanged
+// for the access token only. Reuse of any token of the family is still detected.
+func (ar *Router) endRefreshTokenFamily(familyID, currentToken string) error {
+	if len(familyID) == 0 {
+		return nil
+	}
+
+	family, err := ar.tokenStorage.TokenFamily(familyID)
+	if err != nil {
+		return err
+	}
+
+	family.CurrentToken = ""
+	family.UpdatedAt = time.Now()
+	if err := ar.tokenStorage.SwapTokenFamily(family, currentToken); err == model.ErrorNotFound {
+		return jwtService.ErrTokenFamilyRevoked
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// notifyRefreshTokenReuse lets the user know that their refresh token could have been stolen.
+func (ar *Router) notifyRefreshTokenReuse(userID string, app model.AppData) {
+	user, err := ar.userStorage.UserByID(userID)
+	if err != nil {
+		ar.logger.Println("Cannot get user to notify about refresh token reuse:", err)
+		return
+	}
+	if len(user.Email) == 0 {
+		return
+	}
+
+	body := fmt.Sprintf("Your session in %s has been used from another device after it had been renewed, so we have signed you out of it. "+
+		"If you have not noticed anything unusual, please sign in again and consider changing your password.", app.Name)
+	if err := ar.emailService.SendMessage("Suspicious sign-in activity", body, user.Email); err != nil {
+		ar.logger.Println("Cannot notify user about refresh token reuse:", err)
+	}
+}
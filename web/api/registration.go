@@ -7,6 +7,7 @@ import (
 	"github.com/madappgang/identifo/model"
 	"github.com/madappgang/identifo/web/authorization"
 	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/ratelimit"
 )
 
 type registrationData struct {
@@ -83,6 +84,10 @@ func (ar *Router) RegisterWithPassword() http.HandlerFunc {
 			return
 		}
 
+		if ar.rateLimited(w, r, ratelimit.PolicyRegister, ratelimit.Request{App: app.ID}, "RegisterWithPassword") {
+			return
+		}
+
 		// Validate password.
 		if err := model.StrongPswd(rd.Password); err != nil {
 			ar.Error(w, ErrorAPIRequestPasswordWeak, http.StatusBadRequest, err.Error(), "RegisterWithPassword.StrongPswd")
@@ -101,7 +106,7 @@ func (ar *Router) RegisterWithPassword() http.HandlerFunc {
 		}
 
 		// Do login flow.
-		authResult, err := ar.loginFlow(app, user, rd.Scopes)
+		authResult, err := ar.loginFlow(app, user, rd.Scopes, "", model.NewAuthContext(model.AMRPassword))
 		if err != nil {
 			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "RegisterWithPassword.LoginFlowError")
 			return
@@ -7,6 +7,8 @@ import (
 	"path"
 
 	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/ratelimit"
 )
 
 // RequestResetPassword requests password reset.
@@ -25,6 +27,11 @@ func (ar *Router) RequestResetPassword() http.HandlerFunc {
 			return
 		}
 
+		app := middleware.AppFromContext(r.Context())
+		if ar.rateLimited(w, r, ratelimit.PolicyResetPassword, ratelimit.Request{User: d.Email, App: app.ID}, "RequestResetPassword") {
+			return
+		}
+
 		if userExists := ar.userStorage.UserExists(d.Email); !userExists {
 			ar.Error(w, ErrorAPIUserNotFound, http.StatusBadRequest, "User with this email does not exist", "RequestResetPassword.UserExists")
 			return
@@ -7,10 +7,16 @@ import (
 	"os"
 
 	"github.com/gorilla/mux"
+	"github.com/madappgang/identifo/identity_providers/google"
+	"github.com/madappgang/identifo/identity_providers/registry"
 	jwtService "github.com/madappgang/identifo/jwt/service"
 	"github.com/madappgang/identifo/model"
 	"github.com/madappgang/identifo/server/utils/originchecker"
 	"github.com/madappgang/identifo/web/authorization"
+	"github.com/madappgang/identifo/web/magiclink"
+	"github.com/madappgang/identifo/web/ratelimit"
+	"github.com/madappgang/identifo/web/tfa"
+	"github.com/madappgang/identifo/web/webauthn"
 	"github.com/rs/cors"
 	"github.com/urfave/negroni"
 )
@@ -27,19 +33,29 @@ type Router struct {
 	tokenBlacklist          model.TokenBlacklist
 	inviteStorage           model.InviteStorage
 	verificationCodeStorage model.VerificationCodeStorage
+	deviceCodeStorage       model.DeviceCodeStorage
 	staticFilesStorage      model.StaticFilesStorage
 	tfaType                 model.TFAType
+	tfaSettings             model.TFASettings
 	tokenService            jwtService.TokenService
 	smsService              model.SMSService
 	emailService            model.EmailService
 	oidcConfiguration       *OIDCConfiguration
-	jwk                     *jwk
 	Authorizer              *authorization.Authorizer
 	Host                    string
 	SupportedLoginWays      model.LoginWith
 	WebRouterPrefix         string
 	tokenPayloadServices    map[string]model.TokenPayloadProvider
 	LoggerSettings          model.LoggerSettings
+	googleJWKSURL           string
+	federatedProviders      *model.FederatedProviderRegistry
+	trustedFederated        []string
+	webAuthn                *webauthn.Service
+	tfaService              *tfa.Service
+	magicLink               *magiclink.Service
+	rateLimitStorage        model.RateLimitStorage
+	rateLimitSettings       model.RateLimitSettings
+	rateLimiter             *ratelimit.Limiter
 }
 
 // ServeHTTP implements identifo.Router interface.
@@ -51,6 +67,7 @@ func (ar *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func defaultOptions() []func(*Router) error {
 	return []func(*Router) error{
 		WebRouterPrefixOption("/web"),
+		GoogleJWKSURLOption(google.JWKSURL),
 	}
 }
 
@@ -91,6 +108,32 @@ func TFATypeOption(tfaType model.TFAType) func(*Router) error {
 	}
 }
 
+// TFASettingsOption is for setting one-time password checks.
+func TFASettingsOption(settings model.TFASettings) func(*Router) error {
+	return func(r *Router) error {
+		r.tfaSettings = settings
+		return nil
+	}
+}
+
+// TrustedFederatedProvidersOption sets the providers, whose verified emails link federated identities to the existing users.
+func TrustedFederatedProvidersOption(trusted []string) func(*Router) error {
+	return func(r *Router) error {
+		r.trustedFederated = trusted
+		return nil
+	}
+}
+
+// RateLimitOption sets the storage of request counters and the limits of the authentication endpoints.
+// Requests are not limited without storage.
+func RateLimitOption(storage model.RateLimitStorage, settings model.RateLimitSettings) func(*Router) error {
+	return func(r *Router) error {
+		r.rateLimitStorage = storage
+		r.rateLimitSettings = settings
+		return nil
+	}
+}
+
 // WebRouterPrefixOption sets web prefix host value.
 func WebRouterPrefixOption(prefix string) func(*Router) error {
 	return func(r *Router) error {
@@ -99,8 +142,25 @@ func WebRouterPrefixOption(prefix string) func(*Router) error {
 	}
 }
 
+// GoogleJWKSURLOption sets the URL Google ID token keys are fetched from.
+func GoogleJWKSURLOption(url string) func(*Router) error {
+	return func(r *Router) error {
+		r.googleJWKSURL = url
+		return nil
+	}
+}
+
+// FederatedProvidersOption sets the registry of federated providers users could sign in with.
+// By default, there are Facebook, Apple, Google and the apps' OpenID Connect providers.
+func FederatedProvidersOption(registry *model.FederatedProviderRegistry) func(*Router) error {
+	return func(r *Router) error {
+		r.federatedProviders = registry
+		return nil
+	}
+}
+
 // NewRouter creates and initilizes new router.
-func NewRouter(logger *log.Logger, as model.AppStorage, us model.UserStorage, ts model.TokenStorage, tb model.TokenBlacklist, is model.InviteStorage, vcs model.VerificationCodeStorage, sfs model.StaticFilesStorage, tServ jwtService.TokenService, smsServ model.SMSService, emailServ model.EmailService, authorizer *authorization.Authorizer, loggerSettings model.LoggerSettings, options ...func(*Router) error) (model.Router, error) {
+func NewRouter(logger *log.Logger, as model.AppStorage, us model.UserStorage, ts model.TokenStorage, tb model.TokenBlacklist, is model.InviteStorage, vcs model.VerificationCodeStorage, dcs model.DeviceCodeStorage, sfs model.StaticFilesStorage, tServ jwtService.TokenService, smsServ model.SMSService, emailServ model.EmailService, authorizer *authorization.Authorizer, loggerSettings model.LoggerSettings, options ...func(*Router) error) (model.Router, error) {
 	ar := Router{
 		middleware:              negroni.Classic(),
 		router:                  mux.NewRouter(),
@@ -110,6 +170,7 @@ func NewRouter(logger *log.Logger, as model.AppStorage, us model.UserStorage, ts
 		tokenBlacklist:          tb,
 		inviteStorage:           is,
 		verificationCodeStorage: vcs,
+		deviceCodeStorage:       dcs,
 		staticFilesStorage:      sfs,
 		tokenService:            tServ,
 		smsService:              smsServ,
@@ -124,6 +185,14 @@ func NewRouter(logger *log.Logger, as model.AppStorage, us model.UserStorage, ts
 		}
 	}
 
+	if ar.federatedProviders == nil {
+		ar.federatedProviders = registry.NewDefault(ar.googleJWKSURL)
+	}
+	ar.webAuthn = webauthn.NewService(ar.Host, ar.tokenService, ar.tokenBlacklist, ar.userStorage)
+	ar.tfaService = tfa.NewService(ar.tfaType, ar.tfaSettings, ar.userStorage, ar.smsService, ar.emailService)
+	ar.magicLink = magiclink.NewService(ar.tokenService, ar.tokenBlacklist, ar.userStorage, ar.emailService)
+	ar.rateLimiter = ratelimit.NewLimiter(ar.rateLimitStorage, ar.rateLimitSettings.Policies)
+
 	// setup logger to stdout.
 	if logger == nil {
 		ar.logger = log.New(os.Stdout, "API_ROUTER: ", log.Ldate|log.Ltime|log.Lshortfile)
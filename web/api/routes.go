@@ -34,6 +34,10 @@ func (ar *Router) initRoutes() {
 	auth.Path(`/{login:login/?}`).HandlerFunc(ar.LoginWithPassword()).Methods("POST")
 	auth.Path(`/{request_phone_code:request_phone_code/?}`).HandlerFunc(ar.RequestVerificationCode()).Methods("POST")
 	auth.Path(`/{phone_login:phone_login/?}`).HandlerFunc(ar.PhoneLogin()).Methods("POST")
+	auth.Path(`/{request_email_code:request_email_code/?}`).HandlerFunc(ar.RequestEmailCode()).Methods("POST")
+	auth.Path(`/{email_login:email_login/?}`).HandlerFunc(ar.EmailLogin()).Methods("POST")
+	auth.Path(`/{email_link:email_link/?}`).HandlerFunc(ar.RequestEmailLink()).Methods("POST")
+	auth.Path(`/email_link/{login:login/?}`).HandlerFunc(ar.EmailLinkLogin()).Methods("POST")
 	auth.Path(`/{federated:federated/?}`).HandlerFunc(ar.FederatedLogin()).Methods("POST")
 	auth.Path(`/{register:register/?}`).HandlerFunc(ar.RegisterWithPassword()).Methods("POST")
 	auth.Path(`/{reset_password:reset_password/?}`).HandlerFunc(ar.RequestResetPassword()).Methods("POST")
@@ -60,11 +64,34 @@ func (ar *Router) initRoutes() {
 		ar.Token(model.TokenTypeAccess, []string{model.TokenTFAPreauthScope}),
 		negroni.Wrap(ar.FinalizeTFA()),
 	)).Methods("POST")
+	auth.Path(`/{tfa/code:tfa/code/?}`).Handler(negroni.New(
+		ar.Token(model.TokenTypeAccess, []string{model.TokenTFAPreauthScope}),
+		negroni.Wrap(ar.RequestTFACode()),
+	)).Methods("POST")
 	auth.Path(`/{tfa/reset:tfa/reset/?}`).Handler(negroni.New(
 		ar.Token(model.TokenTypeAccess, nil),
 		negroni.Wrap(ar.RequestTFAReset()),
 	)).Methods("PUT")
 
+	auth.Path(`/webauthn/register/{begin:begin/?}`).Handler(negroni.New(
+		ar.Token(model.TokenTypeAccess, nil),
+		negroni.Wrap(ar.BeginWebAuthnRegistration()),
+	)).Methods("POST")
+	auth.Path(`/webauthn/register/{finish:finish/?}`).Handler(negroni.New(
+		ar.Token(model.TokenTypeAccess, nil),
+		negroni.Wrap(ar.FinishWebAuthnRegistration()),
+	)).Methods("POST")
+	auth.Path(`/webauthn/login/{begin:begin/?}`).HandlerFunc(ar.BeginWebAuthnLogin()).Methods("POST")
+	auth.Path(`/webauthn/login/{finish:finish/?}`).HandlerFunc(ar.FinishWebAuthnLogin()).Methods("POST")
+	auth.Path(`/webauthn/tfa/{begin:begin/?}`).Handler(negroni.New(
+		ar.Token(model.TokenTypeAccess, []string{model.TokenTFAPreauthScope}),
+		negroni.Wrap(ar.BeginWebAuthnTFA()),
+	)).Methods("POST")
+	auth.Path(`/webauthn/tfa/{finish:finish/?}`).Handler(negroni.New(
+		ar.Token(model.TokenTypeAccess, []string{model.TokenTFAPreauthScope}),
+		negroni.Wrap(ar.FinishWebAuthnTFA()),
+	)).Methods("POST")
+
 	meRouter := mux.NewRouter().PathPrefix("/me").Subrouter()
 	ar.router.PathPrefix("/me").Handler(apiMiddlewares.With(
 		ar.SignatureHandler(),
@@ -74,6 +101,47 @@ func (ar *Router) initRoutes() {
 	meRouter.Path("").HandlerFunc(ar.GetUser()).Methods("GET")
 	meRouter.Path("").HandlerFunc(ar.UpdateUser()).Methods("PUT")
 	meRouter.Path(`/{logout:logout/?}`).HandlerFunc(ar.Logout()).Methods("POST")
+	meRouter.Path(`/{identities:identities/?}`).HandlerFunc(ar.FederatedIdentities()).Methods("GET")
+	meRouter.Path(`/{identities:identities/?}`).HandlerFunc(ar.LinkFederatedIdentity()).Methods("POST")
+	meRouter.Path(`/identities/{provider}/{id}`).HandlerFunc(ar.UnlinkFederatedIdentity()).Methods("DELETE")
+	meRouter.Path(`/{tfa/recovery_codes:tfa/recovery_codes/?}`).HandlerFunc(ar.TFARecoveryCodes()).Methods("GET")
+	meRouter.Path(`/{tfa/recovery_codes:tfa/recovery_codes/?}`).HandlerFunc(ar.RegenerateTFARecoveryCodes()).Methods("POST")
+	meRouter.Path(`/{webauthn:webauthn/?}`).HandlerFunc(ar.WebAuthnCredentials()).Methods("GET")
+	meRouter.Path(`/webauthn/{id}`).HandlerFunc(ar.RemoveWebAuthnCredential()).Methods("DELETE")
+	meRouter.Path(`/{step_up:step_up/?}`).HandlerFunc(ar.StepUp()).Methods("POST")
+	meRouter.Path(`/step_up/{code:code/?}`).HandlerFunc(ar.RequestTFACode()).Methods("POST")
+	meRouter.Path(`/step_up/webauthn/{begin:begin/?}`).HandlerFunc(ar.BeginWebAuthnTFA()).Methods("POST")
+	meRouter.Path(`/step_up/webauthn/{finish:finish/?}`).HandlerFunc(ar.FinishWebAuthnStepUp()).Methods("POST")
+	meRouter.Path(`/{trusted_devices:trusted_devices/?}`).HandlerFunc(ar.TrustedDevices()).Methods("GET")
+	meRouter.Path(`/trusted_devices/{id}`).HandlerFunc(ar.RemoveTrustedDevice()).Methods("DELETE")
+
+	oauth := mux.NewRouter().PathPrefix("/oauth").Subrouter()
+
+	oauthHandlers := make([]negroni.Handler, 0)
+
+	if ar.LoggerSettings.DumpRequest {
+		oauthHandlers = append(oauthHandlers, ar.DumpRequest())
+	}
+
+	oauthHandlers = append(oauthHandlers, negroni.Wrap(oauth))
+
+	ar.router.PathPrefix("/oauth").Handler(ar.middleware.With(oauthHandlers...))
+
+	oauth.Path(`/{authorize:authorize/?}`).HandlerFunc(ar.OAuthAuthorize()).Methods("GET")
+	oauth.Path(`/{token:token/?}`).HandlerFunc(ar.OAuthToken()).Methods("POST")
+	oauth.Path(`/{introspect:introspect/?}`).HandlerFunc(ar.OAuthIntrospect()).Methods("POST")
+	oauth.Path(`/{revoke:revoke/?}`).HandlerFunc(ar.OAuthRevoke()).Methods("POST")
+	oauth.Path(`/{device_authorization:device_authorization/?}`).HandlerFunc(ar.OAuthDeviceAuthorization()).Methods("POST")
+
+	userInfoHandlers := make([]negroni.Handler, 0)
+
+	if ar.LoggerSettings.DumpRequest {
+		userInfoHandlers = append(userInfoHandlers, ar.DumpRequest())
+	}
+
+	userInfoHandlers = append(userInfoHandlers, negroni.WrapFunc(ar.UserInfo()))
+
+	ar.router.Path(`/{userinfo:userinfo/?}`).Handler(ar.middleware.With(userInfoHandlers...)).Methods("GET", "POST")
 
 	oidc := mux.NewRouter().PathPrefix("/.well-known").Subrouter()
 
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/madappgang/identifo/jwt"
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/webauthn"
+)
+
+type stepUpResponse struct {
+	AccessToken string `json:"access_token,omitempty"`
+}
+
+// StepUp re-verifies the second factor of the signed in user, and issues the access token with the multi-factor acr and the fresh auth_time.
+// Apps demand it before the sensitive actions, like payments. Codes for SMS and email factors are sent with RequestTFACode.
+func (ar *Router) StepUp() http.HandlerFunc {
+	type requestBody struct {
+		TFACode string        `json:"tfa_code"`
+		TFAType model.TFAType `json:"tfa_type,omitempty"`
+		Scopes  []string      `json:"scopes,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := requestBody{}
+		if ar.MustParseJSON(w, r, &d) != nil {
+			return
+		}
+		if len(d.TFACode) == 0 {
+			ar.Error(w, ErrorAPIRequestTFACodeEmpty, http.StatusBadRequest, "", "StepUp.empty")
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		user, ok := ar.stepUpUser(w, r, "StepUp")
+		if !ok {
+			return
+		}
+
+		user, method, ok := ar.verifyTFACode(w, app, user, d.TFACode, d.TFAType, "StepUp")
+		if !ok {
+			return
+		}
+		ar.stepUp(w, r, app, user, method, d.Scopes, "StepUp")
+	}
+}
+
+// FinishWebAuthnStepUp re-verifies the user with the security key, like StepUp does with one-time password.
+// The options are returned by BeginWebAuthnTFA.
+func (ar *Router) FinishWebAuthnStepUp() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := webAuthnLoginData{}
+		if ar.MustParseJSON(w, r, &d) != nil {
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		user, ok := ar.stepUpUser(w, r, "FinishWebAuthnStepUp")
+		if !ok {
+			return
+		}
+
+		keyUser, err := ar.webAuthn.FinishLogin(app, webauthn.CeremonyTFA, d.Response)
+		if err != nil {
+			ar.Error(w, ErrorAPIWebAuthnVerificationFailed, http.StatusUnauthorized, err.Error(), "FinishWebAuthnStepUp.FinishLogin")
+			return
+		}
+		if keyUser.ID != user.ID {
+			ar.Error(w, ErrorAPIWebAuthnVerificationFailed, http.StatusUnauthorized, "Security key belongs to another user", "FinishWebAuthnStepUp.UserID")
+			return
+		}
+		ar.stepUp(w, r, app, keyUser, model.AMRWebAuthn, d.Scopes, "FinishWebAuthnStepUp")
+	}
+}
+
+// stepUpUser returns the user of the access token, who has passed TFA. It writes the error and returns false otherwise.
+func (ar *Router) stepUpUser(w http.ResponseWriter, r *http.Request, where string) (model.User, bool) {
+	token := tokenFromContext(r.Context())
+	if isPreauthToken(token) {
+		ar.Error(w, ErrorAPIRequestScopesForbidden, http.StatusForbidden, "Please pass two-factor authentication instead", where+".isPreauthToken")
+		return model.User{}, false
+	}
+
+	user, err := ar.userStorage.UserByID(token.UserID())
+	if err != nil {
+		ar.Error(w, ErrorAPIUserNotFound, http.StatusUnauthorized, err.Error(), where+".UserByID")
+		return model.User{}, false
+	}
+	if !user.TFAInfo.IsEnabled {
+		ar.Error(w, ErrorAPIRequestTFANotEnabled, http.StatusBadRequest, "", where+".IsEnabled")
+		return model.User{}, false
+	}
+	return user, true
+}
+
+// stepUp issues the access token with the factor the user has just passed, and invalidates the old one.
+// Scopes of the old token are kept if the user has not requested the others.
+func (ar *Router) stepUp(w http.ResponseWriter, r *http.Request, app model.AppData, user model.User, method string, scopes []string, where string) {
+	oldToken := tokenFromContext(r.Context())
+	if len(scopes) == 0 {
+		scopes = strings.Fields(oldToken.Scopes())
+	}
+	scopes, err := ar.userStorage.RequestScopes(user.ID, scopes)
+	if err != nil {
+		ar.Error(w, ErrorAPIRequestScopesForbidden, http.StatusForbidden, err.Error(), where+".RequestScopes")
+		return
+	}
+
+	tokenPayload, err := ar.getTokenPayloadForApp(app, user)
+	if err != nil {
+		ar.Error(w, ErrorAPIAppAccessTokenNotCreated, http.StatusInternalServerError, err.Error(), where+".getTokenPayloadForApp")
+		return
+	}
+
+	authCtx := authContext(oldToken).WithSecondFactor(method)
+	accessToken, _, err := ar.loginUser(user, scopes, app, false, false, tokenPayload, authCtx)
+	if err != nil {
+		ar.Error(w, ErrorAPIAppAccessTokenNotCreated, http.StatusInternalServerError, err.Error(), where+".loginUser")
+		return
+	}
+
+	if oldTokenBytes, ok := r.Context().Value(model.TokenRawContextKey).([]byte); ok {
+		if err := ar.tokenBlacklist.Add(string(oldTokenBytes)); err != nil {
+			ar.logger.Printf("Cannot blacklist old access token: %s\n", err)
+		}
+	}
+	ar.ServeJSON(w, http.StatusOK, &stepUpResponse{AccessToken: accessToken})
+}
+
+// authContext returns how and when the user of the token has authenticated.
+func authContext(token jwt.Token) model.AuthContext {
+	var authTime int64
+	if t := token.AuthTime(); !t.IsZero() {
+		authTime = t.Unix()
+	}
+	return model.AuthContext{Methods: token.AMR(), ACR: token.ACR(), Time: authTime}
+}
@@ -51,6 +51,10 @@ func (ar *Router) Token(tokenType string, scopes []string) negroni.HandlerFunc {
 		}
 
 		if blacklisted := ar.tokenBlacklist.IsBlacklisted(tokenString); blacklisted {
+			if tokenType == model.TokenTypeRefresh {
+				// Exchanged refresh token should never be presented again, its family gets revoked if it is.
+				ar.checkRefreshTokenFamily(token, tokenString, app)
+			}
 			ar.Error(rw, ErrorAPIRequestTokenInvalid, http.StatusBadRequest, "", "Token.IsBlacklisted")
 			return
 		}
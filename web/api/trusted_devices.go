@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/madappgang/identifo/model"
+	"github.com/rs/xid"
+)
+
+type trustedDevicesResponse struct {
+	Devices []model.TrustedDevice `json:"devices"`
+}
+
+// TrustedDevices returns the devices where the user could skip two-factor authentication.
+func (ar *Router) TrustedDevices() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := ar.userStorage.UserByID(tokenFromContext(r.Context()).UserID())
+		if err != nil {
+			ar.Error(w, ErrorAPIUserNotFound, http.StatusUnauthorized, err.Error(), "TrustedDevices.UserByID")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, trustedDevices(user))
+	}
+}
+
+// RemoveTrustedDevice revokes the device, so the user passes two-factor authentication there again.
+func (ar *Router) RemoveTrustedDevice() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deviceID := mux.Vars(r)["id"]
+
+		token := tokenFromContext(r.Context())
+		if isPreauthToken(token) {
+			ar.Error(w, ErrorAPIRequestScopesForbidden, http.StatusForbidden, "Please pass two-factor authentication before removing trusted device", "RemoveTrustedDevice.isPreauthToken")
+			return
+		}
+
+		user, err := ar.userStorage.RemoveTrustedDevice(token.UserID(), deviceID)
+		if err == model.ErrorNotFound {
+			ar.Error(w, ErrorAPITrustedDeviceNotFound, http.StatusNotFound, "", "RemoveTrustedDevice.RemoveTrustedDevice")
+			return
+		}
+		if err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "RemoveTrustedDevice.RemoveTrustedDevice")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, trustedDevices(user))
+	}
+}
+
+// trustDevice remembers the device of the user who has just passed two-factor authentication,
+// and returns the token the device presents on the next login.
+func (ar *Router) trustDevice(app model.AppData, user model.User, name string) (string, error) {
+	now := time.Now().Unix()
+	device := model.TrustedDevice{
+		ID:        xid.New().String(),
+		Name:      name,
+		AppID:     app.ID,
+		CreatedAt: now,
+		ExpiresAt: now + app.TrustedDeviceLifespan,
+	}
+	if _, err := ar.userStorage.AddTrustedDevice(user.ID, device); err != nil {
+		return "", err
+	}
+
+	token, err := ar.tokenService.NewTrustedDeviceToken(user, app, device.ID, device.ExpiresAt)
+	if err != nil {
+		return "", err
+	}
+	return ar.tokenService.String(token)
+}
+
+// isTrustedDevice checks if the token belongs to the user's device, which is trusted in the app and has not been revoked.
+func (ar *Router) isTrustedDevice(app model.AppData, user model.User, tokenString string) bool {
+	if len(tokenString) == 0 || app.TrustedDeviceLifespan <= 0 {
+		return false
+	}
+
+	token, err := ar.tokenService.Parse(tokenString)
+	if err != nil || token.Type() != model.TokenTypeTrustedDevice {
+		return false
+	}
+	if token.Subject() != user.ID || !contains(token.Audience(), app.ID) {
+		return false
+	}
+
+	device, ok := user.TrustedDevice(token.ID())
+	return ok && device.AppID == app.ID && !device.Expired()
+}
+
+// trustedDevices returns the user's devices which are still trusted.
+func trustedDevices(user model.User) trustedDevicesResponse {
+	devices := make([]model.TrustedDevice, 0, len(user.TrustedDevices))
+	for _, d := range user.TrustedDevices {
+		if !d.Expired() {
+			devices = append(devices, d)
+		}
+	}
+	return trustedDevicesResponse{Devices: devices}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	ijwt "github.com/madappgang/identifo/jwt"
+	jwtService "github.com/madappgang/identifo/jwt/service"
+	jwtValidator "github.com/madappgang/identifo/jwt/validator"
+	"github.com/madappgang/identifo/model"
+)
+
+// userInfoResponse is an OpenID Connect UserInfo response.
+type userInfoResponse struct {
+	Subject string `json:"sub"`
+	ijwt.UserInfo
+}
+
+// UserInfo is an OpenID Connect UserInfo endpoint (https://openid.net/specs/openid-connect-core-1_0.html#UserInfo).
+// It returns claims about the user, authenticated with the access token, which has been issued with "openid" scope.
+// Unlike the rest of the API, it does not require app ID header, the app is taken from the token audience.
+func (ar *Router) UserInfo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenBytes := ijwt.ExtractTokenFromBearerHeader(r.Header.Get(TokenHeaderKey))
+		if tokenBytes == nil {
+			ar.Error(w, ErrorAPIRequestTokenInvalid, http.StatusUnauthorized, "Token is empty or invalid.", "UserInfo.ExtractTokenFromBearerHeader")
+			return
+		}
+		tokenString := string(tokenBytes)
+
+		token, err := ar.tokenService.Parse(tokenString)
+		if err != nil {
+			ar.Error(w, ErrorAPIRequestTokenInvalid, http.StatusUnauthorized, err.Error(), "UserInfo.tokenService_Parse")
+			return
+		}
+
+		if len(token.Audience()) == 0 {
+			ar.Error(w, ErrorAPIRequestTokenInvalid, http.StatusUnauthorized, "Token has no audience.", "UserInfo.Audience")
+			return
+		}
+
+		app, err := ar.appStorage.ActiveAppByID(token.Audience()[0])
+		if err != nil {
+			ar.Error(w, ErrorAPIRequestTokenInvalid, http.StatusUnauthorized, err.Error(), "UserInfo.ActiveAppByID")
+			return
+		}
+
+		v := jwtValidator.NewValidator(
+			[]string{app.ID},
+			[]string{ar.tokenService.Issuer()},
+			[]string{},
+			[]string{model.TokenTypeAccess},
+		)
+		if err := v.Validate(token); err != nil {
+			ar.Error(w, ErrorAPIRequestTokenInvalid, http.StatusUnauthorized, err.Error(), "UserInfo.Validate")
+			return
+		}
+
+		if ar.tokenBlacklist.IsBlacklisted(tokenString) {
+			ar.Error(w, ErrorAPIRequestTokenInvalid, http.StatusUnauthorized, "", "UserInfo.IsBlacklisted")
+			return
+		}
+
+		scopes := strings.Fields(token.Scopes())
+		if !contains(scopes, jwtService.OpenIDScope) {
+			ar.Error(w, ErrorAPIRequestScopesForbidden, http.StatusForbidden, "Token has not been issued with openid scope.", "UserInfo.Scopes")
+			return
+		}
+
+		user, err := ar.userStorage.UserByID(token.UserID())
+		if err != nil {
+			ar.Error(w, ErrorAPIUserNotFound, http.StatusUnauthorized, err.Error(), "UserInfo.UserByID")
+			return
+		}
+
+		ar.ServeJSON(w, http.StatusOK, userInfoResponse{
+			Subject:  user.ID,
+			UserInfo: jwtService.UserInfo(user, scopes),
+		})
+	}
+}
+
+// newIDToken issues OpenID Connect ID token if "openid" scope has been requested.
+// Otherwise it returns empty string.
+func (ar *Router) newIDToken(user model.User, scopes []string, app model.AppData, accessToken, nonce string, authTime int64) (string, error) {
+	if !contains(scopes, jwtService.OpenIDScope) {
+		return "", nil
+	}
+
+	token, err := ar.tokenService.NewIDToken(user, scopes, app, accessToken, nonce, authTime)
+	if err != nil {
+		return "", err
+	}
+	return ar.tokenService.String(token)
+}
@@ -0,0 +1,336 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/madappgang/identifo/jwt"
+	jwtService "github.com/madappgang/identifo/jwt/service"
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/web/authorization"
+	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/tfa"
+	"github.com/madappgang/identifo/web/webauthn"
+)
+
+// webAuthnCredentialsResponse is the list of the user's security keys.
+type webAuthnCredentialsResponse struct {
+	Credentials []model.WebAuthnCredential `json:"credentials"`
+}
+
+// webAuthnLoginData is the client's response to the login ceremony options, with the scopes to issue tokens with.
+type webAuthnLoginData struct {
+	webauthn.Response
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// BeginWebAuthnRegistration returns the options to create new security key credential for the user.
+func (ar *Router) BeginWebAuthnRegistration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app := middleware.AppFromContext(r.Context())
+		user, ok := ar.webAuthnRegistrationUser(w, r, app, "BeginWebAuthnRegistration")
+		if !ok {
+			return
+		}
+
+		options, err := ar.webAuthn.BeginRegistration(user, app)
+		if err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "BeginWebAuthnRegistration.BeginRegistration")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, options)
+	}
+}
+
+// FinishWebAuthnRegistration verifies the new security key credential, and adds it to the user.
+func (ar *Router) FinishWebAuthnRegistration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := webauthn.Response{}
+		if ar.MustParseJSON(w, r, &d) != nil {
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		user, ok := ar.webAuthnRegistrationUser(w, r, app, "FinishWebAuthnRegistration")
+		if !ok {
+			return
+		}
+
+		user, err := ar.webAuthn.FinishRegistration(user, app, d)
+		if err != nil {
+			ar.Error(w, ErrorAPIWebAuthnVerificationFailed, http.StatusBadRequest, err.Error(), "FinishWebAuthnRegistration.FinishRegistration")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, webAuthnCredentials(user))
+	}
+}
+
+// webAuthnRegistrationUser returns the user from the access token.
+// Users could register the key with preauth token to enroll in mandatory TFA, but not to replace the second factor they have.
+func (ar *Router) webAuthnRegistrationUser(w http.ResponseWriter, r *http.Request, app model.AppData, where string) (model.User, bool) {
+	token := tokenFromContext(r.Context())
+	user, err := ar.userStorage.UserByID(token.UserID())
+	if err != nil {
+		ar.Error(w, ErrorAPIUserNotFound, http.StatusUnauthorized, err.Error(), where+".UserByID")
+		return model.User{}, false
+	}
+
+	if isPreauthToken(token) {
+		if _, enabled2FA, _ := ar.tfaService.Check(app, user); enabled2FA {
+			ar.Error(w, ErrorAPIRequestTFAAlreadyEnabled, http.StatusForbidden, "Please pass two-factor authentication before adding security key", where+".check2FA")
+			return model.User{}, false
+		}
+	}
+	return user, true
+}
+
+// BeginWebAuthnLogin returns the options to sign in with the security key, without password.
+func (ar *Router) BeginWebAuthnLogin() http.HandlerFunc {
+	type requestBody struct {
+		Username string `json:"username"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ar.SupportedLoginWays.WebAuthn {
+			ar.Error(w, ErrorAPIAppWebAuthnLoginNotSupported, http.StatusBadRequest, "Application does not support login with security key", "BeginWebAuthnLogin.supportedLoginWays")
+			return
+		}
+
+		d := requestBody{}
+		if ar.MustParseJSON(w, r, &d) != nil {
+			return
+		}
+
+		// The same error for unknown users and users without keys, not to tell who is registered.
+		userID, err := ar.userStorage.IDByName(d.Username)
+		if err != nil {
+			ar.Error(w, ErrorAPIWebAuthnNoCredentials, http.StatusBadRequest, err.Error(), "BeginWebAuthnLogin.IDByName")
+			return
+		}
+		user, err := ar.userStorage.UserByID(userID)
+		if err != nil {
+			ar.Error(w, ErrorAPIWebAuthnNoCredentials, http.StatusBadRequest, err.Error(), "BeginWebAuthnLogin.UserByID")
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		options, err := ar.webAuthn.BeginLogin(user, app, webauthn.CeremonyLogin)
+		if err == webauthn.ErrorNoCredentials {
+			ar.Error(w, ErrorAPIWebAuthnNoCredentials, http.StatusBadRequest, err.Error(), "BeginWebAuthnLogin.BeginLogin")
+			return
+		}
+		if err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "BeginWebAuthnLogin.BeginLogin")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, options)
+	}
+}
+
+// FinishWebAuthnLogin signs the user in with the security key.
+// The key verifies the user with PIN or biometrics, so it is the second factor itself, and user does not pass TFA.
+func (ar *Router) FinishWebAuthnLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ar.SupportedLoginWays.WebAuthn {
+			ar.Error(w, ErrorAPIAppWebAuthnLoginNotSupported, http.StatusBadRequest, "Application does not support login with security key", "FinishWebAuthnLogin.supportedLoginWays")
+			return
+		}
+
+		d := webAuthnLoginData{}
+		if ar.MustParseJSON(w, r, &d) != nil {
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		user, err := ar.webAuthn.FinishLogin(app, webauthn.CeremonyLogin, d.Response)
+		if err != nil {
+			ar.Error(w, ErrorAPIWebAuthnVerificationFailed, http.StatusUnauthorized, err.Error(), "FinishWebAuthnLogin.FinishLogin")
+			return
+		}
+
+		// Authorize user if the app requires authorization.
+		azi := authorization.AuthzInfo{
+			App:         app,
+			UserRole:    user.AccessRole,
+			ResourceURI: r.RequestURI,
+			Method:      r.Method,
+		}
+		if err := ar.Authorizer.Authorize(azi); err != nil {
+			ar.Error(w, ErrorAPIAppAccessDenied, http.StatusForbidden, err.Error(), "FinishWebAuthnLogin.Authorizer")
+			return
+		}
+
+		authResult, err := ar.completeLoginFlow(app, user, d.Scopes, model.NewAuthContext(model.AMRWebAuthn))
+		if err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "FinishWebAuthnLogin.completeLoginFlow")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, authResult)
+	}
+}
+
+// BeginWebAuthnTFA returns the options to pass two-factor authentication with the security key.
+func (ar *Router) BeginWebAuthnTFA() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := ar.userStorage.UserByID(tokenFromContext(r.Context()).UserID())
+		if err != nil {
+			ar.Error(w, ErrorAPIUserNotFound, http.StatusUnauthorized, err.Error(), "BeginWebAuthnTFA.UserByID")
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		if !tfa.ContainsType(tfa.Types(ar.tfaService.Factors(app, user)), model.TFATypeWebAuthn) {
+			ar.Error(w, ErrorAPIRequestTFATypeNotAllowed, http.StatusBadRequest, "User could not pass TFA with security key in this app", "BeginWebAuthnTFA.tfaFactors")
+			return
+		}
+
+		options, err := ar.webAuthn.BeginLogin(user, app, webauthn.CeremonyTFA)
+		if err == webauthn.ErrorNoCredentials {
+			ar.Error(w, ErrorAPIRequestPleaseRegisterWebAuthnForTFA, http.StatusBadRequest, err.Error(), "BeginWebAuthnTFA.BeginLogin")
+			return
+		}
+		if err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "BeginWebAuthnTFA.BeginLogin")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, options)
+	}
+}
+
+// FinishWebAuthnTFA finalizes two-factor authentication with the security key, like FinalizeTFA does with one-time password.
+func (ar *Router) FinishWebAuthnTFA() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := webAuthnLoginData{}
+		if ar.MustParseJSON(w, r, &d) != nil {
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		user, err := ar.webAuthn.FinishLogin(app, webauthn.CeremonyTFA, d.Response)
+		if err != nil {
+			ar.Error(w, ErrorAPIWebAuthnVerificationFailed, http.StatusUnauthorized, err.Error(), "FinishWebAuthnTFA.FinishLogin")
+			return
+		}
+
+		preauthToken := tokenFromContext(r.Context())
+		if user.ID != preauthToken.UserID() {
+			ar.Error(w, ErrorAPIWebAuthnVerificationFailed, http.StatusUnauthorized, "Security key belongs to another user", "FinishWebAuthnTFA.UserID")
+			return
+		}
+
+		authResult, err := ar.completeLoginFlow(app, user, d.Scopes, authContext(preauthToken).WithSecondFactor(model.AMRWebAuthn))
+		if err != nil {
+			ar.Error(w, ErrorAPIAppAccessTokenNotCreated, http.StatusInternalServerError, err.Error(), "FinishWebAuthnTFA.completeLoginFlow")
+			return
+		}
+
+		// Blacklist preauth token.
+		if preauthTokenBytes, ok := r.Context().Value(model.TokenRawContextKey).([]byte); ok {
+			if err := ar.tokenBlacklist.Add(string(preauthTokenBytes)); err != nil {
+				ar.logger.Printf("Cannot blacklist old access token: %s\n", err)
+			}
+		}
+		ar.ServeJSON(w, http.StatusOK, authResult)
+	}
+}
+
+// WebAuthnCredentials returns the user's security keys.
+func (ar *Router) WebAuthnCredentials() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := ar.userStorage.UserByID(tokenFromContext(r.Context()).UserID())
+		if err != nil {
+			ar.Error(w, ErrorAPIUserNotFound, http.StatusUnauthorized, err.Error(), "WebAuthnCredentials.UserByID")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, webAuthnCredentials(user))
+	}
+}
+
+// RemoveWebAuthnCredential removes the security key from the user.
+// The last login method cannot be removed, as well as the last key the user passes TFA with.
+func (ar *Router) RemoveWebAuthnCredential() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		credentialID := mux.Vars(r)["id"]
+
+		token := tokenFromContext(r.Context())
+		if isPreauthToken(token) {
+			ar.Error(w, ErrorAPIRequestScopesForbidden, http.StatusForbidden, "Please pass two-factor authentication before removing security key", "RemoveWebAuthnCredential.isPreauthToken")
+			return
+		}
+
+		userID := token.UserID()
+		user, err := ar.userStorage.UserByID(userID)
+		if err != nil {
+			ar.Error(w, ErrorAPIUserNotFound, http.StatusUnauthorized, err.Error(), "RemoveWebAuthnCredential.UserByID")
+			return
+		}
+
+		if _, ok := user.WebAuthnCredential(credentialID); !ok {
+			ar.Error(w, ErrorAPIWebAuthnCredentialNotFound, http.StatusNotFound, "", "RemoveWebAuthnCredential.WebAuthnCredential")
+			return
+		}
+		if loginMethods(user) < 2 {
+			ar.Error(w, ErrorAPIFederatedIdentityLastLogin, http.StatusBadRequest, "", "RemoveWebAuthnCredential.loginMethods")
+			return
+		}
+		if _, ok := user.TFAInfo.Factor(model.TFATypeWebAuthn, ar.tfaType); ok && len(user.WebAuthnCredentials) == 1 && user.TFAInfo.IsEnabled {
+			ar.Error(w, ErrorAPIWebAuthnLastTFAKey, http.StatusBadRequest, "", "RemoveWebAuthnCredential.TFA")
+			return
+		}
+
+		if user, err = ar.userStorage.RemoveWebAuthnCredential(userID, credentialID); err != nil {
+			ar.Error(w, ErrorAPIInternalServerError, http.StatusInternalServerError, err.Error(), "RemoveWebAuthnCredential.RemoveWebAuthnCredential")
+			return
+		}
+		ar.ServeJSON(w, http.StatusOK, webAuthnCredentials(user))
+	}
+}
+
+// completeLoginFlow issues the tokens to the user who has passed all the factors.
+func (ar *Router) completeLoginFlow(app model.AppData, user model.User, scopes []string, authCtx model.AuthContext) (AuthResponse, error) {
+	scopes, err := ar.userStorage.RequestScopes(user.ID, scopes)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	tokenPayload, err := ar.getTokenPayloadForApp(app, user)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	offline := contains(scopes, jwtService.OfflineScope)
+	accessToken, refreshToken, err := ar.loginUser(user, scopes, app, offline, false, tokenPayload, authCtx)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	idToken, err := ar.newIDToken(user, scopes, app, accessToken, "", time.Now().Unix())
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	ar.userStorage.UpdateLoginMetadata(user.ID)
+	return AuthResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+		User:         user.Sanitized(),
+	}, nil
+}
+
+// isPreauthToken checks if the user has not passed two-factor authentication yet.
+func isPreauthToken(token jwt.Token) bool {
+	return contains(strings.Fields(token.Scopes()), model.TokenTFAPreauthScope)
+}
+
+// webAuthnCredentials returns the user's security keys without public keys.
+func webAuthnCredentials(user model.User) webAuthnCredentialsResponse {
+	credentials := make([]model.WebAuthnCredential, 0, len(user.WebAuthnCredentials))
+	for _, c := range user.WebAuthnCredentials {
+		c.PublicKey = nil
+		credentials = append(credentials, c)
+	}
+	return webAuthnCredentialsResponse{Credentials: credentials}
+}
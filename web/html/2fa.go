@@ -179,9 +179,19 @@ func (ar *Router) ResetTFAHandler() http.HandlerFunc {
 			return
 		}
 
+		// The new secret is the only factor, of the server-wide TFA type.
+		// Old recovery codes are replaced with the new ones along with the secret.
+		recoveryCodes, recoveryCodeHashes, err := model.NewRecoveryCodes()
+		if err != nil {
+			SetFlash(w, FlashErrorMessageKey, "Server Error")
+			http.Redirect(w, r, path.Join(ar.PathPrefix, r.URL.String()), http.StatusMovedPermanently)
+			return
+		}
+
 		user.TFAInfo = model.TFAInfo{
-			IsEnabled: true,
-			Secret:    gotp.RandomSecret(16),
+			IsEnabled:     true,
+			Secret:        gotp.RandomSecret(16),
+			RecoveryCodes: recoveryCodeHashes,
 		}
 
 		if _, err := ar.UserStorage.UpdateUser(user.ID, user); err != nil {
@@ -191,10 +201,11 @@ func (ar *Router) ResetTFAHandler() http.HandlerFunc {
 		}
 
 		data := map[string]interface{}{
-			"Error":     errorMessage,
-			"Token":     token,
-			"Prefix":    ar.PathPrefix,
-			"TFASecret": user.TFAInfo.Secret,
+			"Error":         errorMessage,
+			"Token":         token,
+			"Prefix":        ar.PathPrefix,
+			"TFASecret":     user.TFAInfo.Secret,
+			"RecoveryCodes": recoveryCodes,
 		}
 
 		if err = tmpl.Execute(w, data); err != nil {
@@ -9,6 +9,8 @@ import (
 const (
 	// CookieKeyWebCookieToken cookie key to keep the web cookie token.
 	CookieKeyWebCookieToken = "identifo-user"
+	// CookieKeyTFAChallenge cookie key to keep the token of the user who passes the second factor.
+	CookieKeyTFAChallenge = "identifo-tfa-challenge"
 )
 
 func encode(src string) string {
@@ -29,6 +31,12 @@ func setCookie(w http.ResponseWriter, name, value string, maxAge int) {
 	http.SetCookie(w, c)
 }
 
+// setPathCookie sets the cookie for all the pages under the path, not only for the current page directory.
+func setPathCookie(w http.ResponseWriter, name, value, path string, maxAge int) {
+	c := &http.Cookie{Name: name, Value: encode(value), Path: path, MaxAge: maxAge, HttpOnly: true}
+	http.SetCookie(w, c)
+}
+
 func getCookie(r *http.Request, name string) (string, error) {
 	c, err := r.Cookie(name)
 	if err != nil {
@@ -51,3 +59,8 @@ func deleteCookie(w http.ResponseWriter, name string) {
 	c := &http.Cookie{Name: name, Value: "", Expires: time.Unix(0, 0), MaxAge: -1}
 	http.SetCookie(w, c)
 }
+
+func deletePathCookie(w http.ResponseWriter, name, path string) {
+	c := &http.Cookie{Name: name, Value: "", Path: path, Expires: time.Unix(0, 0), MaxAge: -1}
+	http.SetCookie(w, c)
+}
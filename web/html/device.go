@@ -0,0 +1,217 @@
+package html
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+
+	jwtValidator "github.com/madappgang/identifo/jwt/validator"
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/web/authorization"
+)
+
+const (
+	devicePath = "/device"
+
+	userCodeKey     = "user_code"
+	deviceActionKey = "action"
+	deviceCSRFKey   = "csrf"
+
+	deviceActionApprove = "approve"
+	deviceActionDeny    = "deny"
+)
+
+// DeviceHandler serves the page where the user enters the user code, shown on the device,
+// and confirms the device authorization request (RFC 8628).
+// Users without a valid web cookie are sent to the login page first and then redirected back here.
+func (ar *Router) DeviceHandler() http.HandlerFunc {
+	tmpl, err := ar.staticFilesStorage.ParseTemplate(model.StaticPagesNames.Device)
+	if err != nil {
+		ar.Logger.Fatalln("Cannot parse Device template.", err)
+	}
+	errorPath := path.Join(ar.PathPrefix, "/misconfiguration")
+	tokenValidator := ar.webCookieValidator()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]interface{}{
+			"Prefix": ar.PathPrefix,
+		}
+		serveTemplate := func() {
+			if err := tmpl.Execute(w, data); err != nil {
+				ar.Error(w, err, http.StatusInternalServerError, "")
+			}
+		}
+
+		userCode := model.NormalizeUserCode(r.URL.Query().Get(userCodeKey))
+		if userCode == "" {
+			serveTemplate()
+			return
+		}
+		data["UserCode"] = r.URL.Query().Get(userCodeKey)
+
+		dc, err := ar.DeviceCodeStorage.DeviceCodeByUserCode(userCode)
+		if err != nil || dc.Expired() || dc.Status != model.DeviceCodeStatusPending {
+			ar.Logger.Printf("Error: invalid user code %v: %v", userCode, err)
+			data["Error"] = "The code is invalid or expired"
+			serveTemplate()
+			return
+		}
+
+		app, err := ar.AppStorage.ActiveAppByID(dc.AppID)
+		if err != nil {
+			ar.Logger.Printf("Error: getting app by id. %s", err)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+
+		if _, _, err := ar.webCookieUser(r, tokenValidator); err != nil {
+			ar.Logger.Printf("Error: user is not authenticated: %v", err)
+			deleteCookie(w, CookieKeyWebCookieToken)
+
+			scopesJSON, err := json.Marshal(dc.Scopes)
+			if err != nil {
+				http.Redirect(w, r, errorPath, http.StatusFound)
+				return
+			}
+
+			lq := url.Values{}
+			lq.Set(FormKeyAppID, app.ID)
+			lq.Set(scopesKey, string(scopesJSON))
+			lq.Set(callbackURLKey, path.Join(ar.PathPrefix, devicePath)+"?"+url.Values{userCodeKey: []string{userCode}}.Encode())
+			http.Redirect(w, r, path.Join(ar.PathPrefix, "/login")+"?"+lq.Encode(), http.StatusFound)
+			return
+		}
+
+		errorMessage, err := GetFlash(w, r, FlashErrorMessageKey)
+		if err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+			return
+		}
+
+		appName := app.Name
+		if appName == "" {
+			appName = app.ID
+		}
+		data["AppName"] = appName
+		data["Scopes"] = dc.Scopes
+		data["UserCode"] = dc.UserCode
+		data["CSRF"] = deviceCSRFToken(r, dc.UserCode)
+		data["Error"] = errorMessage
+		serveTemplate()
+	}
+}
+
+// Device handles approval or denial of the device authorization request.
+func (ar *Router) Device() http.HandlerFunc {
+	tmpl, err := ar.staticFilesStorage.ParseTemplate(model.StaticPagesNames.Device)
+	if err != nil {
+		ar.Logger.Fatalln("Cannot parse Device template.", err)
+	}
+	tokenValidator := ar.webCookieValidator()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userCode := model.NormalizeUserCode(r.FormValue(userCodeKey))
+		devicePageURL := path.Join(ar.PathPrefix, devicePath) + "?" + url.Values{userCodeKey: []string{userCode}}.Encode()
+
+		serveMessage := func(message string) {
+			data := map[string]interface{}{
+				"Prefix":  ar.PathPrefix,
+				"Message": message,
+			}
+			if err := tmpl.Execute(w, data); err != nil {
+				ar.Error(w, err, http.StatusInternalServerError, "")
+			}
+		}
+
+		// The device page checks the code and the user authentication itself, so we just send the user back there.
+		user, authCtx, err := ar.webCookieUser(r, tokenValidator)
+		if err != nil {
+			ar.Logger.Printf("Error: user is not authenticated: %v", err)
+			http.Redirect(w, r, devicePageURL, http.StatusFound)
+			return
+		}
+
+		expectedCSRF := deviceCSRFToken(r, userCode)
+		if subtle.ConstantTimeCompare([]byte(r.FormValue(deviceCSRFKey)), []byte(expectedCSRF)) != 1 {
+			ar.Logger.Printf("Error: invalid CSRF token for user code %v", userCode)
+			http.Redirect(w, r, devicePageURL, http.StatusFound)
+			return
+		}
+
+		dc, err := ar.DeviceCodeStorage.DeviceCodeByUserCode(userCode)
+		if err != nil || dc.Expired() || dc.Status != model.DeviceCodeStatusPending {
+			http.Redirect(w, r, devicePageURL, http.StatusFound)
+			return
+		}
+
+		if r.FormValue(deviceActionKey) != deviceActionApprove {
+			dc.Status = model.DeviceCodeStatusDenied
+			if err := ar.DeviceCodeStorage.UpdateDeviceCode(dc); err != nil {
+				ar.Error(w, err, http.StatusInternalServerError, "")
+				return
+			}
+			serveMessage("The request has been denied. You can close this page.")
+			return
+		}
+
+		app, err := ar.AppStorage.ActiveAppByID(dc.AppID)
+		if err != nil {
+			ar.Error(w, err, http.StatusBadRequest, "")
+			return
+		}
+
+		scopes, err := ar.UserStorage.RequestScopes(user.ID, dc.Scopes)
+		if err != nil {
+			SetFlash(w, FlashErrorMessageKey, err.Error())
+			http.Redirect(w, r, devicePageURL, http.StatusFound)
+			return
+		}
+
+		// Authorize user if the app requires authorization.
+		azi := authorization.AuthzInfo{
+			App:         app,
+			UserRole:    user.AccessRole,
+			ResourceURI: r.RequestURI,
+			Method:      r.Method,
+		}
+		if err := ar.Authorizer.Authorize(azi); err != nil {
+			SetFlash(w, FlashErrorMessageKey, err.Error())
+			http.Redirect(w, r, devicePageURL, http.StatusFound)
+			return
+		}
+
+		dc.Status = model.DeviceCodeStatusApproved
+		dc.UserID = user.ID
+		dc.Scopes = scopes
+		dc.AuthTime = authCtx.Time
+		dc.AMR = authCtx.Methods
+		dc.ACR = authCtx.ACR
+		if err := ar.DeviceCodeStorage.UpdateDeviceCode(dc); err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+			return
+		}
+		serveMessage("Your device has been connected. You can close this page and continue on your device.")
+	}
+}
+
+// webCookieValidator returns the validator for web cookie tokens.
+func (ar *Router) webCookieValidator() jwtValidator.Validator {
+	return jwtValidator.NewValidator(
+		[]string{"identifo"},
+		[]string{ar.TokenService.Issuer()},
+		[]string{},
+		[]string{model.TokenTypeWebCookie},
+	)
+}
+
+// deviceCSRFToken binds the confirmation form to the user session and the user code.
+// Web cookie is HTTP only, so other sites cannot compute the token.
+func deviceCSRFToken(r *http.Request, userCode string) string {
+	cookie, _ := getCookie(r, CookieKeyWebCookieToken)
+	s := sha256.Sum256([]byte(cookie + ":" + userCode))
+	return base64.RawURLEncoding.EncodeToString(s[:])
+}
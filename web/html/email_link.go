@@ -0,0 +1,184 @@
+package html
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/web/authorization"
+	"github.com/madappgang/identifo/web/magiclink"
+	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/ratelimit"
+	"github.com/madappgang/identifo/web/tfa"
+)
+
+const emailLinkPath = "/email_link"
+
+// SendEmailLink emails the link, which signs the user in without password.
+// The link leads back to the login flow with the same app, scopes and callback URL.
+func (ar *Router) SendEmailLink() http.HandlerFunc {
+	sentPath := path.Join(ar.PathPrefix, emailLinkPath, "sent")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		app := middleware.AppFromContext(r.Context())
+		q := loginQuery(app.ID, r.FormValue(scopesKey), r.FormValue(callbackURLKey))
+
+		if !ar.SupportedLoginWays.Email {
+			ar.redirectToLogin(w, r, q, "Login with email is not supported")
+			return
+		}
+
+		email := r.FormValue(usernameKey)
+		if !model.EmailRegexp.MatchString(email) {
+			ar.redirectToLogin(w, r, q, "Invalid email")
+			return
+		}
+
+		if ar.rateLimited(w, r, ratelimit.PolicyEmailCode, ratelimit.Request{User: email, App: app.ID}) {
+			return
+		}
+
+		host, err := url.Parse(ar.Host)
+		if err != nil {
+			ar.Logger.Printf("Error: parsing host %v", err)
+			ar.redirectToLogin(w, r, q, "Server Error. Try later please")
+			return
+		}
+		link := url.URL{
+			Scheme:   host.Scheme,
+			Host:     host.Host,
+			Path:     path.Join(ar.PathPrefix, emailLinkPath),
+			RawQuery: q.Encode(),
+		}
+
+		if err = ar.magicLink.Send(app, email, link); err != nil {
+			ar.Logger.Printf("Error: sending email link %v", err)
+			ar.redirectToLogin(w, r, q, "Unable to send email. Try again or contact support team")
+			return
+		}
+		http.Redirect(w, r, sentPath, http.StatusFound)
+	}
+}
+
+// EmailLinkHandler serves the page, where the user who has followed the link from the email confirms the login.
+// The link is not used on GET, so mail scanners which open links do not use it up.
+func (ar *Router) EmailLinkHandler() http.HandlerFunc {
+	tmpl, err := ar.staticFilesStorage.ParseTemplate(model.StaticPagesNames.EmailLink)
+	if err != nil {
+		ar.Logger.Fatalln("Cannot parse EmailLink template.", err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		app := middleware.AppFromContext(r.Context())
+		q := loginQuery(app.ID, r.URL.Query().Get(scopesKey), r.URL.Query().Get(callbackURLKey))
+
+		if !ar.SupportedLoginWays.Email {
+			ar.redirectToLogin(w, r, q, "Login with email is not supported")
+			return
+		}
+
+		data := map[string]interface{}{
+			"Prefix":      ar.PathPrefix,
+			"AppId":       app.ID,
+			"AppName":     app.Name,
+			"Scopes":      r.URL.Query().Get(scopesKey),
+			"CallbackURL": r.URL.Query().Get(callbackURLKey),
+			"Token":       r.URL.Query().Get(magiclink.QueryToken),
+		}
+		if err := tmpl.Execute(w, data); err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+		}
+	}
+}
+
+// EmailLinkSentHandler serves the page, which asks the user to check their email for the link.
+func (ar *Router) EmailLinkSentHandler() http.HandlerFunc {
+	tmpl, err := ar.staticFilesStorage.ParseTemplate(model.StaticPagesNames.EmailLink)
+	if err != nil {
+		ar.Logger.Fatalln("Cannot parse EmailLink template.", err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]interface{}{
+			"Prefix": ar.PathPrefix,
+			"Sent":   true,
+		}
+		if err := tmpl.Execute(w, data); err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+		}
+	}
+}
+
+// EmailLinkLogin signs in the user who has confirmed the login with the link from the email, and registers them if they are new.
+// The user passes the second factor if the app requires it, then the login page redirects them to the callback URL.
+func (ar *Router) EmailLinkLogin() http.HandlerFunc {
+	errorPath := path.Join(ar.PathPrefix, "/misconfiguration")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		app := middleware.AppFromContext(r.Context())
+		scopesJSON := r.FormValue(scopesKey)
+		q := loginQuery(app.ID, scopesJSON, r.FormValue(callbackURLKey))
+
+		if !ar.SupportedLoginWays.Email {
+			ar.redirectToLogin(w, r, q, "Login with email is not supported")
+			return
+		}
+
+		scopes := []string{}
+		if err := json.Unmarshal([]byte(scopesJSON), &scopes); err != nil {
+			ar.Logger.Printf("invalid scopes %v", scopesJSON)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+
+		user, err := ar.magicLink.Login(app, r.FormValue(magiclink.QueryToken))
+		switch err {
+		case nil:
+		case magiclink.ErrorInvalidLink, magiclink.ErrorRegistrationForbidden:
+			ar.redirectToLogin(w, r, q, err.Error())
+			return
+		default:
+			ar.Logger.Printf("Error: email link login %v", err)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+
+		if _, err = ar.UserStorage.RequestScopes(user.ID, scopes); err != nil {
+			ar.Logger.Printf("invalid scopes %v for userID: %v", scopes, user.ID)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+
+		// Authorize user if the app requires authorization.
+		azi := authorization.AuthzInfo{
+			App:         app,
+			UserRole:    user.AccessRole,
+			ResourceURI: r.RequestURI,
+			Method:      r.Method,
+		}
+		if err := ar.Authorizer.Authorize(azi); err != nil {
+			ar.redirectToLogin(w, r, q, err.Error())
+			return
+		}
+
+		// User passes the second factor before they get the web cookie, if the app requires it.
+		tfaPath, err := ar.startWebSession(w, app, user, model.NewAuthContext(model.AMROTP))
+		if _, ok := err.(tfa.Error); ok {
+			ar.redirectToLogin(w, r, q, err.Error())
+			return
+		}
+		if err != nil {
+			ar.Logger.Printf("error starting web session %v", err)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+		if tfaPath != "" {
+			ar.redirectToTFAPage(w, r, tfaPath, q, "")
+			return
+		}
+
+		ar.redirectToLogin(w, r, q, "")
+	}
+}
@@ -9,4 +9,8 @@ func (e Error) Error() string { return string(e) }
 const (
 	// ErrorRegistrationForbidden means that registration is forbidden.
 	ErrorRegistrationForbidden = Error("Registration in this app is forbidden.")
+	// ErrorTFAChallengeExpired means that the user has not passed the second factor in time, and should sign in again.
+	ErrorTFAChallengeExpired = Error("Sign-in has expired, please try again")
+	// ErrorRateLimited means that the client has sent too many requests, and should retry after a while.
+	ErrorRateLimited = Error("Too many requests, please try again later")
 )
@@ -0,0 +1,267 @@
+package html
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/web/authorization"
+	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/tfa"
+)
+
+const (
+	// CookieKeyFederatedState cookie key to keep federated sign-in state until the user comes back from the provider.
+	CookieKeyFederatedState = "identifo-federated"
+
+	federatedStateLifespan = 600 // ten minutes
+	federatedProviderKey   = "provider"
+	federatedCallbackPath  = "/federated/callback"
+)
+
+// federatedState is what we need to complete federated sign-in, when the user comes back from the provider.
+type federatedState struct {
+	State       string `json:"state"`
+	Nonce       string `json:"nonce"`
+	AppID       string `json:"app_id"`
+	Provider    string `json:"provider"`
+	Scopes      string `json:"scopes"`
+	CallbackURL string `json:"callback_url"`
+}
+
+// FederatedLogin redirects the user to the federated provider sign-in page, like Facebook, Apple or the app's OpenID Connect provider.
+func (ar *Router) FederatedLogin() http.HandlerFunc {
+	errorPath := path.Join(ar.PathPrefix, "/misconfiguration")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		app := middleware.AppFromContext(r.Context())
+		providerName := r.URL.Query().Get(federatedProviderKey)
+		provider, settings, ok := ar.redirectProvider(app, providerName)
+		if !ok {
+			ar.Logger.Printf("Federated provider %v does not support sign-in with redirect for app %v", providerName, app.ID)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+
+		state, err := randomFederatedState()
+		if err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+			return
+		}
+		nonce, err := randomFederatedState()
+		if err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+			return
+		}
+
+		signInURL, err := provider.SignInURL(settings, ar.federatedRedirectURI(), state, nonce)
+		if err != nil {
+			ar.Logger.Printf("Error getting federated provider %v sign-in URL: %v", providerName, err)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+
+		fs, err := json.Marshal(federatedState{
+			State:       state,
+			Nonce:       nonce,
+			AppID:       app.ID,
+			Provider:    providerName,
+			Scopes:      strings.TrimSpace(r.URL.Query().Get(scopesKey)),
+			CallbackURL: strings.TrimSpace(r.URL.Query().Get(callbackURLKey)),
+		})
+		if err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+			return
+		}
+
+		setPathCookie(w, CookieKeyFederatedState, string(fs), ar.cookiePath(), federatedStateLifespan)
+		http.Redirect(w, r, signInURL, http.StatusFound)
+	}
+}
+
+// FederatedCallback completes sign-in with the federated provider.
+// The user is registered, unless the app forbids registration, and gets the web cookie token.
+// Then the login page redirects them to the callback URL.
+func (ar *Router) FederatedCallback() http.HandlerFunc {
+	errorPath := path.Join(ar.PathPrefix, "/misconfiguration")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		fsJSON, err := getCookie(r, CookieKeyFederatedState)
+		deletePathCookie(w, CookieKeyFederatedState, ar.cookiePath())
+		var fs federatedState
+		if err != nil || fsJSON == "" || json.Unmarshal([]byte(fsJSON), &fs) != nil {
+			ar.Logger.Printf("Error getting federated sign-in state: %v", err)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+
+		redirectToLogin := func(errorMessage string) {
+			ar.redirectToLogin(w, r, loginQuery(fs.AppID, fs.Scopes, fs.CallbackURL), errorMessage)
+		}
+
+		// State protects from the responses to the requests someone else has made.
+		state := r.URL.Query().Get("state")
+		if subtle.ConstantTimeCompare([]byte(state), []byte(fs.State)) != 1 {
+			ar.Logger.Printf("Federated sign-in state mismatch for app %v", fs.AppID)
+			redirectToLogin("Sign-in has expired, please try again")
+			return
+		}
+		if providerError := r.URL.Query().Get("error"); providerError != "" {
+			ar.Logger.Printf("Federated provider %v error: %v %v", fs.Provider, providerError, r.URL.Query().Get("error_description"))
+			redirectToLogin("Sign-in has been cancelled")
+			return
+		}
+
+		app, err := ar.AppStorage.ActiveAppByID(fs.AppID)
+		if err != nil {
+			ar.Logger.Printf("Error: getting app by id. %s", err)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+		provider, settings, ok := ar.redirectProvider(app, fs.Provider)
+		if !ok {
+			ar.Logger.Printf("Federated provider %v does not support sign-in with redirect for app %v", fs.Provider, app.ID)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+
+		profile, err := provider.UserProfile(settings, model.FederatedCredential{
+			AuthorizationCode: r.URL.Query().Get("code"),
+			RedirectURI:       ar.federatedRedirectURI(),
+			Nonce:             fs.Nonce,
+		})
+		if err == nil && len(profile.ID) == 0 {
+			err = model.ErrUserNotFound
+		}
+		if err != nil {
+			ar.Logger.Printf("Error signing in with federated provider %v: %v", fs.Provider, err)
+			redirectToLogin("Unable to sign in with " + fs.Provider)
+			return
+		}
+
+		fid := provider.ID()
+		user, err := ar.UserStorage.UserByFederatedID(fid, profile.ID)
+		if err == model.ErrUserNotFound && app.LinkFederatedByEmail {
+			user, err = model.LinkFederatedIDByEmail(ar.UserStorage, ar.trustedFederated, fid, profile)
+		}
+		if err == model.ErrUserNotFound {
+			if app.RegistrationForbidden {
+				redirectToLogin(ErrorRegistrationForbidden.Error())
+				return
+			}
+			if user, err = ar.UserStorage.AddUserWithFederatedID(fid, profile.ID, app.NewUserDefaultRole); err == nil && len(profile.Email) > 0 {
+				// Email is saved only if nobody else has it.
+				if _, emailErr := ar.UserStorage.UserByEmail(profile.Email); emailErr != nil {
+					user.Email, user.EmailVerified = profile.Email, profile.EmailVerified
+					user, err = ar.UserStorage.UpdateUser(user.ID, user)
+				}
+			}
+		}
+		if err != nil {
+			ar.Logger.Printf("Error getting federated user: %v", err)
+			redirectToLogin("Unable to sign in with " + fs.Provider)
+			return
+		}
+
+		// Authorize user if the app requires authorization.
+		azi := authorization.AuthzInfo{
+			App:         app,
+			UserRole:    user.AccessRole,
+			ResourceURI: r.RequestURI,
+			Method:      r.Method,
+		}
+		if err := ar.Authorizer.Authorize(azi); err != nil {
+			redirectToLogin(err.Error())
+			return
+		}
+
+		// User passes the second factor before they get the web cookie, if the app requires it.
+		tfaPath, err := ar.startWebSession(w, app, user, model.NewAuthContext(model.AMRFederated))
+		if _, ok := err.(tfa.Error); ok {
+			redirectToLogin(err.Error())
+			return
+		}
+		if err != nil {
+			ar.Logger.Printf("error starting web session %v", err)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+		if tfaPath != "" {
+			ar.redirectToTFAPage(w, r, tfaPath, loginQuery(fs.AppID, fs.Scopes, fs.CallbackURL), "")
+			return
+		}
+
+		redirectToLogin("")
+	}
+}
+
+// redirectProvider returns the provider, if the app has enabled it, and it supports sign-in with redirect.
+func (ar *Router) redirectProvider(app model.AppData, name string) (model.FederatedRedirectProvider, model.FederatedSettings, bool) {
+	provider, settings, ok := ar.federatedProviders.AppProvider(app, name)
+	if !ok {
+		return nil, nil, false
+	}
+	rp, ok := provider.(model.FederatedRedirectProvider)
+	if !ok || !rp.RedirectConfigured(settings) {
+		return nil, nil, false
+	}
+	return rp, settings, true
+}
+
+// federatedLoginLink is a sign-in button of the federated provider on the login and registration pages.
+type federatedLoginLink struct {
+	Name string
+	URL  string
+}
+
+// federatedLoginLinks returns sign-in buttons of the app's providers, which support sign-in with redirect.
+func (ar *Router) federatedLoginLinks(app model.AppData, scopesJSON, callbackURL string) []federatedLoginLink {
+	links := []federatedLoginLink{}
+	for _, name := range ar.federatedProviders.AppProviderNames(app) {
+		if _, _, ok := ar.redirectProvider(app, name); !ok {
+			continue
+		}
+
+		q := url.Values{}
+		q.Set(FormKeyAppID, app.ID)
+		q.Set(federatedProviderKey, name)
+		q.Set(scopesKey, scopesJSON)
+		q.Set(callbackURLKey, callbackURL)
+		links = append(links, federatedLoginLink{Name: name, URL: path.Join(ar.PathPrefix, "/federated") + "?" + q.Encode()})
+	}
+	return links
+}
+
+// federatedRedirectURI is where federated providers send the user back to.
+// It should be registered with the provider.
+func (ar *Router) federatedRedirectURI() string {
+	host, _ := url.Parse(ar.Host)
+	u := &url.URL{
+		Scheme: host.Scheme,
+		Host:   host.Host,
+		Path:   path.Join(ar.PathPrefix, federatedCallbackPath),
+	}
+	return u.String()
+}
+
+// cookiePath is the path of the cookies, which are shared by all the pages.
+func (ar *Router) cookiePath() string {
+	if ar.PathPrefix == "" {
+		return "/"
+	}
+	return ar.PathPrefix
+}
+
+func randomFederatedState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/madappgang/identifo/model"
 	"github.com/madappgang/identifo/web/authorization"
 	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/ratelimit"
+	"github.com/madappgang/identifo/web/tfa"
 )
 
 const (
@@ -51,6 +53,10 @@ func (ar *Router) Login() http.HandlerFunc {
 			return
 		}
 
+		if ar.rateLimited(w, r, ratelimit.PolicyLogin, ratelimit.Request{User: username, App: app.ID}) {
+			return
+		}
+
 		user, err := ar.UserStorage.UserByNamePassword(username, password)
 		if err != nil {
 			SetFlash(w, FlashErrorMessageKey, "invalid Username or Password")
@@ -79,22 +85,23 @@ func (ar *Router) Login() http.HandlerFunc {
 			return
 		}
 
-		token, err := ar.TokenService.NewWebCookieToken(user)
-		if err != nil {
-			ar.Logger.Printf("error creating auth token %v", err)
-			http.Redirect(w, r, errorPath, http.StatusFound)
+		// User passes the second factor before they get the web cookie, if the app requires it.
+		tfaPath, err := ar.startWebSession(w, app, user, model.NewAuthContext(model.AMRPassword))
+		if _, ok := err.(tfa.Error); ok {
+			SetFlash(w, FlashErrorMessageKey, err.Error())
+			redirectToLogin()
 			return
 		}
-
-		tokenString, err := ar.TokenService.String(token)
 		if err != nil {
-			ar.Logger.Printf("error making a call to stringify the token: %v", err)
+			ar.Logger.Printf("error starting web session %v", err)
 			http.Redirect(w, r, errorPath, http.StatusFound)
 			return
 		}
+		if tfaPath != "" {
+			ar.redirectToTFAPage(w, r, tfaPath, loginQuery(app.ID, scopesJSON, callbackURL), "")
+			return
+		}
 
-		ar.UserStorage.UpdateLoginMetadata(user.ID)
-		setCookie(w, CookieKeyWebCookieToken, tokenString, int(ar.TokenService.WebCookieTokenLifespan()))
 		redirectToLogin()
 	}
 }
@@ -106,6 +113,8 @@ func (ar *Router) LoginHandler() http.HandlerFunc {
 		ar.Logger.Fatalln("Cannot parse Login template.", err)
 	}
 	errorPath := path.Join(ar.PathPrefix, "/misconfiguration")
+	authorizePath := path.Join(ar.PathPrefix, oauthAuthorizePath)
+	devicePagePath := path.Join(ar.PathPrefix, devicePath)
 	tokenValidator := jwtValidator.NewValidator(
 		[]string{"identifo"},
 		[]string{ar.TokenService.Issuer()},
@@ -130,7 +139,10 @@ func (ar *Router) LoginHandler() http.HandlerFunc {
 		}
 
 		callbackURL := strings.TrimSpace(r.URL.Query().Get(callbackURLKey))
-		if !contains(app.RedirectURLs, callbackURL) {
+		// OAuth authorization requests come back to the authorize endpoint, which validates redirect URI itself,
+		// and device authorization requests come back to the device page.
+		isInternalCallback := strings.HasPrefix(callbackURL, authorizePath+"?") || strings.HasPrefix(callbackURL, devicePagePath+"?")
+		if !isInternalCallback && !contains(app.RedirectURLs, callbackURL) {
 			ar.Logger.Printf("Unauthorized redirect url %v for app %v", callbackURL, app.ID)
 			http.Redirect(w, r, errorPath, http.StatusFound)
 			return
@@ -144,11 +156,14 @@ func (ar *Router) LoginHandler() http.HandlerFunc {
 			}
 
 			data := map[string]interface{}{
-				"Error":       errorMessage,
-				"Prefix":      ar.PathPrefix,
-				"Scopes":      scopesJSON,
-				"CallbackURL": callbackURL,
-				"AppId":       app.ID,
+				"Error":              errorMessage,
+				"Prefix":             ar.PathPrefix,
+				"Scopes":             scopesJSON,
+				"CallbackURL":        callbackURL,
+				"AppId":              app.ID,
+				"FederatedProviders": ar.federatedLoginLinks(app, scopesJSON, callbackURL),
+				"WebAuthn":           ar.SupportedLoginWays.WebAuthn,
+				"EmailLink":          ar.SupportedLoginWays.Email,
 			}
 
 			if err = tmpl.Execute(w, data); err != nil {
@@ -179,6 +194,11 @@ func (ar *Router) LoginHandler() http.HandlerFunc {
 			return
 		}
 
+		if isInternalCallback {
+			http.Redirect(w, r, callbackURL, http.StatusFound)
+			return
+		}
+
 		userID := webCookieToken.UserID()
 		user, err := ar.UserStorage.UserByID(userID)
 		if err != nil {
@@ -194,8 +214,8 @@ func (ar *Router) LoginHandler() http.HandlerFunc {
 			return
 		}
 
-		// TODO: Add TFA support.
-		token, err := ar.TokenService.NewAccessToken(user, scopes, app, false, nil)
+		// Web cookie is set after the second factor, if the app requires it, and tells how the user has authenticated.
+		token, err := ar.TokenService.NewAccessToken(user, scopes, app, false, nil, authContext(webCookieToken))
 		if err != nil {
 			ar.Logger.Printf("Error creating token: %v", err)
 			serveTemplate()
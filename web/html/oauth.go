@@ -0,0 +1,206 @@
+package html
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	ijwt "github.com/madappgang/identifo/jwt"
+	jwtService "github.com/madappgang/identifo/jwt/service"
+	jwtValidator "github.com/madappgang/identifo/jwt/validator"
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/web/authorization"
+)
+
+const (
+	oauthAuthorizePath = "/oauth/authorize"
+
+	oauthClientIDKey            = "client_id"
+	oauthRedirectURIKey         = "redirect_uri"
+	oauthResponseTypeKey        = "response_type"
+	oauthScopeKey               = "scope"
+	oauthStateKey               = "state"
+	oauthCodeChallengeKey       = "code_challenge"
+	oauthCodeChallengeMethodKey = "code_challenge_method"
+	oauthCodeKey                = "code"
+	oauthNonceKey               = "nonce"
+
+	oauthResponseTypeCode        = "code"
+	oauthCodeChallengeMethodS256 = "S256"
+)
+
+// Authorize handles OAuth 2.0 authorization requests (authorization code grant with PKCE).
+// Users without a valid web cookie are sent to the login page first and then redirected back here.
+func (ar *Router) Authorize() http.HandlerFunc {
+	errorPath := path.Join(ar.PathPrefix, "/misconfiguration")
+	authorizePath := path.Join(ar.PathPrefix, oauthAuthorizePath)
+	tokenValidator := jwtValidator.NewValidator(
+		[]string{"identifo"},
+		[]string{ar.TokenService.Issuer()},
+		[]string{},
+		[]string{model.TokenTypeWebCookie},
+	)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		app, err := ar.AppStorage.ActiveAppByID(strings.TrimSpace(q.Get(oauthClientIDKey)))
+		if err != nil {
+			ar.Logger.Printf("Error: getting app by id. %s", err)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+
+		// We must not redirect to the unverified redirect URI, so show an error page instead.
+		redirectURI := strings.TrimSpace(q.Get(oauthRedirectURIKey))
+		if !contains(app.RedirectURLs, redirectURI) {
+			ar.Logger.Printf("Unauthorized redirect url %v for app %v", redirectURI, app.ID)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+
+		state := q.Get(oauthStateKey)
+		redirectWithError := func(code, description string) {
+			ar.Logger.Printf("Error: authorization request failed: %s. %s", code, description)
+			redirectWithParams(w, r, redirectURI, url.Values{
+				"error":             []string{code},
+				"error_description": []string{description},
+				oauthStateKey:       []string{state},
+			})
+		}
+
+		if q.Get(oauthResponseTypeKey) != oauthResponseTypeCode {
+			redirectWithError("unsupported_response_type", "Only authorization code response type is supported")
+			return
+		}
+
+		codeChallenge := q.Get(oauthCodeChallengeKey)
+		if codeChallenge == "" || q.Get(oauthCodeChallengeMethodKey) != oauthCodeChallengeMethodS256 {
+			redirectWithError("invalid_request", "PKCE code challenge with S256 method is required")
+			return
+		}
+
+		scopes := strings.Fields(q.Get(oauthScopeKey))
+
+		user, authCtx, err := ar.webCookieUser(r, tokenValidator)
+		if err != nil {
+			ar.Logger.Printf("Error: user is not authenticated: %v", err)
+			deleteCookie(w, CookieKeyWebCookieToken)
+
+			scopesJSON, err := json.Marshal(scopes)
+			if err != nil {
+				redirectWithError("invalid_scope", err.Error())
+				return
+			}
+
+			lq := url.Values{}
+			lq.Set(FormKeyAppID, app.ID)
+			lq.Set(scopesKey, string(scopesJSON))
+			lq.Set(callbackURLKey, authorizePath+"?"+r.URL.RawQuery)
+			http.Redirect(w, r, path.Join(ar.PathPrefix, "/login")+"?"+lq.Encode(), http.StatusFound)
+			return
+		}
+
+		scopes, err = ar.UserStorage.RequestScopes(user.ID, scopes)
+		if err != nil {
+			redirectWithError("invalid_scope", err.Error())
+			return
+		}
+
+		// Authorize user if the app requires authorization.
+		azi := authorization.AuthzInfo{
+			App:         app,
+			UserRole:    user.AccessRole,
+			ResourceURI: r.RequestURI,
+			Method:      r.Method,
+		}
+		if err := ar.Authorizer.Authorize(azi); err != nil {
+			redirectWithError("access_denied", err.Error())
+			return
+		}
+
+		code, err := ar.TokenService.NewAuthorizationCode(user, scopes, app, map[string]interface{}{
+			jwtService.PayloadRedirectURI:   redirectURI,
+			jwtService.PayloadCodeChallenge: codeChallenge,
+			jwtService.PayloadNonce:         q.Get(oauthNonceKey),
+			jwtService.PayloadAuthTime:      authCtx.Time,
+			jwtService.PayloadAMR:           authCtx.Methods,
+			jwtService.PayloadACR:           authCtx.ACR,
+		})
+		if err != nil {
+			redirectWithError("server_error", err.Error())
+			return
+		}
+
+		codeString, err := ar.TokenService.String(code)
+		if err != nil {
+			redirectWithError("server_error", err.Error())
+			return
+		}
+
+		redirectWithParams(w, r, redirectURI, url.Values{
+			oauthCodeKey:  []string{codeString},
+			oauthStateKey: []string{state},
+		})
+	}
+}
+
+// webCookieUser returns the user authenticated with the web cookie token, and how and when they have authenticated.
+func (ar *Router) webCookieUser(r *http.Request, v jwtValidator.Validator) (model.User, model.AuthContext, error) {
+	tstr, err := getCookie(r, CookieKeyWebCookieToken)
+	if err != nil {
+		return model.User{}, model.AuthContext{}, err
+	}
+	if tstr == "" {
+		return model.User{}, model.AuthContext{}, http.ErrNoCookie
+	}
+
+	token, err := ar.TokenService.Parse(tstr)
+	if err != nil {
+		return model.User{}, model.AuthContext{}, err
+	}
+
+	if err = v.Validate(token); err != nil {
+		return model.User{}, model.AuthContext{}, err
+	}
+
+	user, err := ar.UserStorage.UserByID(token.UserID())
+	if err != nil {
+		return model.User{}, model.AuthContext{}, err
+	}
+	return user, authContext(token), nil
+}
+
+// authContext returns how and when the user of the web cookie or the challenge token has authenticated.
+// The cookies set before they kept it tell only when they were issued.
+func authContext(token ijwt.Token) model.AuthContext {
+	authTime := token.IssuedAt().Unix()
+	if t := token.AuthTime(); !t.IsZero() {
+		authTime = t.Unix()
+	}
+	return model.AuthContext{Methods: token.AMR(), ACR: token.ACR(), Time: authTime}
+}
+
+// redirectWithParams redirects to the URL with params appended to its query.
+// Empty params are omitted.
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURL string, params url.Values) {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := u.Query()
+	for k, vv := range params {
+		for _, v := range vv {
+			if v != "" {
+				q.Add(k, v)
+			}
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
@@ -0,0 +1,27 @@
+package html
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/madappgang/identifo/web/ratelimit"
+)
+
+// rateLimited counts the request against the limits of the policy, by the client IP address and the identifiers in req.
+// It writes 429 with Retry-After and returns true if the client has exceeded the limits.
+// Storage errors do not stop the request, not to lock everyone out when the storage is down.
+func (ar *Router) rateLimited(w http.ResponseWriter, r *http.Request, policy string, req ratelimit.Request) bool {
+	req.IP = ratelimit.ClientIP(r, ar.rateLimitSettings.TrustProxy)
+	retryAfter, err := ar.rateLimiter.Allow(policy, req)
+	if err != nil {
+		ar.Logger.Printf("Error: counting request to %s: %v", policy, err)
+		return false
+	}
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		ar.Error(w, ErrorRateLimited, http.StatusTooManyRequests, "")
+		return true
+	}
+	return false
+}
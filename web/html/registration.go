@@ -10,6 +10,7 @@ import (
 	"github.com/madappgang/identifo/model"
 	"github.com/madappgang/identifo/web/authorization"
 	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/ratelimit"
 )
 
 // Register creates user.
@@ -56,6 +57,10 @@ func (ar *Router) Register() http.HandlerFunc {
 			http.Redirect(w, r, path.Join(ar.PathPrefix, r.URL.String()), http.StatusFound)
 		}
 
+		if ar.rateLimited(w, r, ratelimit.PolicyRegister, ratelimit.Request{App: app.ID}) {
+			return
+		}
+
 		redirectToLogin := func() {
 			r.URL.Path = "login"
 
@@ -138,21 +143,18 @@ func (ar *Router) Register() http.HandlerFunc {
 			return
 		}
 
-		token, err := ar.TokenService.NewWebCookieToken(user)
+		// Apps with mandatory TFA make the new user enroll the factor before they get the web cookie.
+		tfaPath, err := ar.startWebSession(w, app, user, model.NewAuthContext(model.AMRPassword))
 		if err != nil {
-			ar.Logger.Printf("error creating auth token %v", err)
+			ar.Logger.Printf("error starting web session %v", err)
 			http.Redirect(w, r, errorPath, http.StatusFound)
 			return
 		}
-
-		tokenString, err := ar.TokenService.String(token)
-		if err != nil {
-			ar.Logger.Printf("error while making a call token stringify: %v", err)
-			http.Redirect(w, r, errorPath, http.StatusFound)
+		if tfaPath != "" {
+			ar.redirectToTFAPage(w, r, tfaPath, loginQuery(app.ID, scopesJSON, callbackURL), "")
 			return
 		}
 
-		setCookie(w, CookieKeyWebCookieToken, tokenString, int(ar.TokenService.WebCookieTokenLifespan()))
 		redirectToLogin()
 	}
 }
@@ -190,13 +192,15 @@ func (ar *Router) RegistrationHandler() http.HandlerFunc {
 			return
 		}
 
+		callbackURL := strings.TrimSpace(r.URL.Query().Get(callbackURLKey))
 		data := map[string]interface{}{
-			"Error":       errorMessage,
-			"Prefix":      ar.PathPrefix,
-			"Scopes":      scopesJSON,
-			"CallbackUrl": strings.TrimSpace(r.URL.Query().Get(callbackURLKey)),
-			"AppId":       app.ID,
-			"InviteToken": inviteToken,
+			"Error":              errorMessage,
+			"Prefix":             ar.PathPrefix,
+			"Scopes":             scopesJSON,
+			"CallbackUrl":        callbackURL,
+			"AppId":              app.ID,
+			"InviteToken":        inviteToken,
+			"FederatedProviders": ar.federatedLoginLinks(app, scopesJSON, callbackURL),
 		}
 
 		if err = tmpl.Execute(w, data); err != nil {
@@ -103,7 +103,7 @@ func (ar *Router) RenewToken() http.HandlerFunc {
 			return
 		}
 
-		token, err := ar.TokenService.NewAccessToken(user, scopes, app, false, nil)
+		token, err := ar.TokenService.NewAccessToken(user, scopes, app, false, nil, authContext(webCookieToken))
 		if err != nil {
 			ar.Logger.Printf("Error creating token: %v", err)
 			serveTemplate("server error", "", redirectURI)
@@ -9,6 +9,8 @@ import (
 	"path"
 	"regexp"
 	"strings"
+
+	"github.com/madappgang/identifo/web/ratelimit"
 )
 
 const emailExpr = "^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$"
@@ -39,6 +41,10 @@ func (ar *Router) SendResetToken() http.HandlerFunc {
 			return
 		}
 
+		if ar.rateLimited(w, r, ratelimit.PolicyResetPassword, ratelimit.Request{User: name}) {
+			return
+		}
+
 		if userExists := ar.UserStorage.UserExists(name); !userExists {
 			SetFlash(w, FlashErrorMessageKey, "This Email is unregistered")
 			http.Redirect(w, r, upath, http.StatusMovedPermanently)
@@ -8,9 +8,15 @@ import (
 	"os"
 
 	"github.com/gorilla/mux"
+	"github.com/madappgang/identifo/identity_providers/google"
+	"github.com/madappgang/identifo/identity_providers/registry"
 	jwtService "github.com/madappgang/identifo/jwt/service"
 	"github.com/madappgang/identifo/model"
 	"github.com/madappgang/identifo/web/authorization"
+	"github.com/madappgang/identifo/web/magiclink"
+	"github.com/madappgang/identifo/web/ratelimit"
+	"github.com/madappgang/identifo/web/tfa"
+	"github.com/madappgang/identifo/web/webauthn"
 	"github.com/rs/cors"
 	"github.com/urfave/negroni"
 )
@@ -24,6 +30,7 @@ type Router struct {
 	UserStorage        model.UserStorage
 	TokenStorage       model.TokenStorage
 	TokenBlacklist     model.TokenBlacklist
+	DeviceCodeStorage  model.DeviceCodeStorage
 	TokenService       jwtService.TokenService
 	SMSService         model.SMSService
 	EmailService       model.EmailService
@@ -32,6 +39,17 @@ type Router struct {
 	PathPrefix         string
 	Host               string
 	cors               *cors.Cors
+	federatedProviders *model.FederatedProviderRegistry
+	trustedFederated   []string
+	SupportedLoginWays model.LoginWith
+	webAuthn           *webauthn.Service
+	tfaType            model.TFAType
+	tfaSettings        model.TFASettings
+	tfaService         *tfa.Service
+	magicLink          *magiclink.Service
+	rateLimitStorage   model.RateLimitStorage
+	rateLimitSettings  model.RateLimitSettings
+	rateLimiter        *ratelimit.Limiter
 }
 
 func defaultOptions() []func(*Router) error {
@@ -66,8 +84,52 @@ func CorsOption(corsOptions *model.CorsOptions) func(*Router) error {
 	}
 }
 
+// FederatedProvidersOption sets the registry of federated providers users could sign in with.
+// Login and registration pages show the ones, which support sign-in with redirect.
+func FederatedProvidersOption(registry *model.FederatedProviderRegistry) func(*Router) error {
+	return func(r *Router) error {
+		r.federatedProviders = registry
+		return nil
+	}
+}
+
+// SupportedLoginWaysOption sets the ways users could sign in with, login page shows passkey button if it supports WebAuthn.
+func SupportedLoginWaysOption(loginWays model.LoginWith) func(*Router) error {
+	return func(r *Router) error {
+		r.SupportedLoginWays = loginWays
+		return nil
+	}
+}
+
+// TFAOption sets the server-wide TFA type and settings, login page asks users for the second factor the same way as the API does.
+func TFAOption(tfaType model.TFAType, settings model.TFASettings) func(*Router) error {
+	return func(r *Router) error {
+		r.tfaType = tfaType
+		r.tfaSettings = settings
+		return nil
+	}
+}
+
+// TrustedFederatedProvidersOption sets the providers, whose verified emails link federated identities to the existing users, the same as in the API.
+func TrustedFederatedProvidersOption(trusted []string) func(*Router) error {
+	return func(r *Router) error {
+		r.trustedFederated = trusted
+		return nil
+	}
+}
+
+// RateLimitOption sets the storage of request counters and the limits of the login, registration and reset password forms, the same as in the API.
+// Requests are not limited without storage.
+func RateLimitOption(storage model.RateLimitStorage, settings model.RateLimitSettings) func(*Router) error {
+	return func(r *Router) error {
+		r.rateLimitStorage = storage
+		r.rateLimitSettings = settings
+		return nil
+	}
+}
+
 // NewRouter creates and initializes new router.
-func NewRouter(logger *log.Logger, as model.AppStorage, us model.UserStorage, sfs model.StaticFilesStorage, ts model.TokenStorage, tb model.TokenBlacklist, tServ jwtService.TokenService, smsServ model.SMSService, emailServ model.EmailService, authorizer *authorization.Authorizer, options ...func(*Router) error) (model.Router, error) {
+func NewRouter(logger *log.Logger, as model.AppStorage, us model.UserStorage, sfs model.StaticFilesStorage, ts model.TokenStorage, tb model.TokenBlacklist, dcs model.DeviceCodeStorage, tServ jwtService.TokenService, smsServ model.SMSService, emailServ model.EmailService, authorizer *authorization.Authorizer, options ...func(*Router) error) (model.Router, error) {
 	ar := Router{
 		Middleware:         negroni.Classic(),
 		Router:             mux.NewRouter(),
@@ -75,6 +137,7 @@ func NewRouter(logger *log.Logger, as model.AppStorage, us model.UserStorage, sf
 		UserStorage:        us,
 		TokenStorage:       ts,
 		TokenBlacklist:     tb,
+		DeviceCodeStorage:  dcs,
 		TokenService:       tServ,
 		SMSService:         smsServ,
 		EmailService:       emailServ,
@@ -88,6 +151,14 @@ func NewRouter(logger *log.Logger, as model.AppStorage, us model.UserStorage, sf
 		}
 	}
 
+	if ar.federatedProviders == nil {
+		ar.federatedProviders = registry.NewDefault(google.JWKSURL)
+	}
+	ar.webAuthn = webauthn.NewService(ar.Host, ar.TokenService, ar.TokenBlacklist, ar.UserStorage)
+	ar.tfaService = tfa.NewService(ar.tfaType, ar.tfaSettings, ar.UserStorage, ar.SMSService, ar.EmailService)
+	ar.magicLink = magiclink.NewService(ar.TokenService, ar.TokenBlacklist, ar.UserStorage, ar.EmailService)
+	ar.rateLimiter = ratelimit.NewLimiter(ar.rateLimitStorage, ar.rateLimitSettings.Policies)
+
 	// Setup logger to stdout.
 	if logger == nil {
 		ar.Logger = log.New(os.Stdout, "HTML_ROUTER: ", log.Ldate|log.Ltime|log.Lshortfile)
@@ -53,6 +53,76 @@ func (ar *Router) initRoutes() {
 		negroni.WrapFunc(ar.LoginHandler()),
 	)).Methods("GET")
 
+	ar.Router.Path(`/tfa/{challenge:challenge/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.TFAChallengeHandler()),
+	)).Methods("GET")
+	ar.Router.Path(`/tfa/{challenge:challenge/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.TFAChallenge()),
+	)).Methods("POST")
+	ar.Router.Path(`/tfa/webauthn/{begin:begin/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.BeginWebAuthnTFA()),
+	)).Methods("POST")
+	ar.Router.Path(`/tfa/webauthn/{finish:finish/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.FinishWebAuthnTFA()),
+	)).Methods("POST")
+	ar.Router.Path(`/tfa/{code:code/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.SendTFACode()),
+	)).Methods("POST")
+	ar.Router.Path(`/tfa/{enroll:enroll/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.TFAEnrollHandler()),
+	)).Methods("GET")
+	ar.Router.Path(`/tfa/{enroll:enroll/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.EnrollTFA()),
+	)).Methods("POST")
+
+	ar.Router.Path(`/{email_link:email_link/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.EmailLinkHandler()),
+	)).Methods("GET")
+	ar.Router.HandleFunc(`/email_link/{sent:sent/?}`, ar.EmailLinkSentHandler()).Methods("GET")
+	ar.Router.Path(`/email_link/{login:login/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.EmailLinkLogin()),
+	)).Methods("POST")
+	ar.Router.Path(`/{email_link:email_link/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.SendEmailLink()),
+	)).Methods("POST")
+
+	ar.Router.Path(`/{federated:federated/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.FederatedLogin()),
+	)).Methods("GET")
+	ar.Router.HandleFunc(`/federated/{callback:callback/?}`, ar.FederatedCallback()).Methods("GET")
+
+	ar.Router.Path(`/webauthn/login/{begin:begin/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.BeginWebAuthnLogin()),
+	)).Methods("POST")
+	ar.Router.Path(`/webauthn/login/{finish:finish/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.FinishWebAuthnLogin()),
+	)).Methods("POST")
+	ar.Router.Path(`/{webauthn:webauthn/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.WebAuthnHandler()),
+	)).Methods("GET")
+	ar.Router.Path(`/webauthn/register/{begin:begin/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.BeginWebAuthnRegistration()),
+	)).Methods("POST")
+	ar.Router.Path(`/webauthn/register/{finish:finish/?}`).Handler(negroni.New(
+		ar.AppID(),
+		negroni.WrapFunc(ar.FinishWebAuthnRegistration()),
+	)).Methods("POST")
+
 	ar.Router.Path(`/{register:register/?}`).Handler(negroni.New(
 		ar.AppID(),
 		negroni.WrapFunc(ar.Register()),
@@ -63,6 +133,10 @@ func (ar *Router) initRoutes() {
 		negroni.WrapFunc(ar.RegistrationHandler()),
 	)).Methods("GET")
 
+	ar.Router.HandleFunc(`/oauth/{authorize:authorize/?}`, ar.Authorize()).Methods("GET")
+	ar.Router.HandleFunc(`/{device:device/?}`, ar.DeviceHandler()).Methods("GET")
+	ar.Router.HandleFunc(`/{device:device/?}`, ar.Device()).Methods("POST")
+
 	ar.Router.HandleFunc(`/token/{renew:renew/?}`, ar.RenewToken()).Methods("GET")
 	ar.Router.Path(`/{logout:logout/?}`).Handler(negroni.New(
 		ar.AppID(),
@@ -72,6 +146,7 @@ func (ar *Router) initRoutes() {
 	ar.Router.HandleFunc(`/{register:register/?}`, ar.HTMLFileHandler(model.StaticPagesNames.Registration)).Methods("GET")
 	ar.Router.HandleFunc(`/password/{forgot:forgot/?}`, ar.HTMLFileHandler(model.StaticPagesNames.ForgotPassword)).Methods("GET")
 	ar.Router.HandleFunc(`/password/forgot/{success:success/?}`, ar.HTMLFileHandler(model.StaticPagesNames.ForgotPasswordSuccess)).Methods("GET")
+	ar.Router.HandleFunc(`/email_link/{sent:sent/?}`, ar.HTMLFileHandler(model.StaticPagesNames.ForgotPasswordSuccess)).Methods("GET")
 	ar.Router.HandleFunc(`/password/reset/{error:error/?}`, ar.HTMLFileHandler(model.StaticPagesNames.TokenError)).Methods("GET")
 	ar.Router.HandleFunc(`/password/reset/{success:success/?}`, ar.HTMLFileHandler(model.StaticPagesNames.ResetPasswordSuccess)).Methods("GET")
 	ar.Router.HandleFunc(`/tfa/disable/{success:success/?}`, ar.HTMLFileHandler(model.StaticPagesNames.DisableTFASuccess)).Methods("GET")
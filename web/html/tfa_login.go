@@ -0,0 +1,464 @@
+package html
+
+import (
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	ijwt "github.com/madappgang/identifo/jwt"
+	jwtService "github.com/madappgang/identifo/jwt/service"
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/tfa"
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/xlzd/gotp"
+)
+
+const (
+	tfaChallengePath = "/tfa/challenge"
+	tfaEnrollPath    = "/tfa/enroll"
+	tfaCodeKey       = "tfa_code"
+	tfaTypeKey       = "tfa_type"
+)
+
+// startWebSession sets the web cookie token for the user who has signed in, authCtx tells how.
+// If the app requires the second factor, the user gets the challenge token instead,
+// and startWebSession returns the page where they pass the factor or enroll one.
+func (ar *Router) startWebSession(w http.ResponseWriter, app model.AppData, user model.User, authCtx model.AuthContext) (string, error) {
+	require2FA, enabled2FA, err := ar.tfaService.Check(app, user)
+	if !require2FA {
+		if enabled2FA && err != nil {
+			return "", err
+		}
+		return "", ar.setWebCookie(w, user, authCtx)
+	}
+
+	token, err := ar.TokenService.NewTFAChallengeToken(user, app, authCtx)
+	if err != nil {
+		return "", err
+	}
+	tokenString, err := ar.TokenService.String(token)
+	if err != nil {
+		return "", err
+	}
+	setPathCookie(w, CookieKeyTFAChallenge, tokenString, ar.cookiePath(), int(jwtService.TFAChallengeLifespan))
+
+	if !enabled2FA {
+		return tfaEnrollPath, nil
+	}
+
+	// The code is sent right away if the user has one factor, otherwise they choose the factor on the challenge page.
+	if factors := otpTFATypes(ar.tfaService.Factors(app, user)); len(factors) == 1 {
+		if err := ar.tfaService.SendCode(user, factors[0]); err != nil {
+			ar.Logger.Printf("Error: sending TFA code to user %v: %v", user.ID, err)
+		}
+	}
+	return tfaChallengePath, nil
+}
+
+// setWebCookie sets the web cookie token for the user who has passed all the factors.
+func (ar *Router) setWebCookie(w http.ResponseWriter, user model.User, authCtx model.AuthContext) error {
+	token, err := ar.TokenService.NewWebCookieToken(user, authCtx)
+	if err != nil {
+		return err
+	}
+	tokenString, err := ar.TokenService.String(token)
+	if err != nil {
+		return err
+	}
+
+	ar.UserStorage.UpdateLoginMetadata(user.ID)
+	setPathCookie(w, CookieKeyWebCookieToken, tokenString, ar.cookiePath(), int(ar.TokenService.WebCookieTokenLifespan()))
+	return nil
+}
+
+// TFAChallengeHandler serves the page where the user enters the one-time password after the password.
+func (ar *Router) TFAChallengeHandler() http.HandlerFunc {
+	tmpl, err := ar.staticFilesStorage.ParseTemplate(model.StaticPagesNames.TFAChallenge)
+	if err != nil {
+		ar.Logger.Fatalln("Cannot parse TFAChallenge template.", err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		errorMessage, err := GetFlash(w, r, FlashErrorMessageKey)
+		if err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		q := loginQuery(app.ID, r.URL.Query().Get(scopesKey), r.URL.Query().Get(callbackURLKey))
+		data := map[string]interface{}{
+			"Error":       errorMessage,
+			"Prefix":      ar.PathPrefix,
+			"AppId":       app.ID,
+			"Scopes":      q.Get(scopesKey),
+			"CallbackURL": q.Get(callbackURLKey),
+			"LoginURL":    path.Join(ar.PathPrefix, "/login") + "?" + q.Encode(),
+		}
+
+		user, _, _, err := ar.tfaChallengeUser(r)
+		if err != nil {
+			data["Error"] = ErrorTFAChallengeExpired.Error()
+			data["Expired"] = true
+		} else {
+			userFactors := ar.tfaService.Factors(app, user)
+			factors := otpTFATypes(userFactors)
+			tfaType := model.TFAType(r.URL.Query().Get(tfaTypeKey))
+			if len(factors) == 1 {
+				tfaType = factors[0]
+			}
+			if !tfa.ContainsType(factors, tfaType) {
+				tfaType = ""
+			}
+			data["Factors"] = factors
+			// Security key is passed with the script, it is the only factor which has no one-time password.
+			data["WebAuthn"] = tfa.ContainsType(tfa.Types(userFactors), model.TFATypeWebAuthn)
+			data["TFAType"] = tfaType
+			data["CodeSent"] = tfaType == model.TFATypeSMS || tfaType == model.TFATypeEmail
+		}
+
+		if err = tmpl.Execute(w, data); err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+		}
+	}
+}
+
+// TFAChallenge checks the one-time password or the recovery code, and sets the web cookie token.
+// Then the login page redirects the user to the callback URL.
+func (ar *Router) TFAChallenge() http.HandlerFunc {
+	errorPath := path.Join(ar.PathPrefix, "/misconfiguration")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		app := middleware.AppFromContext(r.Context())
+		q := loginQuery(app.ID, r.FormValue(scopesKey), r.FormValue(callbackURLKey))
+		tfaType := model.TFAType(r.FormValue(tfaTypeKey))
+		tfaCode := r.FormValue(tfaCodeKey)
+
+		redirectToChallenge := func(errorMessage string) {
+			SetFlash(w, FlashErrorMessageKey, errorMessage)
+			ar.redirectToTFAPage(w, r, tfaChallengePath, q, tfaType)
+		}
+
+		user, token, tokenString, err := ar.tfaChallengeUser(r)
+		if err != nil {
+			ar.redirectToLogin(w, r, q, ErrorTFAChallengeExpired.Error())
+			return
+		}
+
+		if len(tfaCode) == 0 {
+			redirectToChallenge("Empty one-time password")
+			return
+		}
+		if time.Until(user.TFAInfo.LockedUntil) > 0 {
+			redirectToChallenge("Too many failed attempts, please try again later")
+			return
+		}
+
+		// Recovery code replaces the one-time password when the user has lost their device.
+		current := user.TFAInfo
+		method := model.AMROTP
+		if !user.TFAInfo.UseRecoveryCode(tfaCode) {
+			if factors := otpTFATypes(ar.tfaService.Factors(app, user)); len(tfaType) == 0 && len(factors) == 1 {
+				tfaType = factors[0]
+			}
+
+			factor, otpVerified, err := ar.tfaService.VerifyCode(app, user, tfaCode, tfaType)
+			if err != nil {
+				redirectToChallenge(err.Error())
+				return
+			}
+			method = model.TFAMethod(tfaType)
+
+			dontNeedVerification := app.DebugTFACode != "" && tfaCode == app.DebugTFACode
+			if !(otpVerified || dontNeedVerification) {
+				if err := ar.tfaService.Fail(user); err != nil {
+					ar.Logger.Printf("Error: counting failed TFA attempt of user %v: %v", user.ID, err)
+				}
+				redirectToChallenge("Invalid one-time password")
+				return
+			}
+			if otpVerified {
+				// Keep the factor's last accepted code, so it could not be replayed.
+				user.TFAInfo.SetFactor(factor, ar.tfaService.DefaultType())
+			}
+		}
+
+		user.TFAInfo.FailedAttempts = 0
+		user.TFAInfo.LockedUntil = time.Time{}
+		// The code is accepted only if no concurrent request has accepted it, or another one, in between.
+		if err = ar.UserStorage.SwapTFAInfo(user.ID, user.TFAInfo, current); err == model.ErrorNotFound {
+			redirectToChallenge("Invalid one-time password")
+			return
+		} else if err != nil {
+			ar.Logger.Printf("Error: updating user %v after TFA: %v", user.ID, err)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+
+		// Challenge token is used once.
+		if err := ar.TokenBlacklist.Add(tokenString); err != nil {
+			ar.Logger.Printf("Cannot blacklist TFA challenge token after use: %s\n", err)
+		}
+		deletePathCookie(w, CookieKeyTFAChallenge, ar.cookiePath())
+
+		if err = ar.setWebCookie(w, user, authContext(token).WithSecondFactor(method)); err != nil {
+			ar.Logger.Printf("Error: setting web cookie: %v", err)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+		ar.redirectToLogin(w, r, q, "")
+	}
+}
+
+// SendTFACode sends the one-time password for the factor the user has chosen on the challenge page.
+func (ar *Router) SendTFACode() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app := middleware.AppFromContext(r.Context())
+		q := loginQuery(app.ID, r.FormValue(scopesKey), r.FormValue(callbackURLKey))
+		tfaType := model.TFAType(r.FormValue(tfaTypeKey))
+
+		user, _, _, err := ar.tfaChallengeUser(r)
+		if err != nil {
+			ar.redirectToLogin(w, r, q, ErrorTFAChallengeExpired.Error())
+			return
+		}
+
+		if !tfa.ContainsType(otpTFATypes(ar.tfaService.Factors(app, user)), tfaType) {
+			SetFlash(w, FlashErrorMessageKey, "This factor is not supported")
+			ar.redirectToTFAPage(w, r, tfaChallengePath, q, "")
+			return
+		}
+		if err := ar.tfaService.SendCode(user, tfaType); err != nil {
+			ar.Logger.Printf("Error: sending TFA code to user %v: %v", user.ID, err)
+			SetFlash(w, FlashErrorMessageKey, "Unable to send one-time password")
+		}
+		ar.redirectToTFAPage(w, r, tfaChallengePath, q, tfaType)
+	}
+}
+
+// TFAEnrollHandler serves the page where the user without the factor chooses one, if the app requires TFA.
+func (ar *Router) TFAEnrollHandler() http.HandlerFunc {
+	tmpl, err := ar.staticFilesStorage.ParseTemplate(model.StaticPagesNames.TFAEnroll)
+	if err != nil {
+		ar.Logger.Fatalln("Cannot parse TFAEnroll template.", err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		errorMessage, err := GetFlash(w, r, FlashErrorMessageKey)
+		if err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		q := loginQuery(app.ID, r.URL.Query().Get(scopesKey), r.URL.Query().Get(callbackURLKey))
+		data := map[string]interface{}{
+			"Error":       errorMessage,
+			"Prefix":      ar.PathPrefix,
+			"AppId":       app.ID,
+			"Scopes":      q.Get(scopesKey),
+			"CallbackURL": q.Get(callbackURLKey),
+			"LoginURL":    path.Join(ar.PathPrefix, "/login") + "?" + q.Encode(),
+		}
+
+		user, _, _, err := ar.tfaChallengeUser(r)
+		if err != nil {
+			data["Error"] = ErrorTFAChallengeExpired.Error()
+			data["Expired"] = true
+		} else if ar.canEnrollTFA(app, user) {
+			if _, _, reason := ar.tfaService.Check(app, user); reason != nil && len(errorMessage) == 0 {
+				data["Reason"] = reason.Error()
+			}
+			data["Types"] = ar.enrollableTFATypes(app, user)
+		} else {
+			ar.redirectToTFAPage(w, r, tfaChallengePath, q, "")
+			return
+		}
+
+		if err = tmpl.Execute(w, data); err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+		}
+	}
+}
+
+// EnrollTFA enrolls the factor the user has chosen, and shows what they need to pass it:
+// the secret for the authenticator app, or the code sent in SMS or email. Recovery codes are shown once.
+func (ar *Router) EnrollTFA() http.HandlerFunc {
+	tmpl, err := ar.staticFilesStorage.ParseTemplate(model.StaticPagesNames.TFAEnroll)
+	if err != nil {
+		ar.Logger.Fatalln("Cannot parse TFAEnroll template.", err)
+	}
+	errorPath := path.Join(ar.PathPrefix, "/misconfiguration")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		app := middleware.AppFromContext(r.Context())
+		q := loginQuery(app.ID, r.FormValue(scopesKey), r.FormValue(callbackURLKey))
+		tfaType := model.TFAType(r.FormValue(tfaTypeKey))
+
+		user, _, _, err := ar.tfaChallengeUser(r)
+		if err != nil {
+			ar.redirectToLogin(w, r, q, ErrorTFAChallengeExpired.Error())
+			return
+		}
+		// The user who could pass the factor they have must not replace it knowing the password only.
+		if !ar.canEnrollTFA(app, user) {
+			ar.redirectToTFAPage(w, r, tfaChallengePath, q, "")
+			return
+		}
+		if !tfa.ContainsType(ar.enrollableTFATypes(app, user), tfaType) {
+			SetFlash(w, FlashErrorMessageKey, "This factor is not supported")
+			ar.redirectToTFAPage(w, r, tfaEnrollPath, q, "")
+			return
+		}
+
+		factor := model.TFAFactor{Type: tfaType, Secret: gotp.RandomSecret(16)}
+		if !user.TFAInfo.IsEnabled {
+			// Factors left from the time TFA was disabled are not valid anymore.
+			user.TFAInfo = model.TFAInfo{}
+		}
+		user.TFAInfo.IsEnabled = true
+		user.TFAInfo.SetFactor(factor, ar.tfaService.DefaultType())
+
+		var recoveryCodes []string
+		if len(user.TFAInfo.RecoveryCodes) == 0 {
+			var recoveryCodeHashes []string
+			if recoveryCodes, recoveryCodeHashes, err = model.NewRecoveryCodes(); err != nil {
+				ar.Logger.Printf("Error: creating recovery codes: %v", err)
+				http.Redirect(w, r, errorPath, http.StatusFound)
+				return
+			}
+			user.TFAInfo.RecoveryCodes = recoveryCodeHashes
+		}
+
+		if _, err = ar.UserStorage.UpdateUser(user.ID, user); err != nil {
+			ar.Logger.Printf("Error: enrolling TFA factor of user %v: %v", user.ID, err)
+			http.Redirect(w, r, errorPath, http.StatusFound)
+			return
+		}
+
+		data := map[string]interface{}{
+			"Prefix":        ar.PathPrefix,
+			"AppId":         app.ID,
+			"Scopes":        q.Get(scopesKey),
+			"CallbackURL":   q.Get(callbackURLKey),
+			"LoginURL":      path.Join(ar.PathPrefix, "/login") + "?" + q.Encode(),
+			"Enrolled":      true,
+			"TFAType":       tfaType,
+			"RecoveryCodes": recoveryCodes,
+		}
+
+		switch tfaType {
+		case model.TFATypeApp:
+			uri := gotp.NewDefaultTOTP(factor.Secret).ProvisioningUri(user.Username, app.Name)
+			png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+			if err != nil {
+				ar.Logger.Printf("Error: creating TFA QR code: %v", err)
+				http.Redirect(w, r, errorPath, http.StatusFound)
+				return
+			}
+			data["TFASecret"] = factor.Secret
+			data["ProvisioningQR"] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+		case model.TFATypeSMS, model.TFATypeEmail:
+			if err := ar.tfaService.SendCode(user, tfaType); err != nil {
+				ar.Logger.Printf("Error: sending TFA code to user %v: %v", user.ID, err)
+				data["Error"] = "Unable to send one-time password"
+			}
+		}
+
+		if err = tmpl.Execute(w, data); err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+		}
+	}
+}
+
+// tfaChallengeUser returns the user who passes the second factor, and their challenge token, parsed and as it is in the cookie.
+func (ar *Router) tfaChallengeUser(r *http.Request) (model.User, ijwt.Token, string, error) {
+	tokenString, err := getCookie(r, CookieKeyTFAChallenge)
+	if err != nil {
+		return model.User{}, nil, "", err
+	}
+	if tokenString == "" {
+		return model.User{}, nil, "", http.ErrNoCookie
+	}
+
+	token, err := ar.TokenService.Parse(tokenString)
+	if err != nil {
+		return model.User{}, nil, "", err
+	}
+	app := middleware.AppFromContext(r.Context())
+	if token.Type() != model.TokenTypeTFAChallenge || !contains(token.Audience(), app.ID) || ar.TokenBlacklist.IsBlacklisted(tokenString) {
+		return model.User{}, nil, "", ErrorTFAChallengeExpired
+	}
+
+	user, err := ar.UserStorage.UserByID(token.Subject())
+	if err != nil {
+		return model.User{}, nil, "", err
+	}
+	return user, token, tokenString, nil
+}
+
+// canEnrollTFA tells if the app requires TFA, and the user has no factor they could pass.
+func (ar *Router) canEnrollTFA(app model.AppData, user model.User) bool {
+	require2FA, enabled2FA, _ := ar.tfaService.Check(app, user)
+	return require2FA && !enabled2FA
+}
+
+// enrollableTFATypes returns the factors the user could enroll on the web page.
+// Security keys are registered by the signed in users only.
+func (ar *Router) enrollableTFATypes(app model.AppData, user model.User) []model.TFAType {
+	types := []model.TFAType{}
+	for _, t := range ar.tfaService.AppTypes(app) {
+		switch {
+		case t == model.TFATypeWebAuthn:
+			continue
+		case t == model.TFATypeSMS && user.Phone == "":
+			continue
+		case t == model.TFATypeEmail && user.Email == "":
+			continue
+		}
+		types = append(types, t)
+	}
+	return types
+}
+
+// redirectToLogin sends the user back to the login page, which redirects them to the callback URL if they have the web cookie.
+// Error message is shown on the login page.
+func (ar *Router) redirectToLogin(w http.ResponseWriter, r *http.Request, q url.Values, errorMessage string) {
+	if errorMessage != "" {
+		setPathCookie(w, FlashErrorMessageKey, errorMessage, ar.cookiePath(), 600)
+	}
+	http.Redirect(w, r, path.Join(ar.PathPrefix, "/login")+"?"+q.Encode(), http.StatusFound)
+}
+
+// redirectToTFAPage sends the user to the challenge or enrollment page with the login request params.
+func (ar *Router) redirectToTFAPage(w http.ResponseWriter, r *http.Request, page string, q url.Values, tfaType model.TFAType) {
+	pq := loginQuery(q.Get(FormKeyAppID), q.Get(scopesKey), q.Get(callbackURLKey))
+	if tfaType != "" {
+		pq.Set(tfaTypeKey, string(tfaType))
+	}
+	http.Redirect(w, r, path.Join(ar.PathPrefix, page)+"?"+pq.Encode(), http.StatusFound)
+}
+
+// loginQuery returns the params of the login request, which the user gets back to after the second factor.
+func loginQuery(appID, scopes, callbackURL string) url.Values {
+	q := url.Values{}
+	q.Set(FormKeyAppID, appID)
+	q.Set(scopesKey, scopes)
+	q.Set(callbackURLKey, callbackURL)
+	return q
+}
+
+// otpTFATypes returns the types of the factors the user passes with one-time password on the web page.
+func otpTFATypes(factors []model.TFAFactor) []model.TFAType {
+	types := []model.TFAType{}
+	for _, t := range tfa.Types(factors) {
+		if t != model.TFATypeWebAuthn {
+			types = append(types, t)
+		}
+	}
+	return types
+}
@@ -0,0 +1,296 @@
+package html
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/web/authorization"
+	"github.com/madappgang/identifo/web/middleware"
+	"github.com/madappgang/identifo/web/tfa"
+	"github.com/madappgang/identifo/web/webauthn"
+)
+
+// BeginWebAuthnLogin returns the options to sign in with the passkey on the login page.
+func (ar *Router) BeginWebAuthnLogin() http.HandlerFunc {
+	type requestBody struct {
+		Username string `json:"username"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ar.SupportedLoginWays.WebAuthn {
+			ar.webAuthnError(w, http.StatusBadRequest, "Login with passkey is not supported")
+			return
+		}
+
+		d := requestBody{}
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			ar.webAuthnError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		// The same error for unknown users and users without passkeys, not to tell who is registered.
+		var user model.User
+		userID, err := ar.UserStorage.IDByName(d.Username)
+		if err == nil {
+			user, err = ar.UserStorage.UserByID(userID)
+		}
+		if err != nil {
+			ar.webAuthnError(w, http.StatusBadRequest, webauthn.ErrorNoCredentials.Error())
+			return
+		}
+
+		options, err := ar.webAuthn.BeginLogin(user, middleware.AppFromContext(r.Context()), webauthn.CeremonyLogin)
+		if err == webauthn.ErrorNoCredentials {
+			ar.webAuthnError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			ar.Logger.Printf("Error: begin webauthn login %v", err)
+			ar.webAuthnError(w, http.StatusInternalServerError, model.ErrorInternal.Error())
+			return
+		}
+		ar.serveJSON(w, http.StatusOK, options)
+	}
+}
+
+// FinishWebAuthnLogin signs the user in with the passkey, and sets the web cookie.
+// The login page redirects the user to the callback URL then.
+func (ar *Router) FinishWebAuthnLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ar.SupportedLoginWays.WebAuthn {
+			ar.webAuthnError(w, http.StatusBadRequest, "Login with passkey is not supported")
+			return
+		}
+
+		d := webauthn.Response{}
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			ar.webAuthnError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		user, err := ar.webAuthn.FinishLogin(app, webauthn.CeremonyLogin, d)
+		if err != nil {
+			ar.Logger.Printf("Error: finish webauthn login %v", err)
+			ar.webAuthnError(w, http.StatusUnauthorized, "Unable to verify passkey")
+			return
+		}
+
+		// Authorize user if the app requires authorization.
+		azi := authorization.AuthzInfo{
+			App:         app,
+			UserRole:    user.AccessRole,
+			ResourceURI: r.RequestURI,
+			Method:      r.Method,
+		}
+		if err := ar.Authorizer.Authorize(azi); err != nil {
+			ar.webAuthnError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		token, err := ar.TokenService.NewWebCookieToken(user, model.NewAuthContext(model.AMRWebAuthn))
+		if err != nil {
+			ar.Logger.Printf("Error: creating web cookie token %v", err)
+			ar.webAuthnError(w, http.StatusInternalServerError, model.ErrorInternal.Error())
+			return
+		}
+		tokenString, err := ar.TokenService.String(token)
+		if err != nil {
+			ar.Logger.Printf("Error: stringifying web cookie token %v", err)
+			ar.webAuthnError(w, http.StatusInternalServerError, model.ErrorInternal.Error())
+			return
+		}
+
+		ar.UserStorage.UpdateLoginMetadata(user.ID)
+		setCookie(w, CookieKeyWebCookieToken, tokenString, int(ar.TokenService.WebCookieTokenLifespan()))
+		ar.serveJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+	}
+}
+
+// WebAuthnHandler serves the page where the signed in user manages their passkeys and security keys.
+func (ar *Router) WebAuthnHandler() http.HandlerFunc {
+	tmpl, err := ar.staticFilesStorage.ParseTemplate(model.StaticPagesNames.WebAuthn)
+	if err != nil {
+		ar.Logger.Fatalln("Cannot parse WebAuthn template.", err)
+	}
+	tokenValidator := ar.webCookieValidator()
+
+	type credential struct {
+		Name      string
+		CreatedAt string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		app := middleware.AppFromContext(r.Context())
+		data := map[string]interface{}{
+			"Prefix": ar.PathPrefix,
+			"AppId":  app.ID,
+		}
+
+		user, _, err := ar.webCookieUser(r, tokenValidator)
+		if err != nil {
+			data["Error"] = "Please sign in to manage your passkeys"
+		} else {
+			credentials := make([]credential, 0, len(user.WebAuthnCredentials))
+			for _, c := range user.WebAuthnCredentials {
+				name := c.Name
+				if len(name) == 0 {
+					name = "Passkey"
+				}
+				credentials = append(credentials, credential{Name: name, CreatedAt: time.Unix(c.CreatedAt, 0).Format("Jan 2, 2006")})
+			}
+			data["Username"] = user.Username
+			data["Credentials"] = credentials
+		}
+
+		if err = tmpl.Execute(w, data); err != nil {
+			ar.Error(w, err, http.StatusInternalServerError, "")
+		}
+	}
+}
+
+// BeginWebAuthnRegistration returns the options to create new passkey for the signed in user.
+func (ar *Router) BeginWebAuthnRegistration() http.HandlerFunc {
+	tokenValidator := ar.webCookieValidator()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _, err := ar.webCookieUser(r, tokenValidator)
+		if err != nil {
+			ar.webAuthnError(w, http.StatusUnauthorized, "Please sign in to add passkey")
+			return
+		}
+
+		options, err := ar.webAuthn.BeginRegistration(user, middleware.AppFromContext(r.Context()))
+		if err != nil {
+			ar.Logger.Printf("Error: begin webauthn registration %v", err)
+			ar.webAuthnError(w, http.StatusInternalServerError, model.ErrorInternal.Error())
+			return
+		}
+		ar.serveJSON(w, http.StatusOK, options)
+	}
+}
+
+// FinishWebAuthnRegistration verifies the new passkey, and adds it to the signed in user.
+func (ar *Router) FinishWebAuthnRegistration() http.HandlerFunc {
+	tokenValidator := ar.webCookieValidator()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _, err := ar.webCookieUser(r, tokenValidator)
+		if err != nil {
+			ar.webAuthnError(w, http.StatusUnauthorized, "Please sign in to add passkey")
+			return
+		}
+
+		d := webauthn.Response{}
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			ar.webAuthnError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		if _, err = ar.webAuthn.FinishRegistration(user, middleware.AppFromContext(r.Context()), d); err != nil {
+			ar.Logger.Printf("Error: finish webauthn registration %v", err)
+			ar.webAuthnError(w, http.StatusBadRequest, "Unable to verify passkey")
+			return
+		}
+		ar.serveJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+	}
+}
+
+// BeginWebAuthnTFA returns the options to pass the second factor with the security key on the challenge page.
+// Security keys are the second factor here, so it works even if the server does not support login with passkey.
+func (ar *Router) BeginWebAuthnTFA() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _, _, err := ar.tfaChallengeUser(r)
+		if err != nil {
+			ar.webAuthnError(w, http.StatusUnauthorized, ErrorTFAChallengeExpired.Error())
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		if !tfa.ContainsType(tfa.Types(ar.tfaService.Factors(app, user)), model.TFATypeWebAuthn) {
+			ar.webAuthnError(w, http.StatusBadRequest, "This factor is not supported")
+			return
+		}
+
+		options, err := ar.webAuthn.BeginLogin(user, app, webauthn.CeremonyTFA)
+		if err == webauthn.ErrorNoCredentials {
+			ar.webAuthnError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			ar.Logger.Printf("Error: begin webauthn TFA %v", err)
+			ar.webAuthnError(w, http.StatusInternalServerError, model.ErrorInternal.Error())
+			return
+		}
+		ar.serveJSON(w, http.StatusOK, options)
+	}
+}
+
+// FinishWebAuthnTFA verifies the security key of the user on the challenge page, and sets the web cookie, like TFAChallenge does with one-time password.
+// The challenge page sends the user back to the login page then, which redirects them to the callback URL.
+func (ar *Router) FinishWebAuthnTFA() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := webauthn.Response{}
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			ar.webAuthnError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+
+		challengeUser, token, tokenString, err := ar.tfaChallengeUser(r)
+		if err != nil {
+			ar.webAuthnError(w, http.StatusUnauthorized, ErrorTFAChallengeExpired.Error())
+			return
+		}
+
+		app := middleware.AppFromContext(r.Context())
+		if !tfa.ContainsType(tfa.Types(ar.tfaService.Factors(app, challengeUser)), model.TFATypeWebAuthn) {
+			ar.webAuthnError(w, http.StatusBadRequest, "This factor is not supported")
+			return
+		}
+
+		user, err := ar.webAuthn.FinishLogin(app, webauthn.CeremonyTFA, d)
+		if err != nil {
+			ar.Logger.Printf("Error: finish webauthn TFA %v", err)
+			ar.webAuthnError(w, http.StatusUnauthorized, "Unable to verify security key")
+			return
+		}
+		if user.ID != challengeUser.ID {
+			ar.webAuthnError(w, http.StatusUnauthorized, "Security key belongs to another user")
+			return
+		}
+
+		// Challenge token is used once.
+		if err = ar.TokenBlacklist.Consume(tokenString); err == model.ErrorNotFound {
+			ar.webAuthnError(w, http.StatusUnauthorized, ErrorTFAChallengeExpired.Error())
+			return
+		} else if err != nil {
+			ar.Logger.Printf("Error: consuming TFA challenge token %v", err)
+			ar.webAuthnError(w, http.StatusInternalServerError, model.ErrorInternal.Error())
+			return
+		}
+		deletePathCookie(w, CookieKeyTFAChallenge, ar.cookiePath())
+
+		if err = ar.setWebCookie(w, user, authContext(token).WithSecondFactor(model.AMRWebAuthn)); err != nil {
+			ar.Logger.Printf("Error: setting web cookie: %v", err)
+			ar.webAuthnError(w, http.StatusInternalServerError, model.ErrorInternal.Error())
+			return
+		}
+		ar.serveJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+	}
+}
+
+// webAuthnError sends the error to the page script.
+func (ar *Router) webAuthnError(w http.ResponseWriter, status int, message string) {
+	ar.serveJSON(w, status, map[string]string{"error": message})
+}
+
+// serveJSON sends the data to the page script.
+func (ar *Router) serveJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		ar.Logger.Printf("Error writing response: %v", err)
+	}
+}
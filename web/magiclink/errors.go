@@ -0,0 +1,14 @@
+package magiclink
+
+// Error - domain level error type
+type Error string
+
+// Error - implementation of std.Error protocol
+func (e Error) Error() string { return string(e) }
+
+const (
+	// ErrorInvalidLink means the link is expired, used, or issued for another app.
+	ErrorInvalidLink = Error("Invalid or expired sign-in link")
+	// ErrorRegistrationForbidden means there is no user with the email, and the app does not let new users register.
+	ErrorRegistrationForbidden = Error("Registration in this app is forbidden")
+)
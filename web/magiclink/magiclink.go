@@ -0,0 +1,104 @@
+package magiclink
+
+import (
+	"net/url"
+
+	jwtService "github.com/madappgang/identifo/jwt/service"
+	"github.com/madappgang/identifo/model"
+)
+
+// QueryToken is the link query parameter with the token.
+const QueryToken = "token"
+
+// Service sends the links, which sign the users in by email, and checks them when the users follow them.
+// It keeps no state: the link carries the short-lived signed token, which is invalidated when used.
+type Service struct {
+	tokenService   jwtService.TokenService
+	tokenBlacklist model.TokenBlacklist
+	userStorage    model.UserStorage
+	emailService   model.EmailService
+}
+
+// NewService creates new magic link service.
+func NewService(ts jwtService.TokenService, tb model.TokenBlacklist, us model.UserStorage, emailServ model.EmailService) *Service {
+	return &Service{
+		tokenService:   ts,
+		tokenBlacklist: tb,
+		userStorage:    us,
+		emailService:   emailServ,
+	}
+}
+
+// Send emails the link, which signs the owner of the email in to the app.
+// The link opens the page or the app screen with the URL, which gets the token in the query.
+func (s *Service) Send(app model.AppData, email string, link url.URL) error {
+	l, err := s.Link(app, email, link)
+	if err != nil {
+		return err
+	}
+	return s.emailService.SendMagicLinkEmail("Sign in to "+app.Name, email, l)
+}
+
+// Link returns the URL with the new token, which signs the owner of the email in to the app.
+func (s *Service) Link(app model.AppData, email string, link url.URL) (string, error) {
+	token, err := s.tokenService.NewMagicLinkToken(email, app)
+	if err != nil {
+		return "", err
+	}
+	tokenString, err := s.tokenService.String(token)
+	if err != nil {
+		return "", err
+	}
+
+	q := link.Query()
+	q.Set(QueryToken, tokenString)
+	link.RawQuery = q.Encode()
+	return link.String(), nil
+}
+
+// Login checks the link token is issued for the app, invalidates it, so the link is used once,
+// and returns the owner of the email. The user is registered if there is none, unless the app forbids registration.
+// The email becomes verified, as the user has got the link with it.
+func (s *Service) Login(app model.AppData, tokenString string) (model.User, error) {
+	token, err := s.tokenService.Parse(tokenString)
+	if err != nil || token.Type() != model.TokenTypeMagicLink || !contains(token.Audience(), app.ID) {
+		return model.User{}, ErrorInvalidLink
+	}
+	email, _ := token.Payload()[jwtService.PayloadEmail].(string)
+	if len(email) == 0 {
+		return model.User{}, ErrorInvalidLink
+	}
+
+	// The link is used once, even by concurrent requests.
+	if err = s.tokenBlacklist.Consume(tokenString); err == model.ErrorNotFound {
+		return model.User{}, ErrorInvalidLink
+	} else if err != nil {
+		return model.User{}, err
+	}
+
+	user, err := s.userStorage.UserByEmail(email)
+	if err == model.ErrUserNotFound {
+		if app.RegistrationForbidden {
+			return model.User{}, ErrorRegistrationForbidden
+		}
+		user, err = s.userStorage.AddUserByEmail(email, app.NewUserDefaultRole)
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+
+	if !user.EmailVerified {
+		user.EmailVerified = true
+		return s.userStorage.UpdateUser(user.ID, user)
+	}
+	return user, nil
+}
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
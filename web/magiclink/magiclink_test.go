@@ -0,0 +1,100 @@
+package magiclink
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	configStorageFile "github.com/madappgang/identifo/configuration/storage/file"
+	ijwt "github.com/madappgang/identifo/jwt"
+	jwtService "github.com/madappgang/identifo/jwt/service"
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/storage/boltdb"
+	"github.com/madappgang/identifo/storage/mem"
+)
+
+func TestService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identifo-magiclink")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := boltdb.InitDB(filepath.Join(dir, "db.db"))
+	if err != nil {
+		t.Fatalf("Unable to open database %v", err)
+	}
+	defer db.Close()
+	us, err := boltdb.NewUserStorage(db)
+	if err != nil {
+		t.Fatalf("Unable to create user storage %v", err)
+	}
+	tstor, _ := mem.NewTokenStorage()
+	as, _ := mem.NewAppStorage()
+	tb, _ := mem.NewTokenBlacklist()
+
+	configStorage, err := configStorageFile.NewConfigurationStorage(model.ConfigurationStorageSettings{
+		Type:       model.ConfigurationStorageTypeFile,
+		KeyStorage: model.KeyStorageSettings{Type: model.KeyStorageTypeLocal, Folder: "../../jwt/test_artifacts/"},
+	})
+	if err != nil {
+		t.Fatalf("Unable to init configuration storage %v", err)
+	}
+	keys, err := configStorage.LoadKeys(ijwt.TokenSignatureAlgorithmES256)
+	if err != nil {
+		t.Fatalf("Unable to load keys %v", err)
+	}
+	ts, err := jwtService.NewJWTokenService(keys, "identifo.example.com", tstor, as, us)
+	if err != nil {
+		t.Fatalf("Unable to create token service %v", err)
+	}
+
+	app := model.AppData{ID: "app", Name: "Test App", Active: true, NewUserDefaultRole: "member"}
+	s := NewService(ts, tb, us, nil)
+	page := url.URL{Scheme: "https", Host: "identifo.example.com", Path: "/web/email_link", RawQuery: "appId=app"}
+
+	token := func(email string, app model.AppData) string {
+		link, err := s.Link(app, email, page)
+		if err != nil {
+			t.Fatalf("Link() error = %v", err)
+		}
+		u, _ := url.Parse(link)
+		if u.Query().Get("appId") != "app" {
+			t.Errorf("Link() = %v, want page query kept", link)
+		}
+		return u.Query().Get(QueryToken)
+	}
+
+	// New users are registered with verified email.
+	tokenString := token("alice@example.com", app)
+	user, err := s.Login(app, tokenString)
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if user.Email != "alice@example.com" || !user.EmailVerified || user.AccessRole != "member" {
+		t.Errorf("Login() user = %+v, want registered user with verified email", user)
+	}
+	if _, err = s.Login(app, tokenString); err != ErrorInvalidLink {
+		t.Errorf("Login() with used link error = %v", err)
+	}
+
+	// Known users are signed in.
+	again, err := s.Login(app, token("alice@example.com", app))
+	if err != nil || again.ID != user.ID {
+		t.Errorf("Login() = %+v, %v, want user %v", again, err, user.ID)
+	}
+
+	other := model.AppData{ID: "other", Active: true}
+	if _, err = s.Login(app, token("alice@example.com", other)); err != ErrorInvalidLink {
+		t.Errorf("Login() with link of other app error = %v", err)
+	}
+
+	forbidden := model.AppData{ID: "app", Active: true, RegistrationForbidden: true}
+	if _, err = s.Login(forbidden, token("bob@example.com", forbidden)); err != ErrorRegistrationForbidden {
+		t.Errorf("Login() of new user when registration is forbidden error = %v", err)
+	}
+	if _, err = s.Login(forbidden, token("alice@example.com", forbidden)); err != nil {
+		t.Errorf("Login() of known user when registration is forbidden error = %v", err)
+	}
+}
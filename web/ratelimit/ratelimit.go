@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/madappgang/identifo/model"
+)
+
+// Names of the policies, which limit the endpoints the same way in the API and on the web pages.
+const (
+	PolicyLogin         = "login"          // PolicyLogin limits login with password.
+	PolicyRegister      = "register"       // PolicyRegister limits registration with password.
+	PolicyResetPassword = "reset_password" // PolicyResetPassword limits reset password emails.
+	PolicyPhoneCode     = "phone_code"     // PolicyPhoneCode limits verification codes sent by SMS.
+	PolicyPhoneLogin    = "phone_login"    // PolicyPhoneLogin limits login with phone verification code.
+	PolicyEmailCode     = "email_code"     // PolicyEmailCode limits verification codes and sign-in links sent by email.
+	PolicyEmailLogin    = "email_login"    // PolicyEmailLogin limits login with email verification code.
+)
+
+// DefaultPolicies are the limits of the endpoints, unless the server settings replace them.
+var DefaultPolicies = map[string][]model.RateLimit{
+	PolicyLogin: {
+		{Key: model.RateLimitKeyIP, Requests: 30, Window: 60},
+		{Key: model.RateLimitKeyUser, Requests: 10, Window: 300},
+	},
+	PolicyRegister: {
+		{Key: model.RateLimitKeyIP, Requests: 10, Window: 600},
+	},
+	PolicyResetPassword: {
+		{Key: model.RateLimitKeyIP, Requests: 10, Window: 600},
+		{Key: model.RateLimitKeyUser, Requests: 3, Window: 600},
+	},
+	PolicyPhoneCode: {
+		{Key: model.RateLimitKeyIP, Requests: 10, Window: 600},
+		{Key: model.RateLimitKeyPhone, Requests: 3, Window: 600},
+	},
+	PolicyPhoneLogin: {
+		{Key: model.RateLimitKeyIP, Requests: 30, Window: 60},
+		{Key: model.RateLimitKeyPhone, Requests: 10, Window: 600},
+	},
+	PolicyEmailCode: {
+		{Key: model.RateLimitKeyIP, Requests: 10, Window: 600},
+		{Key: model.RateLimitKeyUser, Requests: 3, Window: 600},
+	},
+	PolicyEmailLogin: {
+		{Key: model.RateLimitKeyIP, Requests: 30, Window: 60},
+		{Key: model.RateLimitKeyUser, Requests: 10, Window: 600},
+	},
+}
+
+// Request tells who the request comes from and who it is for. Limits of the empty fields are skipped.
+type Request struct {
+	IP    string
+	User  string
+	Phone string
+	App   string
+}
+
+func (r Request) value(key model.RateLimitKey) string {
+	switch key {
+	case model.RateLimitKeyIP:
+		return r.IP
+	case model.RateLimitKeyUser:
+		// Usernames and emails are matched case-insensitively, so they are counted the same way.
+		return strings.ToLower(strings.TrimSpace(r.User))
+	case model.RateLimitKeyPhone:
+		return r.Phone
+	case model.RateLimitKeyApp:
+		return r.App
+	}
+	return ""
+}
+
+// Limiter counts requests in fixed windows, and tells the clients, who have exceeded the limits, how long to wait.
+type Limiter struct {
+	storage  model.RateLimitStorage
+	policies map[string][]model.RateLimit
+	now      func() time.Time
+}
+
+// NewLimiter creates new rate limiter. Policies replace the default ones with the same names.
+// Limiter without storage allows all requests.
+func NewLimiter(storage model.RateLimitStorage, policies map[string][]model.RateLimit) *Limiter {
+	merged := make(map[string][]model.RateLimit, len(DefaultPolicies))
+	for name, limits := range DefaultPolicies {
+		merged[name] = limits
+	}
+	for name, limits := range policies {
+		merged[name] = limits
+	}
+	return &Limiter{storage: storage, policies: merged, now: time.Now}
+}
+
+// Allow counts the request against the limits of the policy.
+// It returns how long the client should wait before the next attempt, zero if the request is allowed.
+func (l *Limiter) Allow(policy string, req Request) (time.Duration, error) {
+	if l == nil || l.storage == nil {
+		return 0, nil
+	}
+
+	now := l.now()
+	var retryAfter time.Duration
+	for _, limit := range l.policies[policy] {
+		value := req.value(limit.Key)
+		if value == "" || limit.Requests <= 0 || limit.Window <= 0 {
+			continue
+		}
+
+		window := int64(limit.Window)
+		windowEnd := time.Unix((now.Unix()/window+1)*window, 0)
+		key := strings.Join([]string{policy, string(limit.Key), strconv.Itoa(limit.Window), value, strconv.FormatInt(now.Unix()/window, 10)}, ":")
+
+		count, err := l.storage.Increment(key, windowEnd)
+		if err != nil {
+			return 0, err
+		}
+		if wait := windowEnd.Sub(now); count > limit.Requests && wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	return retryAfter, nil
+}
+
+// ClientIP returns the IP address of the client.
+// Behind the trusted proxy, it is the last address in the X-Forwarded-For header, the one the proxy has added, as the client could forge the others.
+func ClientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			addrs := strings.Split(forwarded, ",")
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
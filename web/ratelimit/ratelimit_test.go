@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/madappgang/identifo/model"
+	memStorage "github.com/madappgang/identifo/ratelimit/mem"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	storage, _ := memStorage.NewRateLimitStorage()
+	l := NewLimiter(storage, map[string][]model.RateLimit{
+		PolicyLogin: {
+			{Key: model.RateLimitKeyIP, Requests: 5, Window: 60},
+			{Key: model.RateLimitKeyUser, Requests: 2, Window: 60},
+		},
+	})
+	// Storage drops the counters by the wall clock, so the windows are the current ones.
+	now := time.Now().Truncate(time.Minute).Add(20 * time.Second)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if wait, err := l.Allow(PolicyLogin, Request{IP: "10.0.0.1", User: "Alice"}); wait != 0 || err != nil {
+			t.Fatalf("Allow() attempt %d = %v, %v, want allowed", i+1, wait, err)
+		}
+	}
+
+	// The user is counted regardless of the IP address and the case of the username.
+	wait, err := l.Allow(PolicyLogin, Request{IP: "10.0.0.2", User: "alice"})
+	if err != nil || wait != 40*time.Second {
+		t.Errorf("Allow() over the user limit = %v, %v, want to wait 40s", wait, err)
+	}
+	if wait, _ := l.Allow(PolicyLogin, Request{IP: "10.0.0.2", User: "bob"}); wait != 0 {
+		t.Errorf("Allow() for another user = %v, want allowed", wait)
+	}
+
+	// The counters start over in the next window.
+	now = now.Add(time.Minute)
+	if wait, _ := l.Allow(PolicyLogin, Request{IP: "10.0.0.2", User: "alice"}); wait != 0 {
+		t.Errorf("Allow() in the next window = %v, want allowed", wait)
+	}
+
+	// Policies, which are not replaced, keep the default limits.
+	for i := 0; i < 3; i++ {
+		l.Allow(PolicyPhoneCode, Request{Phone: "+380501234567"})
+	}
+	if wait, _ := l.Allow(PolicyPhoneCode, Request{Phone: "+380501234567"}); wait == 0 {
+		t.Errorf("Allow() over the default phone limit is allowed")
+	}
+}
+
+func TestLimiterWithoutStorage(t *testing.T) {
+	l := NewLimiter(nil, nil)
+	for i := 0; i < 100; i++ {
+		if wait, err := l.Allow(PolicyLogin, Request{IP: "10.0.0.1"}); wait != 0 || err != nil {
+			t.Fatalf("Allow() without storage = %v, %v, want allowed", wait, err)
+		}
+	}
+}
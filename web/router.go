@@ -21,6 +21,7 @@ type RouterSetting struct {
 	TokenBlacklist          model.TokenBlacklist
 	InviteStorage           model.InviteStorage
 	VerificationCodeStorage model.VerificationCodeStorage
+	DeviceCodeStorage       model.DeviceCodeStorage
 	TokenService            jwtService.TokenService
 	SMSService              model.SMSService
 	EmailService            model.EmailService
@@ -50,6 +51,7 @@ func NewRouter(settings RouterSetting) (model.Router, error) {
 		settings.TokenBlacklist,
 		settings.InviteStorage,
 		settings.VerificationCodeStorage,
+		settings.DeviceCodeStorage,
 		settings.StaticFilesStorage,
 		settings.TokenService,
 		settings.SMSService,
@@ -69,6 +71,7 @@ func NewRouter(settings RouterSetting) (model.Router, error) {
 		settings.StaticFilesStorage,
 		settings.TokenStorage,
 		settings.TokenBlacklist,
+		settings.DeviceCodeStorage,
 		settings.TokenService,
 		settings.SMSService,
 		settings.EmailService,
@@ -0,0 +1,24 @@
+package tfa
+
+// Error - domain level error type
+type Error string
+
+// Error - implementation of std.Error protocol
+func (e Error) Error() string { return string(e) }
+
+const (
+	// ErrorPleaseEnableTFA means the app requires TFA, but the user has no factor the app supports.
+	ErrorPleaseEnableTFA = Error("please enable two-factor authentication to be able to use this app")
+	// ErrorPleaseSetPhoneTFA means the user's factor is SMS, but they have no phone number.
+	ErrorPleaseSetPhoneTFA = Error("please set phone for two-factor authentication to be able to use this app")
+	// ErrorPleaseSetEmailTFA means the user's factor is email, but they have no email address.
+	ErrorPleaseSetEmailTFA = Error("please set email for two-factor authentication to be able to use this app")
+	// ErrorPleaseDisableTFA means the user has enabled TFA, but the app does not support it.
+	ErrorPleaseDisableTFA = Error("please disable two-factor authentication to be able to use this app")
+	// ErrorPleaseRegisterWebAuthnTFA means the user's factor is security key, but they have not registered any.
+	ErrorPleaseRegisterWebAuthnTFA = Error("please register security key for two-factor authentication to be able to use this app")
+	// ErrorWebAuthnFactor means the user should pass the factor with the security key, not with one-time password.
+	ErrorWebAuthnFactor = Error("one-time passwords are not accepted, please use security key")
+	// ErrorOTPExpired means the one-time password sent to the user is expired.
+	ErrorOTPExpired = Error("OTP token expired, please get the new one and try again")
+)
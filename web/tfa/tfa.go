@@ -0,0 +1,294 @@
+package tfa
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/madappgang/identifo/model"
+	"github.com/xlzd/gotp"
+)
+
+const (
+	smsTFACode        = "%v is your one-time password!"
+	hotpLifespanHours = 12 // One time code expiration in hours, default value is 30 secs for TOTP and 12 hours for HOTP
+	// totpInterval is the TOTP time step in seconds, the one authenticator apps use.
+	totpInterval = 30
+	// swapAttempts is how many times the HOTP counter is swapped in, when other requests change the TFA info in between.
+	swapAttempts = 3
+)
+
+// Service checks and passes the user's second factor the same way in the API and on the web pages.
+type Service struct {
+	defaultType  model.TFAType
+	settings     model.TFASettings
+	userStorage  model.UserStorage
+	smsService   model.SMSService
+	emailService model.EmailService
+}
+
+// NewService creates new two-factor authentication service.
+// Default type is the server-wide TFA type, which the apps use unless they set their own.
+func NewService(defaultType model.TFAType, settings model.TFASettings, us model.UserStorage, smsServ model.SMSService, emailServ model.EmailService) *Service {
+	return &Service{
+		defaultType:  defaultType,
+		settings:     settings,
+		userStorage:  us,
+		smsService:   smsServ,
+		emailService: emailServ,
+	}
+}
+
+// DefaultType returns the server-wide TFA type, which is also the type of the users' legacy factor.
+func (s *Service) DefaultType() model.TFAType {
+	return s.defaultType
+}
+
+// Check checks correspondence between app's TFAstatus and user's TFAInfo,
+// and decides if we require two-factor authentication after all checks are successfully passed.
+// It returns if the user must pass TFA, and if they could.
+func (s *Service) Check(app model.AppData, user model.User) (bool, bool, error) {
+	appTFAStatus := app.TFAStatus
+	if appTFAStatus == model.TFAStatusMandatory && !user.TFAInfo.IsEnabled {
+		return true, false, ErrorPleaseEnableTFA
+	}
+
+	if appTFAStatus == model.TFAStatusDisabled && user.TFAInfo.IsEnabled {
+		return false, true, ErrorPleaseDisableTFA
+	}
+
+	// Request two-factor auth if user enabled it and app supports it.
+	if user.TFAInfo.IsEnabled && appTFAStatus != model.TFAStatusDisabled {
+		if len(s.Factors(app, user)) > 0 {
+			return true, true, nil
+		}
+
+		// Tell the user why they could not use the factors they have enrolled.
+		for _, f := range user.TFAInfo.AllFactors(s.defaultType) {
+			if !ContainsType(s.AppTypes(app), f.Type) {
+				continue
+			}
+			switch f.Type {
+			case model.TFATypeSMS:
+				// Factor is sms but user phone is empty
+				return true, false, ErrorPleaseSetPhoneTFA
+			case model.TFATypeEmail:
+				// Factor is email but user email is empty
+				return true, false, ErrorPleaseSetEmailTFA
+			case model.TFATypeWebAuthn:
+				// Factor is security key but user has not registered any
+				return true, false, ErrorPleaseRegisterWebAuthnTFA
+			}
+		}
+		// Then admin must have enabled TFA for this user manually, or user has no factors the app supports.
+		// User must enroll the factor, i.e send EnableTFA request.
+		return true, false, ErrorPleaseEnableTFA
+	}
+	return false, false, nil
+}
+
+// AppTypes returns the factors users could enroll and pass in the app, the server-wide TFA type by default.
+// Apps which require phishing-resistant TFA use WebAuthn only.
+func (s *Service) AppTypes(app model.AppData) []model.TFAType {
+	if app.PhishingResistantTFA {
+		return []model.TFAType{model.TFATypeWebAuthn}
+	}
+	if len(app.TFATypes) > 0 {
+		return app.TFATypes
+	}
+	return []model.TFAType{s.defaultType}
+}
+
+// Factors returns the user's factors, which the app supports and the user could pass now.
+func (s *Service) Factors(app model.AppData, user model.User) []model.TFAFactor {
+	allowed := s.AppTypes(app)
+	factors := []model.TFAFactor{}
+	for _, f := range user.TFAInfo.AllFactors(s.defaultType) {
+		if !ContainsType(allowed, f.Type) {
+			continue
+		}
+		switch f.Type {
+		case model.TFATypeSMS:
+			if user.Phone == "" {
+				continue
+			}
+		case model.TFATypeEmail:
+			if user.Email == "" {
+				continue
+			}
+		case model.TFATypeWebAuthn:
+			if len(user.WebAuthnCredentials) == 0 {
+				continue
+			}
+		}
+		if f.Type != model.TFATypeWebAuthn && f.Secret == "" {
+			continue
+		}
+		factors = append(factors, f)
+	}
+	return factors
+}
+
+// Types returns the types of the factors.
+func Types(factors []model.TFAFactor) []model.TFAType {
+	types := make([]model.TFAType, 0, len(factors))
+	for _, f := range factors {
+		types = append(types, f.Type)
+	}
+	return types
+}
+
+// ContainsType tells if the type is in the list.
+func ContainsType(types []model.TFAType, t model.TFAType) bool {
+	for _, tt := range types {
+		if tt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// SendCode sends the one-time password in SMS or email, if the factor is one of them.
+func (s *Service) SendCode(user model.User, tfaType model.TFAType) error {
+	// we don't need to send any code for FTA Type App, it uses TOTP and generated on client side with the app,
+	// and for WebAuthn, which signs the challenge with the security key
+	if tfaType == model.TFATypeSMS || tfaType == model.TFATypeEmail {
+		otp, err := s.nextHOTP(user, tfaType)
+		if err != nil {
+			return err
+		}
+		switch tfaType {
+		case model.TFATypeSMS:
+			return s.sendCodeInSMS(user.Phone, otp)
+		case model.TFATypeEmail:
+			return s.sendCodeOnEmail(user.Email, otp)
+		}
+
+	}
+
+	return nil
+}
+
+// nextHOTP increments the HOTP counter of the factor and returns the new code.
+// The counter is swapped in, so the codes other requests accept or send in between are not lost.
+func (s *Service) nextHOTP(user model.User, tfaType model.TFAType) (string, error) {
+	for attempt := 0; ; attempt++ {
+		factor, ok := user.TFAInfo.Factor(tfaType, s.defaultType)
+		if !ok {
+			return "", fmt.Errorf("user has not enrolled %s factor", tfaType)
+		}
+
+		// increment hotp code seed
+		otp := gotp.NewDefaultHOTP(factor.Secret).At(factor.HOTPCounter + 1)
+		factor.HOTPCounter++
+		factor.HOTPExpiredAt = time.Now().Add(time.Hour * hotpLifespanHours)
+		info := user.TFAInfo
+		info.SetFactor(factor, s.defaultType)
+
+		err := s.userStorage.SwapTFAInfo(user.ID, info, user.TFAInfo)
+		if err == nil {
+			return otp, nil
+		}
+		if err != model.ErrorNotFound || attempt == swapAttempts-1 {
+			return "", err
+		}
+		// The TFA info has been changed in between, so the counter is incremented on the fresh one.
+		if user, err = s.userStorage.UserByID(user.ID); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (s *Service) sendCodeInSMS(phone, otp string) error {
+	if phone == "" {
+		return errors.New("unable to send SMS OTP, user has no phone number")
+	}
+
+	if err := s.smsService.SendSMS(phone, fmt.Sprintf(smsTFACode, otp)); err != nil {
+		return fmt.Errorf("unable to send sms. %s", err)
+	}
+	return nil
+}
+
+func (s *Service) sendCodeOnEmail(email, otp string) error {
+	if email == "" {
+		return errors.New("unable to send email OTP, user has no email")
+	}
+
+	if err := s.emailService.SendTFAEmail("One-time password", email, otp); err != nil {
+		return fmt.Errorf("unable to send email with OTP with error: %s", err)
+	}
+	return nil
+}
+
+// VerifyCode checks the one-time password, and returns the factor updated so the password could not be used again.
+// The caller saves the factor to the user.
+func (s *Service) VerifyCode(app model.AppData, user model.User, otp string, tfaType model.TFAType) (model.TFAFactor, bool, error) {
+	if tfaType == model.TFATypeWebAuthn {
+		return model.TFAFactor{}, false, ErrorWebAuthnFactor
+	}
+
+	var factor model.TFAFactor
+	found := false
+	for _, f := range s.Factors(app, user) {
+		if f.Type == tfaType {
+			factor, found = f, true
+		}
+	}
+	if !found {
+		// The debug code is checked by the caller.
+		return model.TFAFactor{}, false, nil
+	}
+
+	if tfaType == model.TFATypeApp {
+		return s.verifyTOTP(factor, otp)
+	}
+
+	if factor.HOTPExpiredAt.Before(time.Now()) {
+		return model.TFAFactor{}, false, ErrorOTPExpired
+	}
+	hotp := gotp.NewDefaultHOTP(factor.Secret)
+	if subtle.ConstantTimeCompare([]byte(hotp.At(factor.HOTPCounter)), []byte(otp)) != 1 {
+		return model.TFAFactor{}, false, nil
+	}
+	// The code is valid until the new one is sent, so it expires when used.
+	factor.HOTPExpiredAt = time.Now()
+	return factor, true, nil
+}
+
+// verifyTOTP checks the code for the time steps within the window around the current one.
+// The codes of the last accepted step and earlier are not accepted again.
+func (s *Service) verifyTOTP(factor model.TFAFactor, otp string) (model.TFAFactor, bool, error) {
+	totp := gotp.NewDefaultTOTP(factor.Secret)
+	step := time.Now().Unix() / totpInterval
+	for i := -int64(s.settings.TOTPWindow); i <= int64(s.settings.TOTPWindow); i++ {
+		if step+i <= factor.LastTOTPStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totp.At(int((step+i)*totpInterval))), []byte(otp)) == 1 {
+			factor.LastTOTPStep = step + i
+			return factor, true, nil
+		}
+	}
+	return model.TFAFactor{}, false, nil
+}
+
+// Fail counts the failed attempt, and locks the user's TFA challenge after too many.
+func (s *Service) Fail(user model.User) error {
+	if s.settings.MaxAttempts <= 0 {
+		return nil
+	}
+
+	userID := user.ID
+	attempts, err := s.userStorage.IncrementTFAFailedAttempts(userID)
+	if err != nil {
+		return err
+	}
+	if attempts < s.settings.MaxAttempts {
+		return nil
+	}
+
+	// The user gets all the attempts again after the lockout.
+	return s.userStorage.LockTFA(userID, time.Now().Add(time.Duration(s.settings.LockoutDuration)*time.Second))
+}
@@ -0,0 +1,36 @@
+package tfa
+
+import (
+	"testing"
+	"time"
+
+	"github.com/madappgang/identifo/model"
+	"github.com/xlzd/gotp"
+)
+
+func TestVerifyTOTP(t *testing.T) {
+	s := &Service{settings: model.TFASettings{TOTPWindow: 1}}
+	factor := model.TFAFactor{Type: model.TFATypeApp, Secret: gotp.RandomSecret(16)}
+	totp := gotp.NewDefaultTOTP(factor.Secret)
+	now := int(time.Now().Unix())
+
+	if _, ok, _ := s.verifyTOTP(factor, totp.At(now-3*totpInterval)); ok {
+		t.Error("verifyTOTP() accepted code outside the window")
+	}
+
+	previous, ok, _ := s.verifyTOTP(factor, totp.At(now-totpInterval))
+	if !ok {
+		t.Fatal("verifyTOTP() rejected code of the previous step within the window")
+	}
+	if _, ok, _ = s.verifyTOTP(previous, totp.At(now-totpInterval)); ok {
+		t.Error("verifyTOTP() accepted replayed code")
+	}
+	if _, ok, _ = s.verifyTOTP(previous, totp.At(now)); !ok {
+		t.Error("verifyTOTP() rejected code of the step after the accepted one")
+	}
+
+	s.settings.TOTPWindow = 0
+	if _, ok, _ = s.verifyTOTP(factor, totp.At(now-totpInterval)); ok && time.Now().Unix()/totpInterval == int64(now)/totpInterval {
+		t.Error("verifyTOTP() accepted code of the previous step without the window")
+	}
+}
@@ -0,0 +1,16 @@
+package webauthn
+
+// Error - domain level error type
+type Error string
+
+// Error - implementation of std.Error protocol
+func (e Error) Error() string { return string(e) }
+
+const (
+	// ErrorInvalidSession means the ceremony session is expired, used, or issued for another app or ceremony.
+	ErrorInvalidSession = Error("Invalid WebAuthn session")
+	// ErrorNoCredentials means the user has not registered the credential.
+	ErrorNoCredentials = Error("User has no WebAuthn credentials")
+	// ErrorClonedAuthenticator means the signature counter went back, so the authenticator could be cloned.
+	ErrorClonedAuthenticator = Error("WebAuthn authenticator could be cloned")
+)
@@ -0,0 +1,62 @@
+package webauthn
+
+import (
+	"encoding/base64"
+
+	wa "github.com/duo-labs/webauthn/webauthn"
+	"github.com/madappgang/identifo/model"
+)
+
+// webAuthnUser is the user as the WebAuthn library sees it.
+type webAuthnUser struct {
+	user model.User
+}
+
+// WebAuthnID returns the user handle, which is the user ID.
+func (u webAuthnUser) WebAuthnID() []byte {
+	return []byte(u.user.ID)
+}
+
+// WebAuthnName returns the name the authenticator shows to tell the accounts apart.
+func (u webAuthnUser) WebAuthnName() string {
+	switch {
+	case len(u.user.Username) > 0:
+		return u.user.Username
+	case len(u.user.Email) > 0:
+		return u.user.Email
+	case len(u.user.Phone) > 0:
+		return u.user.Phone
+	}
+	return u.user.ID
+}
+
+// WebAuthnDisplayName returns the same name, as users do not have display names.
+func (u webAuthnUser) WebAuthnDisplayName() string {
+	return u.WebAuthnName()
+}
+
+// WebAuthnIcon returns no icon.
+func (u webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+// WebAuthnCredentials returns the user's credentials. Credentials with malformed IDs are skipped.
+func (u webAuthnUser) WebAuthnCredentials() []wa.Credential {
+	credentials := make([]wa.Credential, 0, len(u.user.WebAuthnCredentials))
+	for _, c := range u.user.WebAuthnCredentials {
+		id, err := base64.RawURLEncoding.DecodeString(c.ID)
+		if err != nil {
+			continue
+		}
+		credentials = append(credentials, wa.Credential{
+			ID:              id,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Authenticator: wa.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		})
+	}
+	return credentials
+}
@@ -0,0 +1,295 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+
+	"github.com/duo-labs/webauthn/protocol"
+	wa "github.com/duo-labs/webauthn/webauthn"
+	ijwt "github.com/madappgang/identifo/jwt"
+	jwtService "github.com/madappgang/identifo/jwt/service"
+	"github.com/madappgang/identifo/model"
+)
+
+// Ceremony is what the WebAuthn session is issued for.
+type Ceremony string
+
+const (
+	// CeremonyRegistration registers new credential for the signed in user.
+	CeremonyRegistration Ceremony = "registration"
+	// CeremonyLogin signs the user in without password, so the authenticator must verify the user, e.g. with PIN or biometrics.
+	CeremonyLogin Ceremony = "login"
+	// CeremonyTFA is the second factor, after the user signs in with password.
+	CeremonyTFA Ceremony = "tfa"
+)
+
+// defaultDisplayName is the relying party name for the apps without name.
+const defaultDisplayName = "Identifo"
+
+// Service runs WebAuthn ceremonies, with the server as the relying party.
+// It keeps no state: the challenge is kept in the short-lived signed session, which the client sends back to finish the ceremony.
+type Service struct {
+	host           string
+	tokenService   jwtService.TokenService
+	tokenBlacklist model.TokenBlacklist
+	userStorage    model.UserStorage
+}
+
+// NewService creates new WebAuthn service for the server host.
+func NewService(host string, ts jwtService.TokenService, tb model.TokenBlacklist, us model.UserStorage) *Service {
+	return &Service{
+		host:           host,
+		tokenService:   ts,
+		tokenBlacklist: tb,
+		userStorage:    us,
+	}
+}
+
+// Options are the options for the browser WebAuthn API, with the session to finish the ceremony with.
+type Options struct {
+	PublicKey interface{} `json:"publicKey"`
+	Session   string      `json:"session"`
+}
+
+// Response is the client's response to the ceremony options.
+type Response struct {
+	Session    string          `json:"session"`
+	Credential json.RawMessage `json:"credential"`
+	// Transports are the ways the client talks to the new authenticator, like "usb" or "internal".
+	// Browser does not sign them, they are only hints for the next ceremonies.
+	Transports []string `json:"transports,omitempty"`
+	// Name is the name the user gives to the new credential.
+	Name string `json:"name,omitempty"`
+}
+
+// BeginRegistration starts registration of new credential for the user.
+func (s *Service) BeginRegistration(user model.User, app model.AppData) (Options, error) {
+	rp, err := s.relyingParty(app)
+	if err != nil {
+		return Options{}, err
+	}
+
+	creation, session, err := rp.BeginRegistration(webAuthnUser{user}, wa.WithExclusions(descriptors(user)))
+	if err != nil {
+		return Options{}, err
+	}
+	return s.options(user, app, CeremonyRegistration, session.Challenge, creation.Response)
+}
+
+// FinishRegistration verifies the new credential, and adds it to the user.
+func (s *Service) FinishRegistration(user model.User, app model.AppData, r Response) (model.User, error) {
+	session, err := s.session(r.Session, app, CeremonyRegistration)
+	if err != nil {
+		return model.User{}, err
+	}
+	if session.Subject() != user.ID {
+		return model.User{}, ErrorInvalidSession
+	}
+
+	rp, err := s.relyingParty(app)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(r.Credential))
+	if err != nil {
+		return model.User{}, err
+	}
+
+	credential, err := rp.CreateCredential(webAuthnUser{user}, sessionData(user, session, protocol.VerificationPreferred), parsed)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	now := ijwt.TimeFunc().Unix()
+	return s.userStorage.AddWebAuthnCredential(user.ID, model.WebAuthnCredential{
+		ID:              base64.RawURLEncoding.EncodeToString(credential.ID),
+		Name:            r.Name,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      r.Transports,
+		CreatedAt:       now,
+	})
+}
+
+// BeginLogin starts authentication with one of the user's credentials.
+func (s *Service) BeginLogin(user model.User, app model.AppData, ceremony Ceremony) (Options, error) {
+	if len(user.WebAuthnCredentials) == 0 {
+		return Options{}, ErrorNoCredentials
+	}
+
+	rp, err := s.relyingParty(app)
+	if err != nil {
+		return Options{}, err
+	}
+
+	assertion, session, err := rp.BeginLogin(
+		webAuthnUser{user},
+		wa.WithAllowedCredentials(descriptors(user)),
+		wa.WithUserVerification(userVerification(ceremony)),
+	)
+	if err != nil {
+		return Options{}, err
+	}
+	return s.options(user, app, ceremony, session.Challenge, assertion.Response)
+}
+
+// FinishLogin verifies the assertion, and returns the user the credential belongs to.
+func (s *Service) FinishLogin(app model.AppData, ceremony Ceremony, r Response) (model.User, error) {
+	session, err := s.session(r.Session, app, ceremony)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	user, err := s.userStorage.UserByID(session.Subject())
+	if err != nil {
+		return model.User{}, err
+	}
+
+	rp, err := s.relyingParty(app)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(r.Credential))
+	if err != nil {
+		return model.User{}, err
+	}
+
+	credential, err := rp.ValidateLogin(webAuthnUser{user}, sessionData(user, session, userVerification(ceremony)), parsed)
+	if err != nil {
+		return model.User{}, err
+	}
+	if credential.Authenticator.CloneWarning {
+		return model.User{}, ErrorClonedAuthenticator
+	}
+
+	stored, ok := user.WebAuthnCredential(base64.RawURLEncoding.EncodeToString(credential.ID))
+	if !ok {
+		return model.User{}, ErrorNoCredentials
+	}
+	stored.SignCount = credential.Authenticator.SignCount
+	stored.LastUsedAt = ijwt.TimeFunc().Unix()
+	if err = s.userStorage.UpdateWebAuthnCredential(user.ID, stored); err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// relyingParty returns the relying party for the app, with the server host as the origin.
+func (s *Service) relyingParty(app model.AppData) (*wa.WebAuthn, error) {
+	u, err := url.Parse(s.host)
+	if err != nil {
+		return nil, err
+	}
+
+	name := app.Name
+	if len(name) == 0 {
+		name = defaultDisplayName
+	}
+
+	return wa.New(&wa.Config{
+		RPDisplayName:         name,
+		RPID:                  u.Hostname(),
+		RPOrigin:              u.Scheme + "://" + u.Host,
+		AttestationPreference: protocol.PreferNoAttestation,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+}
+
+// options issues the session, which keeps the challenge of the ceremony.
+func (s *Service) options(user model.User, app model.AppData, ceremony Ceremony, challenge string, publicKey interface{}) (Options, error) {
+	token, err := s.tokenService.NewWebAuthnSession(user, app, map[string]interface{}{
+		jwtService.PayloadWebAuthnCeremony:  string(ceremony),
+		jwtService.PayloadWebAuthnChallenge: challenge,
+	})
+	if err != nil {
+		return Options{}, err
+	}
+
+	session, err := s.tokenService.String(token)
+	if err != nil {
+		return Options{}, err
+	}
+	return Options{PublicKey: publicKey, Session: session}, nil
+}
+
+// session checks the session is issued for the app and the ceremony, and invalidates it, so it is used once.
+func (s *Service) session(session string, app model.AppData, ceremony Ceremony) (ijwt.Token, error) {
+	token, err := s.tokenService.Parse(session)
+	if err != nil || token.Type() != model.TokenTypeWebAuthn {
+		return nil, ErrorInvalidSession
+	}
+	if !contains(token.Audience(), app.ID) {
+		return nil, ErrorInvalidSession
+	}
+	if c, _ := token.Payload()[jwtService.PayloadWebAuthnCeremony].(string); c != string(ceremony) {
+		return nil, ErrorInvalidSession
+	}
+
+	// The ceremony is finished once, even by concurrent requests.
+	if err = s.tokenBlacklist.Consume(session); err == model.ErrorNotFound {
+		return nil, ErrorInvalidSession
+	} else if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// sessionData restores the library session data from the session token.
+func sessionData(user model.User, session ijwt.Token, uv protocol.UserVerificationRequirement) wa.SessionData {
+	challenge, _ := session.Payload()[jwtService.PayloadWebAuthnChallenge].(string)
+
+	allowed := make([][]byte, 0, len(user.WebAuthnCredentials))
+	for _, d := range descriptors(user) {
+		allowed = append(allowed, d.CredentialID)
+	}
+
+	return wa.SessionData{
+		Challenge:            challenge,
+		UserID:               []byte(user.ID),
+		AllowedCredentialIDs: allowed,
+		UserVerification:     uv,
+	}
+}
+
+// userVerification returns if the authenticator must verify the user for the ceremony.
+func userVerification(ceremony Ceremony) protocol.UserVerificationRequirement {
+	if ceremony == CeremonyLogin {
+		return protocol.VerificationRequired
+	}
+	return protocol.VerificationPreferred
+}
+
+// descriptors returns the descriptors of the user's credentials.
+func descriptors(user model.User) []protocol.CredentialDescriptor {
+	result := []protocol.CredentialDescriptor{}
+	for _, c := range (webAuthnUser{user}).WebAuthnCredentials() {
+		stored, _ := user.WebAuthnCredential(base64.RawURLEncoding.EncodeToString(c.ID))
+		transports := make([]protocol.AuthenticatorTransport, 0, len(stored.Transports))
+		for _, t := range stored.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		result = append(result, protocol.CredentialDescriptor{
+			Type:         protocol.PublicKeyCredentialType,
+			CredentialID: c.ID,
+			Transport:    transports,
+		})
+	}
+	return result
+}
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	configStorageFile "github.com/madappgang/identifo/configuration/storage/file"
+	ijwt "github.com/madappgang/identifo/jwt"
+	jwtService "github.com/madappgang/identifo/jwt/service"
+	"github.com/madappgang/identifo/model"
+	"github.com/madappgang/identifo/storage/boltdb"
+	"github.com/madappgang/identifo/storage/mem"
+)
+
+const testOrigin = "https://identifo.example.com"
+
+// testAuthenticator is a software authenticator with one ES256 credential and "none" attestation.
+type testAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	signCount uint32
+}
+
+func (a *testAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte("identifo.example.com"))
+	data := append([]byte{}, rpIDHash[:]...)
+	flags := byte(0x05) // User present and verified.
+	if attested {
+		flags |= 0x40
+	}
+	data = append(data, flags)
+	data = append(data, make([]byte, 4)...)
+	binary.BigEndian.PutUint32(data[len(data)-4:], a.signCount)
+	if !attested {
+		return data
+	}
+
+	data = append(data, make([]byte, 16)...) // AAGUID.
+	data = append(data, byte(len(a.id)>>8), byte(len(a.id)))
+	data = append(data, a.id...)
+	key, _ := cbor.Marshal(map[int]interface{}{
+		1:  2,  // EC2 key type.
+		3:  -7, // ES256.
+		-1: 1,  // P-256.
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	return append(data, key...)
+}
+
+func clientData(t *testing.T, ceremony string, options Options) []byte {
+	publicKey, _ := json.Marshal(options.PublicKey)
+	var parsed struct {
+		Challenge string `json:"challenge"`
+	}
+	if err := json.Unmarshal(publicKey, &parsed); err != nil {
+		t.Fatalf("Unable to parse options %v", err)
+	}
+	// The options have the challenge base64 encoded, and the browser sends it back base64url encoded.
+	challenge, err := base64.StdEncoding.DecodeString(parsed.Challenge)
+	if err != nil {
+		t.Fatalf("Unable to decode challenge %v", err)
+	}
+	data, _ := json.Marshal(map[string]string{"type": ceremony, "challenge": encode(challenge), "origin": testOrigin})
+	return data
+}
+
+func (a *testAuthenticator) create(t *testing.T, options Options) Response {
+	attestation, _ := cbor.Marshal(map[string]interface{}{"fmt": "none", "attStmt": map[string]interface{}{}, "authData": a.authData(true)})
+	credential, _ := json.Marshal(map[string]interface{}{
+		"id":    encode(a.id),
+		"rawId": encode(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    encode(clientData(t, "webauthn.create", options)),
+			"attestationObject": encode(attestation),
+		},
+	})
+	return Response{Session: options.Session, Credential: credential, Transports: []string{"usb"}, Name: "Key"}
+}
+
+func (a *testAuthenticator) get(t *testing.T, options Options, userID string) Response {
+	a.signCount++
+	data := clientData(t, "webauthn.get", options)
+	authData := a.authData(false)
+	clientDataHash := sha256.Sum256(data)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("Unable to sign %v", err)
+	}
+
+	credential, _ := json.Marshal(map[string]interface{}{
+		"id":    encode(a.id),
+		"rawId": encode(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    encode(data),
+			"authenticatorData": encode(authData),
+			"signature":         encode(signature),
+			"userHandle":        encode([]byte(userID)),
+		},
+	})
+	return Response{Session: options.Session, Credential: credential}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identifo-webauthn")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := boltdb.InitDB(filepath.Join(dir, "db.db"))
+	if err != nil {
+		t.Fatalf("Unable to open database %v", err)
+	}
+	defer db.Close()
+	us, err := boltdb.NewUserStorage(db)
+	if err != nil {
+		t.Fatalf("Unable to create user storage %v", err)
+	}
+	tstor, _ := mem.NewTokenStorage()
+	as, _ := mem.NewAppStorage()
+	tb, _ := mem.NewTokenBlacklist()
+
+	configStorage, err := configStorageFile.NewConfigurationStorage(model.ConfigurationStorageSettings{
+		Type:       model.ConfigurationStorageTypeFile,
+		KeyStorage: model.KeyStorageSettings{Type: model.KeyStorageTypeLocal, Folder: "../../jwt/test_artifacts/"},
+	})
+	if err != nil {
+		t.Fatalf("Unable to init configuration storage %v", err)
+	}
+	keys, err := configStorage.LoadKeys(ijwt.TokenSignatureAlgorithmES256)
+	if err != nil {
+		t.Fatalf("Unable to load keys %v", err)
+	}
+	ts, err := jwtService.NewJWTokenService(keys, "identifo.example.com", tstor, as, us)
+	if err != nil {
+		t.Fatalf("Unable to create token service %v", err)
+	}
+
+	user, err := us.AddUserByNameAndPassword("alice", "Password1!", "", false)
+	if err != nil {
+		t.Fatalf("Unable to create user %v", err)
+	}
+	app := model.AppData{ID: "app", Name: "Test App", Active: true}
+	s := NewService(testOrigin, ts, tb, us)
+
+	if _, err = s.BeginLogin(user, app, CeremonyLogin); err != ErrorNoCredentials {
+		t.Errorf("BeginLogin() without credentials error = %v", err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	authenticator := &testAuthenticator{key: key, id: []byte("credential-1")}
+
+	options, err := s.BeginRegistration(user, app)
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+	if user, err = s.FinishRegistration(user, app, authenticator.create(t, options)); err != nil {
+		t.Fatalf("FinishRegistration() error = %v", err)
+	}
+	if len(user.WebAuthnCredentials) != 1 || user.WebAuthnCredentials[0].Name != "Key" {
+		t.Fatalf("FinishRegistration() credentials = %+v", user.WebAuthnCredentials)
+	}
+
+	options, err = s.BeginLogin(user, app, CeremonyLogin)
+	if err != nil {
+		t.Fatalf("BeginLogin() error = %v", err)
+	}
+	response := authenticator.get(t, options, user.ID)
+	if _, err = s.FinishLogin(app, CeremonyTFA, response); err != ErrorInvalidSession {
+		t.Errorf("FinishLogin() with other ceremony error = %v", err)
+	}
+	logged, err := s.FinishLogin(app, CeremonyLogin, response)
+	if err != nil {
+		t.Fatalf("FinishLogin() error = %v", err)
+	}
+	if logged.ID != user.ID {
+		t.Errorf("FinishLogin() user = %v, want %v", logged.ID, user.ID)
+	}
+	if _, err = s.FinishLogin(app, CeremonyLogin, response); err != ErrorInvalidSession {
+		t.Errorf("FinishLogin() with used session error = %v", err)
+	}
+
+	stored, _ := us.UserByID(user.ID)
+	if c := stored.WebAuthnCredentials[0]; c.SignCount != 1 || c.LastUsedAt == 0 {
+		t.Errorf("FinishLogin() stored credential = %+v, want updated sign count", c)
+	}
+}